@@ -0,0 +1,129 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+const (
+	flowRecordTime = 3 * time.Second
+	flowMaxPackets = 512
+)
+
+// FlowRecorder keeps the packets of one probed connection: the originals
+// the queue saw and the ones b4 sent in their place
+type FlowRecorder struct {
+	mu       sync.Mutex
+	connKey  string
+	protocol string
+	domain   string
+	started  time.Time
+	packets  []flowPacket
+}
+
+type flowPacket struct {
+	ts      time.Time
+	emitted bool
+	data    []byte
+}
+
+func newFlowRecorder(connKey, protocol, domain string) *FlowRecorder {
+	return &FlowRecorder{
+		connKey:  connKey,
+		protocol: protocol,
+		domain:   domain,
+		started:  time.Now(),
+	}
+}
+
+// Original records packets as they arrived from the client
+func (f *FlowRecorder) Original(packets ...[]byte) { f.add(false, packets) }
+
+// Emitted records packets b4 sent for the flow
+func (f *FlowRecorder) Emitted(packets ...[]byte) { f.add(true, packets) }
+
+func (f *FlowRecorder) add(emitted bool, packets [][]byte) {
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range packets {
+		if len(f.packets) >= flowMaxPackets {
+			return
+		}
+		f.packets = append(f.packets, flowPacket{ts: now, emitted: emitted, data: append([]byte(nil), p...)})
+	}
+}
+
+// FlowRecorder returns the recorder of connKey, or nil if the connection is
+// not being recorded
+func (m *Manager) FlowRecorder(connKey string) *FlowRecorder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.flows[connKey]
+}
+
+// finishFlows saves the recordings that ran their time. Called with m.mu held.
+func (m *Manager) finishFlows(now time.Time) {
+	for connKey, f := range m.flows {
+		if now.Sub(f.started) < flowRecordTime {
+			continue
+		}
+		delete(m.flows, connKey)
+		if err := m.saveFlow(f); err != nil {
+			log.Errorf("Failed to save flow of %s: %v", f.domain, err)
+		}
+	}
+}
+
+func (m *Manager) saveFlow(f *FlowRecorder) error {
+	f.mu.Lock()
+	packets := f.packets
+	f.mu.Unlock()
+	if len(packets) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	nw, err := newNgWriter(&buf, "original", "b4")
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		intf := 0
+		if p.emitted {
+			intf = 1
+		}
+		if err := writeNgPacket(nw, intf, p.ts, p.data); err != nil {
+			return err
+		}
+	}
+	if err := nw.Flush(); err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s_%s.pcapng", f.protocol, sanitizeDomain(f.domain))
+	if err := os.WriteFile(filepath.Join(m.outputPath, filename), buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if m.metadata[f.domain] == nil {
+		m.metadata[f.domain] = make(map[string]*CaptureMetadata)
+	}
+	meta := m.metadata[f.domain][f.protocol]
+	if meta == nil {
+		meta = &CaptureMetadata{Timestamp: f.started, ConnKey: f.connKey}
+		m.metadata[f.domain][f.protocol] = meta
+	}
+	meta.Flow = filename
+
+	log.Infof("✓ Recorded %s flow for %s (%d packets)", f.protocol, f.domain, len(packets))
+	metrics.GetMetricsCollector().RecordCaptureEvent(f.protocol, "flow")
+	return m.saveMetadata()
+}
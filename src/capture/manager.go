@@ -12,6 +12,7 @@ import (
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
 )
 
 var (
@@ -25,8 +26,10 @@ type Manager struct {
 	outputPath      string
 	metadataFile    string
 	activeProbes    map[string]time.Time
+	flowProbes      map[string]bool
 	pendingCaptures map[string]*PendingCapture
 	connToDomain    map[string]string
+	flows           map[string]*FlowRecorder
 }
 
 type PendingCapture struct {
@@ -40,6 +43,8 @@ type CaptureMetadata struct {
 	Timestamp time.Time `json:"timestamp"`
 	Size      int       `json:"size"`
 	Filepath  string    `json:"filepath"`
+	ConnKey   string    `json:"conn_key,omitempty"`
+	Flow      string    `json:"flow,omitempty"`
 }
 
 // API response structure
@@ -50,6 +55,7 @@ type Capture struct {
 	Size      int       `json:"size"`
 	Filepath  string    `json:"filepath"`
 	HexData   string    `json:"hex_data"`
+	HasFlow   bool      `json:"has_flow"`
 }
 
 func GetManager(cfg *config.Config) *Manager {
@@ -62,8 +68,10 @@ func GetManager(cfg *config.Config) *Manager {
 			outputPath:      outputPath,
 			metadataFile:    filepath.Join(outputPath, "payloads.json"),
 			activeProbes:    make(map[string]time.Time),
+			flowProbes:      make(map[string]bool),
 			pendingCaptures: make(map[string]*PendingCapture),
 			connToDomain:    make(map[string]string),
+			flows:           make(map[string]*FlowRecorder),
 		}
 
 		os.MkdirAll(instance.outputPath, 0755)
@@ -89,6 +97,7 @@ func (m *Manager) cleanupExpiredProbes() {
 		for key, expiry := range m.activeProbes {
 			if now.After(expiry) {
 				delete(m.activeProbes, key)
+				delete(m.flowProbes, key)
 				log.Infof("Probe expired: %s", key)
 			}
 		}
@@ -101,6 +110,8 @@ func (m *Manager) cleanupExpiredProbes() {
 			}
 		}
 
+		m.finishFlows(now)
+
 		m.mu.Unlock()
 	}
 }
@@ -199,6 +210,11 @@ func (m *Manager) CapturePayload(connKey, domain, protocol string, payload []byt
 		return false
 	}
 
+	if m.flowProbes[probeKey] && m.flows[connKey] == nil {
+		m.flows[connKey] = newFlowRecorder(connKey, protocol, pending.domain)
+		log.Tracef("Recording flow %s for %s", connKey, pending.domain)
+	}
+
 	pending.data = append(pending.data, payload...)
 	log.Tracef("Connection %s: accumulated %d bytes (total: %d)",
 		connKey, len(payload), len(pending.data))
@@ -271,6 +287,7 @@ func (m *Manager) CapturePayload(connKey, domain, protocol string, payload []byt
 		Timestamp: time.Now(),
 		Size:      len(captureData),
 		Filepath:  filename,
+		ConnKey:   connKey,
 	}
 
 	m.saveMetadata()
@@ -281,14 +298,19 @@ func (m *Manager) CapturePayload(connKey, domain, protocol string, payload []byt
 
 	// Remove probe
 	delete(m.activeProbes, probeKey)
+	delete(m.flowProbes, probeKey)
 
 	log.Infof("✓ Captured %s payload for %s (%d bytes)",
 		protocol, pending.domain, len(captureData))
+	metrics.GetMetricsCollector().RecordCaptureEvent(protocol, "payload")
 
 	return true
 }
 
-func (m *Manager) ProbeCapture(domain, protocol string) error {
+// ProbeCapture arms a capture of the next connection to domain. With
+// fullFlow the packets of that connection, including the ones b4 sends in
+// their place, are recorded as well.
+func (m *Manager) ProbeCapture(domain, protocol string, fullFlow bool) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -301,10 +323,17 @@ func (m *Manager) ProbeCapture(domain, protocol string) error {
 	if protocol == "both" {
 		m.activeProbes[fmt.Sprintf("tls:%s", domain)] = time.Now().Add(30 * time.Second)
 		m.activeProbes[fmt.Sprintf("quic:%s", domain)] = time.Now().Add(30 * time.Second)
+		if fullFlow {
+			m.flowProbes[fmt.Sprintf("tls:%s", domain)] = true
+			m.flowProbes[fmt.Sprintf("quic:%s", domain)] = true
+		}
 		log.Infof("Capture enabled for both TLS and QUIC on %s (expires in 30s)", domain)
 	} else {
 		key := fmt.Sprintf("%s:%s", protocol, domain)
 		m.activeProbes[key] = time.Now().Add(30 * time.Second)
+		if fullFlow {
+			m.flowProbes[key] = true
+		}
 		log.Infof("Capture enabled for %s (expires in 30s)", key)
 	}
 
@@ -337,6 +366,7 @@ func (m *Manager) ListCaptures() []*Capture {
 				Size:      meta.Size,
 				Filepath:  filepath,
 				HexData:   hexData,
+				HasFlow:   meta.Flow != "",
 			})
 		}
 	}
@@ -368,6 +398,7 @@ func (m *Manager) GetCapture(protocol, domain string) (*Capture, bool) {
 		Size:      meta.Size,
 		Filepath:  filepath,
 		HexData:   hexData,
+		HasFlow:   meta.Flow != "",
 	}
 
 	return capture, true
@@ -382,10 +413,9 @@ func (m *Manager) DeleteCapture(protocol, domain string) error {
 		return fmt.Errorf("capture not found")
 	}
 
-	// Delete file
+	// Delete files
 	meta := m.metadata[domain][protocol]
-	filepath := filepath.Join(m.outputPath, meta.Filepath)
-	os.Remove(filepath)
+	m.removeFiles(meta)
 
 	// Update metadata
 	delete(m.metadata[domain], protocol)
@@ -410,8 +440,7 @@ func (m *Manager) ClearAll() error {
 	// Delete all binary files
 	for _, protocols := range m.metadata {
 		for _, meta := range protocols {
-			filepath := filepath.Join(m.outputPath, meta.Filepath)
-			os.Remove(filepath)
+			m.removeFiles(meta)
 		}
 	}
 
@@ -427,6 +456,14 @@ func (m *Manager) ClearAll() error {
 	return nil
 }
 
+func (m *Manager) removeFiles(meta *CaptureMetadata) {
+	for _, name := range []string{meta.Filepath, meta.Flow} {
+		if name != "" {
+			os.Remove(filepath.Join(m.outputPath, name))
+		}
+	}
+}
+
 func sanitizeDomain(domain string) string {
 	result := ""
 	for _, ch := range domain {
@@ -0,0 +1,233 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const synthMSS = 1460
+
+// Addresses used for captures that carry no connection, such as uploads
+var (
+	defaultClient = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 49152}
+	defaultServer = &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 443}
+)
+
+// WritePcap writes a capture as pcapng. A recorded flow is written as it
+// was seen; otherwise the payload is wrapped in IP/TCP or IP/UDP headers
+// synthesized from the connection it was captured on.
+func (m *Manager) WritePcap(protocol, domain string, w io.Writer) error {
+	m.mu.RLock()
+	var meta CaptureMetadata
+	if m.metadata[domain] != nil && m.metadata[domain][protocol] != nil {
+		meta = *m.metadata[domain][protocol]
+	}
+	outputPath := m.outputPath
+	m.mu.RUnlock()
+
+	if meta.Flow != "" {
+		f, err := os.Open(filepath.Join(outputPath, meta.Flow))
+		if err == nil {
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		}
+		if meta.Filepath == "" {
+			return err
+		}
+	}
+	if meta.Filepath == "" {
+		return fmt.Errorf("capture not found")
+	}
+
+	payload, err := os.ReadFile(filepath.Join(outputPath, meta.Filepath))
+	if err != nil {
+		return err
+	}
+
+	packets, err := SynthesizePackets(protocol, meta.ConnKey, payload)
+	if err != nil {
+		return err
+	}
+
+	nw, err := newNgWriter(w, "synthesized")
+	if err != nil {
+		return err
+	}
+	ts := meta.Timestamp
+	for _, p := range packets {
+		if err := writeNgPacket(nw, 0, ts, p); err != nil {
+			return err
+		}
+		ts = ts.Add(time.Millisecond)
+	}
+	return nw.Flush()
+}
+
+// SynthesizePackets wraps a captured payload in the packets that would
+// have carried it: a TCP handshake followed by MSS sized segments for TLS,
+// or a single UDP datagram for QUIC. Addresses and ports come from
+// connKey, "src:port->dst:port", with documentation addresses when it is
+// empty.
+func SynthesizePackets(protocol, connKey string, payload []byte) ([][]byte, error) {
+	client, server := defaultClient, defaultServer
+	if connKey != "" {
+		var err error
+		if client, server, err = parseConnKey(connKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if protocol == "quic" {
+		udp := &layers.UDP{SrcPort: layers.UDPPort(client.Port), DstPort: layers.UDPPort(server.Port)}
+		p, err := serialize(client.IP, server.IP, layers.IPProtocolUDP, udp, payload)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{p}, nil
+	}
+
+	const clientISN, serverISN = 1000, 5000
+	tcp := func(from, to *net.UDPAddr, seq, ack uint32) *layers.TCP {
+		return &layers.TCP{
+			SrcPort: layers.TCPPort(from.Port),
+			DstPort: layers.TCPPort(to.Port),
+			Seq:     seq,
+			Ack:     ack,
+			Window:  64240,
+		}
+	}
+
+	var packets [][]byte
+	add := func(from, to *net.UDPAddr, t *layers.TCP, data []byte) error {
+		p, err := serialize(from.IP, to.IP, layers.IPProtocolTCP, t, data)
+		if err == nil {
+			packets = append(packets, p)
+		}
+		return err
+	}
+
+	syn := tcp(client, server, clientISN, 0)
+	syn.SYN = true
+	synAck := tcp(server, client, serverISN, clientISN+1)
+	synAck.SYN, synAck.ACK = true, true
+	ack := tcp(client, server, clientISN+1, serverISN+1)
+	ack.ACK = true
+	for _, h := range []struct {
+		from, to *net.UDPAddr
+		t        *layers.TCP
+	}{{client, server, syn}, {server, client, synAck}, {client, server, ack}} {
+		if err := add(h.from, h.to, h.t, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for off := 0; off < len(payload); off += synthMSS {
+		end := min(off+synthMSS, len(payload))
+		seg := tcp(client, server, clientISN+1+uint32(off), serverISN+1)
+		seg.ACK = true
+		seg.PSH = end == len(payload)
+		if err := add(client, server, seg, payload[off:end]); err != nil {
+			return nil, err
+		}
+	}
+	return packets, nil
+}
+
+type transportLayer interface {
+	gopacket.SerializableLayer
+	SetNetworkLayerForChecksum(gopacket.NetworkLayer) error
+}
+
+func serialize(src, dst net.IP, proto layers.IPProtocol, l4 transportLayer, payload []byte) ([]byte, error) {
+	var l3 gopacket.SerializableLayer
+	var nl gopacket.NetworkLayer
+	if v4 := src.To4(); v4 != nil {
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Flags: layers.IPv4DontFragment, Protocol: proto, SrcIP: v4, DstIP: dst.To4()}
+		l3, nl = ip, ip
+	} else {
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: src, DstIP: dst}
+		l3, nl = ip, ip
+	}
+	if err := l4.SetNetworkLayerForChecksum(nl); err != nil {
+		return nil, err
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, l3, l4, gopacket.Payload(payload)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseConnKey splits a "src:port->dst:port" key as built by the queue
+// workers. IPv6 addresses appear unbracketed.
+func parseConnKey(key string) (src, dst *net.UDPAddr, err error) {
+	from, to, ok := strings.Cut(key, "->")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid connection %q", key)
+	}
+	if src, err = parseHostPort(from); err != nil {
+		return nil, nil, err
+	}
+	if dst, err = parseHostPort(to); err != nil {
+		return nil, nil, err
+	}
+	if (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+		return nil, nil, fmt.Errorf("mixed address families in %q", key)
+	}
+	return src, dst, nil
+}
+
+func parseHostPort(s string) (*net.UDPAddr, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	ip := net.ParseIP(s[:i])
+	port, err := strconv.ParseUint(s[i+1:], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// newNgWriter starts a raw IP pcapng with one interface per name
+func newNgWriter(w io.Writer, names ...string) (*pcapgo.NgWriter, error) {
+	intf := func(name string) pcapgo.NgInterface {
+		return pcapgo.NgInterface{Name: name, OS: runtime.GOOS, LinkType: layers.LinkTypeRaw, SnapLength: 0xffff, TimestampResolution: 9}
+	}
+	nw, err := pcapgo.NewNgWriterInterface(w, intf(names[0]), pcapgo.NgWriterOptions{
+		SectionInfo: pcapgo.NgSectionInfo{OS: runtime.GOOS, Application: "b4"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names[1:] {
+		if _, err := nw.AddInterface(intf(name)); err != nil {
+			return nil, err
+		}
+	}
+	return nw, nil
+}
+
+func writeNgPacket(nw *pcapgo.NgWriter, intf int, ts time.Time, data []byte) error {
+	return nw.WritePacket(gopacket.CaptureInfo{
+		Timestamp:      ts,
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: intf,
+	}, data)
+}
@@ -0,0 +1,162 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	dir := t.TempDir()
+	return &Manager{
+		metadata:        make(map[string]map[string]*CaptureMetadata),
+		outputPath:      dir,
+		metadataFile:    filepath.Join(dir, "payloads.json"),
+		activeProbes:    make(map[string]time.Time),
+		flowProbes:      make(map[string]bool),
+		pendingCaptures: make(map[string]*PendingCapture),
+		connToDomain:    make(map[string]string),
+		flows:           make(map[string]*FlowRecorder),
+	}
+}
+
+// readNg returns the packets of a pcapng with the interface each came in on
+func readNg(t *testing.T, data []byte) ([][]byte, []int) {
+	t.Helper()
+	r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets [][]byte
+	var intfs []int
+	for {
+		p, ci, err := r.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			return packets, intfs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, p)
+		intfs = append(intfs, ci.InterfaceIndex)
+	}
+}
+
+func TestSynthesizePacketsTLS(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x16, 0x03, 0x01, 0x02}, 500)
+	packets, err := SynthesizePackets("tls", "192.168.1.2:40000->203.0.113.1:443", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Handshake plus a full and a partial segment
+	if len(packets) != 5 {
+		t.Fatalf("got %d packets, want 5", len(packets))
+	}
+
+	var data []byte
+	for i, raw := range packets {
+		p := gopacket.NewPacket(raw, layers.LayerTypeIPv4, gopacket.Default)
+		ip, _ := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		tcp, _ := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if ip == nil || tcp == nil {
+			t.Fatalf("packet %d does not decode as IPv4/TCP", i)
+		}
+		if int(ip.Length) != len(raw) {
+			t.Errorf("packet %d: total length %d, have %d bytes", i, ip.Length, len(raw))
+		}
+		if i == 1 {
+			if !tcp.SYN || !tcp.ACK || ip.SrcIP.String() != "203.0.113.1" || tcp.SrcPort != 443 {
+				t.Errorf("packet 1 is not the server's SYN/ACK: %v %v", ip.SrcIP, tcp.SrcPort)
+			}
+			continue
+		}
+		if ip.SrcIP.String() != "192.168.1.2" || tcp.SrcPort != 40000 || tcp.DstPort != 443 {
+			t.Errorf("packet %d: %v:%d -> %v:%d", i, ip.SrcIP, tcp.SrcPort, ip.DstIP, tcp.DstPort)
+		}
+		if i >= 3 && tcp.Seq != 1001+uint32(len(data)) {
+			t.Errorf("packet %d: seq %d", i, tcp.Seq)
+		}
+		data = append(data, tcp.Payload...)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Error("segments do not carry the payload")
+	}
+}
+
+func TestSynthesizePacketsQUICv6(t *testing.T) {
+	payload := []byte{0xc0, 0x00, 0x00, 0x00, 0x01}
+	packets, err := SynthesizePackets("quic", "2001:db8::2:50000->2001:db8::1:443", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("got %d packets, want 1", len(packets))
+	}
+	p := gopacket.NewPacket(packets[0], layers.LayerTypeIPv6, gopacket.Default)
+	udp, _ := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if udp == nil || udp.SrcPort != 50000 || udp.DstPort != 443 || !bytes.Equal(udp.Payload, payload) {
+		t.Fatalf("unexpected datagram %v", p)
+	}
+}
+
+func TestParseConnKey(t *testing.T) {
+	for _, key := range []string{"", "1.2.3.4:1", "1.2.3.4:1->", "1.2.3.4:x->5.6.7.8:2", "1.2.3.4:1->2001:db8::1:443"} {
+		if _, _, err := parseConnKey(key); err == nil {
+			t.Errorf("parseConnKey(%q) accepted", key)
+		}
+	}
+}
+
+func TestWritePcap(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.SaveUploadedCapture("tls", "example.com", []byte{0x16, 0x03, 0x01, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WritePcap("tls", "example.com", &buf); err != nil {
+		t.Fatal(err)
+	}
+	packets, _ := readNg(t, buf.Bytes())
+	if len(packets) != 4 {
+		t.Fatalf("synthesized %d packets, want 4", len(packets))
+	}
+
+	// A recorded flow takes its place
+	f := newFlowRecorder("192.168.1.2:40000->203.0.113.1:443", "tls", "example.com")
+	f.Original([]byte{0x45, 1})
+	f.Emitted([]byte{0x45, 2}, []byte{0x45, 3})
+	m.mu.Lock()
+	err := m.saveFlow(f)
+	m.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := m.GetCapture("tls", "example.com")
+	if !ok || !c.HasFlow {
+		t.Fatalf("capture %+v should have a flow", c)
+	}
+
+	buf.Reset()
+	if err := m.WritePcap("tls", "example.com", &buf); err != nil {
+		t.Fatal(err)
+	}
+	packets, intfs := readNg(t, buf.Bytes())
+	if len(packets) != 3 || intfs[0] != 0 || intfs[1] != 1 || intfs[2] != 1 {
+		t.Fatalf("flow packets %x on interfaces %v", packets, intfs)
+	}
+
+	if err := m.DeleteCapture("tls", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WritePcap("tls", "example.com", &buf); err == nil {
+		t.Error("deleted capture still exported")
+	}
+}
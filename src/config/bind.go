@@ -12,6 +12,7 @@ func (c *Config) BindFlags(cmd *cobra.Command) {
 	cmd.Flags().UintVar(&c.Queue.Mark, "mark", c.Queue.Mark, "Packet mark value (default 32768)")
 	cmd.Flags().BoolVar(&c.Queue.IPv4Enabled, "ipv4", c.Queue.IPv4Enabled, "Enable IPv4 processing")
 	cmd.Flags().BoolVar(&c.Queue.IPv6Enabled, "ipv6", c.Queue.IPv6Enabled, "Enable IPv6 processing")
+	cmd.Flags().StringVar(&c.Queue.Sender, "sender", c.Queue.Sender, "Packet sender backend: raw or packet (AF_PACKET, bypasses netfilter)")
 
 	// System configuration
 	cmd.Flags().IntVar(&c.System.Tables.MonitorInterval, "tables-monitor-interval", c.System.Tables.MonitorInterval, "Tables monitor interval in seconds (default 10, 0 to disable)")
@@ -24,6 +24,8 @@ var DefaultSetConfig = SetConfig{
 	Name:    "default",
 	Enabled: true,
 
+	Pipeline: PipelineTemplates["standard"],
+
 	UDP: UDPConfig{
 		Mode:           "fake",
 		FakeSeqLength:  6,
@@ -34,11 +36,15 @@ var DefaultSetConfig = SetConfig{
 		FilterSTUN:     true,
 		ConnBytesLimit: 8,
 		Seg2Delay:      0,
+		QUICSplit:      ConfigOff,
+		FakeQUIC:       false,
+		FakeSNI:        "www.google.com",
 	},
 
 	TCP: TCPConfig{
 		ConnBytesLimit: 19,
 		Seg2Delay:      0,
+		DPortFilter:    "443",
 		SynFake:        false,
 		SynFakeLen:     0,
 		SynTTL:         3,
@@ -59,6 +65,14 @@ var DefaultSetConfig = SetConfig{
 		TargetDNS:     "",
 	},
 
+	HTTP: HTTPConfig{
+		Enabled:    false,
+		HostSplit:  true,
+		HeaderCase: false,
+		HostSpace:  false,
+		HostDot:    false,
+	},
+
 	Fragmentation: FragmentationConfig{
 		Strategy:          "tcp", // "tcp", "ip", "tls", "oob", "none", "combo", "hybrid", "disorder", "overlap", "extsplit", "firstbyte"
 		ReverseOrder:      true,
@@ -112,10 +126,15 @@ var DefaultSetConfig = SetConfig{
 	},
 
 	Targets: TargetsConfig{
-		SNIDomains:        []string{},
-		IPs:               []string{},
-		GeoSiteCategories: []string{},
-		GeoIpCategories:   []string{},
+		SNIDomains:               []string{},
+		IPs:                      []string{},
+		GeoSiteCategories:        []string{},
+		GeoIpCategories:          []string{},
+		ExcludeDomains:           []string{},
+		ExcludeIPs:               []string{},
+		ExcludeGeoSiteCategories: []string{},
+		ExcludeGeoIpCategories:   []string{},
+		Subscriptions:            []SubscriptionConfig{},
 	},
 }
 
@@ -130,6 +149,7 @@ var DefaultConfig = Config{
 		IPv4Enabled: true,
 		IPv6Enabled: false,
 		Interfaces:  []string{},
+		Sender:      SenderRaw,
 		Devices: DevicesConfig{
 			Enabled:      false,
 			WhiteIsBlack: false,
@@ -152,6 +172,7 @@ var DefaultConfig = Config{
 		Tables: TablesConfig{
 			MonitorInterval: 10,
 			SkipSetup:       false,
+			IPPrefilter:     false,
 		},
 
 		WebServer: WebServerConfig{
@@ -187,9 +208,15 @@ func NewSetConfig() SetConfig {
 	cfg.Targets.IPs = append(make([]string, 0), DefaultSetConfig.Targets.IPs...)
 	cfg.Targets.GeoSiteCategories = append(make([]string, 0), DefaultSetConfig.Targets.GeoSiteCategories...)
 	cfg.Targets.GeoIpCategories = append(make([]string, 0), DefaultSetConfig.Targets.GeoIpCategories...)
+	cfg.Targets.ExcludeDomains = append(make([]string, 0), DefaultSetConfig.Targets.ExcludeDomains...)
+	cfg.Targets.ExcludeIPs = append(make([]string, 0), DefaultSetConfig.Targets.ExcludeIPs...)
+	cfg.Targets.ExcludeGeoSiteCategories = append(make([]string, 0), DefaultSetConfig.Targets.ExcludeGeoSiteCategories...)
+	cfg.Targets.ExcludeGeoIpCategories = append(make([]string, 0), DefaultSetConfig.Targets.ExcludeGeoIpCategories...)
+	cfg.Targets.Subscriptions = append(make([]SubscriptionConfig, 0), DefaultSetConfig.Targets.Subscriptions...)
 	cfg.Fragmentation.Overlap.FakeSNIs = append(make([]string, 0), DefaultSetConfig.Fragmentation.Overlap.FakeSNIs...)
 	cfg.Fragmentation.SeqOverlapPattern = append(make([]string, 0), DefaultSetConfig.Fragmentation.SeqOverlapPattern...)
 	cfg.Faking.TLSMod = append(make([]string, 0), DefaultSetConfig.Faking.TLSMod...)
+	cfg.Pipeline = append(make([]PipelineStep, 0), DefaultSetConfig.Pipeline...)
 
 	return cfg
 }
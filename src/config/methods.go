@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -111,6 +112,12 @@ func (c *Config) Validate() error {
 
 	for _, set := range c.Sets {
 
+		for i, step := range set.Pipeline {
+			if err := step.Validate(); err != nil {
+				return fmt.Errorf("set %q pipeline step %d: %w", set.Name, i+1, err)
+			}
+		}
+
 		if len(set.Fragmentation.SeqOverlapPattern) > 0 {
 			set.Fragmentation.SeqOverlapBytes = make([]byte, len(set.Fragmentation.SeqOverlapPattern))
 			for i, s := range set.Fragmentation.SeqOverlapPattern {
@@ -120,16 +127,6 @@ func (c *Config) Validate() error {
 			}
 		}
 
-		if set.Id == MAIN_SET_ID {
-			continue
-		}
-		if set.TCP.ConnBytesLimit > c.MainSet.TCP.ConnBytesLimit {
-			set.TCP.ConnBytesLimit = c.MainSet.TCP.ConnBytesLimit
-		}
-		if set.UDP.ConnBytesLimit > c.MainSet.UDP.ConnBytesLimit {
-			set.UDP.ConnBytesLimit = c.MainSet.UDP.ConnBytesLimit
-		}
-
 	}
 
 	if len(c.MainSet.Targets.GeoSiteCategories) > 0 && c.System.Geo.GeoSitePath == "" {
@@ -148,6 +145,14 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("queue-num must be between 0 and 65535")
 	}
 
+	switch c.Queue.Sender {
+	case "":
+		c.Queue.Sender = SenderRaw
+	case SenderRaw, SenderPacket:
+	default:
+		return fmt.Errorf("sender must be %q or %q", SenderRaw, SenderPacket)
+	}
+
 	if len(c.Sets) >= 1 {
 		for _, set := range c.Sets {
 			if set.Id == "" {
@@ -156,10 +161,12 @@ func (c *Config) Validate() error {
 
 			if set.Id == MAIN_SET_ID {
 				set.UDP.DPortFilter = utils.ValidatePorts(set.UDP.DPortFilter)
+				set.TCP.DPortFilter = utils.ValidatePorts(set.TCP.DPortFilter)
 				continue
 			}
 
 			set.UDP.DPortFilter = utils.ValidatePorts(set.UDP.DPortFilter)
+			set.TCP.DPortFilter = utils.ValidatePorts(set.TCP.DPortFilter)
 		}
 	}
 
@@ -230,6 +237,9 @@ func (c *Config) GetTargetsForSetWithCache(set *SetConfig, geositeDomains, geoip
 		}
 	}
 
+	subDomains, subIps := c.LoadSubscriptions(set)
+	domains = append(domains, subDomains...)
+
 	if len(set.Targets.SNIDomains) > 0 {
 		domains = append(domains, set.Targets.SNIDomains...)
 	}
@@ -254,14 +264,59 @@ func (c *Config) GetTargetsForSetWithCache(set *SetConfig, geositeDomains, geoip
 		}
 	}
 
+	ips = append(ips, subIps...)
+
 	if len(set.Targets.IPs) > 0 {
 		ips = append(ips, set.Targets.IPs...)
 	}
 
 	set.Targets.IpsToMatch = ips
+
+	if err := c.loadExclusions(set, geositeDomains, geoipIPs); err != nil {
+		return nil, nil, err
+	}
 	return domains, ips, nil
 }
 
+// loadExclusions fills DomainsToExclude and IpsToExclude of set the same
+// way its targets are loaded
+func (c *Config) loadExclusions(set *SetConfig, geositeDomains, geoipIPs map[string][]string) error {
+	t := &set.Targets
+	domains := []string{}
+	ips := []string{}
+
+	if len(t.ExcludeGeoSiteCategories) > 0 && c.System.Geo.GeoSitePath != "" {
+		if geositeDomains != nil {
+			for _, cat := range t.ExcludeGeoSiteCategories {
+				domains = append(domains, geositeDomains[cat]...)
+			}
+		} else {
+			geoDomains, err := geodat.LoadDomainsFromCategories(c.System.Geo.GeoSitePath, t.ExcludeGeoSiteCategories)
+			if err != nil {
+				return fmt.Errorf("failed to load excluded geosite domains for set '%s': %w", set.Name, err)
+			}
+			domains = append(domains, geoDomains...)
+		}
+	}
+	t.DomainsToExclude = append(domains, t.ExcludeDomains...)
+
+	if len(t.ExcludeGeoIpCategories) > 0 && c.System.Geo.GeoIpPath != "" {
+		if geoipIPs != nil {
+			for _, cat := range t.ExcludeGeoIpCategories {
+				ips = append(ips, geoipIPs[cat]...)
+			}
+		} else {
+			geoIps, err := geodat.LoadIpsFromCategories(c.System.Geo.GeoIpPath, t.ExcludeGeoIpCategories)
+			if err != nil {
+				return fmt.Errorf("failed to load excluded geoip for set '%s': %w", set.Name, err)
+			}
+			ips = append(ips, geoIps...)
+		}
+	}
+	t.IpsToExclude = append(ips, t.ExcludeIPs...)
+	return nil
+}
+
 func (c *Config) GetSetById(id string) *SetConfig {
 	for _, set := range c.Sets {
 		if set.Id == id {
@@ -367,6 +422,197 @@ func (cfg *Config) CollectUDPPorts() []string {
 	return ports
 }
 
+// Ports returns the TCP port filter of the set, HTTPS if none is configured
+func (t *TCPConfig) Ports() string {
+	if strings.TrimSpace(t.DPortFilter) == "" {
+		return "443"
+	}
+	return t.DPortFilter
+}
+
+// CollectTCPPorts returns the merged TCP destination ports of all enabled
+// sets, plus port 80 when plain HTTP handling is enabled.
+func (cfg *Config) CollectTCPPorts() []string {
+	portSet := make(map[string]bool)
+	add := func(ports string) {
+		for _, p := range strings.Split(ports, ",") {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				portSet[p] = true
+			}
+		}
+	}
+
+	if cfg.MainSet != nil && cfg.MainSet.Enabled {
+		add(cfg.MainSet.TCP.Ports())
+	}
+
+	if cfg.HTTPEnabled() {
+		portSet["80"] = true
+	}
+
+	for _, set := range cfg.Sets {
+		if !set.Enabled {
+			continue
+		}
+		add(set.TCP.Ports())
+	}
+
+	ports := make([]string, 0, len(portSet))
+	for p := range portSet {
+		ports = append(ports, p)
+	}
+	sort.Strings(ports)
+	ports = mergeAndNormalizePorts(ports)
+	return ports
+}
+
+// PortLimit is a group of destination ports queued for the first Limit
+// packets of each connection. Sets lists the sets that asked for this limit.
+// A Prefilter group only serves sets without domain targets, so only
+// connections to IPs need to be queued.
+type PortLimit struct {
+	Ports     []string
+	Limit     int
+	Sets      []string
+	Prefilter bool
+	IPs       []string
+}
+
+func (p PortLimit) String() string {
+	s := fmt.Sprintf("%s<=%d", strings.Join(p.Ports, ","), p.Limit)
+	if p.Prefilter {
+		s += "@ip"
+	}
+	return s
+}
+
+type portLimitEntry struct {
+	ports  string
+	limit  int
+	set    string
+	ipOnly bool
+	ips    []string
+}
+
+// newPortLimitEntry describes the ports of set. With prefiltering enabled a
+// set with IP targets but no domain targets can only match by destination
+// IP. A set with neither matches by port alone and is never prefiltered.
+func (cfg *Config) newPortLimitEntry(ports string, limit int, set *SetConfig) portLimitEntry {
+	e := portLimitEntry{ports: ports, limit: limit, set: set.Name}
+	if cfg.System.Tables.IPPrefilter && len(set.Targets.DomainsToMatch) == 0 && len(set.Targets.IpsToMatch) > 0 {
+		e.ipOnly = true
+		e.ips = set.Targets.IpsToMatch
+	}
+	return e
+}
+
+// groupPortLimits merges the requested ports by connbytes limit, keeping
+// prefiltered sets apart from the rest. Ports requested with different
+// limits end up in several groups; since the queue rule is terminal the
+// effective limit of such a port is the largest one.
+func groupPortLimits(entries []portLimitEntry) []PortLimit {
+	type groupKey struct {
+		limit  int
+		ipOnly bool
+	}
+	byKey := make(map[groupKey]*PortLimit)
+	var order []groupKey
+
+	for _, e := range entries {
+		if e.limit <= 0 {
+			continue
+		}
+		key := groupKey{e.limit, e.ipOnly}
+		g, ok := byKey[key]
+		if !ok {
+			g = &PortLimit{Limit: e.limit, Prefilter: e.ipOnly}
+			byKey[key] = g
+			order = append(order, key)
+		}
+		for _, p := range strings.Split(e.ports, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				g.Ports = append(g.Ports, p)
+			}
+		}
+		if !slices.Contains(g.Sets, e.set) {
+			g.Sets = append(g.Sets, e.set)
+		}
+		g.IPs = append(g.IPs, e.ips...)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].limit != order[j].limit {
+			return order[i].limit < order[j].limit
+		}
+		return order[i].ipOnly && !order[j].ipOnly
+	})
+	result := make([]PortLimit, 0, len(order))
+	for _, k := range order {
+		g := byKey[k]
+		g.Ports = mergeAndNormalizePorts(g.Ports)
+		if g.Prefilter {
+			g.IPs = utils.FilterUniqueStrings(g.IPs)
+		}
+		if len(g.Ports) > 0 {
+			result = append(result, *g)
+		}
+	}
+	return result
+}
+
+// CollectTCPPortLimits returns the TCP ports to queue grouped by the
+// connbytes limit of the sets targeting them
+func (cfg *Config) CollectTCPPortLimits() []PortLimit {
+	var entries []portLimitEntry
+	if cfg.MainSet != nil && cfg.MainSet.Enabled {
+		entries = append(entries, cfg.newPortLimitEntry(cfg.MainSet.TCP.Ports(), cfg.MainSet.TCP.ConnBytesLimit, cfg.MainSet))
+	}
+
+	for _, set := range cfg.Sets {
+		if !set.Enabled {
+			continue
+		}
+		entries = append(entries, cfg.newPortLimitEntry(set.TCP.Ports(), set.TCP.ConnBytesLimit, set))
+		if set.HTTP.Enabled {
+			entries = append(entries, cfg.newPortLimitEntry("80", set.TCP.ConnBytesLimit, set))
+		}
+	}
+	return groupPortLimits(entries)
+}
+
+// CollectUDPPortLimits returns the UDP ports to queue grouped by the
+// connbytes limit of the sets targeting them
+func (cfg *Config) CollectUDPPortLimits() []PortLimit {
+	var entries []portLimitEntry
+	if cfg.MainSet != nil {
+		entries = append(entries, cfg.newPortLimitEntry("443", cfg.MainSet.UDP.ConnBytesLimit, cfg.MainSet))
+	}
+
+	for _, set := range cfg.Sets {
+		if !set.Enabled {
+			continue
+		}
+		if set.UDP.FilterQUIC != "" && set.UDP.FilterQUIC != "disabled" {
+			entries = append(entries, cfg.newPortLimitEntry("443", set.UDP.ConnBytesLimit, set))
+		}
+		if set.UDP.DPortFilter != "" {
+			entries = append(entries, cfg.newPortLimitEntry(set.UDP.DPortFilter, set.UDP.ConnBytesLimit, set))
+		}
+	}
+	return groupPortLimits(entries)
+}
+
+// HTTPEnabled reports whether any enabled set handles plain HTTP
+func (cfg *Config) HTTPEnabled() bool {
+	for _, set := range cfg.Sets {
+		if set.Enabled && set.HTTP.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) Clone() *Config {
 	data, _ := json.Marshal(c)
 	var clone Config
@@ -381,6 +627,9 @@ func (c *Config) Clone() *Config {
 
 				set.Targets.IpsToMatch = make([]string, len(origSet.Targets.IpsToMatch))
 				copy(set.Targets.IpsToMatch, origSet.Targets.IpsToMatch)
+
+				set.Targets.DomainsToExclude = append([]string{}, origSet.Targets.DomainsToExclude...)
+				set.Targets.IpsToExclude = append([]string{}, origSet.Targets.IpsToExclude...)
 				break
 			}
 		}
@@ -115,6 +115,32 @@ func TestValidate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid pipeline step fails", func(t *testing.T) {
+		cfg := NewConfig()
+		set := NewSetConfig()
+		cfg.Sets = []*SetConfig{&set}
+		set.Pipeline = []PipelineStep{{Type: StepSplit, At: "sni-start"}}
+		if err := cfg.Validate(); err == nil {
+			t.Error("expected error for bad split position")
+		}
+		set.Pipeline = []PipelineStep{{Type: StepFragment, Strategy: "shuffle"}}
+		if err := cfg.Validate(); err == nil {
+			t.Error("expected error for unknown strategy")
+		}
+		set.Pipeline = PipelineTemplate("fake-split-fake-disorder")
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("template should validate: %v", err)
+		}
+	})
+
+	t.Run("unknown sender fails", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Sender = "pcap"
+		if err := cfg.Validate(); err == nil {
+			t.Error("expected error for unknown sender")
+		}
+	})
+
 	t.Run("geosite categories without path", func(t *testing.T) {
 		cfg := NewConfig()
 		mainSet := NewSetConfig()
@@ -174,7 +200,7 @@ func TestValidate(t *testing.T) {
 		}
 	})
 
-	t.Run("set TCP ConnBytesLimit > main gets capped", func(t *testing.T) {
+	t.Run("set TCP ConnBytesLimit > main is kept", func(t *testing.T) {
 		cfg := NewConfig()
 		cfg.Validate()
 
@@ -186,13 +212,13 @@ func TestValidate(t *testing.T) {
 		if err := cfg.Validate(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if secondSet.TCP.ConnBytesLimit != cfg.MainSet.TCP.ConnBytesLimit {
-			t.Errorf("expected TCP ConnBytesLimit to be capped to %d, got %d",
-				cfg.MainSet.TCP.ConnBytesLimit, secondSet.TCP.ConnBytesLimit)
+		if secondSet.TCP.ConnBytesLimit != cfg.MainSet.TCP.ConnBytesLimit+10 {
+			t.Errorf("expected TCP ConnBytesLimit %d, got %d",
+				cfg.MainSet.TCP.ConnBytesLimit+10, secondSet.TCP.ConnBytesLimit)
 		}
 	})
 
-	t.Run("set UDP ConnBytesLimit > main gets capped", func(t *testing.T) {
+	t.Run("set UDP ConnBytesLimit > main is kept", func(t *testing.T) {
 		cfg := NewConfig()
 		cfg.Validate()
 
@@ -204,9 +230,9 @@ func TestValidate(t *testing.T) {
 		if err := cfg.Validate(); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if secondSet.UDP.ConnBytesLimit != cfg.MainSet.UDP.ConnBytesLimit {
-			t.Errorf("expected UDP ConnBytesLimit to be capped to %d, got %d",
-				cfg.MainSet.UDP.ConnBytesLimit, secondSet.UDP.ConnBytesLimit)
+		if secondSet.UDP.ConnBytesLimit != cfg.MainSet.UDP.ConnBytesLimit+10 {
+			t.Errorf("expected UDP ConnBytesLimit %d, got %d",
+				cfg.MainSet.UDP.ConnBytesLimit+10, secondSet.UDP.ConnBytesLimit)
 		}
 	})
 	t.Run("set without id fails", func(t *testing.T) {
@@ -457,3 +483,176 @@ func TestResetToDefaults(t *testing.T) {
 			DefaultSetConfig.Fragmentation.SNIPosition, set.Fragmentation.SNIPosition)
 	}
 }
+
+func TestCollectTCPPorts(t *testing.T) {
+	t.Run("defaults to https", func(t *testing.T) {
+		cfg := NewConfig()
+		ports := cfg.CollectTCPPorts()
+		if len(ports) != 1 || ports[0] != "443" {
+			t.Errorf("expected [443], got %v", ports)
+		}
+	})
+
+	t.Run("merges sets and http", func(t *testing.T) {
+		cfg := NewConfig()
+		extra := NewSetConfig()
+		extra.Id = "extra"
+		extra.TCP.DPortFilter = "8443,2053,8440-8450"
+		extra.HTTP.Enabled = true
+		cfg.Sets = append(cfg.Sets, &extra)
+
+		ports := cfg.CollectTCPPorts()
+		want := []string{"80", "443", "2053", "8440-8450"}
+		if len(ports) != len(want) {
+			t.Fatalf("expected %v, got %v", want, ports)
+		}
+		for i := range want {
+			if ports[i] != want[i] {
+				t.Errorf("ports[%d] = %q, want %q", i, ports[i], want[i])
+			}
+		}
+	})
+
+	t.Run("disabled sets ignored", func(t *testing.T) {
+		cfg := NewConfig()
+		extra := NewSetConfig()
+		extra.Id = "extra"
+		extra.Enabled = false
+		extra.TCP.DPortFilter = "8443"
+		cfg.Sets = append(cfg.Sets, &extra)
+
+		if ports := cfg.CollectTCPPorts(); len(ports) != 1 || ports[0] != "443" {
+			t.Errorf("expected [443], got %v", ports)
+		}
+	})
+
+	t.Run("https only when targeted", func(t *testing.T) {
+		cfg := NewConfig()
+		main := NewSetConfig()
+		main.Enabled = false
+		extra := NewSetConfig()
+		extra.Id = "extra"
+		extra.TCP.DPortFilter = "8443"
+		cfg.Sets = append(cfg.Sets, &main, &extra)
+		cfg.Validate()
+
+		if ports := cfg.CollectTCPPorts(); len(ports) != 1 || ports[0] != "8443" {
+			t.Errorf("expected [8443], got %v", ports)
+		}
+		if limits := cfg.CollectTCPPortLimits(); len(limits) != 1 || limits[0].String() != "8443<=19" {
+			t.Errorf("unexpected tcp limits: %v", limits)
+		}
+	})
+}
+
+func TestCollectPortLimits(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Validate()
+
+		tcp := cfg.CollectTCPPortLimits()
+		if len(tcp) != 1 || tcp[0].String() != "443<=19" {
+			t.Errorf("unexpected tcp limits: %v", tcp)
+		}
+		udp := cfg.CollectUDPPortLimits()
+		if len(udp) != 1 || udp[0].String() != "443<=8" {
+			t.Errorf("unexpected udp limits: %v", udp)
+		}
+	})
+
+	t.Run("per set limits", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Validate()
+
+		slow := NewSetConfig()
+		slow.Id = "slow"
+		slow.Name = "slow-quic"
+		slow.UDP.FilterQUIC = "parse"
+		slow.UDP.ConnBytesLimit = 30
+		slow.TCP.DPortFilter = "8443"
+		slow.TCP.ConnBytesLimit = 19
+		cfg.Sets = append(cfg.Sets, &slow)
+
+		tcp := cfg.CollectTCPPortLimits()
+		if len(tcp) != 1 || tcp[0].String() != "443,8443<=19" {
+			t.Fatalf("unexpected tcp limits: %v", tcp)
+		}
+		if len(tcp[0].Sets) != 2 {
+			t.Errorf("expected both sets recorded, got %v", tcp[0].Sets)
+		}
+
+		udp := cfg.CollectUDPPortLimits()
+		if len(udp) != 2 || udp[0].String() != "443<=8" || udp[1].String() != "443<=30" {
+			t.Fatalf("unexpected udp limits: %v", udp)
+		}
+		if udp[1].Sets[0] != "slow-quic" {
+			t.Errorf("expected slow-quic to drive the 30 packet rule, got %v", udp[1].Sets)
+		}
+	})
+
+	t.Run("main set ports", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.MainSet.TCP.DPortFilter = "8443,2053"
+
+		tcp := cfg.CollectTCPPortLimits()
+		if len(tcp) != 1 || tcp[0].String() != "2053,8443<=19" {
+			t.Errorf("unexpected tcp limits: %v", tcp)
+		}
+	})
+
+	t.Run("ip prefilter", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.System.Tables.IPPrefilter = true
+		main := NewSetConfig()
+		main.Targets.DomainsToMatch = []string{"example.com"}
+		cfg.Sets = append(cfg.Sets, &main)
+		cfg.Validate()
+
+		ipOnly := NewSetConfig()
+		ipOnly.Id = "ips"
+		ipOnly.Name = "ips"
+		ipOnly.TCP.DPortFilter = "8443"
+		ipOnly.Targets.IpsToMatch = []string{"10.0.0.0/8", "10.0.0.0/8", "2001:db8::/32"}
+		cfg.Sets = append(cfg.Sets, &ipOnly)
+
+		tcp := cfg.CollectTCPPortLimits()
+		if len(tcp) != 2 || tcp[0].String() != "8443<=19@ip" || tcp[1].String() != "443<=19" {
+			t.Fatalf("unexpected tcp limits: %v", tcp)
+		}
+		if len(tcp[0].IPs) != 2 {
+			t.Errorf("expected deduplicated IPs, got %v", tcp[0].IPs)
+		}
+
+		cfg.System.Tables.IPPrefilter = false
+		tcp = cfg.CollectTCPPortLimits()
+		if len(tcp) != 1 || tcp[0].Prefilter {
+			t.Errorf("prefilter must be off when disabled: %v", tcp)
+		}
+	})
+
+	t.Run("ip prefilter skips port only sets", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.System.Tables.IPPrefilter = true
+		main := NewSetConfig()
+		main.Targets.DomainsToMatch = []string{"example.com"}
+		cfg.Sets = append(cfg.Sets, &main)
+		cfg.Validate()
+
+		portOnly := NewSetConfig()
+		portOnly.Id = "games"
+		portOnly.Name = "games"
+		portOnly.TCP.DPortFilter = "27015"
+		portOnly.UDP.DPortFilter = "27015-27030"
+		cfg.Sets = append(cfg.Sets, &portOnly)
+
+		tcp := cfg.CollectTCPPortLimits()
+		if len(tcp) != 1 || tcp[0].Prefilter || tcp[0].String() != "443,27015<=19" {
+			t.Errorf("port only set must not be prefiltered: %v", tcp)
+		}
+		for _, g := range cfg.CollectUDPPortLimits() {
+			if g.Prefilter {
+				t.Errorf("port only set must not be prefiltered: %v", g)
+			}
+		}
+	})
+}
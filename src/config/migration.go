@@ -29,6 +29,109 @@ var migrationRegistry = map[int]MigrationFunc{
 	10: migrateV10to11,
 	11: migrateV11to12,
 	12: migrateV12to13,
+	13: migrateV13to14, // Add plain HTTP settings
+	14: migrateV14to15, // Add TCP port filter
+	15: migrateV15to16, // Add QUIC Initial split
+	16: migrateV16to17, // Add fake QUIC Initials
+	17: migrateV17to18, // Add queue sender backend
+	18: migrateV18to19, // Add strategy pipelines
+	19: migrateV19to20, // Add target exclusions
+	20: migrateV20to21, // Add set priority
+	21: migrateV21to22, // Add list subscriptions
+}
+
+// Migration: v21 -> v22 (add list subscriptions)
+func migrateV21to22(c *Config) error {
+	log.Tracef("Migration v21->v22: Adding list subscriptions")
+
+	for _, set := range c.Sets {
+		set.Targets.Subscriptions = []SubscriptionConfig{}
+	}
+	return nil
+}
+
+// Migration: v20 -> v21 (add set priority)
+func migrateV20to21(c *Config) error {
+	log.Tracef("Migration v20->v21: Adding set priority")
+
+	for _, set := range c.Sets {
+		set.Priority = DefaultSetConfig.Priority
+	}
+	return nil
+}
+
+// Migration: v19 -> v20 (add target exclusions)
+func migrateV19to20(c *Config) error {
+	log.Tracef("Migration v19->v20: Adding target exclusions")
+
+	for _, set := range c.Sets {
+		set.Targets.ExcludeDomains = []string{}
+		set.Targets.ExcludeIPs = []string{}
+		set.Targets.ExcludeGeoSiteCategories = []string{}
+		set.Targets.ExcludeGeoIpCategories = []string{}
+	}
+	return nil
+}
+
+// Migration: v18 -> v19 (add strategy pipelines)
+func migrateV18to19(c *Config) error {
+	log.Tracef("Migration v18->v19: Adding strategy pipelines")
+
+	// The standard template runs the steps in the order they always ran,
+	// driven by the existing settings, so later edits to them still apply
+	for _, set := range c.Sets {
+		set.Pipeline = PipelineTemplate("standard")
+	}
+	return nil
+}
+
+// Migration: v17 -> v18 (add queue sender backend)
+func migrateV17to18(c *Config) error {
+	log.Tracef("Migration v17->v18: Adding queue sender backend")
+
+	c.Queue.Sender = DefaultConfig.Queue.Sender
+	return nil
+}
+
+// Migration: v16 -> v17 (add fake QUIC Initials)
+func migrateV16to17(c *Config) error {
+	log.Tracef("Migration v16->v17: Adding fake QUIC Initials")
+
+	for _, set := range c.Sets {
+		set.UDP.FakeQUIC = DefaultSetConfig.UDP.FakeQUIC
+		set.UDP.FakeSNI = DefaultSetConfig.UDP.FakeSNI
+	}
+	return nil
+}
+
+// Migration: v15 -> v16 (add QUIC Initial split)
+func migrateV15to16(c *Config) error {
+	log.Tracef("Migration v15->v16: Adding QUIC Initial split")
+
+	for _, set := range c.Sets {
+		set.UDP.QUICSplit = DefaultSetConfig.UDP.QUICSplit
+	}
+	return nil
+}
+
+// Migration: v14 -> v15 (add TCP port filter)
+func migrateV14to15(c *Config) error {
+	log.Tracef("Migration v14->v15: Adding TCP port filter")
+
+	for _, set := range c.Sets {
+		set.TCP.DPortFilter = DefaultSetConfig.TCP.DPortFilter
+	}
+	return nil
+}
+
+// Migration: v13 -> v14 (add plain HTTP settings)
+func migrateV13to14(c *Config) error {
+	log.Tracef("Migration v13->v14: Adding plain HTTP settings")
+
+	for _, set := range c.Sets {
+		set.HTTP = DefaultSetConfig.HTTP
+	}
+	return nil
 }
 
 // Migration: v12 -> v13 (add payload file/data to faking config)
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -86,4 +87,72 @@ func TestApplyMigrations(t *testing.T) {
 		}
 	})
 
+	t.Run("v18 to v19 adds the standard pipeline", func(t *testing.T) {
+		cfg := NewConfig()
+		tcp := NewSetConfig()
+		tcp.Fragmentation.Strategy = "tcp"
+		tcp.Fragmentation.ReverseOrder = true
+		oob := NewSetConfig()
+		oob.Fragmentation.Strategy = "oob"
+		oob.Fragmentation.ReverseOrder = false
+		combo := NewSetConfig()
+		combo.Fragmentation.Strategy = "combo"
+		cfg.Sets = []*SetConfig{&tcp, &oob, &combo}
+		for _, set := range cfg.Sets {
+			set.Pipeline = nil
+		}
+
+		if err := cfg.applyMigrations(18); err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		for i, set := range cfg.Sets {
+			if !reflect.DeepEqual(set.Pipeline, PipelineTemplates["standard"]) {
+				t.Errorf("set %d: expected the standard pipeline, got %v", i, set.Pipeline)
+			}
+		}
+	})
+
+	t.Run("v19 to v20 adds empty exclusions", func(t *testing.T) {
+		cfg := NewConfig()
+		set := NewSetConfig()
+		set.Targets.ExcludeDomains = nil
+		set.Targets.ExcludeGeoIpCategories = nil
+		cfg.Sets = []*SetConfig{&set}
+
+		if err := cfg.applyMigrations(19); err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		if cfg.Sets[0].Targets.ExcludeDomains == nil || cfg.Sets[0].Targets.ExcludeGeoIpCategories == nil {
+			t.Error("v19->v20 should initialize the exclusion lists")
+		}
+	})
+
+	t.Run("v20 to v21 resets priority", func(t *testing.T) {
+		cfg := NewConfig()
+		set := NewSetConfig()
+		set.Priority = 7
+		cfg.Sets = []*SetConfig{&set}
+
+		if err := cfg.applyMigrations(20); err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		if cfg.Sets[0].Priority != 0 {
+			t.Errorf("expected priority 0, got %d", cfg.Sets[0].Priority)
+		}
+	})
+
+	t.Run("v21 to v22 adds empty subscriptions", func(t *testing.T) {
+		cfg := NewConfig()
+		set := NewSetConfig()
+		set.Targets.Subscriptions = nil
+		cfg.Sets = []*SetConfig{&set}
+
+		if err := cfg.applyMigrations(21); err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		if cfg.Sets[0].Targets.Subscriptions == nil {
+			t.Error("v21->v22 should initialize the subscriptions")
+		}
+	})
+
 }
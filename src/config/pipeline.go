@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Pipeline step types. Steps run in order over the payload of the packet,
+// which starts as one segment and is cut by split steps.
+const (
+	StepMutate   = "mutate"   // rewrite the ClientHello per faking.sni_mutation
+	StepDesync   = "desync"   // send the tcp.desync_mode packets
+	StepWindow   = "window"   // send the tcp.win_mode packets
+	StepFake     = "fake"     // send fake ClientHellos
+	StepSplit    = "split"    // cut the pending segments at a payload offset
+	StepDisorder = "disorder" // reverse the order of the pending segments
+	StepSend     = "send"     // send pending segments
+	StepOOB      = "oob"      // send a fake urgent byte ahead of the next pending segment
+	StepDelay    = "delay"    // wait before the next step
+	StepFragment = "fragment" // send what is pending with a fragmentation strategy
+)
+
+// Split positions besides a plain payload offset
+const (
+	SplitAtSNI    = "sni"     // start of the SNI
+	SplitAtSNIMid = "sni-mid" // middle of the SNI
+	SplitAtSNIEnd = "sni-end" // end of the SNI
+	SplitAtOOB    = "oob"     // fragmentation.oob_position, or the middle of the SNI with middle_sni
+)
+
+var fragmentStrategies = map[string]bool{
+	"tcp": true, "ip": true, "oob": true, "tls": true, "disorder": true, "overlap": true,
+	"extsplit": true, "firstbyte": true, "combo": true, "hybrid": true, "none": true,
+}
+
+type PipelineStep struct {
+	Type     string `json:"type" bson:"type"`
+	At       string `json:"at,omitempty" bson:"at,omitempty"`             // split: payload offset or one of SplitAt*; empty cuts where the tcp strategy does
+	Count    int    `json:"count,omitempty" bson:"count,omitempty"`       // fake: number of fakes; send, disorder: number of segments; 0 follows the set settings or means all
+	DelayMs  int    `json:"delay_ms,omitempty" bson:"delay_ms,omitempty"` // delay: pause; send: pause between segments, 0 follows tcp.seg2delay
+	Strategy string `json:"strategy,omitempty" bson:"strategy,omitempty"` // fragment: empty follows fragmentation.strategy
+}
+
+// PipelineTemplates are the built-in pipelines. "standard" is the fixed
+// order used before pipelines existed; its steps follow the set settings
+// and do nothing when the feature they stand for is off. The others spell
+// the fragmentation out in steps, "fake-split" and "fake-split-reverse" send
+// what the tcp strategy does and "oob" what the oob strategy does, whatever
+// fragmentation.strategy and reverse_order say.
+var PipelineTemplates = map[string][]PipelineStep{
+	"standard": {
+		{Type: StepMutate},
+		{Type: StepDesync},
+		{Type: StepWindow},
+		{Type: StepFake},
+		{Type: StepFragment},
+	},
+	"fake-split": {
+		{Type: StepMutate},
+		{Type: StepDesync},
+		{Type: StepWindow},
+		{Type: StepFake},
+		{Type: StepSplit},
+		{Type: StepSend},
+	},
+	"fake-split-reverse": {
+		{Type: StepMutate},
+		{Type: StepDesync},
+		{Type: StepWindow},
+		{Type: StepFake},
+		{Type: StepSplit},
+		{Type: StepDisorder, Count: 2},
+		{Type: StepSend},
+	},
+	"oob": {
+		{Type: StepMutate},
+		{Type: StepDesync},
+		{Type: StepWindow},
+		{Type: StepFake},
+		{Type: StepSplit, At: SplitAtOOB},
+		{Type: StepSend, Count: 1},
+		{Type: StepOOB},
+		{Type: StepSend},
+	},
+	"disorder": {
+		{Type: StepMutate},
+		{Type: StepDesync},
+		{Type: StepWindow},
+		{Type: StepFake},
+		{Type: StepSplit, At: SplitAtSNI},
+		{Type: StepSplit, At: SplitAtSNIMid},
+		{Type: StepSplit, At: SplitAtSNIEnd},
+		{Type: StepDisorder},
+		{Type: StepSend},
+	},
+	"sni-split": {
+		{Type: StepMutate},
+		{Type: StepDesync},
+		{Type: StepWindow},
+		{Type: StepFake},
+		{Type: StepSplit, At: SplitAtSNI},
+		{Type: StepSplit, At: SplitAtSNIEnd},
+		{Type: StepSend},
+	},
+	"fake-split-fake-disorder": {
+		{Type: StepFake, Count: 1},
+		{Type: StepSplit, At: SplitAtSNIMid},
+		{Type: StepFake, Count: 1},
+		{Type: StepDisorder},
+	},
+	"fake-split-send-fake": {
+		{Type: StepFake, Count: 1},
+		{Type: StepSplit, At: SplitAtSNIMid},
+		{Type: StepSend, Count: 1},
+		{Type: StepFake, Count: 1},
+	},
+}
+
+// PipelineTemplate returns a copy of the named built-in pipeline
+func PipelineTemplate(name string) []PipelineStep {
+	return append([]PipelineStep(nil), PipelineTemplates[name]...)
+}
+
+// SplitOffset parses a numeric split position
+func (s PipelineStep) SplitOffset() (int, bool) {
+	n, err := strconv.Atoi(s.At)
+	return n, err == nil
+}
+
+func (s PipelineStep) Validate() error {
+	if s.Count < 0 || s.DelayMs < 0 {
+		return fmt.Errorf("%s step: count and delay_ms must not be negative", s.Type)
+	}
+	switch s.Type {
+	case StepMutate, StepDesync, StepWindow, StepFake, StepDisorder, StepSend, StepDelay, StepOOB:
+	case StepSplit:
+		switch s.At {
+		case "", SplitAtSNI, SplitAtSNIMid, SplitAtSNIEnd, SplitAtOOB:
+		default:
+			if n, ok := s.SplitOffset(); !ok || n <= 0 {
+				return fmt.Errorf("split step: invalid position %q", s.At)
+			}
+		}
+	case StepFragment:
+		if s.Strategy != "" && !fragmentStrategies[s.Strategy] {
+			return fmt.Errorf("fragment step: unknown strategy %q", s.Strategy)
+		}
+	default:
+		return fmt.Errorf("unknown pipeline step %q", s.Type)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daniellavrushin/b4/geodat"
+	"github.com/daniellavrushin/b4/log"
+)
+
+// Subscription formats
+const (
+	SubscriptionPlain   = "plain"   // one domain, IP or CIDR per line
+	SubscriptionHosts   = "hosts"   // hosts file, "0.0.0.0 domain"
+	SubscriptionAdblock = "adblock" // "||domain^" rules
+	SubscriptionJSON    = "json"    // array of entries or a sing-box rule set
+	SubscriptionGeoSite = "geosite" // v2fly geosite.dat
+	SubscriptionGeoIP   = "geoip"   // v2fly geoip.dat
+)
+
+// IsDat reports whether the subscription is a dat file its categories are
+// picked from
+func (s *SubscriptionConfig) IsDat() bool {
+	return s.Format == SubscriptionGeoSite || s.Format == SubscriptionGeoIP
+}
+
+// Key identifies the download of a subscription. Sets subscribed to the
+// same list share it.
+func (s *SubscriptionConfig) Key() string {
+	sum := sha256.Sum256([]byte(s.Format + "|" + s.URL))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SubscriptionsDir is where downloaded lists are kept, next to the config
+// file. It is empty when the config has no path.
+func (c *Config) SubscriptionsDir() string {
+	if c.ConfigPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(c.ConfigPath), "subscriptions")
+}
+
+// SubscriptionPath is the file a subscription is stored in. Lists are
+// stored normalized, one entry per line, and dat files as downloaded.
+func (c *Config) SubscriptionPath(sub *SubscriptionConfig) string {
+	dir := c.SubscriptionsDir()
+	if dir == "" {
+		return ""
+	}
+	ext := ".list"
+	if sub.IsDat() {
+		ext = ".dat"
+	}
+	return filepath.Join(dir, sub.Key()+ext)
+}
+
+// LoadSubscriptions returns the domains and IPs of the enabled subscriptions
+// of set that have been downloaded
+func (c *Config) LoadSubscriptions(set *SetConfig) ([]string, []string) {
+	domains := []string{}
+	ips := []string{}
+
+	for i := range set.Targets.Subscriptions {
+		sub := &set.Targets.Subscriptions[i]
+		if !sub.Enabled || sub.URL == "" {
+			continue
+		}
+		path := c.SubscriptionPath(sub)
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			log.Tracef("Subscription %s not downloaded yet", sub.URL)
+			continue
+		}
+
+		switch sub.Format {
+		case SubscriptionGeoSite:
+			d, err := geodat.LoadDomainsFromCategories(path, sub.Categories)
+			if err != nil {
+				log.Errorf("Failed to load subscription %s: %v", sub.URL, err)
+				continue
+			}
+			domains = append(domains, d...)
+		case SubscriptionGeoIP:
+			p, err := geodat.LoadIpsFromCategories(path, sub.Categories)
+			if err != nil {
+				log.Errorf("Failed to load subscription %s: %v", sub.URL, err)
+				continue
+			}
+			ips = append(ips, p...)
+		default:
+			d, p, err := readSubscriptionList(path)
+			if err != nil {
+				log.Errorf("Failed to load subscription %s: %v", sub.URL, err)
+				continue
+			}
+			domains = append(domains, d...)
+			ips = append(ips, p...)
+		}
+	}
+	return domains, ips
+}
+
+func readSubscriptionList(path string) ([]string, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	domains := []string{}
+	ips := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err == nil || net.ParseIP(entry) != nil {
+			ips = append(ips, entry)
+		} else {
+			domains = append(domains, entry)
+		}
+	}
+	return domains, ips, scanner.Err()
+}
@@ -12,6 +12,23 @@ const (
 	FakePayloadCapture
 )
 
+// Queue sender backends. The packet sender writes frames to the egress
+// interface itself, so injected packets skip conntrack and NAT.
+const (
+	SenderRaw    = "raw"
+	SenderPacket = "packet"
+)
+
+// Discovery probes connect from source ports in this range, with ProbeMark
+// set on their sockets. The firewall queues marked traffic from this host
+// while a discovery runs, and the queue workers apply the preset under test
+// to it and nothing else.
+const (
+	ProbePortMin = 61000
+	ProbePortMax = 61999
+	ProbeMark    = 0x4000
+)
+
 type ApiConfig struct {
 	IPInfoToken string `json:"ipinfo_token" bson:"ipinfo_token"`
 }
@@ -24,6 +41,7 @@ type QueueConfig struct {
 	IPv6Enabled bool          `json:"ipv6" bson:"ipv6"`
 	Interfaces  []string      `json:"interfaces" bson:"interfaces"`
 	Devices     DevicesConfig `json:"devices" bson:"devices"`
+	Sender      string        `json:"sender" bson:"sender"` // "raw" or "packet"
 }
 
 type DevicesConfig struct {
@@ -33,12 +51,13 @@ type DevicesConfig struct {
 }
 
 type TCPConfig struct {
-	ConnBytesLimit int   `json:"conn_bytes_limit" bson:"conn_bytes_limit"`
-	Seg2Delay      int   `json:"seg2delay" bson:"seg2delay"`
-	SynFake        bool  `json:"syn_fake" bson:"syn_fake"`
-	SynFakeLen     int   `json:"syn_fake_len" bson:"syn_fake_len"`
-	SynTTL         uint8 `json:"syn_ttl" bson:"syn_ttl"`
-	DropSACK       bool  `json:"drop_sack" bson:"drop_sack"`
+	ConnBytesLimit int    `json:"conn_bytes_limit" bson:"conn_bytes_limit"`
+	Seg2Delay      int    `json:"seg2delay" bson:"seg2delay"`
+	SynFake        bool   `json:"syn_fake" bson:"syn_fake"`
+	SynFakeLen     int    `json:"syn_fake_len" bson:"syn_fake_len"`
+	SynTTL         uint8  `json:"syn_ttl" bson:"syn_ttl"`
+	DropSACK       bool   `json:"drop_sack" bson:"drop_sack"`
+	DPortFilter    string `json:"dport_filter" bson:"dport_filter"` // same syntax as UDP, e.g. "443,8443,2053"; empty means 443
 
 	WinMode   string `json:"win_mode" bson:"win_mode"`     // "off", "oscillate", "zero", "random", "escalate"
 	WinValues []int  `json:"win_values" bson:"win_values"` // Custom window values
@@ -59,6 +78,9 @@ type UDPConfig struct {
 	FilterSTUN     bool   `json:"filter_stun" bson:"filter_stun"`
 	ConnBytesLimit int    `json:"conn_bytes_limit" bson:"conn_bytes_limit"`
 	Seg2Delay      int    `json:"seg2delay" bson:"seg2delay"`
+	QUICSplit      string `json:"quic_split" bson:"quic_split"` // "off", "reorder": re-encrypt the client Initial with its CRYPTO data cut at the SNI
+	FakeQUIC       bool   `json:"fake_quic" bson:"fake_quic"`   // fakes are encrypted QUIC Initials carrying a ClientHello for FakeSNI
+	FakeSNI        string `json:"fake_sni" bson:"fake_sni"`
 }
 
 type FragmentationConfig struct {
@@ -111,6 +133,29 @@ type TargetsConfig struct {
 	GeoIpCategories   []string `json:"geoip_categories" bson:"geoip_categories"`
 	DomainsToMatch    []string `json:"-" bson:"-"`
 	IpsToMatch        []string `json:"-" bson:"-"`
+
+	// Exclusions take priority over the targets above
+	ExcludeDomains           []string `json:"exclude_domains" bson:"exclude_domains"`
+	ExcludeIPs               []string `json:"exclude_ips" bson:"exclude_ips"`
+	ExcludeGeoSiteCategories []string `json:"exclude_geosite_categories" bson:"exclude_geosite_categories"`
+	ExcludeGeoIpCategories   []string `json:"exclude_geoip_categories" bson:"exclude_geoip_categories"`
+	DomainsToExclude         []string `json:"-" bson:"-"`
+	IpsToExclude             []string `json:"-" bson:"-"`
+
+	// Remote lists added to the targets, refreshed in the background
+	Subscriptions []SubscriptionConfig `json:"subscriptions" bson:"subscriptions"`
+}
+
+type SubscriptionConfig struct {
+	Enabled bool   `json:"enabled" bson:"enabled"`
+	URL     string `json:"url" bson:"url"`
+	Format  string `json:"format" bson:"format"`
+	// Categories picked from geosite and geoip dat files
+	Categories []string `json:"categories" bson:"categories"`
+	// Interval between refreshes in minutes, 0 fetches only once
+	Interval    int    `json:"interval" bson:"interval"`
+	SHA256      string `json:"sha256" bson:"sha256"`
+	ChecksumURL string `json:"checksum_url" bson:"checksum_url"`
 }
 
 type SystemConfig struct {
@@ -125,6 +170,7 @@ type SystemConfig struct {
 type TablesConfig struct {
 	MonitorInterval int  `json:"monitor_interval" bson:"monitor_interval"`
 	SkipSetup       bool `json:"skip_setup" bson:"skip_setup"`
+	IPPrefilter     bool `json:"ip_prefilter" bson:"ip_prefilter"`
 }
 
 type WebServerConfig struct {
@@ -156,7 +202,10 @@ type SetConfig struct {
 	Faking        FakingConfig        `json:"faking" bson:"faking"`
 	Targets       TargetsConfig       `json:"targets" bson:"targets"`
 	Enabled       bool                `json:"enabled" bson:"enabled"`
+	Priority      int                 `json:"priority" bson:"priority"` // breaks ties between equally specific matches, higher wins
 	DNS           DNSConfig           `json:"dns" bson:"dns"`
+	HTTP          HTTPConfig          `json:"http" bson:"http"`
+	Pipeline      []PipelineStep      `json:"pipeline" bson:"pipeline"`
 }
 
 type GeoDatConfig struct {
@@ -186,6 +235,14 @@ type DNSConfig struct {
 	FragmentQuery bool   `json:"fragment_query" bson:"fragment_query"`
 }
 
+type HTTPConfig struct {
+	Enabled    bool `json:"enabled" bson:"enabled"`         // handle plain HTTP (port 80) requests
+	HostSplit  bool `json:"host_split" bson:"host_split"`   // split the segment inside the Host header value
+	HeaderCase bool `json:"header_case" bson:"header_case"` // "Host:" -> "hOsT:"
+	HostSpace  bool `json:"host_space" bson:"host_space"`   // extra whitespace before the host value
+	HostDot    bool `json:"host_dot" bson:"host_dot"`       // append a dot to the host name
+}
+
 type OverlapFragConfig struct {
 	FakeSNIs []string `json:"fake_snis" bson:"fake_snis"`
 }
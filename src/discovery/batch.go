@@ -0,0 +1,284 @@
+package discovery
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/nfq"
+	"github.com/google/uuid"
+)
+
+// batchResultTTL keeps a finished batch around long enough to fetch its report
+const batchResultTTL = 10 * time.Minute
+
+func NewBatchDiscoverySuite(domains []string, pool *nfq.Pool) *BatchDiscoverySuite {
+	return &BatchDiscoverySuite{
+		CheckSuite: &CheckSuite{
+			Id:        uuid.New().String(),
+			Status:    CheckStatusPending,
+			StartTime: time.Now(),
+			cancel:    make(chan struct{}),
+			Domain:    fmt.Sprintf("%d domains", len(domains)),
+		},
+		Domains: domains,
+		pool:    pool,
+	}
+}
+
+// BatchDomains turns domains, URLs and geosite entries into a list of
+// distinct discovery inputs. Geosite keyword and regexp entries are skipped
+// as they do not name a host. A limit <= 0 keeps every input.
+func BatchDomains(inputs []string, limit int) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, input := range inputs {
+		input = strings.TrimSpace(input)
+		if strings.HasPrefix(input, "regexp:") || strings.HasPrefix(input, "keyword:") {
+			continue
+		}
+		input = strings.TrimPrefix(strings.TrimPrefix(input, "full:"), "domain:")
+
+		domain, _ := parseDiscoveryInput(input)
+		domain = strings.ToLower(strings.TrimPrefix(domain, "www."))
+		if !strings.Contains(domain, ".") || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		result = append(result, input)
+
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+// RunDiscovery runs discovery for every domain in turn. The network baseline
+// is measured once and the payload variants found working for a domain are
+// reused for the next ones.
+func (bs *BatchDiscoverySuite) RunDiscovery() {
+	log.SetDiscoveryActive(true)
+
+	log.DiscoveryLogf("═══════════════════════════════════════")
+	log.DiscoveryLogf("Starting batch discovery for %d domains", len(bs.Domains))
+	log.DiscoveryLogf("═══════════════════════════════════════")
+
+	suitesMu.Lock()
+	activeSuites[bs.Id] = bs.CheckSuite
+	suitesMu.Unlock()
+
+	defer func() {
+		log.SetDiscoveryActive(false)
+		bs.EndTime = time.Now()
+	}()
+
+	bs.mu.Lock()
+	bs.Status = CheckStatusRunning
+	bs.TotalChecks = len(bs.Domains)
+	bs.mu.Unlock()
+
+	cfg := bs.pool.GetFirstWorkerConfig()
+	if cfg == nil {
+		log.Errorf("Failed to get original configuration")
+		bs.mu.Lock()
+		bs.Status = CheckStatusFailed
+		bs.mu.Unlock()
+		return
+	}
+
+	results := make(map[string]*DomainDiscoveryResult)
+	var baseline float64
+	var payloads []PayloadTestResult
+
+	for i, input := range bs.Domains {
+		if bs.canceled() {
+			log.DiscoveryLogf("Batch discovery canceled after %d of %d domains", i, len(bs.Domains))
+			break
+		}
+
+		ds := bs.domainSuite(input, cfg)
+		if i == 0 {
+			baseline = ds.measureNetworkBaseline()
+		}
+		ds.networkBaseline = baseline
+		ds.workingPayloads = append(ds.workingPayloads, payloads...)
+
+		log.DiscoveryLogf("[%d/%d] %s", i+1, len(bs.Domains), ds.Domain)
+		ds.discoverDomain()
+		ds.logDiscoverySummary()
+
+		payloads = payloads[:0]
+		for _, pr := range ds.workingPayloads {
+			if pr.Works {
+				payloads = append(payloads, pr)
+			}
+		}
+
+		bs.mu.Lock()
+		results[ds.Domain] = ds.domainResult
+		bs.DomainDiscoveryResults = maps.Clone(results)
+		bs.CompletedChecks++
+		if ds.domainResult.BestSuccess {
+			bs.SuccessfulChecks++
+		} else {
+			bs.FailedChecks++
+		}
+		bs.mu.Unlock()
+	}
+
+	bs.pool.ClearProbeConfig()
+
+	report := buildBatchReport(bs.Domains, baseline, results)
+	bs.mu.Lock()
+	bs.Report = report
+	if bs.Status == CheckStatusRunning {
+		bs.Status = CheckStatusComplete
+	}
+	bs.mu.Unlock()
+
+	bs.logReport()
+
+	go func() {
+		time.Sleep(batchResultTTL)
+		suitesMu.Lock()
+		delete(activeSuites, bs.Id)
+		suitesMu.Unlock()
+	}()
+}
+
+func (bs *BatchDiscoverySuite) canceled() bool {
+	select {
+	case <-bs.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// domainSuite returns a discovery suite for a single domain of the batch. It
+// shares the cancel channel of the batch but keeps its own progress.
+func (bs *BatchDiscoverySuite) domainSuite(input string, cfg *config.Config) *DiscoverySuite {
+	domain, checkURL := parseDiscoveryInput(input)
+	return &DiscoverySuite{
+		CheckSuite: &CheckSuite{
+			Id:        bs.Id,
+			Status:    CheckStatusRunning,
+			StartTime: time.Now(),
+			cancel:    bs.cancel,
+			CheckURL:  checkURL,
+			Domain:    domain,
+		},
+		pool: bs.pool,
+		cfg:  cfg,
+		domainResult: &DomainDiscoveryResult{
+			Domain:  domain,
+			Url:     checkURL,
+			Results: make(map[string]*DomainPresetResult),
+		},
+		workingPayloads: []PayloadTestResult{},
+		bestPayload:     config.FakePayloadDefault1,
+	}
+}
+
+// buildBatchReport clusters the domains by best preset, keeping domains that
+// need a different QUIC strategy or DNS bypass apart, and suggests a set for
+// each cluster
+func buildBatchReport(inputs []string, baseline float64, results map[string]*DomainDiscoveryResult) *BatchReport {
+	report := &BatchReport{
+		Domains:       []string{},
+		BaselineSpeed: baseline,
+		Clusters:      []DomainCluster{},
+		NoBypass:      []string{},
+		Failed:        []string{},
+	}
+
+	index := make(map[string]int)
+	for _, input := range inputs {
+		domain, _ := parseDiscoveryInput(input)
+		r, ok := results[domain]
+		if !ok {
+			continue
+		}
+		report.Domains = append(report.Domains, domain)
+
+		best := r.Results[r.BestPreset]
+		switch {
+		case r.BestPreset == "no-bypass":
+			report.NoBypass = append(report.NoBypass, domain)
+		case !r.BestSuccess || best == nil || best.Set == nil:
+			report.Failed = append(report.Failed, domain)
+		default:
+			key := r.BestPreset + "|" + r.BestQUICPreset + "|" + dnsKey(best.Set.DNS)
+			i, ok := index[key]
+			if !ok {
+				i = len(report.Clusters)
+				index[key] = i
+				set := best.Set
+				if q := r.Results[r.BestQUICPreset]; q != nil && q.Set != nil && r.BestQUICPreset != quicBaselinePreset {
+					// Strategy for TCP, UDP from the QUIC phase
+					merged := *best.Set
+					merged.UDP = q.Set.UDP
+					set = &merged
+				}
+				report.Clusters = append(report.Clusters, DomainCluster{Preset: r.BestPreset, QUICPreset: r.BestQUICPreset, Set: set})
+			}
+			report.Clusters[i].Domains = append(report.Clusters[i].Domains, domain)
+			report.Clusters[i].AvgSpeed += r.BestSpeed
+		}
+	}
+
+	for i := range report.Clusters {
+		c := &report.Clusters[i]
+		c.AvgSpeed /= float64(len(c.Domains))
+		c.Set = suggestedSet(c.Set, c.Preset, c.Domains)
+	}
+
+	sort.SliceStable(report.Clusters, func(i, j int) bool {
+		return len(report.Clusters[i].Domains) > len(report.Clusters[j].Domains)
+	})
+
+	return report
+}
+
+func dnsKey(dns config.DNSConfig) string {
+	if !dns.Enabled {
+		return ""
+	}
+	return fmt.Sprintf("%s/%t", dns.TargetDNS, dns.FragmentQuery)
+}
+
+// suggestedSet copies the strategy of the tested set and targets domains
+func suggestedSet(tested *config.SetConfig, preset string, domains []string) *config.SetConfig {
+	set := *tested
+	set.Id = ""
+	set.Name = preset
+	set.Enabled = true
+	set.Targets = config.NewSetConfig().Targets
+	set.Targets.SNIDomains = append([]string{}, domains...)
+	set.Targets.DomainsToMatch = append([]string{}, domains...)
+	return &set
+}
+
+func (bs *BatchDiscoverySuite) logReport() {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	r := bs.Report
+	log.DiscoveryLogf("═══════════════════════════════════════")
+	log.DiscoveryLogf("Batch discovery complete: %d domains in %v", len(r.Domains), time.Since(bs.StartTime).Round(time.Second))
+	for _, c := range r.Clusters {
+		log.DiscoveryLogf("  %s (%.2f KB/s): %s", c.Preset, c.AvgSpeed/1024, strings.Join(c.Domains, ", "))
+	}
+	if len(r.NoBypass) > 0 {
+		log.DiscoveryLogf("  no bypass needed: %s", strings.Join(r.NoBypass, ", "))
+	}
+	if len(r.Failed) > 0 {
+		log.DiscoveryLogf("  no working config: %s", strings.Join(r.Failed, ", "))
+	}
+	log.DiscoveryLogf("═══════════════════════════════════════")
+}
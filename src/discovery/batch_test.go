@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestBatchDomains(t *testing.T) {
+	inputs := []string{
+		"youtube.com",
+		"full:www.youtube.com",
+		"domain:googlevideo.com",
+		"keyword:youtube",
+		"regexp:^yt[0-9]+\\.ggpht\\.com$",
+		"https://discord.com/app",
+		"DISCORD.COM",
+		"localhost",
+	}
+
+	got := BatchDomains(inputs, 0)
+	want := []string{"youtube.com", "googlevideo.com", "https://discord.com/app"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := BatchDomains(inputs, 2); len(got) != 2 {
+		t.Errorf("limit not applied: %v", got)
+	}
+}
+
+func TestBuildBatchReport(t *testing.T) {
+	tested := func(dns config.DNSConfig) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Fragmentation.Strategy = "tcp"
+		set.Targets.IpsToMatch = []string{"1.2.3.4/32"}
+		set.DNS = dns
+		return &set
+	}
+	result := func(domain, preset string, speed float64, set *config.SetConfig) *DomainDiscoveryResult {
+		return &DomainDiscoveryResult{
+			Domain:      domain,
+			BestPreset:  preset,
+			BestSpeed:   speed,
+			BestSuccess: speed > 0,
+			Results: map[string]*DomainPresetResult{
+				preset: {PresetName: preset, Status: CheckStatusComplete, Speed: speed, Set: set},
+			},
+		}
+	}
+
+	fragDNS := config.DNSConfig{Enabled: true, TargetDNS: "9.9.9.9", FragmentQuery: true}
+	results := map[string]*DomainDiscoveryResult{
+		"a.com": result("a.com", "tcp-frag", 100, tested(config.DNSConfig{})),
+		"b.com": result("b.com", "tcp-frag", 300, tested(config.DNSConfig{})),
+		"c.com": result("c.com", "tcp-frag", 200, tested(fragDNS)),
+		"d.com": result("d.com", "no-bypass", 500, nil),
+		"e.com": {Domain: "e.com", Results: map[string]*DomainPresetResult{}},
+	}
+	inputs := []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com"}
+
+	report := buildBatchReport(inputs, 1000, results)
+
+	if !slices.Equal(report.Domains, []string{"a.com", "b.com", "c.com", "d.com", "e.com"}) {
+		t.Errorf("unexpected domains %v", report.Domains)
+	}
+	if !slices.Equal(report.NoBypass, []string{"d.com"}) {
+		t.Errorf("unexpected no-bypass %v", report.NoBypass)
+	}
+	if !slices.Equal(report.Failed, []string{"e.com"}) {
+		t.Errorf("unexpected failed %v", report.Failed)
+	}
+	if len(report.Clusters) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(report.Clusters))
+	}
+
+	c := report.Clusters[0]
+	if !slices.Equal(c.Domains, []string{"a.com", "b.com"}) || c.AvgSpeed != 200 {
+		t.Errorf("unexpected first cluster %+v", c)
+	}
+	if c.Set.Fragmentation.Strategy != "tcp" || !c.Set.Enabled {
+		t.Error("suggested set must keep the tested strategy")
+	}
+	if !slices.Equal(c.Set.Targets.SNIDomains, c.Domains) || len(c.Set.Targets.IpsToMatch) != 0 {
+		t.Errorf("suggested set must only target the cluster domains: %+v", c.Set.Targets)
+	}
+	if report.Clusters[1].Set.DNS != fragDNS {
+		t.Error("domains needing a DNS bypass must be clustered apart")
+	}
+
+	t.Run("quic strategy", func(t *testing.T) {
+		quicSet := config.NewSetConfig()
+		quicSet.UDP = quicConfig(6, 64, "checksum", true, 0).UDP
+		g := result("g.com", "tcp-frag", 100, tested(config.DNSConfig{}))
+		g.BestQUICPreset = "quic-fake-checksum"
+		g.Results["quic-fake-checksum"] = &DomainPresetResult{Status: CheckStatusComplete, Set: &quicSet}
+		results["g.com"] = g
+
+		report := buildBatchReport([]string{"a.com", "g.com"}, 1000, results)
+		if len(report.Clusters) != 2 {
+			t.Fatalf("domains needing a QUIC strategy must be clustered apart, got %d clusters", len(report.Clusters))
+		}
+		for _, c := range report.Clusters {
+			if c.Domains[0] != "g.com" {
+				continue
+			}
+			if c.QUICPreset != "quic-fake-checksum" || c.Set.UDP.FakingStrategy != "checksum" {
+				t.Errorf("suggested set must carry the QUIC strategy: %+v", c)
+			}
+			if c.Set.Fragmentation.Strategy != "tcp" {
+				t.Error("suggested set must keep the TCP strategy")
+			}
+		}
+	})
+}
@@ -92,6 +92,28 @@ func (ds *DiscoverySuite) RunDiscovery() {
 
 	ds.networkBaseline = ds.measureNetworkBaseline()
 
+	ds.discoverDomain()
+	ds.clearProbeConfig()
+	ds.finalize()
+	ds.logDiscoverySummary()
+}
+
+// discoverDomain runs the DNS check and the strategy phases for ds.Domain.
+// ds.cfg and ds.networkBaseline must be set.
+func (ds *DiscoverySuite) discoverDomain() {
+	ds.discoverTCP()
+
+	select {
+	case <-ds.cancel:
+		return
+	default:
+	}
+
+	ds.setPhase(PhaseQUIC)
+	ds.runQUICPhase()
+}
+
+func (ds *DiscoverySuite) discoverTCP() {
 	log.DiscoveryLogf("Starting discovery for domain: %s", ds.Domain)
 
 	ds.setPhase(PhaseDNS)
@@ -142,9 +164,6 @@ func (ds *DiscoverySuite) RunDiscovery() {
 			ds.CheckSuite.mu.Unlock()
 
 			log.DiscoveryLogf("Baseline succeeded for %s - no DPI bypass needed", ds.Domain)
-			ds.restoreConfig()
-			ds.finalize()
-			ds.logDiscoverySummary()
 			return
 		}
 
@@ -159,9 +178,6 @@ func (ds *DiscoverySuite) RunDiscovery() {
 
 		if len(workingFamilies) == 0 {
 			log.Warnf("No working bypass strategies found for %s", ds.Domain)
-			ds.restoreConfig()
-			ds.finalize()
-			ds.logDiscoverySummary()
 			return
 		}
 	}
@@ -180,9 +196,6 @@ func (ds *DiscoverySuite) RunDiscovery() {
 	}
 
 	ds.determineBest(baselineSpeed)
-	ds.restoreConfig()
-	ds.finalize()
-	ds.logDiscoverySummary()
 }
 
 func (ds *DiscoverySuite) runPhase1(presets []ConfigPreset) ([]StrategyFamily, float64, bool) {
@@ -233,6 +246,13 @@ func (ds *DiscoverySuite) runPhase1(presets []ConfigPreset) ([]StrategyFamily, f
 }
 
 func (ds *DiscoverySuite) detectWorkingPayloads(presets []ConfigPreset) {
+	if ds.hasWorkingPayload() {
+		// Known from the domains tested before in the same batch
+		log.DiscoveryLogf("  Reusing known payload variants")
+		ds.selectBestPayload()
+		return
+	}
+
 	log.DiscoveryLogf("  Testing payload variants...")
 
 	var payload1Preset, payload2Preset *ConfigPreset
@@ -339,15 +359,7 @@ func (ds *DiscoverySuite) testPresetWithBestPayload(preset ConfigPreset) CheckRe
 		ds.CheckSuite.mu.Unlock()
 	}()
 
-	hasWorkingPayload := false
-	for _, pr := range ds.workingPayloads {
-		if pr.Works {
-			hasWorkingPayload = true
-			break
-		}
-	}
-
-	if hasWorkingPayload {
+	if ds.hasWorkingPayload() {
 		return ds.testPresetWithPayload(preset, ds.bestPayload)
 	}
 
@@ -366,6 +378,15 @@ func (ds *DiscoverySuite) testPresetWithBestPayload(preset ConfigPreset) CheckRe
 	return result1
 }
 
+func (ds *DiscoverySuite) hasWorkingPayload() bool {
+	for _, pr := range ds.workingPayloads {
+		if pr.Works {
+			return true
+		}
+	}
+	return false
+}
+
 func (ds *DiscoverySuite) testPresetWithPayload(preset ConfigPreset, payloadType int) CheckResult {
 	modifiedPreset := preset
 	modifiedPreset.Config.Faking.SNIType = payloadType
@@ -655,7 +676,7 @@ func (ds *DiscoverySuite) testPresetInternal(preset ConfigPreset) CheckResult {
 
 	testConfig := ds.buildTestConfig(preset)
 
-	if err := ds.pool.UpdateConfig(testConfig); err != nil {
+	if err := ds.pool.SetProbeConfig(testConfig); err != nil {
 		log.DiscoveryLogf("    → FAILED (config error: %v)", err)
 		return CheckResult{
 			Domain: ds.Domain,
@@ -693,6 +714,31 @@ func (ds *DiscoverySuite) fetchWithTimeout(timeout time.Duration) CheckResult {
 		return ds.fetchWithTimeoutUsingIP(timeout, "")
 	}
 
+	allIPs := ds.targetIPs()
+
+	for _, ip := range allIPs {
+		result := ds.fetchWithTimeoutUsingIP(timeout, ip)
+		if result.Status == CheckStatusComplete {
+			log.Tracef("Success with IP %s", ip)
+			return result
+		}
+		log.Tracef("IP %s failed, trying next", ip)
+	}
+
+	if len(allIPs) > 0 {
+		return CheckResult{
+			Domain: ds.Domain,
+			Status: CheckStatusFailed,
+			Error:  fmt.Sprintf("all %d IPs failed", len(allIPs)),
+		}
+	}
+
+	return ds.fetchWithTimeoutUsingIP(timeout, "")
+}
+
+// targetIPs lists the addresses of ds.Domain found by the DNS phase and a
+// fresh lookup, fresh ones first
+func (ds *DiscoverySuite) targetIPs() []string {
 	var allIPs []string
 	if ds.dnsResult != nil {
 		allIPs = append(allIPs, ds.dnsResult.ExpectedIPs...)
@@ -727,24 +773,7 @@ func (ds *DiscoverySuite) fetchWithTimeout(timeout time.Duration) CheckResult {
 		}
 	}
 
-	for _, ip := range allIPs {
-		result := ds.fetchWithTimeoutUsingIP(timeout, ip)
-		if result.Status == CheckStatusComplete {
-			log.Tracef("Success with IP %s", ip)
-			return result
-		}
-		log.Tracef("IP %s failed, trying next", ip)
-	}
-
-	if len(allIPs) > 0 {
-		return CheckResult{
-			Domain: ds.Domain,
-			Status: CheckStatusFailed,
-			Error:  fmt.Sprintf("all %d IPs failed", len(allIPs)),
-		}
-	}
-
-	return ds.fetchWithTimeoutUsingIP(timeout, "")
+	return allIPs
 }
 
 func (ds *DiscoverySuite) fetchWithTimeoutUsingIP(timeout time.Duration, ip string) CheckResult {
@@ -771,16 +800,16 @@ func (ds *DiscoverySuite) fetchWithTimeoutUsingIP(timeout time.Duration, ip stri
 			}
 			directAddr := net.JoinHostPort(ip, port)
 			log.Tracef("DNS bypass: connecting to %s instead of %s", directAddr, addr)
-			return (&net.Dialer{
+			return probeDialer{
 				Timeout:   timeout / 2,
 				KeepAlive: timeout,
-			}).DialContext(ctx, network, directAddr)
+			}.DialContext(ctx, network, directAddr)
 		}
 	} else {
-		transport.DialContext = (&net.Dialer{
+		transport.DialContext = probeDialer{
 			Timeout:   timeout / 2,
 			KeepAlive: timeout,
-		}).DialContext
+		}.DialContext
 	}
 
 	client := &http.Client{
@@ -973,6 +1002,9 @@ func (ds *DiscoverySuite) buildTestConfig(preset ConfigPreset) *config.Config {
 	mainSet.Fragmentation = preset.Config.Fragmentation
 	mainSet.Faking = preset.Config.Faking
 	mainSet.DNS = ds.cfg.MainSet.DNS
+	if ds.dnsBypass != nil {
+		mainSet.DNS = *ds.dnsBypass
+	}
 
 	if mainSet.TCP.WinMode == "" {
 		mainSet.TCP.WinMode = config.ConfigOff
@@ -988,7 +1020,7 @@ func (ds *DiscoverySuite) buildTestConfig(preset ConfigPreset) *config.Config {
 		mainSet.Faking.SNIMutation.FakeSNIs = []string{}
 	}
 
-	if preset.Name == "no-bypass" {
+	if preset.Name == "no-bypass" || preset.Name == quicBaselinePreset {
 		mainSet.Enabled = false
 	} else {
 		mainSet.Enabled = true
@@ -1098,11 +1130,9 @@ func (ds *DiscoverySuite) finalize() {
 	}()
 }
 
-func (ds *DiscoverySuite) restoreConfig() {
-	log.DiscoveryLogf("Restoring original configuration")
-	if err := ds.pool.UpdateConfig(ds.cfg); err != nil {
-		log.DiscoveryLogf("Failed to restore original configuration: %v", err)
-	}
+func (ds *DiscoverySuite) clearProbeConfig() {
+	log.DiscoveryLogf("Clearing probe configuration")
+	ds.pool.ClearProbeConfig()
 }
 
 func (ds *DiscoverySuite) logDiscoverySummary() {
@@ -98,7 +98,7 @@ func (ds *DiscoverySuite) applyDNSConfig(dnsResult *DNSDiscoveryResult) {
 		return
 	}
 
-	ds.cfg.MainSet.DNS = config.DNSConfig{
+	ds.dnsBypass = &config.DNSConfig{
 		Enabled:       true,
 		TargetDNS:     dnsResult.BestServer,
 		FragmentQuery: dnsResult.NeedsFragment,
@@ -416,7 +416,7 @@ func (p *DNSProber) testDNS(ctx context.Context, server string, fragmented bool,
 }
 
 func (p *DNSProber) testIPServesDomain(ctx context.Context, ip string) bool {
-	dialer := &net.Dialer{Timeout: p.timeout / 2}
+	dialer := probeDialer{Timeout: p.timeout / 2}
 	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, "443"))
 	if err != nil {
 		return false
@@ -443,18 +443,18 @@ func (p *DNSProber) testDNSWithFragment(server string, expectedIP string) DNSPro
 		ExpectedIP: expectedIP,
 	}
 
-	// Apply DNS config to pool temporarily
+	// Apply DNS config to the probe flows temporarily
 	testCfg := p.buildDNSTestConfig(server, true)
-	if err := p.pool.UpdateConfig(testCfg); err != nil {
+	if err := p.pool.SetProbeConfig(testCfg); err != nil {
 		return result
 	}
-	defer p.pool.UpdateConfig(p.cfg) // Restore
+	defer p.pool.ClearProbeConfig()
 
 	time.Sleep(time.Duration(p.cfg.System.Checker.ConfigPropagateMs) * time.Millisecond)
 
 	// Now DNS queries should be fragmented via NFQ
 	start := time.Now()
-	ips, err := net.LookupIP(p.domain)
+	ips, err := probeResolver(p.timeout).LookupIP(context.Background(), "ip", p.domain)
 	result.Latency = time.Since(start)
 
 	if err != nil || len(ips) == 0 {
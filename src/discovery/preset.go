@@ -1404,6 +1404,119 @@ func GetCombinationPresets(workingFamilies []StrategyFamily, bestParams map[Stra
 	return presets
 }
 
+// quicBaselinePreset is the QUIC counterpart of "no-bypass"
+const quicBaselinePreset = "quic-no-bypass"
+
+// GetQUICPresets returns the UDP strategies tried against a QUIC handshake.
+// The TCP side of each preset is left at its defaults.
+func GetQUICPresets() []ConfigPreset {
+	baseline := baselineConfig()
+	baseline.UDP.DPortFilter = "443"
+
+	presets := []ConfigPreset{
+		{
+			Name:        quicBaselinePreset,
+			Description: "No bypass techniques - test raw QUIC handshake",
+			Family:      FamilyNone,
+			Phase:       PhaseQUIC,
+			Priority:    0,
+			Config:      baseline,
+		},
+		{
+			Name:        "quic-frag",
+			Description: "Split the Initial in the middle of the SNI with IP fragments",
+			Family:      FamilyQUIC,
+			Phase:       PhaseQUIC,
+			Priority:    1,
+			Config:      quicConfig(0, 0, "none", false, 0),
+		},
+		{
+			Name:        "quic-frag-reverse",
+			Description: "IP fragments of the Initial sent in reverse order",
+			Family:      FamilyQUIC,
+			Phase:       PhaseQUIC,
+			Priority:    2,
+			Config:      quicConfig(0, 0, "none", true, 0),
+		},
+	}
+
+	for _, count := range []int{1, 6} {
+		for _, length := range []int{64, 1200} {
+			presets = append(presets, ConfigPreset{
+				Name:        formatName("quic-fake%d-len%d", count, length),
+				Description: formatName("%d fake datagrams of %d bytes before the Initial", count, length),
+				Family:      FamilyQUIC,
+				Phase:       PhaseQUIC,
+				Priority:    10 + count + length/64,
+				Config:      quicConfig(count, length, "none", true, 0),
+			})
+		}
+	}
+
+	presets = append(presets,
+		ConfigPreset{
+			Name:        "quic-fake-checksum",
+			Description: "Fake datagrams with a broken UDP checksum",
+			Family:      FamilyQUIC,
+			Phase:       PhaseQUIC,
+			Priority:    30,
+			Config:      quicConfig(6, 64, "checksum", true, 0),
+		},
+		ConfigPreset{
+			Name:        "quic-fake-delay",
+			Description: "Fake datagrams with a delay between the Initial fragments",
+			Family:      FamilyQUIC,
+			Phase:       PhaseQUIC,
+			Priority:    31,
+			Config:      quicConfig(6, 64, "none", true, 20),
+		},
+	)
+
+	for _, strategy := range []string{"ttl", "checksum"} {
+		set := quicConfig(6, 0, strategy, true, 0)
+		set.UDP.FakeQUIC = true
+		set.UDP.FakeSNI = "www.google.com"
+		presets = append(presets, ConfigPreset{
+			Name:        "quic-decoy-" + strategy,
+			Description: formatName("Fake Initials with a decoy SNI (%s)", strategy),
+			Family:      FamilyQUIC,
+			Phase:       PhaseQUIC,
+			Priority:    35,
+			Config:      set,
+		})
+	}
+
+	reorder := quicConfig(0, 0, "none", false, 0)
+	reorder.UDP.QUICSplit = "reorder"
+	presets = append(presets, ConfigPreset{
+		Name:        "quic-reorder",
+		Description: "Re-encrypted Initial with the ClientHello cut at the SNI",
+		Family:      FamilyQUIC,
+		Phase:       PhaseQUIC,
+		Priority:    40,
+		Config:      reorder,
+	})
+
+	return presets
+}
+
+func quicConfig(fakeCount, fakeLen int, strategy string, reverse bool, delay int) config.SetConfig {
+	set := baseConfig()
+	set.UDP = config.UDPConfig{
+		Mode:           "fake",
+		FakeSeqLength:  fakeCount,
+		FakeLen:        fakeLen,
+		FakingStrategy: strategy,
+		DPortFilter:    "443",
+		FilterQUIC:     "parse",
+		FilterSTUN:     true,
+		ConnBytesLimit: 8,
+		Seg2Delay:      delay,
+	}
+	set.Fragmentation.ReverseOrder = reverse
+	return set
+}
+
 // Helper functions
 
 func baseConfig() config.SetConfig {
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+// probeDialAttempts bounds how many source ports are tried when the next ones
+// are still held by earlier probe connections
+const probeDialAttempts = 16
+
+var probePortSeq atomic.Uint32
+
+func nextProbePort() int {
+	n := config.ProbePortMax - config.ProbePortMin + 1
+	return config.ProbePortMin + int(probePortSeq.Add(1)%uint32(n))
+}
+
+// probeDialer dials from the discovery probe source port range with the probe
+// mark, so that the queue workers handle the connection with the preset
+// under test
+type probeDialer struct {
+	Timeout   time.Duration
+	KeepAlive time.Duration
+}
+
+func (d probeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var err error
+	for range probeDialAttempts {
+		port := nextProbePort()
+		dialer := net.Dialer{
+			Timeout:   d.Timeout,
+			KeepAlive: d.KeepAlive,
+			Control:   probeSocket,
+		}
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{Port: port}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{Port: port}
+		}
+
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, addr)
+		if err == nil || (!errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL)) {
+			return conn, err
+		}
+	}
+	return nil, err
+}
+
+// probeResolver resolves through the system DNS servers from probe ports
+func probeResolver(timeout time.Duration) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     probeDialer{Timeout: timeout}.DialContext,
+	}
+}
+
+// probeSocket lets probes reuse source ports and marks their packets for
+// the probe firewall rules
+func probeSocket(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		if opErr == nil {
+			opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, config.ProbeMark)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
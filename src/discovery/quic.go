@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/quic"
+)
+
+// runQUICPhase looks for a UDP strategy that lets a QUIC handshake with the
+// domain complete. It runs after the TCP phases whether they found a
+// strategy or not, since DPI often treats QUIC differently.
+func (ds *DiscoverySuite) runQUICPhase() {
+	presets := GetQUICPresets()
+
+	ds.CheckSuite.mu.Lock()
+	ds.TotalChecks += len(presets)
+	ds.CheckSuite.mu.Unlock()
+
+	log.DiscoveryLogf("Phase QUIC: Testing %d UDP strategies", len(presets))
+
+	baseline := ds.testQUICPreset(presets[0])
+	if baseline.Status == CheckStatusComplete {
+		log.DiscoveryLogf("  QUIC handshake works without bypass")
+		ds.setBestQUIC(presets[0].Name)
+		return
+	}
+
+	var best string
+	var bestRTT time.Duration
+	for _, preset := range presets[1:] {
+		select {
+		case <-ds.cancel:
+			return
+		default:
+		}
+
+		result := ds.testQUICPreset(preset)
+		if result.Status == CheckStatusComplete && (best == "" || result.Duration < bestRTT) {
+			best, bestRTT = preset.Name, result.Duration
+		}
+	}
+
+	if best == "" {
+		log.DiscoveryLogf("  No UDP strategy completed a QUIC handshake")
+		return
+	}
+	log.DiscoveryLogf("★ Best QUIC: %s (%v)", best, bestRTT.Round(time.Millisecond))
+	ds.setBestQUIC(best)
+}
+
+func (ds *DiscoverySuite) setBestQUIC(preset string) {
+	ds.CheckSuite.mu.Lock()
+	ds.domainResult.BestQUICPreset = preset
+	ds.CheckSuite.mu.Unlock()
+}
+
+// testQUICPreset applies preset to probe flows and tries a QUIC handshake.
+// The result has no speed so it never competes with the TCP presets.
+func (ds *DiscoverySuite) testQUICPreset(preset ConfigPreset) CheckResult {
+	defer func() {
+		ds.CheckSuite.mu.Lock()
+		ds.CompletedChecks++
+		ds.CheckSuite.mu.Unlock()
+	}()
+
+	log.DiscoveryLogf("  Testing '%s'...", preset.Name)
+
+	testConfig := ds.buildTestConfig(preset)
+	if err := ds.pool.SetProbeConfig(testConfig); err != nil {
+		result := CheckResult{Domain: ds.Domain, Status: CheckStatusFailed, Error: err.Error()}
+		log.DiscoveryLogf("    → FAILED (config error: %v)", err)
+		ds.storeResult(preset, result)
+		return result
+	}
+
+	time.Sleep(time.Duration(ds.cfg.System.Checker.ConfigPropagateMs) * time.Millisecond)
+
+	result := ds.probeQUIC(time.Duration(ds.cfg.System.Checker.DiscoveryTimeoutSec) * time.Second)
+	result.Set = testConfig.MainSet
+
+	if result.Status == CheckStatusComplete {
+		log.DiscoveryLogf("    → OK (handshake in %v)", result.Duration.Round(time.Millisecond))
+	} else {
+		log.DiscoveryLogf("    → FAILED (%s)", result.Error)
+	}
+	ds.storeResult(preset, result)
+	return result
+}
+
+// probeQUIC tries a QUIC handshake with each address of the domain in turn
+// from a probe port
+func (ds *DiscoverySuite) probeQUIC(timeout time.Duration) CheckResult {
+	result := CheckResult{Domain: ds.Domain, Status: CheckStatusFailed}
+
+	ips := ds.targetIPs()
+	if len(ips) == 0 {
+		result.Error = "no addresses to probe"
+		return result
+	}
+
+	for _, ip := range ips {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		res, err := ds.handshakeQUIC(ctx, ip)
+		cancel()
+		if err == nil {
+			result.Status = CheckStatusComplete
+			result.Duration = res.RTT
+			return result
+		}
+		log.Tracef("QUIC handshake with %s failed: %v", ip, err)
+		result.Error = err.Error()
+	}
+
+	if len(ips) > 1 {
+		result.Error = fmt.Sprintf("all %d IPs failed", len(ips))
+	}
+	return result
+}
+
+func (ds *DiscoverySuite) handshakeQUIC(ctx context.Context, ip string) (quic.HandshakeResult, error) {
+	conn, err := probeDialer{Timeout: 5 * time.Second}.DialContext(ctx, "udp", net.JoinHostPort(ip, "443"))
+	if err != nil {
+		return quic.HandshakeResult{}, err
+	}
+	defer conn.Close()
+
+	return quic.Handshake(ctx, conn, ds.Domain)
+}
@@ -65,5 +65,6 @@ func (ts *CheckSuite) GetSnapshot() *CheckSuite {
 		CheckURL:               ts.CheckURL,
 		DomainDiscoveryResults: ts.DomainDiscoveryResults,
 		CurrentPhase:           ts.CurrentPhase,
+		Report:                 ts.Report,
 	}
 }
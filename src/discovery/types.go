@@ -26,6 +26,7 @@ const (
 	PhaseOptimize    DiscoveryPhase = "optimization"
 	PhaseCombination DiscoveryPhase = "combination"
 	PhaseDNS         DiscoveryPhase = "dns_detection"
+	PhaseQUIC        DiscoveryPhase = "quic"
 )
 
 type StrategyFamily string
@@ -49,6 +50,7 @@ const (
 	FamilyFirstByte StrategyFamily = "firstbyte"
 	FamilyCombo     StrategyFamily = "combo"
 	FamilyHybrid    StrategyFamily = "hybrid"
+	FamilyQUIC      StrategyFamily = "quic"
 )
 
 type CheckResult struct {
@@ -77,6 +79,7 @@ type CheckSuite struct {
 	CheckURL               string                            `json:"check_url"`
 	Domain                 string                            `json:"domain"`
 	CurrentPhase           DiscoveryPhase                    `json:"current_phase,omitempty"`
+	Report                 *BatchReport                      `json:"report,omitempty"`
 	mu                     sync.RWMutex                      `json:"-"`
 	cancel                 chan struct{}                     `json:"-"`
 }
@@ -104,6 +107,32 @@ type DomainDiscoveryResult struct {
 	BaselineSpeed float64                        `json:"baseline_speed,omitempty"`
 	Improvement   float64                        `json:"improvement,omitempty"`
 	DNSResult     *DNSDiscoveryResult            `json:"dns_result,omitempty"`
+	// Fastest UDP preset completing a QUIC handshake, empty if none did
+	BestQUICPreset string `json:"best_quic_preset,omitempty"`
+}
+
+// DomainCluster groups the domains of a batch that share the same best preset
+type DomainCluster struct {
+	Preset     string            `json:"preset"`
+	QUICPreset string            `json:"quic_preset,omitempty"`
+	Domains    []string          `json:"domains"`
+	AvgSpeed   float64           `json:"avg_speed"`
+	Set        *config.SetConfig `json:"set"` // suggested set targeting Domains
+}
+
+type BatchReport struct {
+	Domains       []string        `json:"domains"`
+	BaselineSpeed float64         `json:"baseline_speed"`
+	Clusters      []DomainCluster `json:"clusters"`
+	NoBypass      []string        `json:"no_bypass"` // reachable without any strategy
+	Failed        []string        `json:"failed"`    // no working preset found
+}
+
+type BatchDiscoverySuite struct {
+	*CheckSuite
+	Domains []string
+
+	pool *nfq.Pool
 }
 
 type ConfigPreset struct {
@@ -153,4 +182,6 @@ type DiscoverySuite struct {
 	bestPayload     int
 
 	dnsResult *DNSDiscoveryResult
+	// DNS bypass found by the DNS phase, used instead of the main set's
+	dnsBypass *config.DNSConfig
 }
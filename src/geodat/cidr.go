@@ -0,0 +1,117 @@
+package geodat
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cidrExtensions are the plain text IP lists recognized as geoip sources
+var cidrExtensions = map[string]struct{}{
+	".txt":  {},
+	".lst":  {},
+	".list": {},
+	".cidr": {},
+}
+
+// cidrFiles maps the categories of a plain text geoip source to their
+// files. A directory holds one category per file and a single file is a
+// category of its own, both named after the file without extension.
+func cidrFiles(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	add := func(file string) {
+		ext := filepath.Ext(file)
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ext))
+		files[name] = file
+	}
+
+	if !info.IsDir() {
+		add(path)
+		return files, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if _, ok := cidrExtensions[strings.ToLower(filepath.Ext(e.Name()))]; ok {
+			add(filepath.Join(path, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+func loadCIDRCategories(path string, categories []string) ([]string, error) {
+	files, err := cidrFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []string{}
+	for _, c := range categories {
+		file, ok := files[strings.ToLower(strings.TrimSpace(c))]
+		if !ok {
+			continue
+		}
+		list, err := readCIDRFile(file)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, list...)
+	}
+	return ips, nil
+}
+
+func listCIDRCategories(path string) ([]string, error) {
+	files, err := cidrFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(files))
+	for t := range files {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// readCIDRFile reads one IP or CIDR per line, skipping comments and lines
+// that are neither
+func readCIDRFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ips := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if p, err := netip.ParsePrefix(fields[0]); err == nil {
+			ips = append(ips, p.Masked().String())
+		} else if a, err := netip.ParseAddr(fields[0]); err == nil {
+			ips = append(ips, netip.PrefixFrom(a, a.BitLen()).String())
+		}
+	}
+	return ips, scanner.Err()
+}
@@ -14,8 +14,18 @@ import (
 
 func UnpackGeoSite(args *UnpackArgs) error {
 	filePath, suffixes := args.File, args.Filters
+	filters := attrFilters(suffixes)
 
 	save := func(suffix string, domains []*v2data.Domain) error {
+		if len(filters) > 0 {
+			kept := make([]*v2data.Domain, 0, len(domains))
+			for _, d := range domains {
+				if keepDomain(d, filters[suffix]) {
+					kept = append(kept, d)
+				}
+			}
+			domains = kept
+		}
 		return convertV2DomainToText(domains, os.Stdout)
 	}
 
@@ -3,6 +3,8 @@ package geodat
 import (
 	"net/netip"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/daniellavrushin/b4/log"
 	"github.com/urlesistiana/v2dat/v2data"
@@ -19,9 +21,13 @@ func LoadDomainsFromCategories(geodataPath string, categories []string) ([]strin
 	}
 
 	allDomains := []string{}
+	filters := attrFilters(categories)
 
 	save := func(tag string, domainList []*v2data.Domain) error {
 		for _, d := range domainList {
+			if !keepDomain(d, filters[tag]) {
+				continue
+			}
 			domain := extractDomainValue(d)
 			if domain != "" {
 				allDomains = append(allDomains, domain)
@@ -47,6 +53,13 @@ func LoadIpsFromCategories(geodataPath string, categories []string) ([]string, e
 		return []string{}, nil
 	}
 
+	switch geoipFormat(geodataPath) {
+	case formatMMDB:
+		return loadMMDBCategories(geodataPath, categories)
+	case formatCIDR:
+		return loadCIDRCategories(geodataPath, categories)
+	}
+
 	allIps := []string{}
 
 	save := func(tag string, geo *v2data.GeoIP) error {
@@ -71,16 +84,38 @@ func LoadIpsFromCategories(geodataPath string, categories []string) ([]string, e
 	return allIps, nil
 }
 
+// Formats of geoip sources
+const (
+	formatDat  = iota // v2fly geoip.dat
+	formatMMDB        // MaxMind DB, categories are country codes and "asn:N"
+	formatCIDR        // plain text IP lists, a file or a directory of them
+)
+
+// geoipFormat tells the format of a geoip source. MaxMind DB files are
+// recognized by their metadata whatever they are named.
+func geoipFormat(path string) int {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return formatCIDR
+	}
+	if isMMDB(path) {
+		return formatMMDB
+	}
+	if _, ok := cidrExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return formatCIDR
+	}
+	return formatDat
+}
+
+// extractDomainValue returns the domain as a matcher rule, prefixed with
+// its geosite type unless it is a plain domain rule
 func extractDomainValue(d *v2data.Domain) string {
 	switch d.Type {
 	case v2data.Domain_Plain:
-		return d.Value
+		return "keyword:" + d.Value
 	case v2data.Domain_Regex:
 		return "regexp:" + d.Value
 	case v2data.Domain_Full:
-		return d.Value
-	case v2data.Domain_Domain:
-		return d.Value
+		return "full:" + d.Value
 	default:
 		return d.Value
 	}
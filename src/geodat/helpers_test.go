@@ -0,0 +1,308 @@
+package geodat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/urlesistiana/v2dat/v2data"
+	"google.golang.org/protobuf/proto"
+)
+
+func writeGeosite(t *testing.T) string {
+	t.Helper()
+	list := &v2data.GeoSiteList{Entry: []*v2data.GeoSite{{
+		CountryCode: "GOOGLE",
+		Domain: []*v2data.Domain{
+			{Type: v2data.Domain_Domain, Value: "google.com"},
+			{Type: v2data.Domain_Full, Value: "www.google.cn", Attribute: []*v2data.Domain_Attribute{{Key: "cn"}}},
+			{Type: v2data.Domain_Plain, Value: "google", Attribute: []*v2data.Domain_Attribute{{Key: "ads"}}},
+			{Type: v2data.Domain_Regex, Value: `^g[0-9]+\.cn$`, Attribute: []*v2data.Domain_Attribute{{Key: "cn"}, {Key: "ads"}}},
+		},
+	}}}
+	data, err := proto.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "geosite.dat")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDomainsFromCategories(t *testing.T) {
+	path := writeGeosite(t)
+
+	tests := []struct {
+		name       string
+		categories []string
+		want       []string
+	}{
+		{"whole category", []string{"google"}, []string{"google.com", "full:www.google.cn", "keyword:google", `regexp:^g[0-9]+\.cn$`}},
+		{"attribute", []string{"google@cn"}, []string{"full:www.google.cn", `regexp:^g[0-9]+\.cn$`}},
+		{"all attributes", []string{"google@cn@ads"}, []string{`regexp:^g[0-9]+\.cn$`}},
+		{"either filter", []string{"google@cn", "google@ads"}, []string{"full:www.google.cn", "keyword:google", `regexp:^g[0-9]+\.cn$`}},
+		{"unknown attribute", []string{"google@none"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadDomainsFromCategories(path, tt.categories)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// mmdbPtr encodes as a pointer into the data section
+type mmdbPtr uint
+
+// mmdbRec is a search tree record of mmdbBuilder
+type mmdbRec struct {
+	kind int // 0 empty, 1 node, 2 data
+	v    int
+}
+
+// mmdbBuilder writes small MaxMind DB files with 24 bit records
+type mmdbBuilder struct {
+	ipVersion int
+	nodes     [][2]mmdbRec
+	data      bytes.Buffer
+}
+
+func newMMDBBuilder(ipVersion int) *mmdbBuilder {
+	return &mmdbBuilder{ipVersion: ipVersion, nodes: make([][2]mmdbRec, 1)}
+}
+
+func mmdbEncode(buf *bytes.Buffer, v any) {
+	ctrl := func(typ, size int) {
+		if typ <= 7 {
+			buf.WriteByte(byte(typ<<5 | size))
+		} else {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+		}
+	}
+	switch v := v.(type) {
+	case mmdbPtr:
+		buf.WriteByte(byte(1<<5 | (v>>8)&0x7))
+		buf.WriteByte(byte(v))
+	case string:
+		ctrl(2, len(v))
+		buf.WriteString(v)
+	case uint16:
+		ctrl(5, 2)
+		binary.Write(buf, binary.BigEndian, v)
+	case uint32:
+		ctrl(6, 4)
+		binary.Write(buf, binary.BigEndian, v)
+	case map[any]any:
+		ctrl(7, len(v))
+		keys := make([]any, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			mmdbEncode(buf, k)
+			mmdbEncode(buf, v[k])
+		}
+	}
+}
+
+// add stores a data record and returns its offset
+func (b *mmdbBuilder) add(v any) int {
+	off := b.data.Len()
+	mmdbEncode(&b.data, v)
+	return off
+}
+
+func (b *mmdbBuilder) insert(p netip.Prefix, r mmdbRec) {
+	ip := p.Addr().As16()
+	depth := p.Bits()
+	if b.ipVersion == 4 {
+		copy(ip[:4], p.Addr().AsSlice())
+	} else if p.Addr().Is4() {
+		ip = [16]byte{}
+		copy(ip[12:], p.Addr().AsSlice())
+		depth += 96
+	}
+
+	bit := func(i int) int { return int(ip[i/8]>>(7-i%8)) & 1 }
+	node := 0
+	for i := 0; i < depth-1; i++ {
+		child := b.nodes[node][bit(i)]
+		if child.kind != 1 {
+			b.nodes = append(b.nodes, [2]mmdbRec{})
+			child = mmdbRec{kind: 1, v: len(b.nodes) - 1}
+			b.nodes[node][bit(i)] = child
+		}
+		node = child.v
+	}
+	b.nodes[node][bit(depth-1)] = r
+}
+
+// ipv4Start follows the ::/96 path of an IPv6 tree
+func (b *mmdbBuilder) ipv4Start() int {
+	node := 0
+	for i := 0; i < 96; i++ {
+		node = b.nodes[node][0].v
+	}
+	return node
+}
+
+func (b *mmdbBuilder) write(t *testing.T, name string) string {
+	t.Helper()
+	n := len(b.nodes)
+	var out bytes.Buffer
+	for _, node := range b.nodes {
+		for _, r := range node {
+			v := n
+			switch r.kind {
+			case 1:
+				v = r.v
+			case 2:
+				v = n + 16 + r.v
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(b.data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	mmdbEncode(&out, map[any]any{
+		"node_count":    uint32(n),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(b.ipVersion),
+		"database_type": "Test-Country-ASN",
+	})
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadIpsFromCategories(t *testing.T) {
+	build := func(ipVersion int) *mmdbBuilder {
+		b := newMMDBBuilder(ipVersion)
+		country := mmdbPtr(b.add("country"))
+		cloudflare := b.add(map[any]any{
+			country:                    map[any]any{"iso_code": "AU"},
+			"autonomous_system_number": uint32(13335),
+		})
+		google := b.add(map[any]any{
+			"registered_country":       map[any]any{"iso_code": "US"},
+			"autonomous_system_number": uint32(15169),
+		})
+		russia := b.add(map[any]any{country: map[any]any{"iso_code": "RU"}})
+
+		b.insert(netip.MustParsePrefix("1.1.1.0/24"), mmdbRec{kind: 2, v: cloudflare})
+		b.insert(netip.MustParsePrefix("10.0.0.0/8"), mmdbRec{kind: 2, v: russia})
+		if ipVersion == 4 {
+			b.insert(netip.MustParsePrefix("8.8.8.0/24"), mmdbRec{kind: 2, v: google})
+		} else {
+			b.insert(netip.MustParsePrefix("2001:db8::/32"), mmdbRec{kind: 2, v: google})
+			b.insert(netip.MustParsePrefix("::ffff:0:0/96"), mmdbRec{kind: 1, v: b.ipv4Start()})
+		}
+		return b
+	}
+	v4 := build(4).write(t, "country-asn.mmdb")
+	v6 := build(6).write(t, "dbip.bin")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ru.txt"), []byte("# RU\n10.0.0.0/8\n192.0.2.1 ; host\n\nnot an ip\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "Cloudflare.lst"), []byte("1.1.1.0/24\n2606:4700::/32\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.md"), []byte("1.2.3.4\n"), 0644)
+
+	tests := []struct {
+		name       string
+		path       string
+		categories []string
+		want       []string
+	}{
+		{"mmdb country", v4, []string{"AU"}, []string{"1.1.1.0/24"}},
+		{"mmdb registered country", v4, []string{"us"}, []string{"8.8.8.0/24"}},
+		{"mmdb asn and country", v4, []string{"asn:15169", "ru"}, []string{"10.0.0.0/8", "8.8.8.0/24"}},
+		{"mmdb ipv6 skips aliases", v6, []string{"au", "asn:15169"}, []string{"1.1.1.0/24", "2001:db8::/32"}},
+		{"cidr directory", dir, []string{"ru", "cloudflare"}, []string{"1.1.1.0/24", "10.0.0.0/8", "192.0.2.1/32", "2606:4700::/32"}},
+		{"cidr file", filepath.Join(dir, "ru.txt"), []string{"ru"}, []string{"10.0.0.0/8", "192.0.2.1/32"}},
+		{"cidr unknown category", dir, []string{"notes"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadIpsFromCategories(tt.path, tt.categories)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	tags, err := ListCategories(v6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"asn:13335", "asn:15169", "au", "ru", "us"}; !slices.Equal(tags, want) {
+		t.Errorf("ListCategories(mmdb) = %q, want %q", tags, want)
+	}
+	if tags, _ := ListCategories(dir); !slices.Equal(tags, []string{"cloudflare", "ru"}) {
+		t.Errorf("ListCategories(dir) = %q", tags)
+	}
+}
+
+func TestMMDBDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		section []byte
+	}{
+		{"pointer to itself", []byte{1 << 5, 0}},
+		{"map nested in itself", []byte{7<<5 | 1, 2<<5 | 1, 'k', 1 << 5, 0}},
+		{"map larger than section", []byte{7<<5 | 31, 0xff, 0xff, 0xff, 2<<5 | 1, 'k'}},
+		{"array larger than section", []byte{31, 11 - 7, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := mmdbDecode(tt.section, 0, 0); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestGeodataManagerIndexesMMDB(t *testing.T) {
+	b := newMMDBBuilder(4)
+	cloudflare := b.add(map[any]any{
+		"country":                  map[any]any{"iso_code": "AU"},
+		"autonomous_system_number": uint32(13335),
+	})
+	b.insert(netip.MustParsePrefix("1.1.1.0/24"), mmdbRec{kind: 2, v: cloudflare})
+	path := b.write(t, "country-asn.mmdb")
+
+	gm := NewGeodataManager("", path)
+	if ips, err := gm.LoadGeoipCategory("AU"); err != nil || !slices.Equal(ips, []string{"1.1.1.0/24"}) {
+		t.Fatalf("LoadGeoipCategory(AU) = %q, %v", ips, err)
+	}
+
+	// later categories come from the index
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if ips, err := gm.LoadGeoipCategory("asn:13335"); err != nil || !slices.Equal(ips, []string{"1.1.1.0/24"}) {
+		t.Errorf("LoadGeoipCategory(asn:13335) = %q, %v", ips, err)
+	}
+}
@@ -29,6 +29,10 @@ type GeodataManager struct {
 
 	categoryIps       map[string][]string // category -> IPs (cached)
 	categoryIpsCounts map[string]int      // category -> IP count (fast lookup)
+
+	mmdbMu   sync.Mutex // serializes loads from the geoip file
+	mmdb     mmdbIndex  // networks of the geoip file when it is a MaxMind DB
+	mmdbPath string     // path mmdb was loaded from
 }
 
 // NewGeodataManager creates a new geodata manager instance
@@ -58,6 +62,7 @@ func (gm *GeodataManager) UpdatePaths(geositePath, geoipPath string) {
 		gm.categoryDomainsCounts = make(map[string]int)
 		gm.categoryIps = make(map[string][]string)
 		gm.categoryIpsCounts = make(map[string]int)
+		gm.dropMMDB()
 		log.Infof("Geodata paths updated, cache cleared")
 	}
 }
@@ -69,14 +74,15 @@ func (gm *GeodataManager) LoadGeoipCategory(category string) ([]string, error) {
 		log.Tracef("Using cached domains for category: %s (%d domains)", category, len(ips))
 		return ips, nil
 	}
+	path := gm.geoipPath
 	gm.mu.RUnlock()
 
 	// Load from file
-	if gm.geoipPath == "" {
+	if path == "" {
 		return nil, log.Errorf("geoip path not configured")
 	}
 
-	ips, err := LoadIpsFromCategories(gm.geoipPath, []string{category})
+	ips, err := gm.loadGeoip(path, category)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +97,33 @@ func (gm *GeodataManager) LoadGeoipCategory(category string) ([]string, error) {
 	return ips, nil
 }
 
+// loadGeoip loads a category of the geoip file. A MaxMind DB is indexed on
+// first use rather than walked again for every category.
+func (gm *GeodataManager) loadGeoip(path, category string) ([]string, error) {
+	gm.mmdbMu.Lock()
+	defer gm.mmdbMu.Unlock()
+
+	if gm.mmdb == nil || gm.mmdbPath != path {
+		if geoipFormat(path) != formatMMDB {
+			return LoadIpsFromCategories(path, []string{category})
+		}
+		idx, err := loadMMDBIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		gm.mmdb, gm.mmdbPath = idx, path
+		log.Tracef("Indexed %d geoip categories of %s", len(idx), path)
+	}
+	return gm.mmdb.category(category), nil
+}
+
+// dropMMDB forgets the indexed geoip file
+func (gm *GeodataManager) dropMMDB() {
+	gm.mmdbMu.Lock()
+	gm.mmdb, gm.mmdbPath = nil, ""
+	gm.mmdbMu.Unlock()
+}
+
 // loads domains for a single category (uses cache if available)
 func (gm *GeodataManager) LoadGeositeCategory(category string) ([]string, error) {
 	gm.mu.RLock()
@@ -235,6 +268,17 @@ func (gm *GeodataManager) GetGeoipCategoryCounts(categories []string) (map[strin
 }
 
 func (gm *GeodataManager) ListCategories(filePath string) ([]string, error) {
+	return ListCategories(filePath)
+}
+
+// ListCategories reads the sorted tags of a geosite or geoip source
+func ListCategories(filePath string) ([]string, error) {
+	switch geoipFormat(filePath) {
+	case formatMMDB:
+		return listMMDBCategories(filePath)
+	case formatCIDR:
+		return listCIDRCategories(filePath)
+	}
 
 	log.Tracef("Listing geo dat tags from %s", filePath)
 	f, err := os.Open(filePath)
@@ -317,6 +361,7 @@ func (gm *GeodataManager) ClearCache() {
 	gm.categoryDomainsCounts = make(map[string]int)
 	gm.categoryIps = make(map[string][]string)
 	gm.categoryIpsCounts = make(map[string]int)
+	gm.dropMMDB()
 	log.Infof("Geodata cache cleared")
 }
 
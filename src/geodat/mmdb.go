@@ -0,0 +1,495 @@
+package geodat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mmdbMarker starts the metadata section at the end of a MaxMind DB file
+var mmdbMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbMetadataMaxSize bounds the tail searched for the metadata marker
+const mmdbMetadataMaxSize = 128 * 1024
+
+// mmdbMaxDepth bounds the nesting of maps, arrays and pointers, so that a
+// pointer loop fails instead of recursing forever
+const mmdbMaxDepth = 64
+
+// mmdbReader reads MaxMind DB files such as GeoLite2 and DB-IP country and
+// ASN databases. Only what is needed to enumerate networks is supported.
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	data       []byte
+}
+
+func isMMDB(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+	size := info.Size()
+	off := max(size-mmdbMetadataMaxSize, 0)
+	tail := make([]byte, size-off)
+	if _, err := f.ReadAt(tail, off); err != nil {
+		return false
+	}
+	return bytes.LastIndex(tail, mmdbMarker) != -1
+}
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := bytes.LastIndex(buf, mmdbMarker)
+	if idx == -1 {
+		return nil, fmt.Errorf("not a MaxMind DB file")
+	}
+	meta, _, err := mmdbDecode(buf[idx+len(mmdbMarker):], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata")
+	}
+
+	r := &mmdbReader{
+		buf:        buf,
+		nodeCount:  uint(mmdbUint(m["node_count"])),
+		recordSize: uint(mmdbUint(m["record_size"])),
+		ipVersion:  uint(mmdbUint(m["ip_version"])),
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(idx) {
+		return nil, fmt.Errorf("search tree exceeds file")
+	}
+	r.data = buf[treeSize+16 : idx]
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (r *mmdbReader) record(node uint, bit uint) uint {
+	b := r.buf
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return (uint(b[off+3])&0xF0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return (uint(b[off+3])&0x0F)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// networks calls fn for every network with data, passing the offset of
+// its record in the data section. IPv4 networks of IPv6 databases are
+// reported as IPv4 once, skipping the ::ffff:0:0/96 and 2002::/16 aliases.
+func (r *mmdbReader) networks(fn func(netip.Prefix, uint) error) error {
+	bits := 32
+	if r.ipVersion == 6 {
+		bits = 128
+	}
+
+	ipv4Start := r.nodeCount
+	if bits == 128 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		ipv4Start = node
+	}
+
+	type frame struct {
+		node  uint
+		depth int
+		ip    [16]byte
+	}
+	stack := []frame{{}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.node >= r.nodeCount || f.depth >= bits {
+			return fmt.Errorf("invalid search tree")
+		}
+
+		for bit := uint(0); bit < 2; bit++ {
+			ip := f.ip
+			if bit == 1 {
+				ip[f.depth/8] |= 0x80 >> (f.depth % 8)
+			}
+			next := r.record(f.node, bit)
+			depth := f.depth + 1
+
+			switch {
+			case next < r.nodeCount:
+				if next == ipv4Start && ip != [16]byte{} {
+					continue
+				}
+				stack = append(stack, frame{node: next, depth: depth, ip: ip})
+			case next == r.nodeCount:
+				// no data
+			default:
+				offset := next - r.nodeCount - 16
+				if offset >= uint(len(r.data)) {
+					return fmt.Errorf("invalid data pointer")
+				}
+				if err := fn(mmdbPrefix(ip, depth, bits), offset); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func mmdbPrefix(ip [16]byte, depth, bits int) netip.Prefix {
+	if bits == 32 {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(ip[:4])), depth)
+	}
+	if depth >= 96 && [12]byte(ip[:12]) == [12]byte{} {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(ip[12:16])), depth-96)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(ip), depth)
+}
+
+// mmdbTags returns the categories a data record belongs to: the lowercase
+// country code, falling back to the registered country, and "asn:N"
+func mmdbTags(record any) []string {
+	m, ok := record.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	var tags []string
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := m[key].(map[string]any); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				tags = append(tags, strings.ToLower(code))
+				break
+			}
+		}
+	}
+	if asn, ok := m["autonomous_system_number"]; ok {
+		if n := mmdbUint(asn); n > 0 {
+			tags = append(tags, "asn:"+strconv.FormatUint(n, 10))
+		}
+	}
+	return tags
+}
+
+// visit walks the networks of the database with the tags of each
+func (r *mmdbReader) visit(fn func(netip.Prefix, []string)) error {
+	cache := make(map[uint][]string)
+	return r.networks(func(p netip.Prefix, offset uint) error {
+		tags, ok := cache[offset]
+		if !ok {
+			record, _, err := mmdbDecode(r.data, offset, 0)
+			if err != nil {
+				return err
+			}
+			tags = mmdbTags(record)
+			cache[offset] = tags
+		}
+		fn(p, tags)
+		return nil
+	})
+}
+
+func loadMMDBCategories(path string, categories []string) ([]string, error) {
+	r, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	want := make(map[string]struct{}, len(categories))
+	for _, c := range categories {
+		want[strings.ToLower(strings.TrimSpace(c))] = struct{}{}
+	}
+
+	ips := []string{}
+	err = r.visit(func(p netip.Prefix, tags []string) {
+		for _, tag := range tags {
+			if _, ok := want[tag]; ok {
+				ips = append(ips, p.String())
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// mmdbIndex holds the networks of a database by tag
+type mmdbIndex map[string][]netip.Prefix
+
+// loadMMDBIndex walks the database once and groups its networks by tag,
+// for loading many categories of the same file
+func loadMMDBIndex(path string) (mmdbIndex, error) {
+	r, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := make(mmdbIndex)
+	err = r.visit(func(p netip.Prefix, tags []string) {
+		for _, tag := range tags {
+			idx[tag] = append(idx[tag], p)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// category returns the networks tagged with category
+func (idx mmdbIndex) category(category string) []string {
+	prefixes := idx[strings.ToLower(strings.TrimSpace(category))]
+	ips := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		ips[i] = p.String()
+	}
+	return ips
+}
+
+func listMMDBCategories(path string) ([]string, error) {
+	r, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{})
+	err = r.visit(func(_ netip.Prefix, tags []string) {
+		for _, tag := range tags {
+			set[tag] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(set))
+	for t := range set {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// mmdbDecode decodes the value at offset of section and returns it with
+// the offset following it. Pointers are relative to section. depth is the
+// number of maps, arrays and pointers the value is nested in.
+func mmdbDecode(section []byte, offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("data nested too deep")
+	}
+	typ, size, offset, err := mmdbControl(section, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == 1 {
+		target, next, err := mmdbPointer(section, size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := mmdbDecode(section, target, depth+1)
+		return v, next, err
+	}
+
+	end := offset + size
+	left := uint(len(section)) - offset
+	switch typ {
+	case 7:
+		// every entry takes at least a byte for the key and one for the
+		// value, check before allocating for them
+		if size > left/2 {
+			return nil, 0, fmt.Errorf("map exceeds section")
+		}
+	case 11:
+		if size > left {
+			return nil, 0, fmt.Errorf("array exceeds section")
+		}
+	case 14:
+		// booleans hold their value in size
+	default:
+		if end > uint(len(section)) {
+			return nil, 0, fmt.Errorf("value exceeds section")
+		}
+	}
+
+	switch typ {
+	case 2:
+		return string(section[offset:end]), end, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(section[offset:end])), end, nil
+	case 4:
+		return section[offset:end], end, nil
+	case 5, 6, 9, 10:
+		var n uint64
+		for _, b := range section[offset:end] {
+			n = n<<8 | uint64(b)
+		}
+		return n, end, nil
+	case 8:
+		var n uint32
+		for _, b := range section[offset:end] {
+			n = n<<8 | uint32(b)
+		}
+		return int32(n), end, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(section[offset:end])), end, nil
+	case 14:
+		return size != 0, offset, nil
+	case 7:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := mmdbDecode(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			v, next, err := mmdbDecode(section, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := mmdbDecode(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+}
+
+// mmdbControl reads a control byte with its extended type and size bytes
+func mmdbControl(section []byte, offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(section)) {
+		return 0, 0, 0, fmt.Errorf("offset exceeds section")
+	}
+	ctrl := section[offset]
+	offset++
+
+	typ = uint(ctrl >> 5)
+	if typ == 1 {
+		// pointers keep their size bits for the pointer itself
+		return typ, uint(ctrl & 0x1f), offset, nil
+	}
+	if typ == 0 {
+		if offset >= uint(len(section)) {
+			return 0, 0, 0, fmt.Errorf("offset exceeds section")
+		}
+		typ = 7 + uint(section[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(section)) {
+			return 0, 0, 0, fmt.Errorf("size exceeds section")
+		}
+		var v uint
+		for _, b := range section[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + v
+		case 2:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+	return typ, size, offset, nil
+}
+
+// mmdbPointer resolves a pointer whose control bits are bits
+func mmdbPointer(section []byte, bits, offset uint) (target, next uint, err error) {
+	n := bits>>3&0x3 + 1
+	if offset+n > uint(len(section)) {
+		return 0, 0, fmt.Errorf("pointer exceeds section")
+	}
+	var v uint
+	if n < 4 {
+		v = bits & 0x7
+	}
+	for _, b := range section[offset : offset+n] {
+		v = v<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+func mmdbUint(v any) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
@@ -2,6 +2,8 @@ package geodat
 
 import (
 	"strings"
+
+	"github.com/urlesistiana/v2dat/v2data"
 )
 
 func splitAttrs(s string) (string, map[string]struct{}) {
@@ -9,9 +11,48 @@ func splitAttrs(s string) (string, map[string]struct{}) {
 	if ok {
 		m := make(map[string]struct{})
 		for _, attr := range strings.Split(attrs, "@") {
-			m[attr] = struct{}{}
+			if attr = strings.ToLower(strings.TrimSpace(attr)); attr != "" {
+				m[attr] = struct{}{}
+			}
 		}
 		return tag, m
 	}
 	return s, nil
 }
+
+// attrFilters groups the attribute filters of categories like "google@cn"
+// by tag. A tag listed without attributes gets a nil filter that keeps
+// every domain.
+func attrFilters(categories []string) map[string][]map[string]struct{} {
+	filters := make(map[string][]map[string]struct{})
+	for _, c := range categories {
+		tag, attrs := splitAttrs(c)
+		tag = strings.ToLower(tag)
+		filters[tag] = append(filters[tag], attrs)
+	}
+	return filters
+}
+
+// hasAttrs reports whether d carries every attribute in attrs
+func hasAttrs(d *v2data.Domain, attrs map[string]struct{}) bool {
+	if len(attrs) == 0 {
+		return true
+	}
+	found := 0
+	for _, a := range d.GetAttribute() {
+		if _, ok := attrs[strings.ToLower(a.GetKey())]; ok {
+			found++
+		}
+	}
+	return found == len(attrs)
+}
+
+// keepDomain reports whether d passes any of the filters of its tag
+func keepDomain(d *v2data.Domain, filters []map[string]struct{}) bool {
+	for _, attrs := range filters {
+		if hasAttrs(d, attrs) {
+			return true
+		}
+	}
+	return false
+}
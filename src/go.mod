@@ -3,18 +3,18 @@ module github.com/daniellavrushin/b4
 go 1.25.3
 
 require (
-	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/josharian/native v1.1.0 // indirect
-	github.com/mdlayher/netlink v1.7.2 // indirect
-	github.com/mdlayher/socket v0.4.1 // indirect
-	golang.org/x/net v0.46.0 // indirect
+	github.com/mdlayher/netlink v1.8.1-0.20251028132421-dcc6cab9a6eb // indirect
+	github.com/mdlayher/socket v0.5.1 // indirect
 	golang.org/x/sync v0.17.0 // indirect
 )
 
 require (
 	github.com/florianl/go-nfqueue v1.3.2
 	github.com/google/gopacket v1.1.19
+	github.com/google/nftables v0.3.1-0.20251119083706-1db35da82052
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/spf13/cobra v1.10.1
@@ -22,6 +22,7 @@ require (
 	github.com/urlesistiana/v2dat v0.0.0-20221215035016-47b8ee51fb52
 	github.com/yl2chen/cidranger v1.0.2
 	golang.org/x/crypto v0.43.0
+	golang.org/x/net v0.46.0
 	golang.org/x/sys v0.37.0
 	google.golang.org/protobuf v1.33.0
 )
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,7 +16,8 @@ import (
 
 type CaptureRequest struct {
 	Domain   string `json:"domain"`
-	Protocol string `json:"protocol"` // "tls", "quic", or "both"
+	Protocol string `json:"protocol"`  // "tls", "quic", or "both"
+	FullFlow bool   `json:"full_flow"` // also record the packets of the probed connection
 }
 
 func (api *API) RegisterCaptureApi() {
@@ -24,6 +26,7 @@ func (api *API) RegisterCaptureApi() {
 	api.mux.HandleFunc("/api/capture/delete", api.handleDeleteCapture)
 	api.mux.HandleFunc("/api/capture/clear", api.handleClearCaptures)
 	api.mux.HandleFunc("/api/capture/download", api.handleDownloadCapture)
+	api.mux.HandleFunc("/api/capture/pcap", api.handleDownloadCapturePcap)
 	api.mux.HandleFunc("/api/capture/upload", api.handleUploadCapture)
 }
 
@@ -61,14 +64,14 @@ func (api *API) handleProbeCapture(w http.ResponseWriter, r *http.Request) {
 
 	// Probe for the requested protocol(s)
 	if req.Protocol == "both" || req.Protocol == "tls" {
-		if err := manager.ProbeCapture(req.Domain, "tls"); err != nil {
+		if err := manager.ProbeCapture(req.Domain, "tls", req.FullFlow); err != nil {
 			errors = append(errors, fmt.Sprintf("TLS: %v", err))
 			log.Tracef("TLS probe error for %s: %v", req.Domain, err)
 		}
 	}
 
 	if req.Protocol == "both" || req.Protocol == "quic" {
-		if err := manager.ProbeCapture(req.Domain, "quic"); err != nil {
+		if err := manager.ProbeCapture(req.Domain, "quic", req.FullFlow); err != nil {
 			errors = append(errors, fmt.Sprintf("QUIC: %v", err))
 			log.Tracef("QUIC probe error for %s: %v", req.Domain, err)
 		}
@@ -222,6 +225,41 @@ func (api *API) handleDownloadCapture(w http.ResponseWriter, r *http.Request) {
 	log.Tracef("Served capture file: %s", filename)
 }
 
+// Download capture as pcapng, the recorded flow if there is one
+func (api *API) handleDownloadCapturePcap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	protocol := r.URL.Query().Get("protocol")
+	domain := r.URL.Query().Get("domain")
+
+	if protocol == "" || domain == "" {
+		http.Error(w, "Protocol and domain required", http.StatusBadRequest)
+		return
+	}
+
+	manager := capture.GetManager(api.cfg)
+	if _, ok := manager.GetCapture(protocol, domain); !ok {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := manager.WritePcap(protocol, domain, &buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := strings.NewReplacer(".", "_", "/", "_").Replace(fmt.Sprintf("%s_%s", protocol, domain)) + ".pcapng"
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
+	w.Write(buf.Bytes())
+	log.Tracef("Served capture pcap: %s", filename)
+}
+
 func (api *API) handleUploadCapture(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
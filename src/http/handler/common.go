@@ -8,6 +8,7 @@ import (
 	"github.com/daniellavrushin/b4/geodat"
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/nfq"
+	"github.com/daniellavrushin/b4/subscription"
 	"github.com/daniellavrushin/b4/utils"
 )
 
@@ -19,8 +20,11 @@ var (
 )
 
 var (
-	globalPool        *nfq.Pool
-	tablesRefreshFunc func() error
+	globalPool          *nfq.Pool
+	subscriptionManager *subscription.Manager
+	tablesRefreshFunc   func() error
+	startProbesFunc     func() error
+	stopProbesFunc      func()
 )
 
 func setJsonHeader(w http.ResponseWriter) {
@@ -31,6 +35,10 @@ func SetNFQPool(pool *nfq.Pool) {
 	globalPool = pool
 }
 
+func SetSubscriptionManager(m *subscription.Manager) {
+	subscriptionManager = m
+}
+
 func NewAPIHandler(cfg *config.Config) *API {
 	// Initialize geodata manager
 	geodataManager := geodat.NewGeodataManager(cfg.System.Geo.GeoSitePath, cfg.System.Geo.GeoIpPath)
@@ -39,9 +47,8 @@ func NewAPIHandler(cfg *config.Config) *API {
 	geositeCategories := []string{}
 	if len(cfg.Sets) > 0 {
 		for _, set := range cfg.Sets {
-			if len(set.Targets.GeoSiteCategories) > 0 {
-				geositeCategories = append(geositeCategories, set.Targets.GeoSiteCategories...)
-			}
+			geositeCategories = append(geositeCategories, set.Targets.GeoSiteCategories...)
+			geositeCategories = append(geositeCategories, set.Targets.ExcludeGeoSiteCategories...)
 		}
 	}
 	geositeCategories = utils.FilterUniqueStrings(geositeCategories)
@@ -56,9 +63,8 @@ func NewAPIHandler(cfg *config.Config) *API {
 	geoipCategories := []string{}
 	if len(cfg.Sets) > 0 {
 		for _, set := range cfg.Sets {
-			if len(set.Targets.GeoIpCategories) > 0 {
-				geoipCategories = append(geoipCategories, set.Targets.GeoIpCategories...)
-			}
+			geoipCategories = append(geoipCategories, set.Targets.GeoIpCategories...)
+			geoipCategories = append(geoipCategories, set.Targets.ExcludeGeoIpCategories...)
 		}
 	}
 	geoipCategories = utils.FilterUniqueStrings(geoipCategories)
@@ -93,8 +99,11 @@ func (api *API) RegisterEndpoints(mux *http.ServeMux, cfg *config.Config) {
 	api.RegisterGeodatApi()
 	api.RegisterCaptureApi()
 	api.RegisterSetsApi()
+	api.RegisterMatchApi()
+	api.RegisterSubscriptionsApi()
 	api.RegisterDnsApi()
 	api.RegisterDevicesApi()
+	api.RegisterTablesApi()
 }
 
 func sendResponse(w http.ResponseWriter, response interface{}) {
@@ -108,3 +117,9 @@ func sendResponse(w http.ResponseWriter, response interface{}) {
 func SetTablesRefreshFunc(fn func() error) {
 	tablesRefreshFunc = fn
 }
+
+// SetProbeRulesFuncs sets what adds and removes the firewall rules queueing
+// discovery probe traffic
+func SetProbeRulesFuncs(start func() error, stop func()) {
+	startProbesFunc, stopProbesFunc = start, stop
+}
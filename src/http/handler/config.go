@@ -40,55 +40,9 @@ func (a *API) getConfig(w http.ResponseWriter) {
 	totalIPs := 0
 
 	for i, set := range a.cfg.Sets {
-		// Count manual domains and IPs
-		manualDomains := len(set.Targets.SNIDomains)
-		manualIPs := len(set.Targets.IPs)
-
-		// Get geosite category counts
-		geositeCounts := make(map[string]int)
-		geositeTotalDomains := 0
-		if len(set.Targets.GeoSiteCategories) > 0 && a.geodataManager.IsGeositeConfigured() {
-			counts, err := a.geodataManager.GetGeositeCategoryCounts(set.Targets.GeoSiteCategories)
-			if err == nil {
-				geositeCounts = counts
-				for _, count := range counts {
-					geositeTotalDomains += count
-				}
-			}
-		}
-
-		// Get geoip category counts
-		geoipCounts := make(map[string]int)
-		geoipTotalIPs := 0
-		if len(set.Targets.GeoIpCategories) > 0 && a.geodataManager.IsGeoipConfigured() {
-			counts, err := a.geodataManager.GetGeoipCategoryCounts(set.Targets.GeoIpCategories)
-			if err == nil {
-				geoipCounts = counts
-				for _, count := range counts {
-					geoipTotalIPs += count
-				}
-			}
-		}
-
-		setTotalDomains := manualDomains + geositeTotalDomains
-		setTotalIPs := manualIPs + geoipTotalIPs
-
-		totalDomains += setTotalDomains
-		totalIPs += setTotalIPs
-
-		setsWithStats[i] = SetWithStats{
-			SetConfig: set,
-			Stats: SetStatistics{
-				ManualDomains:            manualDomains,
-				ManualIPs:                manualIPs,
-				GeositeDomains:           geositeTotalDomains,
-				GeoipIPs:                 geoipTotalIPs,
-				TotalDomains:             setTotalDomains,
-				TotalIPs:                 setTotalIPs,
-				GeositeCategoryBreakdown: geositeCounts,
-				GeoipCategoryBreakdown:   geoipCounts,
-			},
-		}
+		setsWithStats[i] = a.setWithStats(set)
+		totalDomains += setsWithStats[i].Stats.TotalDomains
+		totalIPs += setsWithStats[i].Stats.TotalIPs
 	}
 
 	//get list of interfaces from system
@@ -192,53 +146,9 @@ func (a *API) updateConfig(w http.ResponseWriter, r *http.Request) {
 	for i, set := range newConfig.Sets {
 		a.loadTargetsForSetCached(set)
 
-		manualDomains := len(set.Targets.SNIDomains)
-		manualIPs := len(set.Targets.IPs)
-
-		// Get geosite counts
-		geositeCounts := make(map[string]int)
-		geositeTotalDomains := 0
-		if len(set.Targets.GeoSiteCategories) > 0 {
-			counts, err := a.geodataManager.GetGeositeCategoryCounts(set.Targets.GeoSiteCategories)
-			if err == nil {
-				geositeCounts = counts
-				for _, count := range counts {
-					geositeTotalDomains += count
-				}
-			}
-		}
-
-		// Get geoip counts
-		geoipCounts := make(map[string]int)
-		geoipTotalIPs := 0
-		if len(set.Targets.GeoIpCategories) > 0 {
-			counts, err := a.geodataManager.GetGeoipCategoryCounts(set.Targets.GeoIpCategories)
-			if err == nil {
-				geoipCounts = counts
-				for _, count := range counts {
-					geoipTotalIPs += count
-				}
-			}
-		}
-
-		setTotalDomains := manualDomains + geositeTotalDomains
-		setTotalIPs := manualIPs + geoipTotalIPs
-
-		allDomainsCount += setTotalDomains
-		allIpsCount += setTotalIPs
-
-		setsWithStats[i] = SetWithStats{
-			SetConfig: set,
-			Stats: SetStatistics{
-				ManualDomains:            manualDomains,
-				ManualIPs:                manualIPs,
-				GeositeDomains:           geositeTotalDomains,
-				TotalDomains:             setTotalDomains,
-				TotalIPs:                 setTotalIPs,
-				GeositeCategoryBreakdown: geositeCounts,
-				GeoipCategoryBreakdown:   geoipCounts,
-			},
-		}
+		setsWithStats[i] = a.setWithStats(set)
+		allDomainsCount += setsWithStats[i].Stats.TotalDomains
+		allIpsCount += setsWithStats[i].Stats.TotalIPs
 	}
 
 	if err := newConfig.Validate(); err != nil {
@@ -323,7 +233,13 @@ func (a *API) resetConfig(w http.ResponseWriter, r *http.Request) {
 func (a *API) saveAndPushConfig(newCfg *config.Config) error {
 
 	if globalPool != nil {
-		err := globalPool.UpdateConfig(newCfg)
+		// The subscription manager reloads subscribed sets in the running
+		// config only. Resolving them again under the pool lock keeps the
+		// save from bringing back a list replaced since they were loaded.
+		err := globalPool.ModifyConfig(func(*config.Config) (*config.Config, error) {
+			a.reloadSubscribedSets(newCfg)
+			return newCfg, nil
+		})
 		if err != nil {
 			return fmt.Errorf("failed to update global pool config: %v", err)
 		}
@@ -339,10 +255,29 @@ func (a *API) saveAndPushConfig(newCfg *config.Config) error {
 	return nil
 }
 
+// reloadSubscribedSets replaces the sets of cfg that have subscriptions
+// with copies whose targets are loaded from the current lists
+func (a *API) reloadSubscribedSets(cfg *config.Config) {
+	sets := make([]*config.SetConfig, len(cfg.Sets))
+	for i, set := range cfg.Sets {
+		sets[i] = set
+		if len(set.Targets.Subscriptions) == 0 {
+			continue
+		}
+		reloaded := *set
+		a.loadTargetsForSetCached(&reloaded)
+		sets[i] = &reloaded
+		if set == cfg.MainSet {
+			cfg.MainSet = &reloaded
+		}
+	}
+	cfg.Sets = sets
+}
+
 func (a *API) PerformSoftRestart(newCfg *config.Config, oldCfg *config.Config) bool {
 
-	oldPorts := strings.Join(oldCfg.CollectUDPPorts(), ",")
-	newPorts := strings.Join(newCfg.CollectUDPPorts(), ",")
+	oldPorts := portLimitsString(oldCfg)
+	newPorts := portLimitsString(newCfg)
 	shouldUpdate := false
 	if oldCfg.System.Tables.SkipSetup != newCfg.System.Tables.SkipSetup {
 
@@ -353,14 +288,6 @@ func (a *API) PerformSoftRestart(newCfg *config.Config, oldCfg *config.Config) b
 		shouldUpdate = true
 	}
 
-	if oldCfg.MainSet.TCP.ConnBytesLimit != newCfg.MainSet.TCP.ConnBytesLimit {
-		shouldUpdate = true
-	}
-
-	if oldCfg.MainSet.UDP.ConnBytesLimit != newCfg.MainSet.UDP.ConnBytesLimit {
-		shouldUpdate = true
-	}
-
 	if oldCfg.Queue.Mark != newCfg.Queue.Mark {
 		shouldUpdate = true
 	}
@@ -375,7 +302,7 @@ func (a *API) PerformSoftRestart(newCfg *config.Config, oldCfg *config.Config) b
 	if shouldUpdate {
 		log.Infof("Core settings changed, performing soft system restart")
 		if oldPorts != newPorts {
-			log.Infof("UDP ports changed (%s -> %s), refreshing firewall rules", oldPorts, newPorts)
+			log.Infof("Queued ports changed (%s -> %s), refreshing firewall rules", oldPorts, newPorts)
 		}
 		if err := tablesRefreshFunc(); err != nil {
 			log.Errorf("Failed to refresh tables: %v", err)
@@ -384,3 +311,16 @@ func (a *API) PerformSoftRestart(newCfg *config.Config, oldCfg *config.Config) b
 
 	return shouldUpdate
 }
+
+// portLimitsString renders the queued ports and their connbytes limits so two
+// configs can be compared
+func portLimitsString(cfg *config.Config) string {
+	var parts []string
+	for _, g := range cfg.CollectTCPPortLimits() {
+		parts = append(parts, "tcp:"+g.String())
+	}
+	for _, g := range cfg.CollectUDPPortLimits() {
+		parts = append(parts, "udp:"+g.String())
+	}
+	return strings.Join(parts, " ")
+}
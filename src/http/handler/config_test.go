@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/geodat"
+)
+
+func TestReloadSubscribedSets(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+	api := &API{cfg: &cfg, geodataManager: geodat.NewGeodataManager("", "")}
+
+	subscribed := config.NewSetConfig()
+	subscribed.Id = "subs"
+	subscribed.Targets.SNIDomains = []string{"manual.com"}
+	subscribed.Targets.DomainsToMatch = []string{"stale.com", "manual.com"}
+	subscribed.Targets.Subscriptions = []config.SubscriptionConfig{{
+		Enabled: true,
+		URL:     "http://example.com/list.txt",
+		Format:  config.SubscriptionPlain,
+	}}
+	plain := config.NewSetConfig()
+	plain.Id = "plain"
+	cfg.Sets = []*config.SetConfig{&subscribed, &plain}
+	cfg.MainSet = &subscribed
+
+	// the subscription manager replaced the list since the set was loaded
+	path := cfg.SubscriptionPath(&subscribed.Targets.Subscriptions[0])
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("fresh.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	api.reloadSubscribedSets(&cfg)
+
+	if cfg.Sets[1] != &plain {
+		t.Error("set without subscriptions was copied")
+	}
+	if cfg.Sets[0] == &subscribed || cfg.MainSet != cfg.Sets[0] {
+		t.Fatal("subscribed set not replaced by a copy")
+	}
+	if d := cfg.Sets[0].Targets.DomainsToMatch; !slices.Equal(d, []string{"fresh.com", "manual.com"}) {
+		t.Errorf("targets not loaded from the current list: %v", d)
+	}
+	if !slices.Equal(subscribed.Targets.DomainsToMatch, []string{"stale.com", "manual.com"}) {
+		t.Error("set shared with the running config modified")
+	}
+}
@@ -15,6 +15,8 @@ import (
 
 func (api *API) RegisterDiscoveryApi() {
 	api.mux.HandleFunc("/api/discovery/start", api.handleStartDiscovery)
+	api.mux.HandleFunc("/api/discovery/batch", api.handleStartBatchDiscovery)
+	api.mux.HandleFunc("/api/discovery/report/{id}", api.handleBatchReport)
 	api.mux.HandleFunc("/api/discovery/status/{id}", api.handleCheckStatus)
 	api.mux.HandleFunc("/api/discovery/cancel/{id}", api.handleCancelCheck)
 	api.mux.HandleFunc("/api/discovery/add", api.handleAddPresetAsSet)
@@ -94,7 +96,7 @@ func (api *API) handleStartDiscovery(w http.ResponseWriter, r *http.Request) {
 	phase1Count := len(discovery.GetPhase1Presets())
 
 	go func() {
-		suite.RunDiscovery()
+		withProbeRules(suite.RunDiscovery)
 		log.Infof("Discovery complete for %s", suite.Domain)
 	}()
 
@@ -111,6 +113,103 @@ func (api *API) handleStartDiscovery(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// withProbeRules runs a discovery with the firewall queueing its probes
+func withProbeRules(run func()) {
+	if startProbesFunc != nil {
+		if err := startProbesFunc(); err != nil {
+			log.Errorf("Failed to add discovery probe rules: %v", err)
+		} else {
+			defer stopProbesFunc()
+		}
+	}
+	run()
+}
+
+// defaultBatchMaxDomains caps a batch when the request sets no limit, as a
+// whole geosite category can take hours to go through
+const defaultBatchMaxDomains = 20
+
+func (api *API) handleStartBatchDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchDiscoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Errorf("Failed to decode batch discovery request: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	inputs := append([]string{}, req.CheckURLs...)
+	if len(req.GeoSiteCategories) > 0 {
+		if !api.geodataManager.IsGeositeConfigured() {
+			http.Error(w, "Geosite file is not configured", http.StatusBadRequest)
+			return
+		}
+		for _, category := range req.GeoSiteCategories {
+			domains, err := api.geodataManager.LoadGeositeCategory(category)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to load geosite category %s: %v", category, err), http.StatusBadRequest)
+				return
+			}
+			inputs = append(inputs, domains...)
+		}
+	}
+
+	maxDomains := req.MaxDomains
+	if maxDomains == 0 {
+		maxDomains = defaultBatchMaxDomains
+	}
+	domains := discovery.BatchDomains(inputs, maxDomains)
+	if len(domains) == 0 {
+		http.Error(w, "At least one domain is required", http.StatusBadRequest)
+		return
+	}
+
+	suite := discovery.NewBatchDiscoverySuite(domains, globalPool)
+
+	go func() {
+		withProbeRules(suite.RunDiscovery)
+		log.Infof("Batch discovery complete for %d domains", len(domains))
+	}()
+
+	response := DiscoveryResponse{
+		Id:             suite.Id,
+		Domain:         suite.Domain,
+		Domains:        domains,
+		EstimatedTests: len(domains),
+		Message:        fmt.Sprintf("Batch discovery started for %d domains", len(domains)),
+	}
+
+	setJsonHeader(w)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *API) handleBatchReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	suite, ok := discovery.GetCheckSuite(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Check suite not found", http.StatusNotFound)
+		return
+	}
+
+	snapshot := suite.GetSnapshot()
+	if snapshot.Report == nil {
+		http.Error(w, "Report not ready", http.StatusConflict)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(snapshot.Report)
+}
+
 func (api *API) handleAddPresetAsSet(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
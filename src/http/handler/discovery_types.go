@@ -4,10 +4,17 @@ type DiscoveryRequest struct {
 	CheckURL string `json:"check_url,omitempty"`
 }
 
+type BatchDiscoveryRequest struct {
+	CheckURLs         []string `json:"check_urls,omitempty"`
+	GeoSiteCategories []string `json:"geosite_categories,omitempty"`
+	MaxDomains        int      `json:"max_domains,omitempty"` // 0 for the default limit
+}
+
 type DiscoveryResponse struct {
-	Id             string `json:"id"`
-	Domain         string `json:"domain"`
-	CheckURL       string `json:"check_url"`
-	EstimatedTests int    `json:"estimated_tests"`
-	Message        string `json:"message"`
+	Id             string   `json:"id"`
+	Domain         string   `json:"domain"`
+	Domains        []string `json:"domains,omitempty"`
+	CheckURL       string   `json:"check_url"`
+	EstimatedTests int      `json:"estimated_tests"`
+	Message        string   `json:"message"`
 }
@@ -1,6 +1,9 @@
 package handler
 
-import "github.com/daniellavrushin/b4/config"
+import (
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/metrics"
+)
 
 // Response types for API endpoints
 type GeositeResponse struct {
@@ -14,13 +17,18 @@ type SetStatistics struct {
 	GeoipIPs                 int            `json:"geoip_ips"`
 	TotalDomains             int            `json:"total_domains"`
 	TotalIPs                 int            `json:"total_ips"`
+	ExcludedDomains          int            `json:"excluded_domains"`
+	ExcludedIPs              int            `json:"excluded_ips"`
+	EffectiveDomains         int            `json:"effective_domains"`
+	EffectiveIPs             int            `json:"effective_ips"`
 	GeositeCategoryBreakdown map[string]int `json:"geosite_category_breakdown,omitempty"`
 	GeoipCategoryBreakdown   map[string]int `json:"geoip_category_breakdown,omitempty"`
 }
 
 type SetWithStats struct {
 	*config.SetConfig
-	Stats SetStatistics `json:"stats"`
+	Stats    SetStatistics               `json:"stats"`
+	Counters metrics.SetCountersSnapshot `json:"counters"`
 }
 
 // CategoryPreviewResponse for previewing category contents
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/daniellavrushin/b4/sni"
+)
+
+func (api *API) RegisterMatchApi() {
+	api.mux.HandleFunc("/api/match", api.handleMatch)
+}
+
+// GET /api/match?host=&ip=&port=&proto= - explain which set handles a flow
+func (api *API) handleMatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	host := q.Get("host")
+
+	var ip net.IP
+	if s := q.Get("ip"); s != "" {
+		if ip = net.ParseIP(s); ip == nil {
+			http.Error(w, "Invalid ip", http.StatusBadRequest)
+			return
+		}
+	}
+	if host == "" && ip == nil {
+		http.Error(w, "host or ip required", http.StatusBadRequest)
+		return
+	}
+
+	port := uint16(443)
+	if s := q.Get("port"); s != "" {
+		p, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			http.Error(w, "Invalid port", http.StatusBadRequest)
+			return
+		}
+		port = uint16(p)
+	}
+
+	var udp bool
+	switch q.Get("proto") {
+	case "", "tcp":
+	case "udp":
+		udp = true
+	default:
+		http.Error(w, "proto must be tcp or udp", http.StatusBadRequest)
+		return
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(api.matcher().Explain(host, ip, port, udp))
+}
+
+// matcher returns the matcher the workers use, or one built from the
+// config when the queue is not running
+func (api *API) matcher() *sni.SuffixSet {
+	if globalPool != nil && len(globalPool.Workers) > 0 {
+		return globalPool.Workers[0].Matcher()
+	}
+	return sni.NewSuffixSet(api.cfg.Sets)
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+func TestHandleMatch(t *testing.T) {
+	cfg := config.NewConfig()
+	set := config.NewSetConfig()
+	set.Id, set.Name = "yt", "youtube"
+	set.Enabled = true
+	set.Targets.DomainsToMatch = []string{"youtube.com"}
+	cfg.Sets = []*config.SetConfig{&set}
+
+	api := &API{cfg: &cfg}
+	mux := http.NewServeMux()
+	api.mux = mux
+	api.RegisterMatchApi()
+
+	tests := []struct {
+		name    string
+		query   string
+		code    int
+		wantSet string
+	}{
+		{"host match", "host=www.youtube.com&ip=1.2.3.4&port=443", http.StatusOK, "youtube"},
+		{"no match", "host=example.com", http.StatusOK, ""},
+		{"missing target", "port=443", http.StatusBadRequest, ""},
+		{"bad ip", "ip=nope", http.StatusBadRequest, ""},
+		{"bad proto", "host=youtube.com&proto=sctp", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/match?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("expected %d, got %d", tt.code, rec.Code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var e sni.Explanation
+			if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if e.SetName != tt.wantSet || e.Reason == "" {
+				t.Errorf("got %+v", e)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/daniellavrushin/b4/metrics"
@@ -20,6 +22,7 @@ func GetMetricsCollector() *metrics.MetricsCollector {
 func (api *API) RegisterMetricsApi() {
 	api.mux.HandleFunc("/api/metrics", api.getMetrics)
 	api.mux.HandleFunc("/api/metrics/summary", api.getMetricsSummary)
+	api.mux.HandleFunc("/metrics", api.getPrometheusMetrics)
 }
 
 func (a *API) getMetrics(w http.ResponseWriter, r *http.Request) {
@@ -56,3 +59,70 @@ func (a *API) getMetricsSummary(w http.ResponseWriter, r *http.Request) {
 	enc := json.NewEncoder(w)
 	_ = enc.Encode(summary)
 }
+
+// getPrometheusMetrics serves the metrics in the Prometheus text format
+func (a *API) getPrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.GetMetricsCollector().WritePrometheus(w)
+
+	if globalPool == nil || len(globalPool.Workers) == 0 {
+		return
+	}
+
+	var processed, verdicts []metrics.Sample
+	for _, wk := range globalPool.Workers {
+		queue := fmt.Sprintf("%d", wk.Queue())
+		n, _ := wk.GetStats()
+		accepted, dropped := wk.GetVerdicts()
+		processed = append(processed, metrics.Sample{Labels: []string{"worker", queue}, Value: float64(n)})
+		verdicts = append(verdicts,
+			metrics.Sample{Labels: []string{"worker", queue, "verdict", "accept"}, Value: float64(accepted)},
+			metrics.Sample{Labels: []string{"worker", queue, "verdict", "drop"}, Value: float64(dropped)},
+		)
+	}
+	metrics.WriteFamily(w, "b4_worker_packets_processed_total", "counter", "Packets received from the queue by each worker.", processed...)
+	metrics.WriteFamily(w, "b4_verdicts_total", "counter", "Verdicts given to queued packets.", verdicts...)
+
+	writeCacheMetrics(w, globalPool.Workers[0].GetCacheStats())
+}
+
+// writeCacheMetrics exports the matcher cache stats. The matcher is shared
+// by all workers; the lookup counters include those of the matchers it
+// replaced.
+func writeCacheMetrics(w io.Writer, stats map[string]interface{}) {
+	var hits, misses, ratio, size []metrics.Sample
+	for _, cache := range []string{"ip", "domain", "regex"} {
+		liveHits, _ := stats[cache+"_cache_hits"].(uint64)
+		liveMisses, _ := stats[cache+"_cache_misses"].(uint64)
+		totalHits, totalMisses := metrics.MatcherCacheTotals(cache, liveHits, liveMisses)
+		h, m := float64(totalHits), float64(totalMisses)
+		labels := []string{"cache", cache}
+		hits = append(hits, metrics.Sample{Labels: labels, Value: h})
+		misses = append(misses, metrics.Sample{Labels: labels, Value: m})
+		size = append(size, metrics.Sample{Labels: labels, Value: statValue(stats[cache+"_cache_size"])})
+		if h+m > 0 {
+			ratio = append(ratio, metrics.Sample{Labels: labels, Value: h / (h + m)})
+		}
+	}
+	metrics.WriteFamily(w, "b4_matcher_cache_hits_total", "counter", "Matcher lookups answered from cache.", hits...)
+	metrics.WriteFamily(w, "b4_matcher_cache_misses_total", "counter", "Matcher lookups that missed the cache.", misses...)
+	metrics.WriteFamily(w, "b4_matcher_cache_hit_ratio", "gauge", "Share of matcher lookups answered from cache.", ratio...)
+	metrics.WriteFamily(w, "b4_matcher_cache_entries", "gauge", "Entries in the matcher caches.", size...)
+}
+
+func statValue(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	}
+	return 0
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+	"github.com/daniellavrushin/b4/sni"
 	"github.com/google/uuid"
 )
 
@@ -96,8 +98,12 @@ func (api *API) handleSetById(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *API) listSets(w http.ResponseWriter) {
+	sets := make([]SetWithStats, len(api.cfg.Sets))
+	for i, set := range api.cfg.Sets {
+		sets[i] = api.setWithStats(set)
+	}
 	setJsonHeader(w)
-	json.NewEncoder(w).Encode(api.cfg.Sets)
+	json.NewEncoder(w).Encode(sets)
 }
 
 func (api *API) getSet(w http.ResponseWriter, id string) {
@@ -107,7 +113,58 @@ func (api *API) getSet(w http.ResponseWriter, id string) {
 		return
 	}
 	setJsonHeader(w)
-	json.NewEncoder(w).Encode(set)
+	json.NewEncoder(w).Encode(api.setWithStats(set))
+}
+
+// setWithStats adds the target counts and traffic counters of set
+func (api *API) setWithStats(set *config.SetConfig) SetWithStats {
+	manualDomains := len(set.Targets.SNIDomains)
+	manualIPs := len(set.Targets.IPs)
+
+	geositeCounts := make(map[string]int)
+	geositeTotalDomains := 0
+	if len(set.Targets.GeoSiteCategories) > 0 && api.geodataManager.IsGeositeConfigured() {
+		counts, err := api.geodataManager.GetGeositeCategoryCounts(set.Targets.GeoSiteCategories)
+		if err == nil {
+			geositeCounts = counts
+			for _, count := range counts {
+				geositeTotalDomains += count
+			}
+		}
+	}
+
+	geoipCounts := make(map[string]int)
+	geoipTotalIPs := 0
+	if len(set.Targets.GeoIpCategories) > 0 && api.geodataManager.IsGeoipConfigured() {
+		counts, err := api.geodataManager.GetGeoipCategoryCounts(set.Targets.GeoIpCategories)
+		if err == nil {
+			geoipCounts = counts
+			for _, count := range counts {
+				geoipTotalIPs += count
+			}
+		}
+	}
+
+	effectiveDomains, effectiveIPs := sni.EffectiveTargets(set)
+
+	return SetWithStats{
+		SetConfig: set,
+		Stats: SetStatistics{
+			ManualDomains:            manualDomains,
+			ManualIPs:                manualIPs,
+			GeositeDomains:           geositeTotalDomains,
+			GeoipIPs:                 geoipTotalIPs,
+			TotalDomains:             manualDomains + geositeTotalDomains,
+			TotalIPs:                 manualIPs + geoipTotalIPs,
+			GeositeCategoryBreakdown: geositeCounts,
+			GeoipCategoryBreakdown:   geoipCounts,
+			ExcludedDomains:          len(set.Targets.DomainsToExclude),
+			ExcludedIPs:              len(set.Targets.IpsToExclude),
+			EffectiveDomains:         effectiveDomains,
+			EffectiveIPs:             effectiveIPs,
+		},
+		Counters: metrics.CountersFor(set.Id).Snapshot(),
+	}
 }
 
 func (api *API) createSet(w http.ResponseWriter, r *http.Request) {
@@ -207,6 +264,7 @@ func (api *API) deleteSet(w http.ResponseWriter, id string) {
 	}
 
 	api.cfg.Sets = filtered
+	metrics.DeleteSetCounters(id)
 
 	if err := api.saveAndPushConfig(api.cfg); err != nil {
 		log.Errorf("Failed to save config after deleting set: %v", err)
@@ -285,6 +343,18 @@ func (api *API) initializeSetDefaults(set *config.SetConfig) {
 	if set.Targets.GeoIpCategories == nil {
 		set.Targets.GeoIpCategories = []string{}
 	}
+	if set.Targets.ExcludeDomains == nil {
+		set.Targets.ExcludeDomains = []string{}
+	}
+	if set.Targets.ExcludeIPs == nil {
+		set.Targets.ExcludeIPs = []string{}
+	}
+	if set.Targets.ExcludeGeoSiteCategories == nil {
+		set.Targets.ExcludeGeoSiteCategories = []string{}
+	}
+	if set.Targets.ExcludeGeoIpCategories == nil {
+		set.Targets.ExcludeGeoIpCategories = []string{}
+	}
 	if set.TCP.WinValues == nil {
 		set.TCP.WinValues = []int{0, 1460, 8192, 65535}
 	}
@@ -302,6 +372,8 @@ func (api *API) loadTargetsForSetCached(set *config.SetConfig) {
 			domains = append(domains, cached...)
 		}
 	}
+	subDomains, subIps := api.cfg.LoadSubscriptions(set)
+	domains = append(domains, subDomains...)
 	domains = append(domains, set.Targets.SNIDomains...)
 	set.Targets.DomainsToMatch = domains
 
@@ -310,6 +382,23 @@ func (api *API) loadTargetsForSetCached(set *config.SetConfig) {
 			ips = append(ips, cached...)
 		}
 	}
+	ips = append(ips, subIps...)
 	ips = append(ips, set.Targets.IPs...)
 	set.Targets.IpsToMatch = ips
+
+	excludedDomains := []string{}
+	for _, cat := range set.Targets.ExcludeGeoSiteCategories {
+		if cached, err := api.geodataManager.LoadGeositeCategory(cat); err == nil {
+			excludedDomains = append(excludedDomains, cached...)
+		}
+	}
+	set.Targets.DomainsToExclude = append(excludedDomains, set.Targets.ExcludeDomains...)
+
+	excludedIPs := []string{}
+	for _, cat := range set.Targets.ExcludeGeoIpCategories {
+		if cached, err := api.geodataManager.LoadGeoipCategory(cat); err == nil {
+			excludedIPs = append(excludedIPs, cached...)
+		}
+	}
+	set.Targets.IpsToExclude = append(excludedIPs, set.Targets.ExcludeIPs...)
 }
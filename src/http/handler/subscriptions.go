@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+func (api *API) RegisterSubscriptionsApi() {
+	api.mux.HandleFunc("/api/subscriptions", api.handleSubscriptions)
+	api.mux.HandleFunc("/api/subscriptions/refresh", api.handleSubscriptionsRefresh)
+}
+
+// GET /api/subscriptions - download state of every subscribed list
+func (api *API) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if subscriptionManager == nil {
+		http.Error(w, "Subscriptions not available", http.StatusServiceUnavailable)
+		return
+	}
+	sendResponse(w, subscriptionManager.Statuses())
+}
+
+// POST /api/subscriptions/refresh - fetch every list now and reload the
+// sets whose lists changed
+func (api *API) handleSubscriptionsRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if subscriptionManager == nil {
+		http.Error(w, "Subscriptions not available", http.StatusServiceUnavailable)
+		return
+	}
+	log.Infof("Refreshing subscriptions")
+	sendResponse(w, subscriptionManager.Refresh(true))
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+var tablesPlanFunc func(cfg *config.Config, backend string) (*TablesPlan, error)
+
+func SetTablesPlanFunc(fn func(cfg *config.Config, backend string) (*TablesPlan, error)) {
+	tablesPlanFunc = fn
+}
+
+func (api *API) RegisterTablesApi() {
+	api.mux.HandleFunc("/api/tables/plan", api.handleTablesPlan)
+}
+
+func (api *API) handleTablesPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tablesPlanFunc == nil {
+		http.Error(w, "Tables plan not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	backend := r.URL.Query().Get("backend")
+	switch backend {
+	case "", "iptables", "nftables":
+	default:
+		http.Error(w, "Unknown backend", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := tablesPlanFunc(api.cfg, backend)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendResponse(w, plan)
+}
@@ -0,0 +1,11 @@
+package handler
+
+// TablesPlan is the firewall ruleset b4 would install, rendered without
+// executing anything, along with its difference from the installed rules
+type TablesPlan struct {
+	Backend   string   `json:"backend"`
+	Script    string   `json:"script"`
+	Installed string   `json:"installed"`
+	Diff      []string `json:"diff"`
+	InSync    bool     `json:"in_sync"`
+}
@@ -7,8 +7,16 @@ import {
 
 export const captureApi = {
   list: () => apiGet<Capture[]>("/api/capture/list"),
-  probe: (domain: string, protocol: string) =>
-    apiPost<CaptureProbeResponse>("/api/capture/probe", { domain, protocol }),
+  probe: (domain: string, protocol: string, fullFlow = false) =>
+    apiPost<CaptureProbeResponse>("/api/capture/probe", {
+      domain,
+      protocol,
+      full_flow: fullFlow,
+    }),
+  pcapUrl: (protocol: string, domain: string) =>
+    `/api/capture/pcap?protocol=${encodeURIComponent(
+      protocol
+    )}&domain=${encodeURIComponent(domain)}`,
   delete: (protocol: string, domain: string) =>
     apiDelete(`/api/capture/delete?protocol=${protocol}&domain=${domain}`),
   clear: () => apiPost<{ success: boolean }>("/api/capture/clear"),
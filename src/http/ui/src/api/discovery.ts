@@ -1,10 +1,18 @@
 import { apiDelete, apiPost, apiGet } from "./apiClient";
 import { B4SetConfig } from "@b4.sets";
-import { DiscoveryResponse, DiscoverySuite } from "@b4.discovery";
+import {
+  BatchDiscoveryRequest,
+  BatchReport,
+  DiscoveryResponse,
+  DiscoverySuite,
+} from "@b4.discovery";
 
 export const discoveryApi = {
   start: (check_url: string) =>
     apiPost<DiscoveryResponse>("/api/discovery/start", { check_url }),
+  startBatch: (req: BatchDiscoveryRequest) =>
+    apiPost<DiscoveryResponse>("/api/discovery/batch", req),
+  report: (id: string) => apiGet<BatchReport>(`/api/discovery/report/${id}`),
   status: (id: string) => apiGet<DiscoverySuite>(`/api/discovery/status/${id}`),
   cancel: (id: string) => apiDelete(`/api/discovery/cancel/${id}`),
   addPresetAsSet: (preset: B4SetConfig) =>
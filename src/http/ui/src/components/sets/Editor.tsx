@@ -37,6 +37,8 @@ import {
   B4Config,
   B4SetConfig,
   MAIN_SET_ID,
+  PipelineStep,
+  SubscriptionConfig,
   SystemConfig,
 } from "@models/config";
 
@@ -45,6 +47,7 @@ import { FakingSettings } from "./Faking";
 import { FragmentationSettings } from "./Fragmentation";
 import { ImportExportSettings } from "./ImportExport";
 import { SetStats } from "./Manager";
+import { PipelineSettings } from "./Pipeline";
 import { TargetSettings } from "./Target";
 import { TcpSettings } from "./Tcp";
 import { UdpSettings } from "./Udp";
@@ -94,7 +97,16 @@ export const SetEditor = ({
 
   const handleChange = (
     field: string,
-    value: string | number | boolean | string[] | number[] | null | undefined
+    value:
+      | string
+      | number
+      | boolean
+      | string[]
+      | number[]
+      | PipelineStep[]
+      | SubscriptionConfig[]
+      | null
+      | undefined
   ) => {
     if (!editedSet) return;
 
@@ -144,18 +156,33 @@ export const SetEditor = ({
         </DialogHeader>
 
         <div className="flex-1 overflow-hidden flex flex-col gap-4">
-          <Field>
-            <FieldLabel>Set Name</FieldLabel>
-            <Input
-              value={editedSet.name}
-              onChange={(e) => handleChange("name", e.target.value)}
-              placeholder="e.g., YouTube Bypass, Gaming, Streaming"
-              required
-            />
-            <FieldDescription>
-              Give this set a descriptive name
-            </FieldDescription>
-          </Field>
+          <div className="grid grid-cols-1 md:grid-cols-[3fr_1fr] gap-4">
+            <Field>
+              <FieldLabel>Set Name</FieldLabel>
+              <Input
+                value={editedSet.name}
+                onChange={(e) => handleChange("name", e.target.value)}
+                placeholder="e.g., YouTube Bypass, Gaming, Streaming"
+                required
+              />
+              <FieldDescription>
+                Give this set a descriptive name
+              </FieldDescription>
+            </Field>
+            <Field>
+              <FieldLabel>Priority</FieldLabel>
+              <Input
+                type="number"
+                value={editedSet.priority ?? 0}
+                onChange={(e) =>
+                  handleChange("priority", Number(e.target.value))
+                }
+              />
+              <FieldDescription>
+                Wins when sets match equally specific rules
+              </FieldDescription>
+            </Field>
+          </div>
 
           <Tabs
             value={activeTab.toString()}
@@ -244,6 +271,7 @@ export const SetEditor = ({
                     config={editedSet}
                     onChange={handleChange}
                   />
+                  <PipelineSettings config={editedSet} onChange={handleChange} />
                 </div>
               </TabsContent>
 
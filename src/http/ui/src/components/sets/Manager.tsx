@@ -63,12 +63,28 @@ export interface SetStats {
   geoip_ips: number;
   total_domains: number;
   total_ips: number;
+  excluded_domains: number;
+  excluded_ips: number;
+  effective_domains: number;
+  effective_ips: number;
   geosite_category_breakdown?: Record<string, number>;
   geoip_category_breakdown?: Record<string, number>;
 }
 
+export interface SetCounters {
+  sni_matches: number;
+  ip_matches: number;
+  port_matches: number;
+  bytes: number;
+  packets_injected: number;
+  fakes_sent: number;
+  strategies: Record<string, number>;
+  last_match?: string;
+}
+
 export interface SetWithStats extends B4SetConfig {
   stats: SetStats;
+  counters?: SetCounters;
 }
 
 interface SetsManagerProps {
@@ -152,6 +168,9 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
   const setsStats = setsData.map((s) =>
     "stats" in s ? s.stats : null
   ) as (SetStats | null)[];
+  const setsCounters = setsData.map((s) =>
+    "counters" in s ? s.counters : null
+  ) as (SetCounters | null)[];
 
   const sensors = useSensors(
     useSensor(PointerSensor, {
@@ -226,6 +245,7 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
       id: uuidv4(),
       name: `Set ${sets.length + 1}`,
       enabled: true,
+      priority: 0,
       tcp: {
         conn_bytes_limit: 19,
         seg2delay: 0,
@@ -233,6 +253,7 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
         syn_fake_len: 0,
         syn_ttl: 3,
         drop_sack: false,
+        dport_filter: "443",
         win_mode: "off",
         win_values: [0, 1460, 8192, 65535],
         desync_mode: "off",
@@ -249,12 +270,29 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
         filter_stun: true,
         conn_bytes_limit: 8,
         seg2delay: 0,
+        quic_split: "off",
+        fake_quic: false,
+        fake_sni: "www.google.com",
       } as B4SetConfig["udp"],
       dns: {
         enabled: false,
         target_dns: "",
         fragment_query: false,
       } as B4SetConfig["dns"],
+      http: {
+        enabled: false,
+        host_split: true,
+        header_case: false,
+        host_space: false,
+        host_dot: false,
+      } as B4SetConfig["http"],
+      pipeline: [
+        { type: "mutate" },
+        { type: "desync" },
+        { type: "window" },
+        { type: "fake" },
+        { type: "fragment" },
+      ],
       fragmentation: {
         strategy: "tcp",
         reverse_order: true,
@@ -304,6 +342,11 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
         ip: [],
         geosite_categories: [],
         geoip_categories: [],
+        exclude_domains: [],
+        exclude_ips: [],
+        exclude_geosite_categories: [],
+        exclude_geoip_categories: [],
+        subscriptions: [],
       } as B4SetConfig["targets"],
     };
 
@@ -438,6 +481,7 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
                 {filteredSets.map((set) => {
                   const index = sets.findIndex((s) => s.id === set.id);
                   const stats = setsStats[index] || undefined;
+                  const counters = setsCounters[index] || undefined;
 
                   return (
                     <div key={set.id}>
@@ -448,6 +492,7 @@ export const SetsManager = ({ config, onRefresh }: SetsManagerProps) => {
                           <SetCard
                             set={set}
                             stats={stats}
+                            counters={counters}
                             index={index}
                             onEdit={() => handleEditSet(set)}
                             onDuplicate={() => handleDuplicateSet(set)}
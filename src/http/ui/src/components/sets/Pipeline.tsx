@@ -0,0 +1,365 @@
+import { AddIcon, ClearIcon, CollapseIcon, ExpandIcon } from "@b4.icons";
+import { Button } from "@design/components/ui/button";
+import {
+  Card,
+  CardContent,
+  CardDescription,
+  CardHeader,
+  CardTitle,
+} from "@design/components/ui/card";
+import { FieldDescription } from "@design/components/ui/field";
+import { Input } from "@design/components/ui/input";
+import {
+  Select,
+  SelectContent,
+  SelectItem,
+  SelectTrigger,
+  SelectValue,
+} from "@design/components/ui/select";
+import {
+  B4SetConfig,
+  FragmentationStrategy,
+  PipelineStep,
+  PipelineStepType,
+} from "@models/config";
+
+interface PipelineSettingsProps {
+  config: B4SetConfig;
+  onChange: (field: string, value: PipelineStep[]) => void;
+}
+
+const STEP_TYPES: {
+  value: PipelineStepType;
+  label: string;
+  description: string;
+}[] = [
+  {
+    value: "mutate",
+    label: "Mutate",
+    description: "Rewrite the ClientHello (Faking → SNI mutation)",
+  },
+  { value: "desync", label: "Desync", description: "Send TCP desync packets" },
+  {
+    value: "window",
+    label: "Window",
+    description: "Send TCP window manipulation packets",
+  },
+  {
+    value: "fake",
+    label: "Fake",
+    description: "Send fake ClientHellos (count 0 follows Faking settings)",
+  },
+  {
+    value: "split",
+    label: "Split",
+    description:
+      "Cut pending segments at sni, sni-mid, sni-end, oob or an offset (empty cuts like the tcp strategy)",
+  },
+  {
+    value: "disorder",
+    label: "Disorder",
+    description:
+      "Reverse the order of the first N pending segments (0 reverses all)",
+  },
+  {
+    value: "send",
+    label: "Send",
+    description:
+      "Send the first N pending segments (0 sends all), delay 0 follows TCP segment 2 delay",
+  },
+  {
+    value: "oob",
+    label: "OOB",
+    description: "Send a fake urgent byte ahead of the next pending segment",
+  },
+  { value: "delay", label: "Delay", description: "Wait before the next step" },
+  {
+    value: "fragment",
+    label: "Fragment",
+    description: "Send what is pending with a fragmentation strategy",
+  },
+];
+
+const TEMPLATES: { value: string; label: string; steps: PipelineStep[] }[] = [
+  {
+    value: "standard",
+    label: "Standard",
+    steps: [
+      { type: "mutate" },
+      { type: "desync" },
+      { type: "window" },
+      { type: "fake" },
+      { type: "fragment" },
+    ],
+  },
+  {
+    value: "fake-split",
+    label: "Fake → split (tcp)",
+    steps: [
+      { type: "mutate" },
+      { type: "desync" },
+      { type: "window" },
+      { type: "fake" },
+      { type: "split" },
+      { type: "send" },
+    ],
+  },
+  {
+    value: "fake-split-reverse",
+    label: "Fake → split → reverse (tcp, reverse order)",
+    steps: [
+      { type: "mutate" },
+      { type: "desync" },
+      { type: "window" },
+      { type: "fake" },
+      { type: "split" },
+      { type: "disorder", count: 2 },
+      { type: "send" },
+    ],
+  },
+  {
+    value: "oob",
+    label: "Fake → split → urgent byte (oob)",
+    steps: [
+      { type: "mutate" },
+      { type: "desync" },
+      { type: "window" },
+      { type: "fake" },
+      { type: "split", at: "oob" },
+      { type: "send", count: 1 },
+      { type: "oob" },
+      { type: "send" },
+    ],
+  },
+  {
+    value: "disorder",
+    label: "Fake → split around SNI → disorder",
+    steps: [
+      { type: "mutate" },
+      { type: "desync" },
+      { type: "window" },
+      { type: "fake" },
+      { type: "split", at: "sni" },
+      { type: "split", at: "sni-mid" },
+      { type: "split", at: "sni-end" },
+      { type: "disorder" },
+      { type: "send" },
+    ],
+  },
+  {
+    value: "sni-split",
+    label: "Fake → split around SNI",
+    steps: [
+      { type: "mutate" },
+      { type: "desync" },
+      { type: "window" },
+      { type: "fake" },
+      { type: "split", at: "sni" },
+      { type: "split", at: "sni-end" },
+      { type: "send" },
+    ],
+  },
+  {
+    value: "fake-split-fake-disorder",
+    label: "Fake → split → fake → disorder",
+    steps: [
+      { type: "fake", count: 1 },
+      { type: "split", at: "sni-mid" },
+      { type: "fake", count: 1 },
+      { type: "disorder" },
+    ],
+  },
+  {
+    value: "fake-split-send-fake",
+    label: "Fake → split → send → fake",
+    steps: [
+      { type: "fake", count: 1 },
+      { type: "split", at: "sni-mid" },
+      { type: "send", count: 1 },
+      { type: "fake", count: 1 },
+    ],
+  },
+];
+
+const FRAGMENT_STRATEGIES: FragmentationStrategy[] = [
+  "combo",
+  "hybrid",
+  "disorder",
+  "overlap",
+  "extsplit",
+  "firstbyte",
+  "tcp",
+  "ip",
+  "tls",
+  "oob",
+  "none",
+];
+
+export const PipelineSettings = ({ config, onChange }: PipelineSettingsProps) => {
+  const steps = config.pipeline ?? [];
+
+  const update = (next: PipelineStep[]) => onChange("pipeline", next);
+  const setStep = (i: number, step: PipelineStep) =>
+    update(steps.map((s, j) => (j === i ? step : s)));
+  const move = (i: number, by: number) => {
+    const next = [...steps];
+    [next[i], next[i + by]] = [next[i + by], next[i]];
+    update(next);
+  };
+
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle>Strategy Pipeline</CardTitle>
+        <CardDescription>
+          Steps run in order over the ClientHello. Segments still pending after
+          the last step are sent as they are. The fragmentation strategy only
+          applies to Fragment steps.
+        </CardDescription>
+      </CardHeader>
+      <CardContent className="flex flex-col gap-3">
+        <Select
+          value=""
+          onValueChange={(value) =>
+            update(
+              (TEMPLATES.find((t) => t.value === value)?.steps ?? []).map(
+                (s) => ({ ...s })
+              )
+            )
+          }
+        >
+          <SelectTrigger>
+            <SelectValue placeholder="Load a template" />
+          </SelectTrigger>
+          <SelectContent>
+            {TEMPLATES.map((t) => (
+              <SelectItem key={t.value} value={t.value}>
+                {t.label}
+              </SelectItem>
+            ))}
+          </SelectContent>
+        </Select>
+
+        {steps.map((step, i) => (
+          <div key={i} className="flex items-center gap-2">
+            <span className="w-6 text-sm text-muted-foreground">{i + 1}</span>
+            <Select
+              value={step.type}
+              onValueChange={(value) =>
+                setStep(i, { type: value as PipelineStepType })
+              }
+            >
+              <SelectTrigger className="w-40">
+                <SelectValue />
+              </SelectTrigger>
+              <SelectContent>
+                {STEP_TYPES.map((t) => (
+                  <SelectItem key={t.value} value={t.value}>
+                    {t.label}
+                  </SelectItem>
+                ))}
+              </SelectContent>
+            </Select>
+
+            {step.type === "split" && (
+              <Input
+                className="w-32"
+                value={step.at ?? ""}
+                placeholder="sni-mid"
+                onChange={(e) => setStep(i, { ...step, at: e.target.value })}
+              />
+            )}
+            {(step.type === "fake" ||
+              step.type === "send" ||
+              step.type === "disorder") && (
+              <Input
+                className="w-24"
+                type="number"
+                min={0}
+                value={step.count ?? 0}
+                onChange={(e) =>
+                  setStep(i, { ...step, count: Number(e.target.value) })
+                }
+              />
+            )}
+            {(step.type === "delay" || step.type === "send") && (
+              <Input
+                className="w-24"
+                type="number"
+                min={0}
+                value={step.delay_ms ?? 0}
+                placeholder="ms"
+                onChange={(e) =>
+                  setStep(i, { ...step, delay_ms: Number(e.target.value) })
+                }
+              />
+            )}
+            {step.type === "fragment" && (
+              <Select
+                value={step.strategy ?? "set"}
+                onValueChange={(value) =>
+                  setStep(i, {
+                    ...step,
+                    strategy:
+                      value === "set"
+                        ? undefined
+                        : (value as FragmentationStrategy),
+                  })
+                }
+              >
+                <SelectTrigger className="w-40">
+                  <SelectValue />
+                </SelectTrigger>
+                <SelectContent>
+                  <SelectItem value="set">Set strategy</SelectItem>
+                  {FRAGMENT_STRATEGIES.map((s) => (
+                    <SelectItem key={s} value={s}>
+                      {s}
+                    </SelectItem>
+                  ))}
+                </SelectContent>
+              </Select>
+            )}
+
+            <FieldDescription className="flex-1">
+              {STEP_TYPES.find((t) => t.value === step.type)?.description}
+            </FieldDescription>
+
+            <Button
+              variant="ghost"
+              size="icon"
+              disabled={i === 0}
+              onClick={() => move(i, -1)}
+            >
+              <CollapseIcon />
+            </Button>
+            <Button
+              variant="ghost"
+              size="icon"
+              disabled={i === steps.length - 1}
+              onClick={() => move(i, 1)}
+            >
+              <ExpandIcon />
+            </Button>
+            <Button
+              variant="ghost"
+              size="icon"
+              onClick={() => update(steps.filter((_, j) => j !== i))}
+            >
+              <ClearIcon />
+            </Button>
+          </div>
+        ))}
+
+        <Button
+          variant="outline"
+          className="self-start"
+          onClick={() => update([...steps, { type: "fragment" }])}
+        >
+          <AddIcon />
+          Add Step
+        </Button>
+      </CardContent>
+    </Card>
+  );
+};
@@ -34,11 +34,12 @@ import {
 import { cn } from "@design/lib/utils";
 import { B4SetConfig, MAIN_SET_ID } from "@models/config";
 import { useMemo, useState } from "react";
-import { SetStats } from "./Manager";
+import { SetCounters, SetStats } from "./Manager";
 
 interface SetCardProps {
   set: B4SetConfig;
   stats?: SetStats;
+  counters?: SetCounters;
   index: number;
   onEdit: () => void;
   onDuplicate: () => void;
@@ -110,6 +111,7 @@ const FAKE_STRATEGY_LABELS: Record<string, string> = {
 export const SetCard = ({
   set,
   stats,
+  counters,
   index,
   onEdit,
   onDuplicate,
@@ -122,8 +124,12 @@ export const SetCard = ({
   const isMain = set.id === MAIN_SET_ID;
   const strategy = set.fragmentation.strategy;
 
-  const domainCount = stats?.total_domains ?? set.targets.sni_domains.length;
-  const ipCount = stats?.total_ips ?? set.targets.ip.length;
+  const domainCount =
+    stats?.effective_domains ?? set.targets.sni_domains.length;
+  const ipCount = stats?.effective_ips ?? set.targets.ip.length;
+  const matchCount = counters
+    ? counters.sni_matches + counters.ip_matches + counters.port_matches
+    : 0;
 
   // Calculate total targets count
   const totalTargets = useMemo(() => {
@@ -344,6 +350,8 @@ export const SetCard = ({
                 <p>
                   {stats?.manual_domains || 0} manual,{" "}
                   {stats?.geosite_domains || 0} geosite
+                  {!!stats?.excluded_domains &&
+                    `, ${stats.excluded_domains} excluded`}
                 </p>
               </TooltipContent>
             </Tooltip>
@@ -360,9 +368,41 @@ export const SetCard = ({
               <TooltipContent>
                 <p>
                   {stats?.manual_ips || 0} manual, {stats?.geoip_ips || 0} geoip
+                  {!!stats?.excluded_ips && `, ${stats.excluded_ips} excluded`}
                 </p>
               </TooltipContent>
             </Tooltip>
+            {counters && (
+              <Tooltip>
+                <TooltipTrigger asChild>
+                  <div className="flex items-center gap-1.5 w-fit">
+                    <span className="text-sm font-semibold text-foreground">
+                      {matchCount.toLocaleString()}
+                    </span>
+                    <span className="text-xs text-muted-foreground">
+                      matches
+                    </span>
+                  </div>
+                </TooltipTrigger>
+                <TooltipContent>
+                  <p>
+                    {counters.sni_matches} SNI, {counters.ip_matches} IP,{" "}
+                    {counters.port_matches} port
+                  </p>
+                  <p>
+                    {counters.packets_injected} injected,{" "}
+                    {counters.fakes_sent} fakes
+                  </p>
+                  <p>
+                    {counters.last_match
+                      ? `Last match ${new Date(
+                          counters.last_match
+                        ).toLocaleString()}`
+                      : "Never matched"}
+                  </p>
+                </TooltipContent>
+              </Tooltip>
+            )}
           </div>
 
           {/* Combined techniques and flags */}
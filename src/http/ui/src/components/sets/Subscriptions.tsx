@@ -0,0 +1,232 @@
+import { AddIcon, ClearIcon, RefreshIcon, IconLoader2 } from "@b4.icons";
+import { useCallback, useEffect, useState } from "react";
+
+import { Alert, AlertDescription } from "@design/components/ui/alert";
+import { Badge } from "@design/components/ui/badge";
+import { Button } from "@design/components/ui/button";
+import { Field, FieldLabel } from "@design/components/ui/field";
+import { Input } from "@design/components/ui/input";
+import {
+  Select,
+  SelectContent,
+  SelectItem,
+  SelectTrigger,
+  SelectValue,
+} from "@design/components/ui/select";
+import { Switch } from "@design/components/ui/switch";
+import {
+  SubscriptionConfig,
+  SubscriptionFormat,
+  SubscriptionStatus,
+} from "@models/config";
+
+const FORMATS: { value: SubscriptionFormat; label: string }[] = [
+  { value: "plain", label: "Plain list" },
+  { value: "hosts", label: "Hosts file" },
+  { value: "adblock", label: "Adblock (||domain^)" },
+  { value: "json", label: "JSON / sing-box rule set" },
+  { value: "geosite", label: "geosite.dat" },
+  { value: "geoip", label: "geoip.dat" },
+];
+
+const NEW_SUBSCRIPTION: SubscriptionConfig = {
+  enabled: true,
+  url: "",
+  format: "plain",
+  categories: [],
+  interval: 1440,
+  sha256: "",
+  checksum_url: "",
+};
+
+interface SubscriptionsProps {
+  value: SubscriptionConfig[];
+  onChange: (subscriptions: SubscriptionConfig[]) => void;
+}
+
+export const Subscriptions = ({ value, onChange }: SubscriptionsProps) => {
+  const [statuses, setStatuses] = useState<SubscriptionStatus[]>([]);
+  const [refreshing, setRefreshing] = useState(false);
+
+  const loadStatuses = useCallback(async () => {
+    try {
+      const response = await fetch("/api/subscriptions");
+      if (response.ok) {
+        setStatuses((await response.json()) as SubscriptionStatus[]);
+      }
+    } catch (error) {
+      console.error("Failed to load subscriptions:", error);
+    }
+  }, []);
+
+  useEffect(() => {
+    void loadStatuses();
+  }, [loadStatuses]);
+
+  const refresh = async () => {
+    setRefreshing(true);
+    try {
+      const response = await fetch("/api/subscriptions/refresh", {
+        method: "POST",
+      });
+      if (response.ok) {
+        setStatuses((await response.json()) as SubscriptionStatus[]);
+      }
+    } catch (error) {
+      console.error("Failed to refresh subscriptions:", error);
+    } finally {
+      setRefreshing(false);
+    }
+  };
+
+  const update = (index: number, patch: Partial<SubscriptionConfig>) => {
+    onChange(value.map((s, i) => (i === index ? { ...s, ...patch } : s)));
+  };
+
+  const statusOf = (sub: SubscriptionConfig) =>
+    statuses.find((s) => s.url === sub.url && s.format === sub.format);
+
+  return (
+    <div className="flex flex-col gap-4">
+      <Alert>
+        <AlertDescription>
+          Remote lists are downloaded next to the config file and refreshed in
+          the background. Changed lists are applied without a restart; a
+          download that fails its checksum or has no entries keeps the previous
+          version.
+        </AlertDescription>
+      </Alert>
+
+      {value.map((sub, index) => {
+        const status = statusOf(sub);
+        const isDat = sub.format === "geosite" || sub.format === "geoip";
+        return (
+          <div
+            key={index}
+            className="flex flex-col gap-3 rounded-md border border-border p-4"
+          >
+            <div className="flex items-center gap-2">
+              <Switch
+                checked={sub.enabled}
+                onCheckedChange={(checked: boolean) =>
+                  update(index, { enabled: checked })
+                }
+              />
+              <Input
+                className="flex-1"
+                value={sub.url}
+                onChange={(e) => update(index, { url: e.target.value })}
+                placeholder="https://example.com/list.txt"
+              />
+              <Button
+                variant="ghost"
+                size="icon"
+                onClick={() => onChange(value.filter((_, i) => i !== index))}
+              >
+                <ClearIcon className="h-4 w-4" />
+              </Button>
+            </div>
+            <div className="grid grid-cols-1 md:grid-cols-3 gap-4">
+              <Field>
+                <FieldLabel>Format</FieldLabel>
+                <Select
+                  value={sub.format}
+                  onValueChange={(v) =>
+                    update(index, { format: v as SubscriptionFormat })
+                  }
+                >
+                  <SelectTrigger>
+                    <SelectValue />
+                  </SelectTrigger>
+                  <SelectContent>
+                    {FORMATS.map((f) => (
+                      <SelectItem key={f.value} value={f.value}>
+                        {f.label}
+                      </SelectItem>
+                    ))}
+                  </SelectContent>
+                </Select>
+              </Field>
+              <Field>
+                <FieldLabel>Refresh interval (minutes, 0 = once)</FieldLabel>
+                <Input
+                  type="number"
+                  min={0}
+                  value={sub.interval}
+                  onChange={(e) =>
+                    update(index, { interval: Number(e.target.value) })
+                  }
+                />
+              </Field>
+              <Field>
+                <FieldLabel>Checksum URL</FieldLabel>
+                <Input
+                  value={sub.checksum_url}
+                  onChange={(e) =>
+                    update(index, { checksum_url: e.target.value })
+                  }
+                  placeholder="https://example.com/list.txt.sha256sum"
+                />
+              </Field>
+            </div>
+            {isDat && (
+              <Field>
+                <FieldLabel>Categories</FieldLabel>
+                <Input
+                  value={sub.categories.join(", ")}
+                  onChange={(e) =>
+                    update(index, {
+                      categories: e.target.value
+                        .split(",")
+                        .map((c) => c.trim())
+                        .filter(Boolean),
+                    })
+                  }
+                  placeholder="youtube, google@cn"
+                />
+              </Field>
+            )}
+            {status && (
+              <div className="flex flex-wrap items-center gap-2 text-xs text-muted-foreground">
+                {status.error ? (
+                  <Badge variant="destructive">{status.error}</Badge>
+                ) : (
+                  <Badge variant="secondary">
+                    {status.entries} {isDat ? "categories" : "entries"}
+                  </Badge>
+                )}
+                {status.updated_at && !status.updated_at.startsWith("0001") && (
+                  <span>
+                    Updated {new Date(status.updated_at).toLocaleString()}
+                  </span>
+                )}
+              </div>
+            )}
+          </div>
+        );
+      })}
+
+      <div className="flex gap-2">
+        <Button
+          variant="secondary"
+          onClick={() => onChange([...value, { ...NEW_SUBSCRIPTION }])}
+        >
+          <AddIcon className="h-4 w-4 mr-2" />
+          Add Subscription
+        </Button>
+        <Button
+          variant="outline"
+          onClick={() => void refresh()}
+          disabled={refreshing || value.length === 0}
+        >
+          {refreshing ? (
+            <IconLoader2 className="h-4 w-4 mr-2 animate-spin" />
+          ) : (
+            <RefreshIcon className="h-4 w-4 mr-2" />
+          )}
+          Refresh Now
+        </Button>
+      </div>
+    </div>
+  );
+};
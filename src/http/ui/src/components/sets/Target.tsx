@@ -6,6 +6,7 @@ import {
   CategoryIcon,
   ClearIcon,
   DomainIcon,
+  CloudDownloadIcon,
 } from "@b4.icons";
 import * as React from "react";
 import { useDeferredValue, useEffect, useState, useTransition } from "react";
@@ -52,14 +53,18 @@ import {
   TooltipContent,
   TooltipTrigger,
 } from "@design/components/ui/tooltip";
-import { B4SetConfig, GeoConfig } from "@models/config";
+import { B4SetConfig, GeoConfig, SubscriptionConfig } from "@models/config";
 import { SetStats } from "./Manager";
+import { Subscriptions } from "./Subscriptions";
 
 interface TargetSettingsProps {
   config: B4SetConfig;
   geo: GeoConfig;
   stats?: SetStats;
-  onChange: (field: string, value: string | string[]) => void;
+  onChange: (
+    field: string,
+    value: string | string[] | SubscriptionConfig[]
+  ) => void;
 }
 
 interface CategoryPreview {
@@ -131,6 +136,12 @@ function CategoryList({
       return options.slice(0, MAX_DISPLAYED_RESULTS);
     }
 
+    // Offer attribute filters like "google@cn" for known categories
+    const [tag, ...attrs] = searchTerm.split("@");
+    if (attrs.length > 0 && attrs.every(Boolean) && options.includes(tag)) {
+      return [searchTerm];
+    }
+
     // Use fuzzy search for better matching
     // First try exact matches (starts with), then fuzzy matches
     const exactMatches: string[] = [];
@@ -321,6 +332,8 @@ export const TargetSettings = ({
   const [tabValue, setTabValue] = useState(0);
   const [newBypassDomain, setNewBypassDomain] = useState("");
   const [newBypassIP, setNewBypassIP] = useState("");
+  const [newExcludeDomain, setNewExcludeDomain] = useState("");
+  const [newExcludeIP, setNewExcludeIP] = useState("");
 
   const { categories: availableCategories, loading: loadingCategories } =
     useCategories("/api/geosite", !!geo.sitedat_path);
@@ -342,6 +355,18 @@ export const TargetSettings = ({
     "targets.sni_domains"
   );
   const ipsManager = useListManager(config.targets.ip, onChange, "targets.ip");
+  const excludeGeosite = config.targets.exclude_geosite_categories ?? [];
+  const excludeGeoip = config.targets.exclude_geoip_categories ?? [];
+  const excludeDomainsManager = useListManager(
+    config.targets.exclude_domains ?? [],
+    onChange,
+    "targets.exclude_domains"
+  );
+  const excludeIpsManager = useListManager(
+    config.targets.exclude_ips ?? [],
+    onChange,
+    "targets.exclude_ips"
+  );
 
   const previewCategory = React.useCallback(async (category: string) => {
     setPreviewDialog({ open: true, category, loading: true });
@@ -424,6 +449,24 @@ export const TargetSettings = ({
                       <span>Bypass IPs</span>
                     </div>
                   </TabsTrigger>
+                  <TabsTrigger
+                    value="2"
+                    className="data-[state=active]:border-b-2 data-[state=active]:border-primary rounded-none border-b-2 border-transparent"
+                  >
+                    <div className="flex items-center gap-1.5">
+                      <ClearIcon />
+                      <span>Exclusions</span>
+                    </div>
+                  </TabsTrigger>
+                  <TabsTrigger
+                    value="3"
+                    className="data-[state=active]:border-b-2 data-[state=active]:border-primary rounded-none border-b-2 border-transparent"
+                  >
+                    <div className="flex items-center gap-1.5">
+                      <CloudDownloadIcon />
+                      <span>Subscriptions</span>
+                    </div>
+                  </TabsTrigger>
                 </TabsList>
 
                 {/* DPI Bypass Tab */}
@@ -698,6 +741,185 @@ export const TargetSettings = ({
                     )}
                   </div>
                 </TabsContent>
+
+                {/* Exclusions Tab */}
+                <TabsContent value="2" className="pt-6">
+                  <Alert className="mb-4">
+                    <AlertDescription>
+                      Excluded domains and IPs are never handled by this set,
+                      even when a bypass target above covers them.
+                    </AlertDescription>
+                  </Alert>
+                  <div className="grid grid-cols-1 md:grid-cols-2 gap-4">
+                    <div className="flex flex-col gap-1.5">
+                      <Label className="text-sm font-medium">
+                        <DomainIcon className="h-5 w-5" /> Excluded Domains
+                      </Label>
+                      <div className="flex gap-2 items-start">
+                        <Field className="flex-1">
+                          <Input
+                            value={newExcludeDomain}
+                            onChange={(e) =>
+                              setNewExcludeDomain(e.target.value)
+                            }
+                            onKeyDown={(e) => {
+                              if (
+                                e.key === "Enter" ||
+                                e.key === "Tab" ||
+                                e.key === ","
+                              ) {
+                                e.preventDefault();
+                                excludeDomainsManager.addItems(
+                                  newExcludeDomain,
+                                  setNewExcludeDomain
+                                );
+                              }
+                            }}
+                            placeholder="accounts.google.com"
+                          />
+                        </Field>
+                        <Button
+                          variant="secondary"
+                          size="icon"
+                          onClick={() =>
+                            excludeDomainsManager.addItems(
+                              newExcludeDomain,
+                              setNewExcludeDomain
+                            )
+                          }
+                          disabled={!newExcludeDomain.trim()}
+                        >
+                          <AddIcon className="h-4 w-4" />
+                        </Button>
+                      </div>
+                      <ChipList
+                        items={config.targets.exclude_domains ?? []}
+                        getKey={(d) => d}
+                        getLabel={(d) => d}
+                        onDelete={excludeDomainsManager.removeItem}
+                        emptyMessage="No excluded domains"
+                        showEmpty
+                      />
+                    </div>
+                    <div className="flex flex-col gap-1.5">
+                      <Label className="text-sm font-medium">
+                        <IpIcon className="h-5 w-5" /> Excluded IPs
+                      </Label>
+                      <div className="flex gap-2 items-start">
+                        <Field className="flex-1">
+                          <Input
+                            value={newExcludeIP}
+                            onChange={(e) => setNewExcludeIP(e.target.value)}
+                            onKeyDown={(e) => {
+                              if (
+                                e.key === "Enter" ||
+                                e.key === "Tab" ||
+                                e.key === ","
+                              ) {
+                                e.preventDefault();
+                                excludeIpsManager.addItems(
+                                  newExcludeIP,
+                                  setNewExcludeIP
+                                );
+                              }
+                            }}
+                            placeholder="10.0.0.0/8"
+                          />
+                        </Field>
+                        <Button
+                          variant="secondary"
+                          size="icon"
+                          onClick={() =>
+                            excludeIpsManager.addItems(
+                              newExcludeIP,
+                              setNewExcludeIP
+                            )
+                          }
+                          disabled={!newExcludeIP.trim()}
+                        >
+                          <AddIcon className="h-4 w-4" />
+                        </Button>
+                      </div>
+                      <ChipList
+                        items={config.targets.exclude_ips ?? []}
+                        getKey={(ip) => ip}
+                        getLabel={(ip) => ip}
+                        onDelete={excludeIpsManager.removeItem}
+                        emptyMessage="No excluded ip"
+                        showEmpty
+                      />
+                    </div>
+                    {geo.sitedat_path && (
+                      <div className="flex flex-col gap-1.5">
+                        <Label className="text-sm font-medium">
+                          <CategoryIcon className="h-5 w-5" /> Excluded
+                          GeoSite Categories
+                        </Label>
+                        <CategoryList
+                          value={excludeGeosite}
+                          options={availableCategories}
+                          onValueChange={(values) =>
+                            onChange(
+                              "targets.exclude_geosite_categories",
+                              values
+                            )
+                          }
+                          loading={loadingCategories}
+                          searchPlaceholder="Search categories..."
+                          onCategoryClick={(c) => void previewCategory(c)}
+                        />
+                        <ChipList
+                          items={excludeGeosite}
+                          getKey={(c) => c}
+                          getLabel={(c) => c}
+                          onDelete={(c) =>
+                            onChange(
+                              "targets.exclude_geosite_categories",
+                              excludeGeosite.filter((cat) => cat !== c)
+                            )
+                          }
+                          onClick={(c) => void previewCategory(c)}
+                        />
+                      </div>
+                    )}
+                    {geo.ipdat_path && availableGeoIPCategories.length > 0 && (
+                      <div className="flex flex-col gap-1.5">
+                        <Label className="text-sm font-medium">
+                          <CategoryIcon className="h-5 w-5" /> Excluded GeoIP
+                          Categories
+                        </Label>
+                        <CategoryList
+                          value={excludeGeoip}
+                          options={availableGeoIPCategories}
+                          onValueChange={(values: string[]) =>
+                            onChange("targets.exclude_geoip_categories", values)
+                          }
+                          loading={loadingGeoIPCategories}
+                          searchPlaceholder="Search GeoIP categories..."
+                        />
+                        <ChipList
+                          items={excludeGeoip}
+                          getKey={(c) => c}
+                          getLabel={(c) => c}
+                          onDelete={(c) =>
+                            onChange(
+                              "targets.exclude_geoip_categories",
+                              excludeGeoip.filter((cat) => cat !== c)
+                            )
+                          }
+                        />
+                      </div>
+                    )}
+                  </div>
+                </TabsContent>
+
+                {/* Subscriptions Tab */}
+                <TabsContent value="3" className="pt-6">
+                  <Subscriptions
+                    value={config.targets.subscriptions ?? []}
+                    onChange={(subs) => onChange("targets.subscriptions", subs)}
+                  />
+                </TabsContent>
               </Tabs>
             </div>
           </CardContent>
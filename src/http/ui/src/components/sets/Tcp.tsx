@@ -74,7 +74,7 @@ const windowModeDescriptions: Record<WindowMode, string> = {
   escalate: "Gradually increase: 0 → 100 → 500 → 1460 → 8192 → 32768 → 65535",
 };
 
-export const TcpSettings = ({ config, main, onChange }: TcpSettingsProps) => {
+export const TcpSettings = ({ config, onChange }: TcpSettingsProps) => {
   const [newWinValue, setNewWinValue] = useState("");
 
   const winValues = config.tcp.win_values || [0, 1460, 8192, 65535];
@@ -134,14 +134,13 @@ export const TcpSettings = ({ config, main, onChange }: TcpSettingsProps) => {
                   onChange("tcp.conn_bytes_limit", values[0])
                 }
                 min={1}
-                max={main.id === config.id ? 100 : main.tcp.conn_bytes_limit}
+                max={100}
                 step={1}
                 className="w-full"
               />
               <FieldDescription>
-                {main.id === config.id
-                  ? "Main set limit (changing requires service restart to take effect)"
-                  : `Max: ${main.tcp.conn_bytes_limit} (limited by main set)`}
+                Packets per connection queued for this set's ports (the largest
+                limit wins when sets share a port)
               </FieldDescription>
             </Field>
           </div>
@@ -169,6 +168,21 @@ export const TcpSettings = ({ config, main, onChange }: TcpSettingsProps) => {
             </Field>
           </div>
 
+          <div>
+            <Field>
+              <FieldLabel>Port Filter</FieldLabel>
+              <Input
+                value={config.tcp.dport_filter}
+                onChange={(e) => onChange("tcp.dport_filter", e.target.value)}
+                placeholder="443"
+              />
+              <FieldDescription>
+                TCP ports this set targets, e.g. 443,8443,2053 - leave empty for
+                443
+              </FieldDescription>
+            </Field>
+          </div>
+
           {/* SACK and SYN Fake */}
           <div>
             <label htmlFor="switch-tcp-drop-sack">
@@ -75,7 +75,21 @@ const UDP_FAKING_STRATEGIES = [
   { value: "checksum", label: "Checksum", description: "Corrupt UDP checksum" },
 ];
 
-export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
+const UDP_QUIC_SPLITS = [
+  {
+    value: "off",
+    label: "Off",
+    description: "Send the Initial as IP fragments split at the SNI",
+  },
+  {
+    value: "reorder",
+    label: "Reorder CRYPTO",
+    description:
+      "Re-encrypt the Initial with the ClientHello cut at the SNI and the second part first",
+  },
+];
+
+export const UdpSettings = ({ config, onChange }: UdpSettingsProps) => {
   const isQuicEnabled = config.udp.filter_quic !== "disabled";
   const hasPortFilter =
     config.udp.dport_filter && config.udp.dport_filter.trim() !== "";
@@ -270,14 +284,13 @@ export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
                       onChange("udp.conn_bytes_limit", values[0])
                     }
                     min={1}
-                    max={main.id === config.id ? 30 : main.udp.conn_bytes_limit}
+                    max={30}
                     step={1}
                     className="w-full"
                   />
                   <FieldDescription>
-                    {main.id === config.id
-                      ? "Main set limit (changing requires service restart to take effect)"
-                      : `Max: ${main.udp.conn_bytes_limit} (limited by main set)`}
+                    Packets per connection queued for this set's ports (the largest
+                    limit wins when sets share a port)
                   </FieldDescription>
                 </Field>
               </div>
@@ -346,6 +359,44 @@ export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
                 </Field>
               </div>
 
+              <div>
+                <label htmlFor="switch-udp-fake-quic">
+                  <Field orientation="horizontal" className="has-[>[data-state=checked]]:bg-primary/5 dark:has-[>[data-state=checked]]:bg-primary/10 has-[>[data-checked]]:bg-primary/5 dark:has-[>[data-checked]]:bg-primary/10 p-2">
+                    <FieldContent>
+                      <FieldTitle>QUIC Initial Fakes</FieldTitle>
+                      <FieldDescription>
+                        Send encrypted QUIC Initials with a decoy SNI instead of
+                        zero-filled packets
+                      </FieldDescription>
+                    </FieldContent>
+                    <Switch
+                      id="switch-udp-fake-quic"
+                      checked={config.udp.fake_quic}
+                      onCheckedChange={(checked) =>
+                        onChange("udp.fake_quic", checked)
+                      }
+                    />
+                  </Field>
+                </label>
+              </div>
+
+              {config.udp.fake_quic && (
+                <div>
+                  <Field>
+                    <FieldLabel>Decoy SNI</FieldLabel>
+                    <Input
+                      value={config.udp.fake_sni}
+                      onChange={(e) => onChange("udp.fake_sni", e.target.value)}
+                      placeholder="www.google.com"
+                    />
+                    <FieldDescription>
+                      Server name in the fake ClientHello, each fake uses a new
+                      random connection ID
+                    </FieldDescription>
+                  </Field>
+                </div>
+              )}
+
               <div>
                 <Field className="w-full space-y-2">
                   <div className="flex items-center justify-between">
@@ -420,6 +471,36 @@ export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
                   <FieldDescription>Delay between segments</FieldDescription>
                 </Field>
               </div>
+
+              <div>
+                <Field>
+                  <FieldLabel>QUIC Initial Split</FieldLabel>
+                  <Select
+                    value={config.udp.quic_split || "off"}
+                    onValueChange={(value) =>
+                      onChange("udp.quic_split", value as string)
+                    }
+                  >
+                    <SelectTrigger>
+                      <SelectValue placeholder="Select QUIC split" />
+                    </SelectTrigger>
+                    <SelectContent>
+                      {UDP_QUIC_SPLITS.map((option) => (
+                        <SelectItem key={option.value} value={option.value}>
+                          {option.label}
+                        </SelectItem>
+                      ))}
+                    </SelectContent>
+                  </Select>
+                  <FieldDescription>
+                    {
+                      UDP_QUIC_SPLITS.find(
+                        (o) => o.value === (config.udp.quic_split || "off")
+                      )?.description
+                    }
+                  </FieldDescription>
+                </Field>
+              </div>
             </>
           )}
         </div>
@@ -3,6 +3,7 @@ import {
   CaptureIcon,
   ClearIcon,
   CopyIcon,
+  DescriptionIcon,
   DownloadIcon,
   RefreshIcon,
   SuccessIcon,
@@ -33,8 +34,10 @@ import {
   FieldLabel,
 } from "@design/components/ui/field";
 import { Input } from "@design/components/ui/input";
+import { Label } from "@design/components/ui/label";
 import { Separator } from "@design/components/ui/separator";
 import { Spinner } from "@design/components/ui/spinner";
+import { Switch } from "@design/components/ui/switch";
 import {
   Tooltip,
   TooltipContent,
@@ -45,7 +48,10 @@ import { useEffect, useState } from "react";
 
 export const CaptureSettings = () => {
   const { showError, showSuccess } = useSnackbar();
-  const [probeForm, setProbeForm] = useState({ domain: "" });
+  const [probeForm, setProbeForm] = useState({
+    domain: "",
+    fullFlow: false,
+  });
   const [uploadForm, setUploadForm] = useState<{
     domain: string;
     file: File | null;
@@ -61,6 +67,7 @@ export const CaptureSettings = () => {
     clearAll,
     upload,
     download,
+    downloadPcap,
   } = useCaptures();
 
   useEffect(() => {
@@ -90,7 +97,7 @@ export const CaptureSettings = () => {
     }, 1000);
 
     try {
-      const result = await probe(capturedDomain, "tls");
+      const result = await probe(capturedDomain, "tls", probeForm.fullFlow);
       clearInterval(countdownInterval);
       setCountdown(null);
 
@@ -98,7 +105,7 @@ export const CaptureSettings = () => {
         showSuccess(`Already have payload for ${capturedDomain}`);
       } else if (captures.some((c) => c.domain === capturedDomain)) {
         showSuccess(`Captured payload for ${capturedDomain}`);
-        setProbeForm({ domain: "" });
+        setProbeForm((prev) => ({ ...prev, domain: "" }));
       } else {
         showError(`Capture timed out for ${capturedDomain}`);
       }
@@ -260,7 +267,10 @@ export const CaptureSettings = () => {
                 <Input
                   value={probeForm.domain}
                   onChange={(e) =>
-                    setProbeForm({ domain: e.target.value.toLowerCase() })
+                    setProbeForm((prev) => ({
+                      ...prev,
+                      domain: e.target.value.toLowerCase(),
+                    }))
                   }
                   onKeyPress={(e) => {
                     if (e.key === "Enter" && !loading && probeForm.domain) {
@@ -274,6 +284,18 @@ export const CaptureSettings = () => {
                   Enter domain to capture from
                 </FieldDescription>
               </Field>
+              <div className="flex items-center gap-2">
+                <Switch
+                  checked={probeForm.fullFlow}
+                  onCheckedChange={(checked: boolean) =>
+                    setProbeForm((prev) => ({ ...prev, fullFlow: checked }))
+                  }
+                  disabled={loading}
+                />
+                <Label className="font-medium cursor-pointer">
+                  Record full flow (original and sent packets as pcapng)
+                </Label>
+              </div>
               <div className="flex flex-row gap-2">
                 <Button
                   className="flex-1"
@@ -391,6 +413,7 @@ export const CaptureSettings = () => {
                   capture={capture}
                   onViewHex={() => setHexDialog({ open: true, capture })}
                   onDownload={() => download(capture)}
+                  onDownloadPcap={() => downloadPcap(capture)}
                   onDelete={() => void handleDelete(capture)}
                 />
               ))}
@@ -473,6 +496,7 @@ interface CaptureCardProps {
   capture: Capture;
   onViewHex: () => void;
   onDownload: () => void;
+  onDownloadPcap: () => void;
   onDelete: () => void;
 }
 
@@ -480,6 +504,7 @@ const CaptureCard = ({
   capture,
   onViewHex,
   onDownload,
+  onDownloadPcap,
   onDelete,
 }: CaptureCardProps) => {
   return (
@@ -527,6 +552,20 @@ const CaptureCard = ({
             <p>Download .bin</p>
           </TooltipContent>
         </Tooltip>
+        <Tooltip>
+          <TooltipTrigger asChild>
+            <Button size="sm" variant="ghost" onClick={onDownloadPcap}>
+              <DescriptionIcon className="h-4 w-4" />
+            </Button>
+          </TooltipTrigger>
+          <TooltipContent>
+            <p>
+              {capture.has_flow
+                ? "Download recorded flow (.pcapng)"
+                : "Download .pcapng"}
+            </p>
+          </TooltipContent>
+        </Tooltip>
         <div className="flex-1" />
         <Tooltip>
           <TooltipTrigger asChild>
@@ -114,6 +114,27 @@ export const FeatureSettings = ({ config, onChange }: FeatureSettingsProps) => {
                 />
               </Field>
             </label>
+            <label htmlFor="switch-system-tables-ip-prefilter">
+              <Field
+                orientation="horizontal"
+                className="has-[>[data-state=checked]]:bg-primary/5 dark:has-[>[data-state=checked]]:bg-primary/10 has-[>[data-checked]]:bg-primary/5 dark:has-[>[data-checked]]:bg-primary/10 p-2"
+              >
+                <FieldContent>
+                  <FieldTitle>Prefilter IP-only Sets in Firewall</FieldTitle>
+                  <FieldDescription>
+                    Queue only destinations of sets without domains; other
+                    connections on their ports are no longer logged
+                  </FieldDescription>
+                </FieldContent>
+                <Switch
+                  id="switch-system-tables-ip-prefilter"
+                  checked={config.system.tables.ip_prefilter}
+                  onCheckedChange={(checked: boolean) =>
+                    onChange("system.tables.ip_prefilter", checked)
+                  }
+                />
+              </Field>
+            </label>
             <Field className="w-full space-y-2">
               <div className="flex items-center justify-between">
                 <FieldLabel className="text-sm font-medium">
@@ -13,14 +13,35 @@ import {
   FieldLabel,
 } from "@design/components/ui/field";
 import { Input } from "@design/components/ui/input";
+import {
+  Select,
+  SelectContent,
+  SelectItem,
+  SelectTrigger,
+  SelectValue,
+} from "@design/components/ui/select";
 import { Slider } from "@design/components/ui/slider";
 import { B4Config } from "@models/config";
 
 interface NetworkSettingsProps {
   config: B4Config;
-  onChange: (field: string, value: number) => void;
+  onChange: (field: string, value: number | string) => void;
 }
 
+const QUEUE_SENDERS = [
+  {
+    value: "raw",
+    label: "Raw socket",
+    description: "Send through the kernel IP stack (default)",
+  },
+  {
+    value: "packet",
+    label: "AF_PACKET",
+    description:
+      "Write frames to the egress interface so fakes skip conntrack and NAT. Traffic forwarded from LAN clients still uses the raw socket",
+  },
+];
+
 export const NetworkSettings = ({ config, onChange }: NetworkSettingsProps) => (
   <Card>
     <CardHeader>
@@ -73,6 +94,30 @@ export const NetworkSettings = ({ config, onChange }: NetworkSettingsProps) => (
               (default 4)
             </FieldDescription>
           </Field>
+          <Field>
+            <FieldLabel>Packet Sender</FieldLabel>
+            <Select
+              value={config.queue.sender}
+              onValueChange={(value) => onChange("queue.sender", value)}
+            >
+              <SelectTrigger>
+                <SelectValue placeholder="Select sender" />
+              </SelectTrigger>
+              <SelectContent>
+                {QUEUE_SENDERS.map((option) => (
+                  <SelectItem key={option.value} value={option.value}>
+                    {option.label}
+                  </SelectItem>
+                ))}
+              </SelectContent>
+            </Select>
+            <FieldDescription>
+              {
+                QUEUE_SENDERS.find((o) => o.value === config.queue.sender)
+                  ?.description
+              }
+            </FieldDescription>
+          </Field>
         </div>
       </div>
     </CardContent>
@@ -20,35 +20,38 @@ export function useCaptures() {
     }
   }, []);
 
-  const probe = useCallback(async (domain: string, protocol: string) => {
-    setLoading(true);
-    try {
-      const result = await captureApi.probe(domain, protocol);
-
-      if (result.already_captured) {
-        return result;
-      }
+  const probe = useCallback(
+    async (domain: string, protocol: string, fullFlow = false) => {
+      setLoading(true);
+      try {
+        const result = await captureApi.probe(domain, protocol, fullFlow);
 
-      const normalizedDomain = domain.toLowerCase().trim();
-      for (let i = 0; i < 30; i++) {
-        await new Promise((r) => setTimeout(r, 1000));
-        const list = await captureApi.list();
-        const found = list.some(
-          (c) =>
-            c.domain === normalizedDomain &&
-            (protocol === "both" || c.protocol === protocol)
-        );
-        if (found) {
-          setCaptures(list);
+        if (result.already_captured) {
           return result;
         }
-      }
 
-      return result;
-    } finally {
-      setLoading(false);
-    }
-  }, []);
+        const normalizedDomain = domain.toLowerCase().trim();
+        for (let i = 0; i < 30; i++) {
+          await new Promise((r) => setTimeout(r, 1000));
+          const list = await captureApi.list();
+          const found = list.some(
+            (c) =>
+              c.domain === normalizedDomain &&
+              (protocol === "both" || c.protocol === protocol)
+          );
+          if (found) {
+            setCaptures(list);
+            return result;
+          }
+        }
+
+        return result;
+      } finally {
+        setLoading(false);
+      }
+    },
+    []
+  );
 
   const deleteCapture = useCallback(
     async (protocol: string, domain: string) => {
@@ -89,6 +92,18 @@ export function useCaptures() {
     document.body.removeChild(link);
   }, []);
 
+  const downloadPcap = useCallback((capture: Capture) => {
+    const link = document.createElement("a");
+    link.href = captureApi.pcapUrl(capture.protocol, capture.domain);
+    link.download = `${capture.protocol}_${capture.domain.replace(
+      /\./g,
+      "_"
+    )}.pcapng`;
+    document.body.appendChild(link);
+    link.click();
+    document.body.removeChild(link);
+  }, []);
+
   return {
     captures,
     loading,
@@ -98,5 +113,6 @@ export function useCaptures() {
     clearAll,
     upload,
     download,
+    downloadPcap,
   };
 }
@@ -5,6 +5,7 @@ export interface Capture {
   size: number;
   filepath: string;
   hex_data: string;
+  has_flow: boolean;
 }
 
 export interface CaptureProbeResponse {
@@ -89,6 +89,42 @@ export interface TargetsConfig {
   ip: string[];
   geosite_categories: string[];
   geoip_categories: string[];
+  exclude_domains: string[];
+  exclude_ips: string[];
+  exclude_geosite_categories: string[];
+  exclude_geoip_categories: string[];
+  subscriptions: SubscriptionConfig[];
+}
+
+export type SubscriptionFormat =
+  | "plain"
+  | "hosts"
+  | "adblock"
+  | "json"
+  | "geosite"
+  | "geoip";
+
+export interface SubscriptionConfig {
+  enabled: boolean;
+  url: string;
+  format: SubscriptionFormat;
+  categories: string[];
+  interval: number;
+  sha256: string;
+  checksum_url: string;
+}
+
+export interface SubscriptionStatus {
+  url: string;
+  format: SubscriptionFormat;
+  sets: string[];
+  etag?: string;
+  last_modified?: string;
+  sha256?: string;
+  entries: number;
+  checked_at: string;
+  updated_at: string;
+  error?: string;
 }
 
 export interface DomainStatisticsConfig {
@@ -109,6 +145,7 @@ export interface CategoryPreviewConfig {
 export type UdpMode = "drop" | "fake";
 export type UdpFilterQuicMode = "disabled" | "all" | "parse";
 export type UdpFakingStrategy = "none" | "ttl" | "checksum";
+export type UdpQuicSplitMode = "off" | "reorder";
 
 export interface UdpConfig {
   mode: UdpMode;
@@ -120,6 +157,9 @@ export interface UdpConfig {
   conn_bytes_limit: number;
   filter_stun: boolean;
   seg2delay: number;
+  quic_split: UdpQuicSplitMode;
+  fake_quic: boolean;
+  fake_sni: string;
 }
 export interface QueueConfig {
   start_num: number;
@@ -129,8 +169,11 @@ export interface QueueConfig {
   ipv6: boolean;
   interfaces: string[];
   devices: DevicesConfig;
+  sender: QueueSender;
 }
 
+export type QueueSender = "raw" | "packet";
+
 export interface DevicesConfig {
   mac: string[];
   enabled: boolean;
@@ -153,6 +196,7 @@ export interface TcpConfig {
   syn_fake_len: number;
   syn_ttl: number;
   drop_sack: boolean;
+  dport_filter: string;
 
   win_mode: WindowMode;
   win_values: number[];
@@ -168,6 +212,7 @@ export interface WebServerConfig {
 export interface TableConfig {
   monitor_interval: number;
   skip_setup: false;
+  ip_prefilter: boolean;
 }
 
 export interface GeoConfig {
@@ -201,6 +246,7 @@ export interface B4SetConfig {
   id: string;
   name: string;
   enabled: boolean;
+  priority: number;
 
   tcp: TcpConfig;
   udp: UdpConfig;
@@ -208,6 +254,28 @@ export interface B4SetConfig {
   faking: FakingConfig;
   targets: TargetsConfig;
   dns: DNSConfig;
+  http: HTTPConfig;
+  pipeline: PipelineStep[];
+}
+
+export type PipelineStepType =
+  | "mutate"
+  | "desync"
+  | "window"
+  | "fake"
+  | "split"
+  | "disorder"
+  | "send"
+  | "oob"
+  | "delay"
+  | "fragment";
+
+export interface PipelineStep {
+  type: PipelineStepType;
+  at?: string;
+  count?: number;
+  delay_ms?: number;
+  strategy?: FragmentationStrategy;
 }
 
 export type ComboShuffleMode = "middle" | "full" | "reverse";
@@ -236,5 +304,13 @@ export interface DNSConfig {
   fragment_query: boolean;
 }
 
+export interface HTTPConfig {
+  enabled: boolean;
+  host_split: boolean;
+  header_case: boolean;
+  host_space: boolean;
+  host_dot: boolean;
+}
+
 export const MAIN_SET_ID = "11111111-1111-1111-1111-111111111111";
 export const NEW_SET_ID = "00000000-0000-0000-0000-000000000000";
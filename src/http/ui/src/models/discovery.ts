@@ -17,14 +17,16 @@ export type StrategyFamily =
   | "combo"
   | "hybrid"
   | "window"
-  | "mutation";
+  | "mutation"
+  | "quic";
 
 export type DiscoveryPhase =
   | "baseline"
   | "strategy_detection"
   | "optimization"
   | "dns_detection"
-  | "combination";
+  | "combination"
+  | "quic";
 
 export interface DomainPresetResult {
   preset_name: string;
@@ -47,6 +49,7 @@ export interface DiscoveryResult {
   results: Record<string, DomainPresetResult>;
   baseline_speed?: number;
   improvement?: number;
+  best_quic_preset?: string;
 }
 
 export interface DiscoverySuite {
@@ -58,6 +61,29 @@ export interface DiscoverySuite {
   completed_checks: number;
   current_phase?: DiscoveryPhase;
   domain_discovery_results?: Record<string, DiscoveryResult>;
+  report?: BatchReport;
+}
+
+export interface DomainCluster {
+  preset: string;
+  quic_preset?: string;
+  domains: string[];
+  avg_speed: number;
+  set: B4SetConfig;
+}
+
+export interface BatchReport {
+  domains: string[];
+  baseline_speed: number;
+  clusters: DomainCluster[];
+  no_bypass: string[];
+  failed: string[];
+}
+
+export interface BatchDiscoveryRequest {
+  check_urls?: string[];
+  geosite_categories?: string[];
+  max_domains?: number;
 }
 
 export interface DiscoveryResponse {
@@ -65,5 +91,6 @@ export interface DiscoveryResponse {
   estimated_tests: number;
   message: string;
   domain: string;
+  domains?: string[];
   check_url: string;
 }
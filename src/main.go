@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/nfq"
 	"github.com/daniellavrushin/b4/quic"
+	"github.com/daniellavrushin/b4/subscription"
 	"github.com/daniellavrushin/b4/tables"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -29,6 +31,7 @@ var (
 	verboseFlag     string
 	showVersion     bool
 	clearTables     bool
+	tablesDryRun    bool
 	Version         = "dev"
 	Commit          = "none"
 	Date            = "unknown"
@@ -42,6 +45,40 @@ var rootCmd = &cobra.Command{
 	RunE:  runB4,
 }
 
+var (
+	planBackend string
+	planJSON    bool
+)
+
+var tablesCmd = &cobra.Command{
+	Use:   "tables",
+	Short: "Inspect the iptables/nftables rules managed by B4",
+}
+
+var tablesPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Print the firewall rules B4 would install and diff them against the installed ones",
+	Long: `Render the iptables commands or nftables ruleset for the current configuration
+without executing anything, followed by the difference from what is currently
+installed ("+" missing, "-" unexpected). Exits with status 2 if they differ.`,
+	RunE: runTablesPlan,
+}
+
+var (
+	simSet string
+	simIn  string
+	simOut string
+)
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Run a set's strategies on recorded packets and write what B4 would send to a pcap",
+	Long: `Read a pcap or pcapng capture, run every IPv4/IPv6 packet through the
+pipeline of one set as if it had matched, and write the packets B4 would
+inject or let through as a raw IP pcap for inspection in Wireshark.`,
+	RunE: runSimulate,
+}
+
 func init() {
 	// Bind all configuration flags
 	cfg.BindFlags(rootCmd)
@@ -50,6 +87,21 @@ func init() {
 	rootCmd.Flags().StringVar(&verboseFlag, "verbose", "info", "Set verbosity level (debug, trace, info, silent), default: info")
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Show version and exit")
 	rootCmd.Flags().BoolVar(&clearTables, "clear-tables", false, "Perform only iptables/nftables cleanup and exit")
+	rootCmd.Flags().BoolVar(&tablesDryRun, "tables-dry-run", false, "Print the iptables/nftables rules that would be installed and exit")
+
+	tablesPlanCmd.Flags().StringVar(&cfg.ConfigPath, "config", cfg.ConfigPath, "Path to config file")
+	tablesPlanCmd.Flags().StringVar(&planBackend, "backend", "", "Firewall backend to render (iptables, nftables), detected if empty")
+	tablesPlanCmd.Flags().BoolVar(&planJSON, "json", false, "Print the plan as JSON")
+	tablesCmd.AddCommand(tablesPlanCmd)
+	rootCmd.AddCommand(tablesCmd)
+
+	simulateCmd.Flags().StringVar(&cfg.ConfigPath, "config", cfg.ConfigPath, "Path to config file")
+	simulateCmd.Flags().StringVar(&simSet, "set", "", "ID or name of the set to run, the main set if empty")
+	simulateCmd.Flags().StringVar(&simIn, "in", "", "Capture to read (pcap or pcapng)")
+	simulateCmd.Flags().StringVar(&simOut, "out", "", "Pcap to write")
+	_ = simulateCmd.MarkFlagRequired("in")
+	_ = simulateCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(simulateCmd)
 
 }
 
@@ -96,6 +148,17 @@ func runB4(cmd *cobra.Command, args []string) error {
 		return log.Errorf("invalid configuration: %w", err)
 	}
 
+	if tablesDryRun {
+		plan, err := tables.PlanRules(&cfg, "")
+		if err != nil {
+			return err
+		}
+		fmt.Print(plan.Script)
+		return nil
+	}
+
+	handler.SetTablesPlanFunc(tables.PlanRules)
+
 	printConfigDefaults(cmd)
 
 	// Initialize metrics collector early
@@ -143,6 +206,14 @@ func runB4(cmd *cobra.Command, args []string) error {
 	metrics.RecordEvent("info", fmt.Sprintf("NFQueue started with %d threads", cfg.Queue.Threads))
 	metrics.NFQueueStatus = "active"
 
+	if !cfg.System.Tables.SkipSetup {
+		pool.OnConfigUpdate(func(c *config.Config) {
+			if err := tables.SyncTargetSets(c); err != nil {
+				log.Errorf("%v", err)
+			}
+		})
+	}
+
 	// Start tables monitor to handle rule restoration if system wipes them
 	var tablesMonitor *tables.Monitor
 	if !cfg.System.Tables.SkipSetup && cfg.System.Tables.MonitorInterval > 0 {
@@ -150,6 +221,12 @@ func runB4(cmd *cobra.Command, args []string) error {
 		tablesMonitor.Start()
 	}
 
+	// Start subscription manager to keep remote target lists up to date
+	subs := subscription.NewManager(pool)
+	subs.Start()
+	pool.OnConfigUpdate(func(*config.Config) { subs.Wake() })
+	handler.SetSubscriptionManager(subs)
+
 	// Start internal web server if configured
 	httpServer, err := b4http.StartServer(&cfg, pool)
 	if err != nil {
@@ -168,10 +245,86 @@ func runB4(cmd *cobra.Command, args []string) error {
 	log.Infof("Received signal: %v, shutting down gracefully", sig)
 	metrics.RecordEvent("info", fmt.Sprintf("Shutdown initiated by signal: %v", sig))
 
+	subs.Stop()
+
 	// Perform graceful shutdown with timeout
 	return gracefulShutdown(&cfg, pool, httpServer, metrics)
 }
 
+func runTablesPlan(cmd *cobra.Command, args []string) error {
+	if err := cfg.LoadWithMigration(cfg.ConfigPath); err != nil {
+		return err
+	}
+	plan, err := tables.PlanRules(&cfg, planBackend)
+	if err != nil {
+		return err
+	}
+
+	if planJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("# backend: %s\n%s\n", plan.Backend, plan.Script)
+		if plan.InSync {
+			fmt.Println("# installed rules match the plan")
+		} else {
+			fmt.Println("# diff against installed rules")
+			for _, l := range plan.Diff {
+				fmt.Println(l)
+			}
+		}
+	}
+
+	if !plan.InSync {
+		os.Exit(2)
+	}
+	return nil
+}
+
+func runSimulate(cmd *cobra.Command, args []string) error {
+	if err := cfg.LoadWithMigration(cfg.ConfigPath); err != nil {
+		return err
+	}
+
+	set := cfg.MainSet
+	if simSet != "" {
+		set = cfg.GetSetById(simSet)
+		for _, s := range cfg.Sets {
+			if set == nil && s.Name == simSet {
+				set = s
+			}
+		}
+		if set == nil {
+			return fmt.Errorf("set %q not found", simSet)
+		}
+	}
+
+	in, err := os.Open(simIn)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(simOut)
+	if err != nil {
+		return err
+	}
+
+	res, err := nfq.SimulatePcap(set, in, out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("set %q: read %d packets (%d not IP), wrote %d to %s\n", set.Name, res.Read, res.Skipped, res.Written, simOut)
+	return nil
+}
+
 func gracefulShutdown(cfg *config.Config, pool *nfq.Pool, httpServer *http.Server, metrics *handler.MetricsCollector) error {
 	// Create shutdown context with timeout
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -0,0 +1,29 @@
+package metrics
+
+import "sync"
+
+// The matcher counts its cache lookups from zero and is rebuilt on every
+// config update, so the lookups of replaced matchers are kept here and
+// added to those of the running one.
+var (
+	retiredCacheMu sync.Mutex
+	retiredCache   = make(map[string][2]uint64) // cache -> hits, misses
+)
+
+// RetireMatcherCache keeps the lookup counts of a cache of a matcher that
+// was replaced
+func RetireMatcherCache(cache string, hits, misses uint64) {
+	retiredCacheMu.Lock()
+	defer retiredCacheMu.Unlock()
+	c := retiredCache[cache]
+	retiredCache[cache] = [2]uint64{c[0] + hits, c[1] + misses}
+}
+
+// MatcherCacheTotals returns the lookups of cache since start, given the
+// counts of the running matcher
+func MatcherCacheTotals(cache string, hits, misses uint64) (uint64, uint64) {
+	retiredCacheMu.Lock()
+	defer retiredCacheMu.Unlock()
+	c := retiredCache[cache]
+	return c[0] + hits, c[1] + misses
+}
@@ -0,0 +1,15 @@
+package metrics
+
+import "testing"
+
+func TestMatcherCacheTotals(t *testing.T) {
+	RetireMatcherCache("test", 5, 2)
+	RetireMatcherCache("test", 1, 1)
+
+	if hits, misses := MatcherCacheTotals("test", 4, 0); hits != 10 || misses != 3 {
+		t.Errorf("got %d hits, %d misses, want 10 and 3", hits, misses)
+	}
+	if hits, misses := MatcherCacheTotals("other", 4, 1); hits != 4 || misses != 1 {
+		t.Errorf("cache without retired counts: got %d hits, %d misses", hits, misses)
+	}
+}
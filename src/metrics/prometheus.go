@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counterVec is a counter family keyed by its label values. Incrementing an
+// existing series takes a read lock and an atomic add.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	values map[string]*uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]*uint64)}
+}
+
+func (c *counterVec) inc(values ...string) {
+	key := strings.Join(values, "\x00")
+
+	c.mu.RLock()
+	v, ok := c.values[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		if v, ok = c.values[key]; !ok {
+			v = new(uint64)
+			c.values[key] = v
+		}
+		c.mu.Unlock()
+	}
+	atomic.AddUint64(v, 1)
+}
+
+func (c *counterVec) samples() []Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	samples := make([]Sample, 0, len(c.values))
+	for key, v := range c.values {
+		values := strings.Split(key, "\x00")
+		labels := make([]string, 0, 2*len(c.labels))
+		for i, name := range c.labels {
+			labels = append(labels, name, values[i])
+		}
+		samples = append(samples, Sample{Labels: labels, Value: float64(atomic.LoadUint64(v))})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\x00") < strings.Join(samples[j].Labels, "\x00")
+	})
+	return samples
+}
+
+var (
+	setMatches    = newCounterVec("b4_set_matched_connections_total", "Connections matched by a set.", "set", "protocol")
+	injections    = newCounterVec("b4_injections_total", "Strategies applied to matched packets.", "set", "strategy", "protocol")
+	dnsRedirects  = newCounterVec("b4_dns_redirects_total", "DNS queries redirected to the resolver of a set.", "set", "protocol")
+	captureEvents = newCounterVec("b4_capture_events_total", "Payloads and flows saved by the capture manager.", "protocol", "event")
+)
+
+// RecordSetMatch counts a connection handled by set
+func (m *MetricsCollector) RecordSetMatch(set, protocol string) {
+	setMatches.inc(set, protocol)
+}
+
+// RecordInjection counts a strategy applied to a packet of set
+func (m *MetricsCollector) RecordInjection(set, strategy, protocol string) {
+	injections.inc(set, strategy, protocol)
+}
+
+// RecordDNSRedirect counts a DNS query sent to the resolver of set
+func (m *MetricsCollector) RecordDNSRedirect(set, protocol string) {
+	dnsRedirects.inc(set, protocol)
+}
+
+// RecordCaptureEvent counts a capture saved for protocol, "payload" or "flow"
+func (m *MetricsCollector) RecordCaptureEvent(protocol, event string) {
+	captureEvents.inc(protocol, event)
+}
+
+// Sample is one series of a metric family. Labels holds name, value pairs.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// WriteFamily writes a metric family in the Prometheus text format.
+// Families without samples are left out.
+func WriteFamily(w io.Writer, name, typ, help string, samples ...Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, s := range samples {
+		w.Write([]byte(name))
+		if len(s.Labels) > 0 {
+			w.Write([]byte{'{'})
+			for i := 0; i+1 < len(s.Labels); i += 2 {
+				if i > 0 {
+					w.Write([]byte{','})
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", s.Labels[i], escapeLabel(s.Labels[i+1]))
+			}
+			w.Write([]byte{'}'})
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(s.Value, 'g', -1, 64))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// WritePrometheus writes the collector's counters and gauges in the
+// Prometheus text format
+func (m *MetricsCollector) WritePrometheus(w io.Writer) {
+	m.mu.RLock()
+	var protocols []Sample
+	for proto, n := range m.ProtocolDist {
+		protocols = append(protocols, Sample{Labels: []string{"protocol", strings.ToLower(proto)}, Value: float64(n)})
+	}
+	sort.Slice(protocols, func(i, j int) bool { return protocols[i].Labels[1] < protocols[j].Labels[1] })
+	targeted := m.TargetedConnections
+	packets, bytes := m.PacketsProcessed, m.BytesProcessed
+	active := m.ActiveFlows
+	mem := m.MemoryUsage
+	uptime := time.Since(m.StartTime).Seconds()
+	m.mu.RUnlock()
+
+	WriteFamily(w, "b4_uptime_seconds", "gauge", "Seconds since B4 started.", Sample{Value: uptime})
+	WriteFamily(w, "b4_connections_total", "counter", "Connections seen by the queue workers.", protocols...)
+	WriteFamily(w, "b4_targeted_connections_total", "counter", "Connections matched by any set.", Sample{Value: float64(targeted)})
+	WriteFamily(w, "b4_matched_packets_total", "counter", "Packets of matched connections.", Sample{Value: float64(packets)})
+	WriteFamily(w, "b4_matched_bytes_total", "counter", "Bytes of matched connections.", Sample{Value: float64(bytes)})
+	WriteFamily(w, "b4_active_flows", "gauge", "Flows currently tracked.", Sample{Value: float64(active)})
+	WriteFamily(w, "b4_memory_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", Sample{Value: float64(mem.HeapAlloc)})
+	WriteFamily(w, "b4_memory_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", Sample{Value: float64(mem.System)})
+
+	for _, c := range []*counterVec{setMatches, injections, dnsRedirects, captureEvents} {
+		WriteFamily(w, c.name, "counter", c.help, c.samples()...)
+	}
+}
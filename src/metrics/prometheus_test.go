@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteFamily(t *testing.T) {
+	var buf bytes.Buffer
+	WriteFamily(&buf, "b4_test_total", "counter", "Test counter.",
+		Sample{Labels: []string{"set", `a "quoted"\set`, "protocol", "tcp"}, Value: 3},
+		Sample{Value: 0.5},
+	)
+	want := `# HELP b4_test_total Test counter.
+# TYPE b4_test_total counter
+b4_test_total{set="a \"quoted\"\\set",protocol="tcp"} 3
+b4_test_total 0.5
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	WriteFamily(&buf, "b4_empty_total", "counter", "No samples.")
+	if buf.Len() != 0 {
+		t.Errorf("empty family written: %q", buf.String())
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	c := newCounterVec("b4_injections_total", "Injections.", "set", "strategy")
+	c.inc("main", "fake")
+	c.inc("main", "fake")
+	c.inc("alt", "tcp")
+
+	var buf bytes.Buffer
+	WriteFamily(&buf, c.name, "counter", c.help, c.samples()...)
+	out := buf.String()
+	for _, line := range []string{
+		`b4_injections_total{set="alt",strategy="tcp"} 1`,
+		`b4_injections_total{set="main",strategy="fake"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	if strings.Index(out, `set="alt"`) > strings.Index(out, `set="main"`) {
+		t.Error("series are not sorted")
+	}
+}
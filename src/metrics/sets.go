@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetCounters counts the traffic one set handled. The workers update them
+// with atomic adds only; they are kept by set ID so they survive config
+// reloads.
+type SetCounters struct {
+	SNIMatches  atomic.Uint64
+	IPMatches   atomic.Uint64
+	PortMatches atomic.Uint64
+	Bytes       atomic.Uint64
+	Injected    atomic.Uint64
+	Fakes       atomic.Uint64
+	lastMatch   atomic.Int64
+	strategies  sync.Map // fragmentation strategy -> *atomic.Uint64
+}
+
+// SetCountersSnapshot is the JSON form of SetCounters
+type SetCountersSnapshot struct {
+	SNIMatches  uint64            `json:"sni_matches"`
+	IPMatches   uint64            `json:"ip_matches"`
+	PortMatches uint64            `json:"port_matches"`
+	Bytes       uint64            `json:"bytes"`
+	Injected    uint64            `json:"packets_injected"`
+	Fakes       uint64            `json:"fakes_sent"`
+	Strategies  map[string]uint64 `json:"strategies"`
+	LastMatch   *time.Time        `json:"last_match,omitempty"`
+}
+
+// Match kinds for SetCounters.RecordMatch
+const (
+	MatchSNI  = "sni"
+	MatchIP   = "ip"
+	MatchPort = "port"
+)
+
+var setCounters sync.Map // set ID -> *SetCounters
+
+// CountersFor returns the counters of the set with id, creating them on
+// first use
+func CountersFor(id string) *SetCounters {
+	if c, ok := setCounters.Load(id); ok {
+		return c.(*SetCounters)
+	}
+	c, _ := setCounters.LoadOrStore(id, &SetCounters{})
+	return c.(*SetCounters)
+}
+
+// DeleteSetCounters drops the counters of a removed set
+func DeleteSetCounters(id string) {
+	setCounters.Delete(id)
+}
+
+// RecordMatch counts a packet of size bytes matched by kind
+func (c *SetCounters) RecordMatch(kind string, size int) {
+	switch kind {
+	case MatchSNI:
+		c.SNIMatches.Add(1)
+	case MatchIP:
+		c.IPMatches.Add(1)
+	case MatchPort:
+		c.PortMatches.Add(1)
+	}
+	c.Bytes.Add(uint64(size))
+	c.lastMatch.Store(time.Now().Unix())
+}
+
+// RecordStrategy counts a packet sent with a fragmentation strategy
+func (c *SetCounters) RecordStrategy(strategy string) {
+	n, ok := c.strategies.Load(strategy)
+	if !ok {
+		n, _ = c.strategies.LoadOrStore(strategy, new(atomic.Uint64))
+	}
+	n.(*atomic.Uint64).Add(1)
+}
+
+// Snapshot reads the counters
+func (c *SetCounters) Snapshot() SetCountersSnapshot {
+	s := SetCountersSnapshot{
+		SNIMatches:  c.SNIMatches.Load(),
+		IPMatches:   c.IPMatches.Load(),
+		PortMatches: c.PortMatches.Load(),
+		Bytes:       c.Bytes.Load(),
+		Injected:    c.Injected.Load(),
+		Fakes:       c.Fakes.Load(),
+		Strategies:  make(map[string]uint64),
+	}
+	c.strategies.Range(func(k, v any) bool {
+		s.Strategies[k.(string)] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	if ts := c.lastMatch.Load(); ts != 0 {
+		t := time.Unix(ts, 0)
+		s.LastMatch = &t
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSetCounters(t *testing.T) {
+	const id = "test-set-counters"
+	defer DeleteSetCounters(id)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := CountersFor(id)
+			for j := 0; j < 100; j++ {
+				c.RecordMatch(MatchSNI, 10)
+				c.RecordStrategy("tcp")
+			}
+		}()
+	}
+	wg.Wait()
+
+	c := CountersFor(id)
+	c.RecordMatch(MatchIP, 1)
+	c.RecordMatch(MatchPort, 1)
+	c.Fakes.Add(3)
+
+	s := c.Snapshot()
+	if s.SNIMatches != 800 || s.IPMatches != 1 || s.PortMatches != 1 {
+		t.Errorf("matches = %d/%d/%d, want 800/1/1", s.SNIMatches, s.IPMatches, s.PortMatches)
+	}
+	if s.Bytes != 8002 {
+		t.Errorf("bytes = %d, want 8002", s.Bytes)
+	}
+	if s.Strategies["tcp"] != 800 {
+		t.Errorf("tcp strategy = %d, want 800", s.Strategies["tcp"])
+	}
+	if s.Fakes != 3 {
+		t.Errorf("fakes = %d, want 3", s.Fakes)
+	}
+	if s.LastMatch == nil {
+		t.Error("last match not set")
+	}
+
+	DeleteSetCounters(id)
+	if s := CountersFor(id).Snapshot(); s.SNIMatches != 0 || s.LastMatch != nil {
+		t.Errorf("counters kept after delete: %+v", s)
+	}
+}
@@ -5,14 +5,13 @@ import (
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
 	"github.com/daniellavrushin/b4/utils"
 )
 
 func (w *Worker) sendComboFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	pi, ok := ExtractPacketInfoV4(packet)
+	pi, ok := ExtractPacketInfo(packet)
 	if !ok || pi.PayloadLen < 20 {
-		_ = w.sock.SendIPv4(packet, dst)
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
@@ -35,24 +34,24 @@ func (w *Worker) sendComboFragments(cfg *config.SetConfig, packet []byte, dst ne
 		if splitPos <= prevEnd {
 			continue
 		}
-		seg := BuildSegmentV4(packet, pi, pi.Payload[prevEnd:splitPos], uint32(prevEnd), uint16(idx))
+		seg := BuildSegment(packet, pi, pi.Payload[prevEnd:splitPos], uint32(prevEnd), uint16(idx))
 		segments = append(segments, Segment{Data: seg, Seq: pi.Seq0 + uint32(prevEnd)})
 		prevEnd = splitPos
 	}
 
 	if prevEnd < pi.PayloadLen {
-		seg := BuildSegmentV4(packet, pi, pi.Payload[prevEnd:], uint32(prevEnd), uint16(len(segments)))
+		seg := BuildSegment(packet, pi, pi.Payload[prevEnd:], uint32(prevEnd), uint16(len(segments)))
 		segments = append(segments, Segment{Data: seg, Seq: pi.Seq0 + uint32(prevEnd)})
 	}
 
 	if len(segments) == 0 {
-		_ = w.sock.SendIPv4(packet, dst)
+		w.send(pi.L3, packet, dst)
 		return
 	}
 
 	r := utils.NewRand()
 	ShuffleSegments(segments, combo.ShuffleMode, r)
-	SetMaxSeqPSH(segments, pi.IPHdrLen, sock.FixTCPChecksum)
+	SetMaxSeqPSH(segments, pi.IPHdrLen, pi.L3.FixTCPChecksum)
 
 	firstDelayMs := combo.FirstDelayMs
 	if firstDelayMs <= 0 {
@@ -70,15 +69,15 @@ func (w *Worker) sendComboFragments(cfg *config.SetConfig, packet []byte, dst ne
 			payloadLen := len(seg.Data) - pi.PayloadStart
 			if seqovlLen <= payloadLen {
 				seqOffset := seg.Seq - pi.Seq0
-				fakeSeg := BuildFakeOverlapSegmentV4(packet, pi, payloadLen, seqOffset, 0, seqovlPattern, cfg.Faking.TTL, true)
+				fakeSeg := BuildFakeOverlapSegment(packet, pi, payloadLen, seqOffset, 0, seqovlPattern, cfg.Faking.TTL, true)
 				if fakeSeg != nil {
-					_ = w.sock.SendIPv4(fakeSeg, dst)
+					w.send(pi.L3, fakeSeg, dst)
 					time.Sleep(50 * time.Microsecond)
 				}
 			}
 		}
 
-		_ = w.sock.SendIPv4(seg.Data, dst)
+		w.send(pi.L3, seg.Data, dst)
 
 		if i == 0 {
 			jitter := r.Intn(firstDelayMs/3 + 1)
@@ -5,10 +5,8 @@ import (
 	"math/rand"
 	"net"
 	"sort"
-	"time"
 
 	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
 )
 
 func ExtractPacketInfoV4(packet []byte) (PacketInfo, bool) {
@@ -35,24 +33,10 @@ func ExtractPacketInfoV4(packet []byte) (PacketInfo, bool) {
 		Seq0:         binary.BigEndian.Uint32(packet[ipHdrLen+4 : ipHdrLen+8]),
 		ID0:          binary.BigEndian.Uint16(packet[4:6]),
 		IsIPv6:       false,
+		L3:           IPv4Layer,
 	}, true
 }
 
-func BuildSegmentV4(packet []byte, pi PacketInfo, payloadSlice []byte, seqOffset uint32, idOffset uint16) []byte {
-	segLen := pi.PayloadStart + len(payloadSlice)
-	seg := make([]byte, segLen)
-	copy(seg[:pi.PayloadStart], packet[:pi.PayloadStart])
-	copy(seg[pi.PayloadStart:], payloadSlice)
-
-	binary.BigEndian.PutUint32(seg[pi.IPHdrLen+4:pi.IPHdrLen+8], pi.Seq0+seqOffset)
-	binary.BigEndian.PutUint16(seg[4:6], pi.ID0+idOffset)
-	binary.BigEndian.PutUint16(seg[2:4], uint16(segLen))
-
-	sock.FixIPv4Checksum(seg[:pi.IPHdrLen])
-	sock.FixTCPChecksum(seg)
-	return seg
-}
-
 func ShuffleSegments(segments []Segment, mode string, r *rand.Rand) {
 	switch mode {
 	case "full":
@@ -79,23 +63,19 @@ func ShuffleSegments(segments []Segment, mode string, r *rand.Rand) {
 	}
 }
 
-func (w *Worker) SendSegmentsV4(segs [][]byte, dst net.IP, cfg *config.SetConfig) {
-	delay := cfg.TCP.Seg2Delay
+func (w *Worker) SendSegments(l3 L3, segs [][]byte, dst net.IP, cfg *config.SetConfig) {
 	if cfg.Fragmentation.ReverseOrder {
-		for i := len(segs) - 1; i >= 0; i-- {
-			_ = w.sock.SendIPv4(segs[i], dst)
-			if i > 0 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
-		}
-	} else {
-		for i, seg := range segs {
-			_ = w.sock.SendIPv4(seg, dst)
-			if i < len(segs)-1 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
-		}
+		segs = reversed(segs)
 	}
+	w.sendPackets(l3, segs, dst, cfg.TCP.Seg2Delay)
+}
+
+func reversed(packets [][]byte) [][]byte {
+	out := make([][]byte, len(packets))
+	for i, p := range packets {
+		out[len(packets)-1-i] = p
+	}
+	return out
 }
 
 func SetPSH(seg []byte, ipHdrLen int) {
@@ -127,22 +107,6 @@ func GetSNISplitPoints(payload []byte, payloadLen int, middleSNI bool, sniPositi
 	return splits
 }
 
-func (w *Worker) SendTwoSegmentsV4(seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
-	if reverse {
-		_ = w.sock.SendIPv4(seg2, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg1, dst)
-	} else {
-		_ = w.sock.SendIPv4(seg1, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg2, dst)
-	}
-}
-
 func uniqueSorted(splits []int, maxVal int) []int {
 	seen := make(map[int]bool)
 	result := make([]int, 0, len(splits))
@@ -318,45 +282,3 @@ func BuildValidSplits(splits []int, payloadLen int) []int {
 	validSplits = append(validSplits, payloadLen)
 	return validSplits
 }
-
-func BuildFakeOverlapSegmentV4(packet []byte, pi PacketInfo, payloadLen int, seqOffset uint32, idOffset uint16, fakePattern []byte, fakeTTL uint8, corruptChecksum bool) []byte {
-	if payloadLen <= 0 {
-		return nil
-	}
-
-	segLen := pi.PayloadStart + payloadLen
-	seg := make([]byte, segLen)
-	copy(seg[:pi.PayloadStart], packet[:pi.PayloadStart])
-
-	patLen := len(fakePattern)
-	if patLen == 0 {
-		for i := 0; i < payloadLen; i++ {
-			seg[pi.PayloadStart+i] = byte((i * 7) & 0xFF)
-		}
-	} else {
-		for i := 0; i < payloadLen; i++ {
-			seg[pi.PayloadStart+i] = fakePattern[i%patLen]
-		}
-	}
-
-	binary.BigEndian.PutUint32(seg[pi.IPHdrLen+4:pi.IPHdrLen+8], pi.Seq0+seqOffset)
-	binary.BigEndian.PutUint16(seg[4:6], pi.ID0+idOffset)
-	binary.BigEndian.PutUint16(seg[2:4], uint16(segLen))
-
-	if fakeTTL == 0 {
-		fakeTTL = 3
-	}
-	seg[8] = fakeTTL
-
-	seg[pi.IPHdrLen+13] &^= 0x08
-
-	sock.FixIPv4Checksum(seg[:pi.IPHdrLen])
-	sock.FixTCPChecksum(seg)
-
-	if corruptChecksum {
-		seg[pi.IPHdrLen+16] ^= 0xFF
-		seg[pi.IPHdrLen+17] ^= 0xFF
-	}
-
-	return seg
-}
@@ -1,13 +1,6 @@
 package nfq
 
-import (
-	"encoding/binary"
-	"net"
-	"time"
-
-	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
-)
+import "encoding/binary"
 
 func ExtractPacketInfoV6(packet []byte) (PacketInfo, bool) {
 	if len(packet) < 60 {
@@ -58,93 +51,6 @@ done:
 		Payload:      packet[payloadStart:],
 		Seq0:         binary.BigEndian.Uint32(packet[offset+4 : offset+8]),
 		IsIPv6:       true,
+		L3:           IPv6Layer,
 	}, true
 }
-
-func (w *Worker) SendSegmentsV6(segs [][]byte, dst net.IP, cfg *config.SetConfig) {
-	delay := cfg.TCP.Seg2Delay
-	if cfg.Fragmentation.ReverseOrder {
-		for i := len(segs) - 1; i >= 0; i-- {
-			_ = w.sock.SendIPv6(segs[i], dst)
-			if i > 0 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
-		}
-	} else {
-		for i, seg := range segs {
-			_ = w.sock.SendIPv6(seg, dst)
-			if i < len(segs)-1 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
-		}
-	}
-}
-
-func BuildSegmentV6(packet []byte, pi PacketInfo, payloadSlice []byte, seqOffset uint32) []byte {
-	segLen := pi.PayloadStart + len(payloadSlice)
-	seg := make([]byte, segLen)
-	copy(seg[:pi.PayloadStart], packet[:pi.PayloadStart])
-	copy(seg[pi.PayloadStart:], payloadSlice)
-
-	binary.BigEndian.PutUint32(seg[pi.IPHdrLen+4:pi.IPHdrLen+8], pi.Seq0+seqOffset)
-	binary.BigEndian.PutUint16(seg[4:6], uint16(segLen-40))
-
-	sock.FixTCPChecksumV6(seg)
-	return seg
-}
-
-func (w *Worker) SendTwoSegmentsV6(seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
-	if reverse {
-		_ = w.sock.SendIPv6(seg2, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg1, dst)
-	} else {
-		_ = w.sock.SendIPv6(seg1, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg2, dst)
-	}
-}
-
-func BuildFakeOverlapSegmentV6(packet []byte, pi PacketInfo, payloadLen int, seqOffset uint32, fakePattern []byte, fakeHopLimit uint8, corruptChecksum bool) []byte {
-	if payloadLen <= 0 {
-		return nil
-	}
-
-	segLen := pi.PayloadStart + payloadLen
-	seg := make([]byte, segLen)
-	copy(seg[:pi.PayloadStart], packet[:pi.PayloadStart])
-
-	patLen := len(fakePattern)
-	if patLen == 0 {
-		for i := 0; i < payloadLen; i++ {
-			seg[pi.PayloadStart+i] = byte((i * 7) & 0xFF)
-		}
-	} else {
-		for i := 0; i < payloadLen; i++ {
-			seg[pi.PayloadStart+i] = fakePattern[i%patLen]
-		}
-	}
-
-	binary.BigEndian.PutUint32(seg[pi.IPHdrLen+4:pi.IPHdrLen+8], pi.Seq0+seqOffset)
-	binary.BigEndian.PutUint16(seg[4:6], uint16(segLen-40))
-
-	if fakeHopLimit == 0 {
-		fakeHopLimit = 3
-	}
-	seg[7] = fakeHopLimit
-
-	seg[pi.IPHdrLen+13] &^= 0x08
-
-	sock.FixTCPChecksumV6(seg)
-
-	if corruptChecksum {
-		seg[pi.IPHdrLen+16] ^= 0xFF
-		seg[pi.IPHdrLen+17] ^= 0xFF
-	}
-
-	return seg
-}
@@ -0,0 +1,380 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+	"github.com/daniellavrushin/b4/quic"
+	"github.com/daniellavrushin/b4/sni"
+	"github.com/daniellavrushin/b4/sock"
+	"github.com/daniellavrushin/b4/stun"
+	"github.com/daniellavrushin/b4/utils"
+)
+
+// route is what the worker does with a queued packet
+type route int
+
+const (
+	routeAccept  route = iota // let it through unchanged
+	routeDrop                 // drop it
+	routeHeld                 // held for ClientHello reassembly
+	routeDNS                  // handed to the DNS redirection
+	routeSynFake              // send a fake SYN ahead of it
+	routeTCP                  // drop it and run the set's TCP pipeline
+	routeHTTP                 // drop it and inject per the set's HTTP settings
+	routeQUIC                 // drop it and inject per the set's UDP settings
+)
+
+// decision is what matching found out about a queued packet
+type decision struct {
+	route   route
+	cfg     *config.Config
+	matcher *sni.SuffixSet
+	set     *config.SetConfig
+
+	v, proto     uint8
+	ihl          int
+	tcpHdrLen    int
+	src, dst     net.IP
+	sport, dport uint16
+	connKey      string
+
+	raw      []byte   // the packet, or the ClientHello reassembled with it
+	original []byte   // the packet as queued
+	held     [][]byte // segments held for reassembly before the packet
+	payload  []byte   // TCP or UDP payload of raw
+
+	flow      string // "TCP" or "UDP" once the packet went through matching
+	capture   string // payload kind to capture, empty for none
+	host      string
+	kind      string // metrics match kind, empty when no set handles the packet
+	ipTarget  string
+	sniTarget string
+}
+
+// parseIP reads the addresses, transport protocol and header length of an
+// IPv4 or IPv6 packet, skipping IPv6 extension headers
+func parseIP(raw []byte) (v, proto uint8, ihl int, src, dst net.IP, ok bool) {
+	if len(raw) == 0 {
+		return
+	}
+	v = raw[0] >> 4
+	switch v {
+	case IPv4:
+		if len(raw) < 20 {
+			return
+		}
+		ihl = int(raw[0]&0x0f) * 4
+		if len(raw) < ihl {
+			return
+		}
+		return v, raw[9], ihl, net.IP(raw[12:16]), net.IP(raw[16:20]), true
+
+	case IPv6:
+		if len(raw) < IPv6HeaderLen {
+			return
+		}
+		nextHeader := raw[6]
+		offset := IPv6HeaderLen
+		for {
+			switch nextHeader {
+			case 0, 43, 60: // Hop-by-Hop, Routing, Destination Options
+				if len(raw) < offset+2 {
+					return
+				}
+				nextHeader = raw[offset]
+				offset += int(raw[offset+1])*8 + 8
+			case 44:
+				if len(raw) < offset+8 {
+					return
+				}
+				nextHeader = raw[offset]
+				offset += 8
+			default:
+				return v, nextHeader, offset, net.IP(raw[8:24]), net.IP(raw[24:40]), true
+			}
+		}
+	}
+	return
+}
+
+// decide matches a queued packet carrying mark against the sets and picks
+// what to do with it. Live queues and simulations both go through it.
+func (w *Worker) decide(raw []byte, mark uint32) decision {
+	d := decision{route: routeAccept, raw: raw, original: raw, cfg: w.getConfig(), matcher: w.getMatcher()}
+
+	var ok bool
+	d.v, d.proto, d.ihl, d.src, d.dst, ok = parseIP(raw)
+	if !ok || d.src.IsLoopback() || d.dst.IsLoopback() {
+		return d
+	}
+
+	if probe := w.probeFor(mark); probe != nil {
+		d.cfg, d.matcher = probe.cfg, probe.matcher
+	}
+	d.set = d.cfg.MainSet
+
+	switch {
+	case d.proto == 6 && len(raw) >= d.ihl+TCPHeaderMinLen:
+		w.decideTCP(&d)
+	case d.proto == 17 && len(raw) >= d.ihl+8:
+		w.decideUDP(&d)
+	}
+	return d
+}
+
+func (w *Worker) decideTCP(d *decision) {
+	matcher := d.matcher
+	tcp := d.raw[d.ihl:]
+	datOff := int((tcp[12]>>4)&0x0f) * 4
+	if len(tcp) < datOff {
+		return
+	}
+	d.tcpHdrLen = datOff
+	d.payload = tcp[datOff:]
+	d.sport = binary.BigEndian.Uint16(tcp[0:2])
+	d.dport = binary.BigEndian.Uint16(tcp[2:4])
+	dport := d.dport
+
+	flags := tcp[13]
+	isSyn := flags&0x02 != 0 // SYN flag
+	isAck := flags&0x10 != 0 // ACK flag
+	isRst := flags&0x04 != 0
+	isHTTP := dport == HTTPPort
+	if isRst && matcher.IsTCPTargetPort(dport) {
+		log.Tracef("RST received from %s:%d", d.dst, dport)
+	}
+
+	matched, ipSet := matcher.MatchIP(d.dst)
+	if matched {
+		d.set = ipSet
+		d.ipTarget = ipSet.Name
+	}
+
+	if d.set.TCP.SynFake && isSyn && !isAck && matcher.TCPPortMatchesSet(dport, d.set) {
+		if matched {
+			log.Tracef("TCP SYN to %s:%d - sending fake SYN (set: %s)", d.dst, dport, d.set.Name)
+			d.route = routeSynFake
+			d.kind = metrics.MatchIP
+		} else {
+			log.Tracef("TCP SYN to %s:%d - passing through", d.dst, dport)
+		}
+		return
+	}
+
+	matchedSNI := false
+	if !isHTTP && matcher.IsTCPTargetPort(dport) && len(d.payload) > 0 {
+		payload := d.payload
+		log.Tracef("TCP payload to %s: len=%d, first5=%x", d.dst, len(payload), payload[:min(5, len(payload))])
+		if len(payload) >= 5 && payload[0] == 0x16 {
+			log.Tracef("TLS record: type=%x ver=%x%x len=%d", payload[0], payload[1], payload[2],
+				int(payload[3])<<8|int(payload[4]))
+		}
+		d.connKey = fmt.Sprintf("%s:%d->%s:%d", d.src, d.sport, d.dst, dport)
+
+		switch res, full, held := w.reasm.Push(d.connKey, d.raw, d.ihl, d.ihl+datOff, d.dst); res {
+		case reasmHeld:
+			d.route = routeHeld
+			return
+		case reasmComplete:
+			d.raw = full
+			d.payload = full[d.ihl+datOff:]
+			d.held = held
+		}
+
+		d.host, _ = sni.ParseTLSClientHelloSNI(d.payload)
+		d.capture = "tls"
+	} else if isHTTP && len(d.payload) > 0 {
+		d.host, _ = sni.ParseHTTPRequestHost(d.payload)
+	}
+
+	// A host match takes precedence over the IP match, see
+	// SuffixSet.Explain
+	if d.host != "" {
+		if mSNI, sniSet := matcher.MatchSNI(d.host); mSNI {
+			matchedSNI = true
+			matched = true
+			d.set = sniSet
+		}
+	}
+
+	if matched && matcher.Excludes(d.set, d.host, d.dst) {
+		// Matched by IP but the host is excluded, or the other way
+		// round. The next set targeting the host, then the next one
+		// targeting the IP, may exclude neither.
+		matched, matchedSNI = false, false
+		d.ipTarget = ""
+		if mSNI, sniSet := matcher.MatchSNIForIP(d.host, d.dst); mSNI {
+			matched, matchedSNI = true, true
+			d.set = sniSet
+		} else if matched, ipSet = matcher.MatchIPForHost(d.dst, d.host); matched {
+			d.set = ipSet
+			d.ipTarget = ipSet.Name
+		}
+	}
+	if isHTTP && (len(d.payload) == 0 || !d.set.HTTP.Enabled) {
+		// Plain HTTP is only touched for sets that opted in
+		matched = false
+	} else if !isHTTP && !matcher.TCPPortMatchesSet(dport, d.set) {
+		matched = false
+	}
+
+	d.flow = "TCP"
+	if matchedSNI {
+		d.sniTarget = d.set.Name
+	}
+	if !matched {
+		return
+	}
+
+	d.kind = metrics.MatchIP
+	if matchedSNI {
+		d.kind = metrics.MatchSNI
+	}
+	d.route = routeTCP
+	if isHTTP {
+		d.route = routeHTTP
+	}
+}
+
+func (w *Worker) decideUDP(d *decision) {
+	matcher := d.matcher
+	udp := d.raw[d.ihl:]
+	d.payload = udp[8:]
+	d.sport = binary.BigEndian.Uint16(udp[0:2])
+	d.dport = binary.BigEndian.Uint16(udp[2:4])
+	d.connKey = fmt.Sprintf("%s:%d->%s:%d", d.src, d.sport, d.dst, d.dport)
+
+	if d.sport == 53 || d.dport == 53 {
+		d.route = routeDNS
+		return
+	}
+	if utils.IsPrivateIP(d.dst) {
+		return
+	}
+
+	matchedIP, ipSet := matcher.MatchIP(d.dst)
+	matchedPort := false
+	matchedQUIC := false
+
+	if matchedIP {
+		d.set = ipSet
+		d.ipTarget = ipSet.Name
+		matchedPort = matcher.PortMatchesSet(d.dport, ipSet)
+	} else if mport, portSet := matcher.MatchUDPPortOnly(d.dport); mport && !matcher.Excludes(portSet, "", d.dst) {
+		matchedPort = true
+		d.set = portSet
+		d.ipTarget = portSet.Name
+	}
+
+	switch d.set.UDP.FilterQUIC {
+	case "all":
+		if quic.IsInitial(d.payload) {
+			matchedQUIC = true
+			if h, ok := sni.ParseQUICClientHelloSNI(d.payload); ok {
+				d.host = h
+			}
+		}
+
+	case "parse":
+		if h, ok := sni.ParseQUICClientHelloSNI(d.payload); ok {
+			d.host = h
+			if mSNI, sniSet := matcher.MatchSNI(h); mSNI {
+				matchedQUIC = true
+				d.set = sniSet
+				d.sniTarget = sniSet.Name
+			}
+		}
+	}
+
+	d.flow = "UDP"
+	d.capture = "quic"
+
+	if matcher.Excludes(d.set, d.host, d.dst) {
+		// Fall back as decideTCP does: to the next set targeting the
+		// host if the host matched, then to the next one targeting the IP
+		matchedIP, matchedPort, matchedQUIC = false, false, false
+		var sniSet *config.SetConfig
+		if d.sniTarget != "" {
+			_, sniSet = matcher.MatchSNIForIP(d.host, d.dst)
+		}
+		d.ipTarget, d.sniTarget = "", ""
+		if sniSet != nil {
+			matchedQUIC = true
+			d.set = sniSet
+			d.sniTarget = sniSet.Name
+		} else if mIP, ipSet := matcher.MatchIPForHost(d.dst, d.host); mIP {
+			matchedIP = true
+			d.set = ipSet
+			d.ipTarget = ipSet.Name
+			matchedPort = matcher.PortMatchesSet(d.dport, ipSet)
+		} else {
+			return
+		}
+	}
+	if !matchedPort && !matchedIP && !matchedQUIC {
+		return
+	}
+	if d.set.UDP.FilterSTUN && stun.IsSTUNMessage(d.payload) {
+		return
+	}
+
+	d.kind = metrics.MatchPort
+	if matchedQUIC {
+		d.kind = metrics.MatchSNI
+	} else if matchedIP {
+		d.kind = metrics.MatchIP
+	}
+
+	switch d.set.UDP.Mode {
+	case "drop":
+		d.route = routeDrop
+	case "fake":
+		d.route = routeQUIC
+	}
+}
+
+// detach copies the packet and destination so that they outlive the queued
+// packet, and can be modified by the strategies
+func (d *decision) detach() {
+	d.raw = append([]byte(nil), d.raw...)
+	d.dst = append(net.IP(nil), d.dst...)
+}
+
+// inject sends what the decision calls for in place of the packet. It
+// returns once everything, delays included, has been sent. Except for a
+// fake SYN, the packet must have been detached.
+func (w *Worker) inject(d *decision) {
+	set, dst := d.set, d.dst
+	switch d.route {
+	case routeSynFake:
+		w.sendFakeSyn(set, d.raw, d.ihl, d.tcpHdrLen)
+		w.send(L3Of(d.raw), d.raw, dst)
+
+	case routeTCP, routeHTTP:
+		packet := d.raw
+		if set.TCP.DropSACK {
+			if d.v == IPv4 {
+				packet = sock.StripSACKFromTCP(packet)
+			} else {
+				packet = sock.StripSACKFromTCPv6(packet)
+			}
+		}
+		if d.route == routeHTTP {
+			w.dropAndInjectHTTP(set, packet, dst)
+		} else {
+			w.dropAndInjectTCP(set, packet, dst)
+		}
+
+	case routeQUIC:
+		if d.v == IPv4 {
+			w.dropAndInjectQUIC(set, d.raw, dst)
+		} else {
+			w.dropAndInjectQUICV6(set, d.raw, dst)
+		}
+	}
+}
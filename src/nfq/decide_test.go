@@ -0,0 +1,128 @@
+package nfq
+
+import (
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/quic"
+)
+
+func TestDecideExcludedHostFallsThrough(t *testing.T) {
+	newSet := func(name string, priority int) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Priority = priority
+		set.Targets.IpsToMatch = []string{"203.0.113.0/24"}
+		return &set
+	}
+	a := newSet("a", 10)
+	a.Targets.DomainsToExclude = []string{"allowed.example.com"}
+	b := newSet("b", 0)
+
+	cfg := config.NewConfig()
+	cfg.Sets = []*config.SetConfig{a, b}
+	w := NewWorkerWithSink(&cfg, 0, &recordSender{})
+	w.matcher.Store(buildMatcher(&cfg))
+
+	d := w.decide(buildTCPv4(1000, 0x18, buildHelloPayload("allowed.example.com", 16)), 0)
+	if d.route != routeTCP || d.set != b {
+		t.Errorf("host excluded by a: route %d set %s, want b's TCP pipeline", d.route, d.set.Name)
+	}
+	d = w.decide(buildTCPv4(5000, 0x18, buildHelloPayload("blocked.example.com", 16)), 0)
+	if d.route != routeTCP || d.set != a {
+		t.Errorf("other host: route %d set %s, want a's TCP pipeline", d.route, d.set.Name)
+	}
+}
+
+func TestDecideExcludedIPFallsThroughToHost(t *testing.T) {
+	newSet := func(name string, priority int) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Priority = priority
+		set.Targets.DomainsToMatch = []string{"blocked.example.com"}
+		set.UDP.FilterQUIC = "parse"
+		return &set
+	}
+	a := newSet("a", 10)
+	a.Targets.IpsToExclude = []string{"203.0.113.0/24"}
+	c := newSet("c", 0)
+
+	cfg := config.NewConfig()
+	cfg.Sets = []*config.SetConfig{a, c}
+	cfg.MainSet = c
+	w := NewWorkerWithSink(&cfg, 0, &recordSender{})
+	w.matcher.Store(buildMatcher(&cfg))
+
+	t.Run("tcp", func(t *testing.T) {
+		d := w.decide(buildTCPv4(1000, 0x18, buildHelloPayload("blocked.example.com", 16)), 0)
+		if d.route != routeTCP || d.set != c || d.sniTarget != "c" {
+			t.Errorf("IP excluded by a: route %d set %s target %q, want c's TCP pipeline", d.route, d.set.Name, d.sniTarget)
+		}
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		initial, err := quic.FakeInitial(1, "blocked.example.com", 1200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := w.decide(buildUDPv4(443, initial), 0)
+		if d.route != routeQUIC || d.set != c || d.sniTarget != "c" {
+			t.Errorf("IP excluded by a: route %d set %s target %q, want c's QUIC route", d.route, d.set.Name, d.sniTarget)
+		}
+	})
+}
+
+func TestDecideExcludedWithoutFallback(t *testing.T) {
+	set := config.NewSetConfig()
+	set.Id, set.Name = "a", "a"
+	set.Targets.IpsToMatch = []string{"203.0.113.0/24"}
+	set.Targets.DomainsToExclude = []string{"allowed.example.com"}
+	set.UDP.FilterQUIC = "all"
+
+	cfg := config.NewConfig()
+	cfg.Sets = []*config.SetConfig{&set}
+	w := NewWorkerWithSink(&cfg, 0, &recordSender{})
+	w.matcher.Store(buildMatcher(&cfg))
+
+	d := w.decide(buildTCPv4(1000, 0x18, buildHelloPayload("allowed.example.com", 16)), 0)
+	if d.route != routeAccept || d.ipTarget != "" {
+		t.Errorf("tcp: route %d IP target %q, want the packet accepted untargeted", d.route, d.ipTarget)
+	}
+
+	initial, err := quic.FakeInitial(1, "allowed.example.com", 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = w.decide(buildUDPv4(443, initial), 0)
+	if d.route != routeAccept || d.ipTarget != "" {
+		t.Errorf("udp: route %d IP target %q, want the packet accepted untargeted", d.route, d.ipTarget)
+	}
+}
+
+func TestDecideUDPExcludedHostFallsThrough(t *testing.T) {
+	newSet := func(name string, priority int) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Priority = priority
+		set.Targets.IpsToMatch = []string{"203.0.113.0/24"}
+		set.UDP.FilterQUIC = "all"
+		return &set
+	}
+	a := newSet("a", 10)
+	a.Targets.DomainsToExclude = []string{"allowed.example.com"}
+	b := newSet("b", 0)
+
+	cfg := config.NewConfig()
+	cfg.Sets = []*config.SetConfig{a, b}
+	w := NewWorkerWithSink(&cfg, 0, &recordSender{})
+	w.matcher.Store(buildMatcher(&cfg))
+
+	initial, err := quic.FakeInitial(1, "allowed.example.com", 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := w.decide(buildUDPv4(443, initial), 0)
+	if d.route != routeQUIC || d.set != b || d.ipTarget != "b" {
+		t.Errorf("host excluded by a: route %d set %s target %q, want b's QUIC route", d.route, d.set.Name, d.ipTarget)
+	}
+}
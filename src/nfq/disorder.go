@@ -5,15 +5,14 @@ import (
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
 	"github.com/daniellavrushin/b4/utils"
 )
 
 func (w *Worker) sendDisorderFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
 	disorder := &cfg.Fragmentation.Disorder
-	pi, ok := ExtractPacketInfoV4(packet)
+	pi, ok := ExtractPacketInfo(packet)
 	if !ok || pi.PayloadLen < 10 {
-		_ = w.sock.SendIPv4(packet, dst)
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
@@ -32,17 +31,17 @@ func (w *Worker) sendDisorderFragments(cfg *config.SetConfig, packet []byte, dst
 		start, end := validSplits[i], validSplits[i+1]
 		realPayload := pi.Payload[start:end]
 
-		seg := BuildSegmentV4(packet, pi, realPayload, uint32(start), uint16(i))
+		seg := BuildSegment(packet, pi, realPayload, uint32(start), uint16(i))
 		if i < len(validSplits)-2 {
 			ClearPSH(seg, pi.IPHdrLen)
-			sock.FixTCPChecksum(seg)
+			pi.L3.FixTCPChecksum(seg)
 		}
 		segments = append(segments, Segment{Data: seg, Seq: pi.Seq0 + uint32(start)})
 	}
 
 	r := utils.NewRand()
-	ShuffleSegments(segments, cfg.Fragmentation.Disorder.ShuffleMode, r)
-	SetMaxSeqPSH(segments, pi.IPHdrLen, sock.FixTCPChecksum)
+	ShuffleSegments(segments, disorder.ShuffleMode, r)
+	SetMaxSeqPSH(segments, pi.IPHdrLen, pi.L3.FixTCPChecksum)
 
 	minJitter, maxJitter := GetDisorderJitter(disorder)
 
@@ -52,15 +51,15 @@ func (w *Worker) sendDisorderFragments(cfg *config.SetConfig, packet []byte, dst
 			payloadLen := len(seg.Data) - pi.PayloadStart
 			if seqovlLen <= payloadLen {
 				seqOffset := seg.Seq - pi.Seq0
-				fakeSeg := BuildFakeOverlapSegmentV4(packet, pi, payloadLen, seqOffset, 0, seqovlPattern, cfg.Faking.TTL, true)
+				fakeSeg := BuildFakeOverlapSegment(packet, pi, payloadLen, seqOffset, 0, seqovlPattern, cfg.Faking.TTL, true)
 				if fakeSeg != nil {
-					_ = w.sock.SendIPv4(fakeSeg, dst)
+					w.send(pi.L3, fakeSeg, dst)
 					time.Sleep(50 * time.Microsecond)
 				}
 			}
 		}
 
-		_ = w.sock.SendIPv4(seg.Data, dst)
+		w.send(pi.L3, seg.Data, dst)
 		if i < len(segments)-1 {
 			if seg2d > 0 {
 				jitter := r.Intn(seg2d/2 + 1)
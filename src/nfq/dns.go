@@ -7,21 +7,22 @@ import (
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/dns"
 	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+	"github.com/daniellavrushin/b4/sni"
 	"github.com/daniellavrushin/b4/sock"
 	"github.com/florianl/go-nfqueue"
 )
 
-func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, payload []byte, raw []byte, ihl int, id uint32) int {
+func (w *Worker) processDnsPacket(matcher *sni.SuffixSet, ipVersion byte, sport uint16, dport uint16, payload []byte, raw []byte, ihl int, id uint32) int {
 
 	if dport == 53 {
 		domain, ok := dns.ParseQueryDomain(payload)
 		if ok {
-			matcher := w.getMatcher()
 			if matchedSet, set := matcher.MatchSNI(domain); matchedSet && set.DNS.Enabled && set.DNS.TargetDNS != "" {
 
 				targetIP := net.ParseIP(set.DNS.TargetDNS)
 				if targetIP == nil {
-					_ = w.q.SetVerdict(id, nfqueue.NfAccept)
+					w.setVerdict(id, nfqueue.NfAccept)
 					return 0
 				}
 
@@ -29,7 +30,7 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 					targetDNS := targetIP.To4()
 					if targetDNS == nil {
 						// Target is IPv6 but packet is IPv4 - can't redirect
-						_ = w.q.SetVerdict(id, nfqueue.NfAccept)
+						w.setVerdict(id, nfqueue.NfAccept)
 						return 0
 					}
 
@@ -46,20 +47,21 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 					} else {
 						_ = w.sock.SendIPv4(raw, targetDNS)
 					}
-					_ = w.q.SetVerdict(id, nfqueue.NfDrop)
+					w.setVerdict(id, nfqueue.NfDrop)
 					log.Infof("DNS redirect: %s -> %s (set: %s)", domain, set.DNS.TargetDNS, set.Name)
+					metrics.GetMetricsCollector().RecordDNSRedirect(set.Name, "dns")
 					return 0
 
 				} else { // IPv6
 					cfg := w.getConfig()
 					if !cfg.Queue.IPv6Enabled {
-						_ = w.q.SetVerdict(id, nfqueue.NfAccept)
+						w.setVerdict(id, nfqueue.NfAccept)
 						return 0
 					}
 
 					targetDNS := targetIP.To16()
 					if targetDNS == nil {
-						_ = w.q.SetVerdict(id, nfqueue.NfAccept)
+						w.setVerdict(id, nfqueue.NfAccept)
 						return 0
 					}
 
@@ -76,8 +78,9 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 					} else {
 						_ = w.sock.SendIPv6(raw, targetDNS)
 					}
-					_ = w.q.SetVerdict(id, nfqueue.NfDrop)
+					w.setVerdict(id, nfqueue.NfDrop)
 					log.Infof("DNS redirect (IPv6): %s -> %s (set: %s)", domain, set.DNS.TargetDNS, set.Name)
+					metrics.GetMetricsCollector().RecordDNSRedirect(set.Name, "dns")
 					return 0
 				}
 			}
@@ -92,7 +95,7 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 				sock.FixUDPChecksum(raw, ihl)
 				dns.DnsNATDelete(net.IP(raw[16:20]), dport)
 				_ = w.sock.SendIPv4(raw, net.IP(raw[16:20]))
-				_ = w.q.SetVerdict(id, nfqueue.NfDrop)
+				w.setVerdict(id, nfqueue.NfDrop)
 				return 0
 			}
 		} else { // IPv6
@@ -103,14 +106,14 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 					sock.FixUDPChecksumV6(raw)
 					dns.DnsNATDelete(net.IP(raw[24:40]), dport)
 					_ = w.sock.SendIPv6(raw, net.IP(raw[24:40]))
-					_ = w.q.SetVerdict(id, nfqueue.NfDrop)
+					w.setVerdict(id, nfqueue.NfDrop)
 					return 0
 				}
 			}
 		}
 	}
 
-	_ = w.q.SetVerdict(id, nfqueue.NfAccept)
+	w.setVerdict(id, nfqueue.NfAccept)
 	return 0
 }
 
@@ -148,7 +151,7 @@ func (w *Worker) sendFragmentedDNSQueryV4(cfg *config.SetConfig, raw []byte, ihl
 
 	seg2d := cfg.UDP.Seg2Delay
 
-	w.SendTwoSegmentsV4(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.sendTwoSegments(IPv4Layer, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
 
 	log.Tracef("DNS frag: sent %d fragments for query", len(frags))
 }
@@ -186,7 +189,7 @@ func (w *Worker) sendFragmentedDNSQueryV6(cfg *config.SetConfig, raw []byte, dst
 
 	seg2d := cfg.UDP.Seg2Delay
 
-	w.SendTwoSegmentsV6(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.sendTwoSegments(IPv6Layer, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
 
 	log.Tracef("DNS frag v6: sent %d fragments", len(frags))
 }
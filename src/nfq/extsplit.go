@@ -5,7 +5,6 @@ import (
 	"net"
 
 	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
 )
 
 // findPreSNIExtensionPoint finds a good split point BEFORE the SNI extension
@@ -76,9 +75,9 @@ func findPreSNIExtensionPoint(payload []byte) int {
 }
 
 func (w *Worker) sendExtSplitFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	pi, ok := ExtractPacketInfoV4(packet)
+	pi, ok := ExtractPacketInfo(packet)
 	if !ok || pi.PayloadLen < 50 {
-		_ = w.sock.SendIPv4(packet, dst)
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
@@ -90,14 +89,14 @@ func (w *Worker) sendExtSplitFragments(cfg *config.SetConfig, packet []byte, dst
 	}
 
 	// Segment 1: everything before SNI extension
-	seg1 := BuildSegmentV4(packet, pi, pi.Payload[:splitPos], 0, 0)
+	seg1 := BuildSegment(packet, pi, pi.Payload[:splitPos], 0, 0)
 	ClearPSH(seg1, pi.IPHdrLen)
-	sock.FixTCPChecksum(seg1)
+	pi.L3.FixTCPChecksum(seg1)
 
 	// Segment 2: SNI extension onwards
-	seg2 := BuildSegmentV4(packet, pi, pi.Payload[splitPos:], uint32(splitPos), 1)
+	seg2 := BuildSegment(packet, pi, pi.Payload[splitPos:], uint32(splitPos), 1)
 
 	delay := cfg.TCP.Seg2Delay
 
-	w.SendTwoSegmentsV4(seg1, seg2, dst, delay, cfg.Fragmentation.ReverseOrder)
+	w.sendTwoSegments(pi.L3, seg1, seg2, dst, delay, cfg.Fragmentation.ReverseOrder)
 }
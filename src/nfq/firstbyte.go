@@ -5,33 +5,32 @@ import (
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
 )
 
 func (w *Worker) sendFirstByteDesync(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	pi, ok := ExtractPacketInfoV4(packet)
+	pi, ok := ExtractPacketInfo(packet)
 	if !ok || pi.PayloadLen < 2 {
-		_ = w.sock.SendIPv4(packet, dst)
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
 	// Segment 1: Just first byte
-	seg1 := BuildSegmentV4(packet, pi, pi.Payload[:1], 0, 0)
+	seg1 := BuildSegment(packet, pi, pi.Payload[:1], 0, 0)
 	ClearPSH(seg1, pi.IPHdrLen)
-	sock.FixTCPChecksum(seg1)
+	pi.L3.FixTCPChecksum(seg1)
 
 	// Segment 2: Rest
-	seg2 := BuildSegmentV4(packet, pi, pi.Payload[1:], 1, 1)
+	seg2 := BuildSegment(packet, pi, pi.Payload[1:], 1, 1)
 
-	_ = w.sock.SendIPv4(seg1, dst)
+	w.send(pi.L3, seg1, dst)
 
 	delay := cfg.TCP.Seg2Delay
 	if delay < 10 {
 		delay = 30
 	}
 
-	jitter := int(pi.ID0) % (delay/3 + 1)
+	jitter := int(pi.Seq0 % uint32(delay/3+1))
 	time.Sleep(time.Duration(delay+jitter) * time.Millisecond)
 
-	_ = w.sock.SendIPv4(seg2, dst)
+	w.send(pi.L3, seg2, dst)
 }
@@ -0,0 +1,125 @@
+package nfq
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+// dropAndInjectHTTP applies the plain HTTP tricks of the set to a request
+// carrying a Host header and re-injects it, split inside the host if enabled.
+func (w *Worker) dropAndInjectHTTP(cfg *config.SetConfig, raw []byte, dst net.IP) {
+	pi, ok := ExtractPacketInfo(raw)
+	if !ok || pi.PayloadLen == 0 {
+		w.sendRaw([][]byte{raw}, dst)
+		return
+	}
+
+	payload := mutateHTTPRequest(pi.Payload, &cfg.HTTP)
+
+	var splits []int
+	if cfg.HTTP.HostSplit {
+		if _, vs, ve, ok := sni.LocateHTTPHost(payload); ok {
+			splits = uniqueSorted([]int{vs, vs + (ve-vs)/2}, len(payload))
+		}
+	}
+
+	segs := make([][]byte, 0, len(splits)+1)
+	prev := 0
+	for i, end := range append(splits, len(payload)) {
+		segs = append(segs, BuildSegment(raw, pi, payload[prev:end], uint32(prev), uint16(i)))
+		prev = end
+	}
+
+	w.SendSegments(pi.L3, segs, dst, cfg)
+}
+
+// mutateHTTPRequest rewrites the Host header according to hc. The request
+// length is kept unchanged so the TCP sequence space stays intact: bytes added
+// around the host are compensated by dropping the optional space after the
+// colon of other headers. If that is not possible only the header case change
+// is applied.
+func mutateHTTPRequest(payload []byte, hc *config.HTTPConfig) []byte {
+	nameStart, vs, ve, ok := sni.LocateHTTPHost(payload)
+	if !ok || !(hc.HeaderCase || hc.HostSpace || hc.HostDot) {
+		return payload
+	}
+
+	build := func(space, dot bool) []byte {
+		out := make([]byte, 0, len(payload)+2)
+		out = append(out, payload[:nameStart]...)
+		if hc.HeaderCase {
+			out = append(out, "hOsT"...)
+		} else {
+			out = append(out, payload[nameStart:nameStart+4]...)
+		}
+		out = append(out, payload[nameStart+4:vs]...)
+		if space {
+			out = append(out, ' ')
+		}
+
+		host := payload[vs:ve]
+		hostEnd := len(host)
+		if host[0] != '[' {
+			if i := bytes.IndexByte(host, ':'); i >= 0 {
+				hostEnd = i
+			}
+		}
+		out = append(out, host[:hostEnd]...)
+		if dot && hostEnd > 0 && host[hostEnd-1] != '.' {
+			out = append(out, '.')
+		}
+		out = append(out, host[hostEnd:]...)
+		out = append(out, payload[ve:]...)
+		return out
+	}
+
+	out := build(hc.HostSpace, hc.HostDot)
+	if extra := len(out) - len(payload); extra > 0 {
+		if trimmed, ok := trimHeaderSpaces(out, extra, nameStart); ok {
+			return trimmed
+		}
+		return build(false, false)
+	}
+	return out
+}
+
+// trimHeaderSpaces removes n single spaces following the colon of header
+// lines other than the one starting at skipLine.
+func trimHeaderSpaces(req []byte, n, skipLine int) ([]byte, bool) {
+	headersEnd := bytes.Index(req, []byte("\r\n\r\n"))
+	if headersEnd < 0 {
+		headersEnd = len(req)
+	}
+
+	var cuts []int
+	p := bytes.Index(req, []byte("\r\n")) + 2
+	for p > 1 && p < headersEnd && len(cuts) < n {
+		eol := bytes.Index(req[p:], []byte("\r\n"))
+		if eol < 0 {
+			break
+		}
+		if p != skipLine {
+			line := req[p : p+eol]
+			if c := bytes.IndexByte(line, ':'); c > 0 && c+1 < len(line) && line[c+1] == ' ' {
+				cuts = append(cuts, p+c+1)
+			}
+		}
+		p += eol + 2
+	}
+
+	if len(cuts) < n {
+		return nil, false
+	}
+
+	out := make([]byte, 0, len(req)-n)
+	prev := 0
+	for _, c := range cuts {
+		out = append(out, req[prev:c]...)
+		prev = c + 1
+	}
+	out = append(out, req[prev:]...)
+	return out, true
+}
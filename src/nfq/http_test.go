@@ -0,0 +1,59 @@
+package nfq
+
+import (
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+const testHTTPRequest = "GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.0\r\nAccept: */*\r\n\r\n"
+
+func TestMutateHTTPRequest(t *testing.T) {
+	req := []byte(testHTTPRequest)
+
+	t.Run("header case", func(t *testing.T) {
+		out := mutateHTTPRequest(req, &config.HTTPConfig{HeaderCase: true})
+		want := "GET / HTTP/1.1\r\nhOsT: example.com\r\nUser-Agent: curl/8.0\r\nAccept: */*\r\n\r\n"
+		if string(out) != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("space and dot keep length", func(t *testing.T) {
+		out := mutateHTTPRequest(req, &config.HTTPConfig{HostSpace: true, HostDot: true})
+		want := "GET / HTTP/1.1\r\nHost:  example.com.\r\nUser-Agent:curl/8.0\r\nAccept:*/*\r\n\r\n"
+		if string(out) != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+		if host, ok := sni.ParseHTTPRequestHost(out); !ok || host != "example.com" {
+			t.Errorf("host = %q, %v", host, ok)
+		}
+	})
+
+	t.Run("dot before port", func(t *testing.T) {
+		in := []byte("GET / HTTP/1.1\r\nHost: example.com:8080\r\nAccept: */*\r\n\r\n")
+		out := mutateHTTPRequest(in, &config.HTTPConfig{HostDot: true})
+		want := "GET / HTTP/1.1\r\nHost: example.com.:8080\r\nAccept:*/*\r\n\r\n"
+		if string(out) != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("no room falls back to case only", func(t *testing.T) {
+		in := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		out := mutateHTTPRequest(in, &config.HTTPConfig{HeaderCase: true, HostDot: true})
+		want := "GET / HTTP/1.1\r\nhOsT: example.com\r\n\r\n"
+		if string(out) != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	})
+
+	t.Run("not http", func(t *testing.T) {
+		in := []byte{0x16, 0x03, 0x01, 0x00, 0x10}
+		out := mutateHTTPRequest(in, &config.HTTPConfig{HeaderCase: true})
+		if string(out) != string(in) {
+			t.Error("non-HTTP payload must be returned unchanged")
+		}
+	})
+}
@@ -7,17 +7,13 @@ import (
 )
 
 func (w *Worker) sendHybridFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
-	payloadLen := len(packet) - payloadStart
-
-	if payloadLen < 10 {
-		_ = w.sock.SendIPv4(packet, dst)
+	pi, ok := ExtractPacketInfo(packet)
+	if !ok || pi.PayloadLen < 10 {
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
-	payload := packet[payloadStart:]
+	payload := pi.Payload
 
 	extSplit := findPreSNIExtensionPoint(payload)
 	sniStart, sniEnd, hasSNI := locateSNI(payload)
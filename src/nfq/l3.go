@@ -0,0 +1,189 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// PacketSink receives the packets a worker injects. sock.Sender is the one
+// used on the wire; tests and the simulator record them instead.
+type PacketSink interface {
+	SendIPv4(packet []byte, dst net.IP) error
+	SendIPv6(packet []byte, dst net.IP) error
+	SendBatchIPv4(packets [][]byte, dst net.IP) error
+	SendBatchIPv6(packets [][]byte, dst net.IP) error
+	Close()
+}
+
+// L3 is the network layer of a TCP packet. The TCP strategies build and
+// send segments through it, so each is written once for both families.
+type L3 interface {
+	IPv6() bool
+	// SetTTL sets the IPv4 TTL or the IPv6 hop limit
+	SetTTL(packet []byte, ttl uint8)
+	// SetID sets the IPv4 identification; IPv6 packets carry none
+	SetID(packet []byte, id uint16)
+	// Finish stores the length of packet in its IP header and fixes the checksums
+	Finish(packet []byte)
+	// FixTCPChecksum fixes the TCP checksum only
+	FixTCPChecksum(packet []byte)
+	Dst(packet []byte) net.IP
+	Send(s PacketSink, packet []byte, dst net.IP) error
+	SendBatch(s PacketSink, packets [][]byte, dst net.IP) error
+}
+
+var (
+	IPv4Layer L3 = ipv4L3{}
+	IPv6Layer L3 = ipv6L3{}
+)
+
+// L3Of returns the network layer of packet by its version
+func L3Of(packet []byte) L3 {
+	if len(packet) > 0 && packet[0]>>4 == IPv6 {
+		return IPv6Layer
+	}
+	return IPv4Layer
+}
+
+type ipv4L3 struct{}
+
+func (ipv4L3) IPv6() bool { return false }
+
+func (ipv4L3) SetTTL(packet []byte, ttl uint8) { packet[8] = ttl }
+
+func (ipv4L3) SetID(packet []byte, id uint16) { binary.BigEndian.PutUint16(packet[4:6], id) }
+
+func (ipv4L3) Finish(packet []byte) {
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	sock.FixIPv4Checksum(packet[:int(packet[0]&0x0F)*4])
+	sock.FixTCPChecksum(packet)
+}
+
+func (ipv4L3) FixTCPChecksum(packet []byte) { sock.FixTCPChecksum(packet) }
+
+func (ipv4L3) Dst(packet []byte) net.IP { return net.IP(packet[16:20]) }
+
+func (ipv4L3) Send(s PacketSink, packet []byte, dst net.IP) error {
+	return s.SendIPv4(packet, dst)
+}
+
+func (ipv4L3) SendBatch(s PacketSink, packets [][]byte, dst net.IP) error {
+	return s.SendBatchIPv4(packets, dst)
+}
+
+type ipv6L3 struct{}
+
+func (ipv6L3) IPv6() bool { return true }
+
+func (ipv6L3) SetTTL(packet []byte, ttl uint8) { packet[7] = ttl }
+
+func (ipv6L3) SetID(packet []byte, id uint16) {}
+
+func (ipv6L3) Finish(packet []byte) {
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)-IPv6HeaderLen))
+	sock.FixTCPChecksumV6(packet)
+}
+
+func (ipv6L3) FixTCPChecksum(packet []byte) { sock.FixTCPChecksumV6(packet) }
+
+func (ipv6L3) Dst(packet []byte) net.IP { return net.IP(packet[24:40]) }
+
+func (ipv6L3) Send(s PacketSink, packet []byte, dst net.IP) error {
+	return s.SendIPv6(packet, dst)
+}
+
+func (ipv6L3) SendBatch(s PacketSink, packets [][]byte, dst net.IP) error {
+	return s.SendBatchIPv6(packets, dst)
+}
+
+// ExtractPacketInfo parses a TCP packet of either family
+func ExtractPacketInfo(packet []byte) (PacketInfo, bool) {
+	if L3Of(packet).IPv6() {
+		return ExtractPacketInfoV6(packet)
+	}
+	return ExtractPacketInfoV4(packet)
+}
+
+// BuildSegment builds a copy of packet carrying payloadSlice at seqOffset.
+// idOffset is added to the IP ID of IPv4 packets.
+func BuildSegment(packet []byte, pi PacketInfo, payloadSlice []byte, seqOffset uint32, idOffset uint16) []byte {
+	seg := make([]byte, pi.PayloadStart+len(payloadSlice))
+	copy(seg[:pi.PayloadStart], packet[:pi.PayloadStart])
+	copy(seg[pi.PayloadStart:], payloadSlice)
+
+	binary.BigEndian.PutUint32(seg[pi.IPHdrLen+4:pi.IPHdrLen+8], pi.Seq0+seqOffset)
+	pi.L3.SetID(seg, pi.ID0+idOffset)
+	pi.L3.Finish(seg)
+	return seg
+}
+
+// BuildFakeOverlapSegment builds a low TTL segment of payloadLen junk bytes
+// at seqOffset, filled with fakePattern
+func BuildFakeOverlapSegment(packet []byte, pi PacketInfo, payloadLen int, seqOffset uint32, idOffset uint16, fakePattern []byte, fakeTTL uint8, corruptChecksum bool) []byte {
+	if payloadLen <= 0 {
+		return nil
+	}
+
+	seg := make([]byte, pi.PayloadStart+payloadLen)
+	copy(seg[:pi.PayloadStart], packet[:pi.PayloadStart])
+
+	patLen := len(fakePattern)
+	if patLen == 0 {
+		for i := 0; i < payloadLen; i++ {
+			seg[pi.PayloadStart+i] = byte((i * 7) & 0xFF)
+		}
+	} else {
+		for i := 0; i < payloadLen; i++ {
+			seg[pi.PayloadStart+i] = fakePattern[i%patLen]
+		}
+	}
+
+	binary.BigEndian.PutUint32(seg[pi.IPHdrLen+4:pi.IPHdrLen+8], pi.Seq0+seqOffset)
+	pi.L3.SetID(seg, pi.ID0+idOffset)
+
+	if fakeTTL == 0 {
+		fakeTTL = 3
+	}
+	pi.L3.SetTTL(seg, fakeTTL)
+
+	seg[pi.IPHdrLen+13] &^= 0x08
+
+	pi.L3.Finish(seg)
+
+	if corruptChecksum {
+		seg[pi.IPHdrLen+16] ^= 0xFF
+		seg[pi.IPHdrLen+17] ^= 0xFF
+	}
+
+	return seg
+}
+
+// send sends packet as is
+func (w *Worker) send(l3 L3, packet []byte, dst net.IP) {
+	_ = l3.Send(w.sock, packet, dst)
+}
+
+// sendPackets sends packets in order with delay ms between them, or in a
+// single batch when there is no delay
+func (w *Worker) sendPackets(l3 L3, packets [][]byte, dst net.IP, delay int) {
+	if delay <= 0 {
+		_ = l3.SendBatch(w.sock, packets, dst)
+		return
+	}
+	for i, p := range packets {
+		_ = l3.Send(w.sock, p, dst)
+		if i < len(packets)-1 {
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+		}
+	}
+}
+
+func (w *Worker) sendTwoSegments(l3 L3, seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
+	if reverse {
+		seg1, seg2 = seg2, seg1
+	}
+	w.sendPackets(l3, [][]byte{seg1, seg2}, dst, delay)
+}
@@ -0,0 +1,194 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// recordSender keeps the packets a worker sends
+type recordSender struct {
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func (r *recordSender) add(packets ...[]byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range packets {
+		r.packets = append(r.packets, append([]byte(nil), p...))
+	}
+	return nil
+}
+
+func (r *recordSender) SendIPv4(p []byte, _ net.IP) error        { return r.add(p) }
+func (r *recordSender) SendIPv6(p []byte, _ net.IP) error        { return r.add(p) }
+func (r *recordSender) SendBatchIPv4(p [][]byte, _ net.IP) error { return r.add(p...) }
+func (r *recordSender) SendBatchIPv6(p [][]byte, _ net.IP) error { return r.add(p...) }
+func (r *recordSender) Close()                                   {}
+
+func buildTCPv6(seq uint32, flags byte, payload []byte) []byte {
+	pkt := make([]byte, 60+len(payload))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(20+len(payload)))
+	pkt[6] = 6
+	pkt[7] = 64
+	copy(pkt[8:24], net.ParseIP("2001:db8::2"))
+	copy(pkt[24:40], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(pkt[40:42], 40000)
+	binary.BigEndian.PutUint16(pkt[42:44], HTTPSPort)
+	binary.BigEndian.PutUint32(pkt[44:48], seq)
+	pkt[52] = 0x50
+	pkt[53] = flags
+	copy(pkt[60:], payload)
+	sock.FixTCPChecksumV6(pkt)
+	return pkt
+}
+
+// tcpChecksumOK reports whether the TCP checksum of packet is right
+func tcpChecksumOK(packet []byte) bool {
+	pi, ok := ExtractPacketInfo(packet)
+	if !ok {
+		return false
+	}
+	fixed := append([]byte(nil), packet...)
+	pi.L3.FixTCPChecksum(fixed)
+	return bytes.Equal(fixed, packet)
+}
+
+func TestStrategiesEquivalentAcrossFamilies(t *testing.T) {
+	fragment := func(strategy string) func(*Worker, *config.SetConfig, []byte) {
+		return func(w *Worker, cfg *config.SetConfig, packet []byte) {
+			w.fragment(cfg, strategy, packet, L3Of(packet).Dst(packet))
+		}
+	}
+
+	cases := []struct {
+		name  string
+		setup func(*config.SetConfig)
+		run   func(*Worker, *config.SetConfig, []byte)
+	}{
+		{"tcp", nil, fragment("tcp")},
+		{"tcp reverse", func(c *config.SetConfig) { c.Fragmentation.ReverseOrder = true }, fragment("tcp")},
+		{"oob", nil, fragment("oob")},
+		{"tls", nil, fragment("tls")},
+		{"disorder", func(c *config.SetConfig) { c.Fragmentation.Disorder.ShuffleMode = "reverse" }, fragment("disorder")},
+		{"overlap", nil, fragment("overlap")},
+		{"extsplit", nil, fragment("extsplit")},
+		{"firstbyte", func(c *config.SetConfig) { c.TCP.Seg2Delay = 10 }, fragment("firstbyte")},
+		{"combo", func(c *config.SetConfig) {
+			c.Fragmentation.Combo.ShuffleMode = "reverse"
+			c.Fragmentation.Combo.FirstDelayMs = 1
+			c.Fragmentation.SeqOverlapBytes = []byte{0x16, 0x03, 0x01}
+		}, fragment("combo")},
+		{"hybrid", func(c *config.SetConfig) {
+			c.Fragmentation.Combo.ShuffleMode = "reverse"
+			c.Fragmentation.Combo.FirstDelayMs = 1
+		}, fragment("hybrid")},
+		{"none", nil, fragment("none")},
+		{"fake sni", func(c *config.SetConfig) {
+			c.Faking.SNI = true
+			c.Faking.SNISeqLength = 3
+			c.Faking.SNIType = config.FakePayloadDefault1
+			c.Faking.Strategy = "ttl"
+		}, func(w *Worker, c *config.SetConfig, p []byte) { w.sendFakeSNISequence(c, p, nil) }},
+		{"desync rst", func(c *config.SetConfig) { c.TCP.DesyncMode = "rst"; c.TCP.DesyncCount = 3 },
+			func(w *Worker, c *config.SetConfig, p []byte) { w.ExecuteDesync(c, p, nil) }},
+		{"desync fin", func(c *config.SetConfig) { c.TCP.DesyncMode = "fin"; c.TCP.DesyncCount = 2 },
+			func(w *Worker, c *config.SetConfig, p []byte) { w.ExecuteDesync(c, p, nil) }},
+		{"desync full", func(c *config.SetConfig) { c.TCP.DesyncMode = "full" },
+			func(w *Worker, c *config.SetConfig, p []byte) { w.ExecuteDesync(c, p, nil) }},
+		{"window escalate", func(c *config.SetConfig) { c.TCP.WinMode = "escalate" },
+			func(w *Worker, c *config.SetConfig, p []byte) { w.ManipulateWindow(c, p, nil) }},
+		{"window zero", func(c *config.SetConfig) { c.TCP.WinMode = "zero" },
+			func(w *Worker, c *config.SetConfig, p []byte) { w.ManipulateWindow(c, p, nil) }},
+		{"fake syn", func(c *config.SetConfig) { c.TCP.SynFakeLen = 64 },
+			func(w *Worker, c *config.SetConfig, p []byte) {
+				pi, _ := ExtractPacketInfo(p)
+				w.sendFakeSyn(c, p, pi.IPHdrLen, pi.TCPHdrLen)
+			}},
+		{"pipeline", func(c *config.SetConfig) { c.Pipeline = config.PipelineTemplate("fake-split-fake-disorder") },
+			func(w *Worker, c *config.SetConfig, p []byte) { w.runPipeline(c, p, nil) }},
+	}
+
+	payload := buildHelloPayload("blocked.example.com", 64)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent [2][][]byte
+			for i, packet := range [][]byte{buildTCPv4(1000, 0x18, payload), buildTCPv6(1000, 0x18, payload)} {
+				cfg := config.NewSetConfig()
+				cfg.TCP.Seg2Delay = 0
+				if tc.setup != nil {
+					tc.setup(&cfg)
+				}
+				rec := &recordSender{}
+				tc.run(&Worker{sock: rec}, &cfg, packet)
+				sent[i] = rec.packets
+			}
+
+			v4, v6 := sent[0], sent[1]
+			if len(v4) == 0 {
+				t.Fatal("nothing sent")
+			}
+			if len(v4) != len(v6) {
+				t.Fatalf("IPv4 sent %d packets, IPv6 sent %d", len(v4), len(v6))
+			}
+			for i := range v4 {
+				checkFamilyHeaders(t, i, v4[i], v6[i])
+
+				tcp4, tcp6 := v4[i][20:], v6[i][40:]
+				if len(tcp4) != len(tcp6) {
+					t.Fatalf("packet %d: TCP length %d vs %d", i, len(tcp4), len(tcp6))
+				}
+				// Everything but the checksum, which covers the addresses
+				if !bytes.Equal(tcp4[:16], tcp6[:16]) || !bytes.Equal(tcp4[18:], tcp6[18:]) {
+					t.Errorf("packet %d: TCP segments differ\nv4 % x\nv6 % x", i, tcp4[:20], tcp6[:20])
+				}
+				if tcpChecksumOK(v4[i]) != tcpChecksumOK(v6[i]) {
+					t.Errorf("packet %d: TCP checksum valid in one family only", i)
+				}
+			}
+		})
+	}
+}
+
+// checkFamilyHeaders checks the length fields of a packet pair and that the
+// TTL and hop limit match
+func checkFamilyHeaders(t *testing.T, i int, v4, v6 []byte) {
+	t.Helper()
+	if got := int(binary.BigEndian.Uint16(v4[2:4])); got != len(v4) {
+		t.Errorf("packet %d: IPv4 total length %d, have %d bytes", i, got, len(v4))
+	}
+	if got := int(binary.BigEndian.Uint16(v6[4:6])); got != len(v6)-IPv6HeaderLen {
+		t.Errorf("packet %d: IPv6 payload length %d, have %d bytes", i, got, len(v6)-IPv6HeaderLen)
+	}
+	if v4[8] != v6[7] {
+		t.Errorf("packet %d: TTL %d, hop limit %d", i, v4[8], v6[7])
+	}
+}
+
+func TestBuildSegmentBothFamilies(t *testing.T) {
+	payload := []byte("0123456789abcdef")
+	for _, packet := range [][]byte{buildTCPv4(500, 0x18, payload), buildTCPv6(500, 0x18, payload)} {
+		pi, ok := ExtractPacketInfo(packet)
+		if !ok {
+			t.Fatal("extract failed")
+		}
+		seg := BuildSegment(packet, pi, payload[4:10], 4, 1)
+		if !bytes.Equal(seg[pi.PayloadStart:], payload[4:10]) {
+			t.Errorf("IPv6=%v: wrong payload", pi.L3.IPv6())
+		}
+		if seq := binary.BigEndian.Uint32(seg[pi.IPHdrLen+4:]); seq != 504 {
+			t.Errorf("IPv6=%v: seq %d", pi.L3.IPv6(), seq)
+		}
+		if !tcpChecksumOK(seg) {
+			t.Errorf("IPv6=%v: bad TCP checksum", pi.L3.IPv6())
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/log"
-	"github.com/daniellavrushin/b4/sock"
 )
 
 const (
@@ -52,9 +51,10 @@ func (w *Worker) MutateClientHello(cfg *config.SetConfig, packet []byte, dst net
 		return packet
 	}
 
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
+	payloadStart := tcpPayloadStart(packet)
+	if payloadStart < 0 {
+		return packet
+	}
 
 	if len(packet) <= payloadStart+5 {
 		return packet
@@ -96,9 +96,10 @@ func (w *Worker) duplicateSNI(packet []byte, cfg *config.SetConfig) []byte {
 		return packet
 	}
 
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
+	payloadStart := tcpPayloadStart(packet)
+	if payloadStart < 0 {
+		return packet
+	}
 
 	extOffset := w.findExtensionsOffset(packet[payloadStart:])
 	if extOffset < 0 {
@@ -197,9 +198,10 @@ func (w *Worker) reorderExtensions(packet []byte, cfg *config.SetConfig) []byte
 		return packet
 	}
 
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
+	payloadStart := tcpPayloadStart(packet)
+	if payloadStart < 0 {
+		return packet
+	}
 
 	extOffset := w.findExtensionsOffset(packet[payloadStart:])
 	if extOffset < 0 {
@@ -539,9 +541,10 @@ func (w *Worker) parseExtensions(data []byte) [][]byte {
 
 // Helper: Insert extensions into packet with size limits
 func (w *Worker) insertExtensions(packet []byte, newExts []byte) []byte {
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
+	payloadStart := tcpPayloadStart(packet)
+	if payloadStart < 0 {
+		return packet
+	}
 
 	extOffset := w.findExtensionsOffset(packet[payloadStart:])
 	if extOffset < 0 {
@@ -599,9 +602,10 @@ func (w *Worker) insertExtensions(packet []byte, newExts []byte) []byte {
 
 // Helper: Replace all extensions with safety checks
 func (w *Worker) replaceExtensions(packet []byte, newExts []byte) []byte {
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
+	payloadStart := tcpPayloadStart(packet)
+	if payloadStart < 0 {
+		return packet
+	}
 
 	extOffset := w.findExtensionsOffset(packet[payloadStart:])
 	if extOffset < 0 {
@@ -651,58 +655,15 @@ func (w *Worker) replaceExtensions(packet []byte, newExts []byte) []byte {
 	return newPacket
 }
 
-// Helper: Update all packet lengths after mutation with IPv6 support
+// Helper: Update all packet lengths after mutation
 func (w *Worker) updatePacketLengths(packet []byte) {
-	if len(packet) < 1 {
-		return
-	}
-
-	version := packet[0] >> 4
-
-	if version == 6 {
-		// IPv6 handling
-		ipv6HdrLen := 40
-		if len(packet) < ipv6HdrLen+20 {
-			return
-		}
-
-		tcpHdrLen := int((packet[ipv6HdrLen+12] >> 4) * 4)
-		payloadStart := ipv6HdrLen + tcpHdrLen
-		payloadLen := len(packet) - payloadStart
-
-		// Update IPv6 payload length
-		binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)-ipv6HdrLen))
-
-		// Update TLS record length
-		if payloadLen >= 5 && packet[payloadStart] == 0x16 {
-			binary.BigEndian.PutUint16(packet[payloadStart+3:payloadStart+5], uint16(payloadLen-5))
-		}
-
-		// Update ClientHello length
-		if payloadLen >= 9 && packet[payloadStart] == 0x16 && packet[payloadStart+5] == 0x01 {
-			helloLen := payloadLen - 9
-			packet[payloadStart+6] = byte(helloLen >> 16)
-			packet[payloadStart+7] = byte(helloLen >> 8)
-			packet[payloadStart+8] = byte(helloLen)
-		}
-
-		// Fix TCP checksum for IPv6
-		sock.FixTCPChecksumV6(packet)
+	pi, ok := ExtractPacketInfo(packet)
+	if !ok {
 		return
 	}
 
-	// IPv4 handling
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	if len(packet) < ipHdrLen+20 {
-		return
-	}
-
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
-	payloadLen := len(packet) - payloadStart
-
-	// Update IP total length
-	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	payloadStart := pi.PayloadStart
+	payloadLen := pi.PayloadLen
 
 	// Update TLS record length
 	if payloadLen >= 5 && packet[payloadStart] == 0x16 {
@@ -717,9 +678,18 @@ func (w *Worker) updatePacketLengths(packet []byte) {
 		packet[payloadStart+8] = byte(helloLen)
 	}
 
-	// Fix checksums
-	sock.FixIPv4Checksum(packet[:ipHdrLen])
-	sock.FixTCPChecksum(packet)
+	// Update the IP length and fix checksums
+	pi.L3.Finish(packet)
+}
+
+// tcpPayloadStart returns the offset of the payload of a TCP packet of
+// either family, or -1 if packet is not one
+func tcpPayloadStart(packet []byte) int {
+	pi, ok := ExtractPacketInfo(packet)
+	if !ok {
+		return -1
+	}
+	return pi.PayloadStart
 }
 
 // randomUint32 generates a random uint32 with thread safety
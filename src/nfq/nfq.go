@@ -3,7 +3,6 @@ package nfq
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"net"
 	"os"
 	"strings"
@@ -16,21 +15,25 @@ import (
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/metrics"
 	"github.com/daniellavrushin/b4/quic"
-	"github.com/daniellavrushin/b4/sni"
 	"github.com/daniellavrushin/b4/sock"
-	"github.com/daniellavrushin/b4/stun"
-	"github.com/daniellavrushin/b4/utils"
 	"github.com/florianl/go-nfqueue"
 )
 
 func (w *Worker) Start() error {
 	cfg := w.getConfig()
 	mark := cfg.Queue.Mark
-	s, err := sock.NewSenderWithMark(int(mark))
-	if err != nil {
-		return err
+	if w.sock == nil {
+		s, err := sock.NewSenderWithMark(int(mark))
+		if err != nil {
+			return err
+		}
+		if cfg.Queue.Sender == config.SenderPacket {
+			if err := s.UseLinkLayer(); err != nil {
+				log.Warnf("AF_PACKET sender unavailable, using raw sockets: %v", err)
+			}
+		}
+		w.sock = s
 	}
-	w.sock = s
 
 	c := nfqueue.Config{
 		NfQueue:      w.qnum,
@@ -54,20 +57,16 @@ func (w *Worker) Start() error {
 		log.Tracef("NFQ bound pid=%d queue=%d", pid, w.qnum)
 		defer w.wg.Done()
 		_ = q.RegisterWithErrorFunc(w.ctx, func(a nfqueue.Attribute) int {
-			cfg := w.getConfig()
-			set := cfg.MainSet
-
-			matcher := w.getMatcher()
 			id := *a.PacketID
 
 			if a.Mark != nil && *a.Mark == uint32(mark) {
-				_ = q.SetVerdict(id, nfqueue.NfAccept)
+				w.setVerdict(id, nfqueue.NfAccept)
 				return 0
 			}
 
 			// Interface filtering
 			if !w.matchesInterface(a) {
-				_ = q.SetVerdict(id, nfqueue.NfAccept)
+				w.setVerdict(id, nfqueue.NfAccept)
 				return 0
 			}
 
@@ -83,331 +82,41 @@ func (w *Worker) Start() error {
 				return 0
 			}
 			raw := *a.Payload
-
-			v := raw[0] >> 4
-			if v != IPv4 && v != IPv6 {
-				_ = q.SetVerdict(id, nfqueue.NfAccept)
-				return 0
+			var pktMark uint32
+			if a.Mark != nil {
+				pktMark = *a.Mark
 			}
-			var proto uint8
-			var src, dst net.IP
-			var ihl int
-			if v == IPv4 {
-				if len(raw) < 20 {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-				ihl = int(raw[0]&0x0f) * 4
-				if len(raw) < ihl {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-				proto = raw[9]
-				src = net.IP(raw[12:16])
-				dst = net.IP(raw[16:20])
 
-			} else {
-				if len(raw) < IPv6HeaderLen {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-				ihl = IPv6HeaderLen
-				nextHeader := raw[6]
-				offset := 40
-
-				for {
-					switch nextHeader {
-					case 0, 43, 60: // Hop-by-Hop, Routing, Destination Options
-						if len(raw) < offset+2 {
-							_ = q.SetVerdict(id, nfqueue.NfAccept)
-							return 0
-						}
-						nextHeader = raw[offset]
-						hdrLen := int(raw[offset+1])*8 + 8
-						offset += hdrLen
-					case 44:
-						if len(raw) < offset+8 {
-							_ = q.SetVerdict(id, nfqueue.NfAccept)
-							return 0
-						}
-						nextHeader = raw[offset]
-						offset += 8
-					default:
-						goto done
-					}
-				}
-			done:
-				proto = nextHeader
-				ihl = offset
-				src = net.IP(raw[8:24])
-				dst = net.IP(raw[24:40])
-			}
-
-			if src.IsLoopback() || dst.IsLoopback() {
-				_ = q.SetVerdict(id, nfqueue.NfAccept)
+			d := w.decide(raw, pktMark)
+			switch d.route {
+			case routeHeld:
+				w.setVerdict(id, nfqueue.NfDrop)
 				return 0
+			case routeDNS:
+				return w.processDnsPacket(d.matcher, d.v, d.sport, d.dport, d.payload, raw, d.ihl, id)
 			}
-			srcStr := src.String()
-			dstStr := dst.String()
+			rec := w.record(&d)
 
-			srcMac := w.getMacByIp(srcStr)
+			switch d.route {
+			case routeDrop:
+				w.setVerdict(id, nfqueue.NfDrop)
 
-			matched, st := matcher.MatchIP(dst)
-			if matched {
-				set = st
-			}
-
-			// TCP processing
-			if proto == 6 && len(raw) >= ihl+TCPHeaderMinLen {
-				tcp := raw[ihl:]
-				if len(tcp) < TCPHeaderMinLen {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-				datOff := int((tcp[12]>>4)&0x0f) * 4
-				if len(tcp) < datOff {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-				payload := tcp[datOff:]
-				sport := binary.BigEndian.Uint16(tcp[0:2])
-				dport := binary.BigEndian.Uint16(tcp[2:4])
-
-				tcpFlags := tcp[13]
-				isSyn := (tcpFlags & 0x02) != 0 // SYN flag
-				isAck := (tcpFlags & 0x10) != 0 // ACK flag
-				isRst := (tcpFlags & 0x04) != 0
-				if isRst && dport == HTTPSPort {
-					log.Tracef("RST received from %s:%d", dstStr, dport)
-				}
-
-				if set.TCP.SynFake && isSyn && !isAck && dport == HTTPSPort {
-
-					if matched {
-						log.Tracef("TCP SYN to %s:%d - sending fake SYN (set: %s)", dstStr, dport, set.Name)
-
-						metrics := metrics.GetMetricsCollector()
-						metrics.RecordConnection("TCP-SYN", "", srcStr, dstStr, true)
-
-						if v == IPv4 {
-							w.sendFakeSyn(set, raw, ihl, datOff)
-							_ = w.sock.SendIPv4(raw, dst)
-						} else {
-							w.sendFakeSynV6(set, raw, ihl, datOff)
-							_ = w.sock.SendIPv6(raw, dst)
-						}
-						_ = q.SetVerdict(id, nfqueue.NfDrop)
-						return 0
-					}
-
-					log.Tracef("TCP SYN to %s:%d - passing through", dstStr, dport)
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-
-				host := ""
-				matchedIP := matched
-				matchedSNI := false
-				ipTarget := ""
-				sniTarget := ""
-
-				if dport == HTTPSPort && len(payload) > 0 {
-					log.Tracef("TCP payload to %s: len=%d, first5=%x", dstStr, len(payload), payload[:min(5, len(payload))])
-					if len(payload) >= 5 && payload[0] == 0x16 {
-						log.Tracef("TLS record: type=%x ver=%x%x len=%d", payload[0], payload[1], payload[2],
-							int(payload[3])<<8|int(payload[4]))
-					}
-					connKey := fmt.Sprintf("%s:%d->%s:%d", srcStr, sport, dstStr, dport)
-
-					host, _ = sni.ParseTLSClientHelloSNI(payload)
-
-					if captureManager := capture.GetManager(cfg); captureManager != nil {
-						captureManager.CapturePayload(connKey, host, "tls", payload)
-					}
-
-					if host != "" {
-						if mSNI, stSNI := matcher.MatchSNI(host); mSNI {
-							matchedSNI = true
-							matched = true
-							set = stSNI
-						}
-					}
-				}
-
-				if matchedIP {
-					ipTarget = st.Name
-				}
-				if matchedSNI {
-					sniTarget = set.Name
-				}
-
-				if !log.IsDiscoveryActive() {
-					log.Infof(",TCP,%s,%s,%s:%d,%s,%s:%d,%s", sniTarget, host, srcStr, sport, ipTarget, dstStr, dport, srcMac)
-				}
+			case routeSynFake:
+				w.forFlow(d.set, nil).inject(&d)
+				w.setVerdict(id, nfqueue.NfDrop)
 
-				if matched {
-					metrics := metrics.GetMetricsCollector()
-					metrics.RecordConnection("TCP", host, srcStr, dstStr, true)
-					metrics.RecordPacket(uint64(len(raw)))
+			case routeTCP, routeHTTP, routeQUIC:
+				d.detach()
+				w.setVerdict(id, nfqueue.NfDrop)
+				go w.forFlow(d.set, rec).inject(&d)
 
-					packetCopy := make([]byte, len(raw))
-					copy(packetCopy, raw)
-
-					if set.TCP.DropSACK {
-						if v == 4 {
-							packetCopy = sock.StripSACKFromTCP(packetCopy)
-						} else {
-							packetCopy = sock.StripSACKFromTCPv6(packetCopy)
-						}
-					}
-
-					dstCopy := make(net.IP, len(dst))
-					copy(dstCopy, dst)
-					setCopy := set
-
-					_ = q.SetVerdict(id, nfqueue.NfDrop)
-
-					go func(s *config.SetConfig, pkt []byte, d net.IP) {
-						if v == 4 {
-							w.dropAndInjectTCP(s, pkt, d)
-						} else {
-							w.dropAndInjectTCPv6(s, pkt, d)
-						}
-					}(setCopy, packetCopy, dstCopy)
-					return 0
-				}
-
-				_ = q.SetVerdict(id, nfqueue.NfAccept)
-				return 0
-			}
-
-			// UDP processing
-			if proto == 17 && len(raw) >= ihl+8 {
-				udp := raw[ihl:]
-				if len(udp) < 8 {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-
-				payload := udp[8:]
-				sport := binary.BigEndian.Uint16(udp[0:2])
-				dport := binary.BigEndian.Uint16(udp[2:4])
-				connKey := fmt.Sprintf("%s:%d->%s:%d", srcStr, sport, dstStr, dport)
-
-				// Handle DNS packets
-				if sport == 53 || dport == 53 {
-					return w.processDnsPacket(v, sport, dport, payload, raw, ihl, id)
-				}
-
-				if utils.IsPrivateIP(dst) {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-
-				matchedIP := matched
-				matchedPort := false
-				matchedQUIC := false
-				isSTUN := false
-				host := ""
-				ipTarget := ""
-				sniTarget := ""
-
-				if matchedIP {
-					ipTarget = st.Name
-				}
-
-				if matchedIP && matcher.PortMatchesSet(dport, st) {
-					matchedPort = true
-				} else if !matchedIP {
-					if mport, portSet := matcher.MatchUDPPortOnly(dport); mport {
-						matchedPort = true
-						set = portSet
-						ipTarget = portSet.Name
-					}
-				}
-
-				isSTUN = stun.IsSTUNMessage(payload)
-
-				switch set.UDP.FilterQUIC {
-				case "disabled":
-
-				case "all":
-					if quic.IsInitial(payload) {
-						matchedQUIC = true
-						if h, ok := sni.ParseQUICClientHelloSNI(payload); ok {
-							host = h
-						}
-					}
-
-				case "parse":
-					if h, ok := sni.ParseQUICClientHelloSNI(payload); ok {
-						host = h
-						if mSNI, sniSet := matcher.MatchSNI(host); mSNI {
-							matchedQUIC = true
-							set = sniSet
-							sniTarget = sniSet.Name
-						}
-					}
-				}
-
-				if captureManager := capture.GetManager(cfg); captureManager != nil {
-					captureManager.CapturePayload(connKey, host, "quic", payload)
-				}
-
-				shouldHandle := (matchedPort || matchedIP || matchedQUIC) && !(isSTUN && set.UDP.FilterSTUN)
-
-				matched = shouldHandle
-
-				if !log.IsDiscoveryActive() {
-					log.Infof(",UDP,%s,%s,%s:%d,%s,%s:%d,%s", sniTarget, host, srcStr, sport, ipTarget, dstStr, dport, srcMac)
-				}
-				// Early exit for STUN
-				if isSTUN && set.UDP.FilterSTUN {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-
-				// Accept if no match
-				if !shouldHandle {
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
-				}
-
-				metrics := metrics.GetMetricsCollector()
-				metrics.RecordConnection("UDP", host, srcStr, dstStr, matched)
-				metrics.RecordPacket(uint64(len(raw)))
-
-				// Apply configured UDP mode
-				switch set.UDP.Mode {
-				case "drop":
-					_ = q.SetVerdict(id, nfqueue.NfDrop)
-					return 0
-
-				case "fake":
-					packetCopy := make([]byte, len(raw))
-					copy(packetCopy, raw)
-					dstCopy := make(net.IP, len(dst))
-					copy(dstCopy, dst)
-					setCopy := set
-
-					_ = q.SetVerdict(id, nfqueue.NfDrop)
-
-					go func(s *config.SetConfig, pkt []byte, d net.IP) {
-						if v == IPv4 {
-							w.dropAndInjectQUIC(s, pkt, d)
-						} else {
-							w.dropAndInjectQUICV6(s, pkt, d)
-						}
-					}(setCopy, packetCopy, dstCopy)
-					return 0
-
-				default:
-					_ = q.SetVerdict(id, nfqueue.NfAccept)
-					return 0
+			default:
+				if d.held != nil {
+					// Not targeted: put back the segments held for reassembly before this one
+					w.sendRaw(d.held, d.dst)
 				}
+				w.setVerdict(id, nfqueue.NfAccept)
 			}
-
-			_ = q.SetVerdict(id, nfqueue.NfAccept)
 			return 0
 		}, func(e error) int {
 			if w.ctx.Err() != nil {
@@ -431,6 +140,52 @@ func (w *Worker) Start() error {
 	return nil
 }
 
+// record captures, logs and counts a packet that went through matching,
+// and returns the flow recording to copy the injected packets to
+func (w *Worker) record(d *decision) *capture.FlowRecorder {
+	var rec *capture.FlowRecorder
+	if d.capture != "" {
+		if captureManager := capture.GetManager(d.cfg); captureManager != nil {
+			captureManager.CapturePayload(d.connKey, d.host, d.capture, d.payload)
+			if rec = captureManager.FlowRecorder(d.connKey); rec != nil {
+				rec.Original(d.held...)
+				rec.Original(d.original)
+			}
+		}
+	}
+
+	srcStr, dstStr := d.src.String(), d.dst.String()
+	if d.flow != "" && !log.IsDiscoveryActive() {
+		log.Infof(",%s,%s,%s,%s:%d,%s,%s:%d,%s", d.flow, d.sniTarget, d.host, srcStr, d.sport, d.ipTarget, dstStr, d.dport, w.getMacByIp(srcStr))
+	}
+	if d.kind == "" {
+		return rec
+	}
+
+	set := d.set
+	metrics.CountersFor(set.Id).RecordMatch(d.kind, len(d.raw))
+	m := metrics.GetMetricsCollector()
+	switch d.route {
+	case routeSynFake:
+		m.RecordConnection("TCP-SYN", "", srcStr, dstStr, true)
+		m.RecordInjection(set.Name, "syn-fake", "tcp")
+		return rec
+	case routeHTTP:
+		m.RecordSetMatch(set.Name, "http")
+		m.RecordInjection(set.Name, "http", "http")
+	case routeTCP:
+		m.RecordSetMatch(set.Name, "tcp")
+	default:
+		m.RecordSetMatch(set.Name, "udp")
+		if d.route == routeQUIC {
+			m.RecordInjection(set.Name, "quic-fake", "udp")
+		}
+	}
+	m.RecordConnection(d.flow, d.host, srcStr, dstStr, true)
+	m.RecordPacket(uint64(len(d.raw)))
+	return rec
+}
+
 func (w *Worker) dropAndInjectQUIC(cfg *config.SetConfig, raw []byte, dst net.IP) {
 	udpCfg := &cfg.UDP
 	seg2d := udpCfg.Seg2Delay
@@ -439,7 +194,7 @@ func (w *Worker) dropAndInjectQUIC(cfg *config.SetConfig, raw []byte, dst net.IP
 	}
 	if udpCfg.FakeSeqLength > 0 {
 		for i := 0; i < udpCfg.FakeSeqLength; i++ {
-			fake, ok := sock.BuildFakeUDPFromOriginalV4(raw, udpCfg.FakeLen, cfg.Faking.TTL)
+			fake, ok := buildFakeUDPV4(cfg, raw)
 			if ok {
 				if udpCfg.FakingStrategy == "checksum" {
 					ipHdrLen := int((fake[0] & 0x0F) * 4)
@@ -449,6 +204,7 @@ func (w *Worker) dropAndInjectQUIC(cfg *config.SetConfig, raw []byte, dst net.IP
 					}
 				}
 				_ = w.sock.SendIPv4(fake, dst)
+				metrics.CountersFor(cfg.Id).Fakes.Add(1)
 				if seg2d > 0 {
 					time.Sleep(time.Duration(seg2d) * time.Millisecond)
 				}
@@ -456,6 +212,10 @@ func (w *Worker) dropAndInjectQUIC(cfg *config.SetConfig, raw []byte, dst net.IP
 		}
 	}
 
+	if w.sendQUICSplitV4(cfg, raw, dst) {
+		return
+	}
+
 	// Try to locate SNI within encrypted QUIC payload
 	splitPos := 24 // fallback
 	ipHdrLen := int((raw[0] & 0x0F) * 4)
@@ -474,48 +234,31 @@ func (w *Worker) dropAndInjectQUIC(cfg *config.SetConfig, raw []byte, dst net.IP
 		return
 	}
 
-	w.SendTwoSegmentsV4(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.sendTwoSegments(IPv4Layer, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
 func (w *Worker) dropAndInjectTCP(cfg *config.SetConfig, raw []byte, dst net.IP) {
-
-	if len(raw) < 40 {
-		_ = w.sock.SendIPv4(raw, dst)
-		return
-	}
-
-	ipHdrLen := int((raw[0] & 0x0F) * 4)
-	tcpHdrLen := int((raw[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
-	payloadLen := len(raw) - payloadStart
-
-	if payloadLen <= 0 {
-		_ = w.sock.SendIPv4(raw, dst)
+	pi, ok := ExtractPacketInfo(raw)
+	if !ok || pi.PayloadLen <= 0 {
+		w.send(L3Of(raw), raw, dst)
 		return
 	}
 
-	if cfg.Faking.SNIMutation.Mode != config.ConfigOff {
-		raw = w.MutateClientHello(cfg, raw, dst)
-	}
-
-	if cfg.TCP.DesyncMode != config.ConfigOff {
-		w.ExecuteDesyncIPv4(cfg, raw, dst)
-		time.Sleep(time.Duration(cfg.TCP.Seg2Delay) * time.Millisecond)
-	}
-
-	if cfg.TCP.WinMode != config.ConfigOff {
-		w.ManipulateWindowIPv4(cfg, raw, dst)
-	}
-
-	if cfg.Faking.SNI && cfg.Faking.SNISeqLength > 0 {
-		w.sendFakeSNISequence(cfg, raw, dst)
-	}
+	w.runPipeline(cfg, raw, dst)
+}
 
-	switch cfg.Fragmentation.Strategy {
+// fragment sends raw split up by a fragmentation strategy
+func (w *Worker) fragment(cfg *config.SetConfig, strategy string, raw []byte, dst net.IP) {
+	metrics.CountersFor(cfg.Id).RecordStrategy(strategy)
+	switch strategy {
 	case "tcp":
 		w.sendTCPFragments(cfg, raw, dst)
 	case "ip":
-		w.sendIPFragments(cfg, raw, dst)
+		if L3Of(raw).IPv6() {
+			w.sendIPFragmentsv6(cfg, raw, dst)
+		} else {
+			w.sendIPFragments(cfg, raw, dst)
+		}
 	case "oob":
 		w.sendOOBFragments(cfg, raw, dst)
 	case "tls":
@@ -533,26 +276,17 @@ func (w *Worker) dropAndInjectTCP(cfg *config.SetConfig, raw []byte, dst net.IP)
 	case "hybrid":
 		w.sendHybridFragments(cfg, raw, dst)
 	case "none":
-		_ = w.sock.SendIPv4(raw, dst)
+		w.send(L3Of(raw), raw, dst)
 	default:
 		w.sendComboFragments(cfg, raw, dst)
 	}
 }
 
-func (w *Worker) sendTCPFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
-
-	seg2d := cfg.TCP.Seg2Delay
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	totalLen := len(packet)
-	payloadStart := ipHdrLen + tcpHdrLen
-	payloadLen := totalLen - payloadStart
-	if payloadLen <= 0 {
-		_ = w.sock.SendIPv4(packet, dst)
-		return
-	}
-
-	payload := packet[payloadStart:]
+// tcpSplitPoints returns the sorted payload offsets the tcp strategy cuts
+// at: fragmentation.sni_position and, with middle_sni, a point inside the
+// SNI. Without either it cuts after the first byte.
+func tcpSplitPoints(cfg *config.SetConfig, payload []byte) []int {
+	payloadLen := len(payload)
 	p1 := cfg.Fragmentation.SNIPosition
 	validP1 := p1 > 0 && p1 < payloadLen
 
@@ -580,95 +314,51 @@ func (w *Worker) sendTCPFragments(cfg *config.SetConfig, packet []byte, dst net.
 		validP1 = p1 < payloadLen
 	}
 
-	if validP1 && validP2 && p2 < p1 {
-		p1, p2 = p2, p1
+	switch {
+	case validP1 && validP2:
+		return []int{min(p1, p2), max(p1, p2)}
+	case validP1:
+		return []int{p1}
+	case validP2:
+		return []int{p2}
 	}
+	return nil
+}
 
-	if validP1 && validP2 {
-		seg1Len := payloadStart + p1
-		seg2Len := payloadStart + (p2 - p1)
-		seg3Len := payloadStart + (payloadLen - p2)
-
-		seg1 := make([]byte, seg1Len)
-		copy(seg1, packet[:seg1Len])
-
-		seg2 := make([]byte, seg2Len)
-		copy(seg2[:payloadStart], packet[:payloadStart])
-		copy(seg2[payloadStart:], payload[p1:p2])
-
-		seg3 := make([]byte, seg3Len)
-		copy(seg3[:payloadStart], packet[:payloadStart])
-		copy(seg3[payloadStart:], payload[p2:])
-
-		binary.BigEndian.PutUint16(seg1[2:4], uint16(seg1Len))
-		sock.FixIPv4Checksum(seg1[:ipHdrLen])
-		sock.FixTCPChecksum(seg1)
-
-		seq0 := binary.BigEndian.Uint32(packet[ipHdrLen+4 : ipHdrLen+8])
-		id0 := binary.BigEndian.Uint16(packet[4:6])
+func (w *Worker) sendTCPFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+	seg2d := cfg.TCP.Seg2Delay
+	pi, ok := ExtractPacketInfo(packet)
+	if !ok || pi.PayloadLen <= 0 {
+		w.send(L3Of(packet), packet, dst)
+		return
+	}
 
-		binary.BigEndian.PutUint32(seg2[ipHdrLen+4:ipHdrLen+8], seq0+uint32(p1))
-		binary.BigEndian.PutUint16(seg2[4:6], id0+1)
-		binary.BigEndian.PutUint16(seg2[2:4], uint16(seg2Len))
-		sock.FixIPv4Checksum(seg2[:ipHdrLen])
-		sock.FixTCPChecksum(seg2)
+	payload := pi.Payload
+	points := tcpSplitPoints(cfg, payload)
 
-		binary.BigEndian.PutUint32(seg3[ipHdrLen+4:ipHdrLen+8], seq0+uint32(p2))
-		binary.BigEndian.PutUint16(seg3[4:6], id0+2)
-		binary.BigEndian.PutUint16(seg3[2:4], uint16(seg3Len))
-		sock.FixIPv4Checksum(seg3[:ipHdrLen])
-		sock.FixTCPChecksum(seg3)
+	if len(points) == 2 {
+		p1, p2 := points[0], points[1]
+		seg1 := BuildSegment(packet, pi, payload[:p1], 0, 0)
+		seg2 := BuildSegment(packet, pi, payload[p1:p2], uint32(p1), 1)
+		seg3 := BuildSegment(packet, pi, payload[p2:], uint32(p2), 2)
 
 		if cfg.Fragmentation.ReverseOrder {
-			_ = w.sock.SendIPv4(seg2, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg1, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg3, dst)
+			w.sendPackets(pi.L3, [][]byte{seg2, seg1, seg3}, dst, seg2d)
 		} else {
-			_ = w.sock.SendIPv4(seg1, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg2, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg3, dst)
+			w.sendPackets(pi.L3, [][]byte{seg1, seg2, seg3}, dst, seg2d)
 		}
 		return
 	}
-
-	splitPos := p1
-	if !validP1 {
-		splitPos = p2
+	if len(points) == 0 {
+		w.send(pi.L3, packet, dst)
+		return
 	}
-	seg1Len := payloadStart + splitPos
-	seg1 := make([]byte, seg1Len)
-	copy(seg1, packet[:seg1Len])
-
-	seg2Len := payloadStart + (payloadLen - splitPos)
-	seg2 := make([]byte, seg2Len)
-	copy(seg2[:payloadStart], packet[:payloadStart])
-	copy(seg2[payloadStart:], packet[payloadStart+splitPos:])
-
-	binary.BigEndian.PutUint16(seg1[2:4], uint16(seg1Len))
-	sock.FixIPv4Checksum(seg1[:ipHdrLen])
-	sock.FixTCPChecksum(seg1)
 
-	seq := binary.BigEndian.Uint32(seg2[ipHdrLen+4 : ipHdrLen+8])
-	binary.BigEndian.PutUint32(seg2[ipHdrLen+4:ipHdrLen+8], seq+uint32(splitPos))
-	id := binary.BigEndian.Uint16(seg1[4:6])
-	binary.BigEndian.PutUint16(seg2[4:6], id+1)
-	binary.BigEndian.PutUint16(seg2[2:4], uint16(seg2Len))
-	sock.FixIPv4Checksum(seg2[:ipHdrLen])
-	sock.FixTCPChecksum(seg2)
+	splitPos := points[0]
+	seg1 := BuildSegment(packet, pi, payload[:splitPos], 0, 0)
+	seg2 := BuildSegment(packet, pi, payload[splitPos:], uint32(splitPos), 1)
 
-	w.SendTwoSegmentsV4(seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.sendTwoSegments(pi.L3, seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
 func (w *Worker) sendIPFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
@@ -744,7 +434,7 @@ func (w *Worker) sendIPFragments(cfg *config.SetConfig, packet []byte, dst net.I
 	binary.BigEndian.PutUint16(frag2[2:4], uint16(frag2Len))
 	sock.FixIPv4Checksum(frag2[:ipHdrLen])
 
-	w.SendTwoSegmentsV4(frag1, frag2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.sendTwoSegments(IPv4Layer, frag1, frag2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
 func (w *Worker) sendFakeSNISequence(cfg *config.SetConfig, original []byte, dst net.IP) {
@@ -752,27 +442,39 @@ func (w *Worker) sendFakeSNISequence(cfg *config.SetConfig, original []byte, dst
 	if !fk.SNI || fk.SNISeqLength <= 0 {
 		return
 	}
+	w.sendFakeSNIs(cfg, original, dst, fk.SNISeqLength)
+}
 
-	fake := sock.BuildFakeSNIPacketV4(original, cfg)
-	ipHdrLen := int((fake[0] & 0x0F) * 4)
-	tcpHdrLen := int((fake[ipHdrLen+12] >> 4) * 4)
+// sendFakeSNIs sends count fake ClientHellos built per cfg.Faking
+func (w *Worker) sendFakeSNIs(cfg *config.SetConfig, original []byte, dst net.IP, count int) {
+	fk := &cfg.Faking
+	l3 := L3Of(original)
+	var fake []byte
+	if l3.IPv6() {
+		fake = sock.BuildFakeSNIPacketV6(original, cfg)
+	} else {
+		fake = sock.BuildFakeSNIPacketV4(original, cfg)
+	}
+	pi, ok := ExtractPacketInfo(fake)
+	if !ok {
+		return
+	}
+	ipHdrLen := pi.IPHdrLen
 
-	for i := 0; i < fk.SNISeqLength; i++ {
-		_ = w.sock.SendIPv4(fake, dst)
+	metrics.CountersFor(cfg.Id).Fakes.Add(uint64(count))
+	for i := 0; i < count; i++ {
+		w.send(l3, fake, dst)
 
 		// Update for next iteration
-		if i+1 < fk.SNISeqLength {
+		if i+1 < count {
 			// Increment IP ID
-			id := binary.BigEndian.Uint16(fake[4:6])
-			binary.BigEndian.PutUint16(fake[4:6], id+1)
+			l3.SetID(fake, pi.ID0+uint16(i)+1)
 
 			// Adjust sequence number for non-past/rand strategies
 			if fk.Strategy != "pastseq" && fk.Strategy != "randseq" {
-				payloadLen := len(fake) - (ipHdrLen + tcpHdrLen)
 				seq := binary.BigEndian.Uint32(fake[ipHdrLen+4 : ipHdrLen+8])
-				binary.BigEndian.PutUint32(fake[ipHdrLen+4:ipHdrLen+8], seq+uint32(payloadLen))
-				sock.FixIPv4Checksum(fake[:ipHdrLen])
-				sock.FixTCPChecksum(fake)
+				binary.BigEndian.PutUint32(fake[ipHdrLen+4:ipHdrLen+8], seq+uint32(pi.PayloadLen))
+				l3.Finish(fake)
 			}
 		}
 	}
@@ -800,6 +502,7 @@ func (w *Worker) Stop() {
 	case <-time.After(2 * time.Second):
 	}
 	if w.sock != nil {
+		w.reasm.Flush()
 		w.sock.Close()
 	}
 }
@@ -827,3 +530,22 @@ func (w *Worker) gc(cfg *config.Config) {
 func (w *Worker) GetStats() (uint64, string) {
 	return atomic.LoadUint64(&w.packetsProcessed), "active"
 }
+
+// Queue returns the netfilter queue number of the worker
+func (w *Worker) Queue() uint16 {
+	return w.qnum
+}
+
+// GetVerdicts returns how many packets the worker accepted and dropped
+func (w *Worker) GetVerdicts() (accepted, dropped uint64) {
+	return atomic.LoadUint64(&w.accepted), atomic.LoadUint64(&w.dropped)
+}
+
+func (w *Worker) setVerdict(id uint32, verdict int) {
+	if verdict == nfqueue.NfDrop {
+		atomic.AddUint64(&w.dropped, 1)
+	} else {
+		atomic.AddUint64(&w.accepted, 1)
+	}
+	_ = w.q.SetVerdict(id, verdict)
+}
@@ -3,274 +3,113 @@ package nfq
 import (
 	"encoding/binary"
 	"net"
-	"time"
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/log"
-	"github.com/daniellavrushin/b4/sock"
 )
 
 func (w *Worker) sendOOBFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	ipHdrLen := int((packet[0] & 0x0F) * 4)
-	if len(packet) < ipHdrLen+20 {
-		_ = w.sock.SendIPv4(packet, dst)
+	pi, ok := ExtractPacketInfo(packet)
+	if !ok || pi.PayloadLen <= 0 {
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
-	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
-	payloadStart := ipHdrLen + tcpHdrLen
-	payloadLen := len(packet) - payloadStart
-
-	if payloadLen <= 0 {
-		_ = w.sock.SendIPv4(packet, dst)
-		return
-	}
-
-	// Determine split position
-	oobPos := cfg.Fragmentation.OOBPosition
-	if oobPos <= 0 {
-		oobPos = 1
-	}
-
-	// Handle middle SNI positioning
-	if cfg.Fragmentation.MiddleSNI {
-		if sniStart, sniEnd, ok := locateSNI(packet[payloadStart:]); ok && sniEnd > sniStart {
-			oobPos = sniStart + (sniEnd-sniStart)/2
-			log.Tracef("OOB: SNI at %d-%d, injecting at %d", sniStart, sniEnd, oobPos)
-		}
-	}
-
-	// Clamp to valid range
-	if oobPos >= payloadLen {
-		oobPos = payloadLen / 2
-	}
-	if oobPos <= 0 {
-		oobPos = 1
-	}
-
-	oobChar := cfg.Fragmentation.OOBChar
-	if oobChar == 0 {
-		oobChar = 'x'
-	}
-
-	seq := binary.BigEndian.Uint32(packet[ipHdrLen+4 : ipHdrLen+8])
-	id := binary.BigEndian.Uint16(packet[4:6])
-	payload := packet[payloadStart:]
+	ipHdrLen := pi.IPHdrLen
+	oobPos := oobPosition(cfg, pi)
+	payload := pi.Payload
 	seg2delay := cfg.TCP.Seg2Delay
 
-	log.Tracef("OOB: Injecting fake 0x%02x at pos %d of %d bytes", oobChar, oobPos, payloadLen)
-
 	// ===== Segment 1: payload[0:oobPos] - CLEAN, no OOB =====
-	seg1Len := payloadStart + oobPos
-	seg1 := make([]byte, seg1Len)
-	copy(seg1[:payloadStart], packet[:payloadStart])
-	copy(seg1[payloadStart:], payload[:oobPos])
-
-	binary.BigEndian.PutUint16(seg1[2:4], uint16(seg1Len))
-	sock.FixIPv4Checksum(seg1[:ipHdrLen])
-	sock.FixTCPChecksum(seg1)
+	seg1 := BuildSegment(packet, pi, payload[:oobPos], 0, 0)
 
 	// ===== Fake OOB packet: single byte with URG, LOW TTL =====
-	fakeLen := payloadStart + 1
-	fake := make([]byte, fakeLen)
-	copy(fake[:payloadStart], packet[:payloadStart])
-	fake[payloadStart] = oobChar
-
-	// Set sequence to where this byte would be
-	binary.BigEndian.PutUint32(fake[ipHdrLen+4:ipHdrLen+8], seq+uint32(oobPos))
-
-	// Set URG flag and urgent pointer
-	fake[ipHdrLen+13] |= 0x20                                    // URG flag
-	binary.BigEndian.PutUint16(fake[ipHdrLen+18:ipHdrLen+20], 1) // Urgent pointer = 1
-
-	// LOW TTL so it doesn't reach server, only DPI sees it
-	fake[8] = cfg.Faking.TTL
-	if fake[8] == 0 {
-		fake[8] = 3
-	}
-
-	// Update IP ID and length
-	binary.BigEndian.PutUint16(fake[4:6], id+1)
-	binary.BigEndian.PutUint16(fake[2:4], uint16(fakeLen))
-
-	sock.FixIPv4Checksum(fake[:ipHdrLen])
-	sock.FixTCPChecksum(fake)
-
-	// Optionally corrupt checksum based on faking strategy
-	switch cfg.Faking.Strategy {
-	case "tcp_check":
-		fake[ipHdrLen+16] ^= 0xFF
-		fake[ipHdrLen+17] ^= 0xFF
-	case "md5sum":
-		fake[ipHdrLen+16] ^= 0xFF
-		fake[10] ^= 0xFF
-	}
+	fake := buildOOBFake(cfg, packet, pi, oobPos, 1)
 
 	// ===== Segment 2: payload[oobPos:] - CLEAN =====
-	seg2DataLen := payloadLen - oobPos
-	seg2Len := payloadStart + seg2DataLen
-	seg2 := make([]byte, seg2Len)
-	copy(seg2[:payloadStart], packet[:payloadStart])
-	copy(seg2[payloadStart:], payload[oobPos:])
-
 	// Sequence continues from where seg1 ended (no gap for fake)
-	binary.BigEndian.PutUint32(seg2[ipHdrLen+4:ipHdrLen+8], seq+uint32(oobPos))
-	binary.BigEndian.PutUint16(seg2[4:6], id+2)
-	binary.BigEndian.PutUint16(seg2[2:4], uint16(seg2Len))
+	seg2 := BuildSegment(packet, pi, payload[oobPos:], uint32(oobPos), 2)
 
 	// Clear any URG flag that might have been copied
 	seg2[ipHdrLen+13] &^= 0x20
 	binary.BigEndian.PutUint16(seg2[ipHdrLen+18:ipHdrLen+20], 0)
-
-	sock.FixIPv4Checksum(seg2[:ipHdrLen])
-	sock.FixTCPChecksum(seg2)
+	pi.L3.FixTCPChecksum(seg2)
 
 	// ===== Send order =====
 	if cfg.Fragmentation.ReverseOrder {
 		// Reverse: seg2, fake, seg1
-		_ = w.sock.SendIPv4(seg2, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg1, dst)
+		w.sendPackets(pi.L3, [][]byte{seg2, fake, seg1}, dst, seg2delay)
 	} else {
 		// Normal: seg1, fake, seg2
-		_ = w.sock.SendIPv4(seg1, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg2, dst)
+		w.sendPackets(pi.L3, [][]byte{seg1, fake, seg2}, dst, seg2delay)
 	}
 
-	log.Tracef("OOB: Sent seg1=%d, fake=%d (TTL=%d), seg2=%d bytes", seg1Len, fakeLen, fake[8], seg2Len)
+	log.Tracef("OOB: Sent seg1=%d, fake=%d, seg2=%d bytes", len(seg1), len(fake), len(seg2))
 }
 
-// sendOOBFragmentsV6 is the IPv6 version of OOB injection
-func (w *Worker) sendOOBFragmentsV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	const ipv6HdrLen = 40
-
-	if len(packet) < ipv6HdrLen+20 {
-		_ = w.sock.SendIPv6(packet, dst)
-		return
-	}
-
-	tcpHdrLen := int((packet[ipv6HdrLen+12] >> 4) * 4)
-	payloadStart := ipv6HdrLen + tcpHdrLen
-	payloadLen := len(packet) - payloadStart
-
-	if payloadLen <= 0 {
-		_ = w.sock.SendIPv6(packet, dst)
-		return
-	}
-
+// oobPosition returns the payload offset the fake urgent byte goes to
+func oobPosition(cfg *config.SetConfig, pi PacketInfo) int {
 	oobPos := cfg.Fragmentation.OOBPosition
 	if oobPos <= 0 {
 		oobPos = 1
 	}
 
+	// Handle middle SNI positioning
 	if cfg.Fragmentation.MiddleSNI {
-		if sniStart, sniEnd, ok := locateSNI(packet[payloadStart:]); ok && sniEnd > sniStart {
+		if sniStart, sniEnd, ok := locateSNI(pi.Payload); ok && sniEnd > sniStart {
 			oobPos = sniStart + (sniEnd-sniStart)/2
-			log.Tracef("OOB v6: SNI at %d-%d, injecting at %d", sniStart, sniEnd, oobPos)
+			log.Tracef("OOB: SNI at %d-%d, injecting at %d", sniStart, sniEnd, oobPos)
 		}
 	}
 
-	if oobPos >= payloadLen {
-		oobPos = payloadLen / 2
+	// Clamp to valid range
+	if oobPos >= pi.PayloadLen {
+		oobPos = pi.PayloadLen / 2
 	}
 	if oobPos <= 0 {
 		oobPos = 1
 	}
+	return oobPos
+}
+
+// buildOOBFake builds the single urgent byte sent at payload offset pos,
+// with a low TTL so that only the DPI sees it
+func buildOOBFake(cfg *config.SetConfig, packet []byte, pi PacketInfo, pos int, id uint16) []byte {
+	ipHdrLen := pi.IPHdrLen
 
 	oobChar := cfg.Fragmentation.OOBChar
 	if oobChar == 0 {
 		oobChar = 'x'
 	}
+	log.Tracef("OOB: Injecting fake 0x%02x at pos %d of %d bytes", oobChar, pos, pi.PayloadLen)
 
-	seq := binary.BigEndian.Uint32(packet[ipv6HdrLen+4 : ipv6HdrLen+8])
-	payload := packet[payloadStart:]
-	seg2delay := cfg.TCP.Seg2Delay
-
-	log.Tracef("OOB v6: Injecting fake 0x%02x at pos %d of %d bytes", oobChar, oobPos, payloadLen)
-
-	// ===== Segment 1: payload[0:oobPos] - CLEAN =====
-	seg1Len := payloadStart + oobPos
-	seg1 := make([]byte, seg1Len)
-	copy(seg1[:payloadStart], packet[:payloadStart])
-	copy(seg1[payloadStart:], payload[:oobPos])
-
-	binary.BigEndian.PutUint16(seg1[4:6], uint16(seg1Len-ipv6HdrLen))
-	sock.FixTCPChecksumV6(seg1)
-
-	// ===== Fake OOB packet: single byte with URG, LOW HOP LIMIT =====
-	fakeLen := payloadStart + 1
-	fake := make([]byte, fakeLen)
-	copy(fake[:payloadStart], packet[:payloadStart])
-	fake[payloadStart] = oobChar
+	// Sequence is where this byte would be
+	fake := BuildSegment(packet, pi, []byte{oobChar}, uint32(pos), id)
 
-	binary.BigEndian.PutUint32(fake[ipv6HdrLen+4:ipv6HdrLen+8], seq+uint32(oobPos))
-	fake[ipv6HdrLen+13] |= 0x20
-	binary.BigEndian.PutUint16(fake[ipv6HdrLen+18:ipv6HdrLen+20], 1)
+	// Set URG flag and urgent pointer
+	fake[ipHdrLen+13] |= 0x20                                    // URG flag
+	binary.BigEndian.PutUint16(fake[ipHdrLen+18:ipHdrLen+20], 1) // Urgent pointer = 1
 
-	// Low hop limit (IPv6 equivalent of TTL)
-	fake[7] = cfg.Faking.TTL
-	if fake[7] == 0 {
-		fake[7] = 3
+	// LOW TTL so it doesn't reach server, only DPI sees it
+	ttl := cfg.Faking.TTL
+	if ttl == 0 {
+		ttl = 3
 	}
+	pi.L3.SetTTL(fake, ttl)
+	pi.L3.Finish(fake)
 
-	binary.BigEndian.PutUint16(fake[4:6], uint16(fakeLen-ipv6HdrLen))
-	sock.FixTCPChecksumV6(fake)
-
+	// Optionally corrupt checksum based on faking strategy
 	switch cfg.Faking.Strategy {
-	case "tcp_check", "md5sum":
-		fake[ipv6HdrLen+16] ^= 0xFF
-		fake[ipv6HdrLen+17] ^= 0xFF
-	}
-
-	// ===== Segment 2: payload[oobPos:] - CLEAN =====
-	seg2DataLen := payloadLen - oobPos
-	seg2Len := payloadStart + seg2DataLen
-	seg2 := make([]byte, seg2Len)
-	copy(seg2[:payloadStart], packet[:payloadStart])
-	copy(seg2[payloadStart:], payload[oobPos:])
-
-	binary.BigEndian.PutUint32(seg2[ipv6HdrLen+4:ipv6HdrLen+8], seq+uint32(oobPos))
-	binary.BigEndian.PutUint16(seg2[4:6], uint16(seg2Len-ipv6HdrLen))
-	seg2[ipv6HdrLen+13] &^= 0x20
-	binary.BigEndian.PutUint16(seg2[ipv6HdrLen+18:ipv6HdrLen+20], 0)
-
-	sock.FixTCPChecksumV6(seg2)
-
-	// ===== Send =====
-	if cfg.Fragmentation.ReverseOrder {
-		_ = w.sock.SendIPv6(seg2, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg1, dst)
-	} else {
-		_ = w.sock.SendIPv6(seg1, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
+	case "tcp_check":
+		fake[ipHdrLen+16] ^= 0xFF
+		fake[ipHdrLen+17] ^= 0xFF
+	case "md5sum":
+		fake[ipHdrLen+16] ^= 0xFF
+		if pi.L3.IPv6() {
+			fake[ipHdrLen+17] ^= 0xFF
+		} else {
+			fake[10] ^= 0xFF // IP header checksum
 		}
-		_ = w.sock.SendIPv6(seg2, dst)
 	}
-
-	log.Tracef("OOB v6: Sent seg1=%d, fake=%d (hop=%d), seg2=%d bytes", seg1Len, fakeLen, fake[7], seg2Len)
+	return fake
 }
@@ -6,14 +6,13 @@ import (
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
-	"github.com/daniellavrushin/b4/sock"
 )
 
 // sendOverlapFragments exploits TCP segment overlap behavior
 func (w *Worker) sendOverlapFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
-	pi, ok := ExtractPacketInfoV4(packet)
+	pi, ok := ExtractPacketInfo(packet)
 	if !ok || pi.PayloadLen < 20 {
-		_ = w.sock.SendIPv4(packet, dst)
+		w.send(L3Of(packet), packet, dst)
 		return
 	}
 
@@ -29,7 +28,7 @@ func (w *Worker) sendOverlapFragments(cfg *config.SetConfig, packet []byte, dst
 		overlapStart = 0
 	}
 
-	seg1 := BuildSegmentV4(packet, pi, pi.Payload[overlapStart:], uint32(overlapStart), 0)
+	seg1 := BuildSegment(packet, pi, pi.Payload[overlapStart:], uint32(overlapStart), 0)
 
 	// Segment 2: From start through SNI, with FAKE SNI (sent SECOND - DPI sees, server discards overlap)
 	seg2End := sniEnd + 4
@@ -37,7 +36,7 @@ func (w *Worker) sendOverlapFragments(cfg *config.SetConfig, packet []byte, dst
 		seg2End = pi.PayloadLen
 	}
 
-	seg2 := BuildSegmentV4(packet, pi, pi.Payload[:seg2End], 0, 1)
+	seg2 := BuildSegment(packet, pi, pi.Payload[:seg2End], 0, 1)
 
 	// Inject fake SNI
 	sniLen := sniEnd - sniStart
@@ -64,14 +63,14 @@ func (w *Worker) sendOverlapFragments(cfg *config.SetConfig, packet []byte, dst
 	copy(seg2[destStart:destEnd], fakeSNI[:sniLen])
 
 	ClearPSH(seg2, pi.IPHdrLen)
-	sock.FixTCPChecksum(seg2)
+	pi.L3.FixTCPChecksum(seg2)
 
 	delay := cfg.TCP.Seg2Delay
 
 	// REAL first (server keeps), then FAKE (DPI sees but server discards)
-	_ = w.sock.SendIPv4(seg1, dst)
+	w.send(pi.L3, seg1, dst)
 	if delay > 0 {
 		time.Sleep(time.Duration(delay) * time.Millisecond)
 	}
-	_ = w.sock.SendIPv4(seg2, dst)
+	w.send(pi.L3, seg2, dst)
 }
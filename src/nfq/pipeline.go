@@ -0,0 +1,252 @@
+package nfq
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+// span is a part of the payload that has not been sent yet
+type span struct {
+	start, end int
+}
+
+// pipelineRun holds the state of one packet going through a pipeline
+type pipelineRun struct {
+	w       *Worker
+	cfg     *config.SetConfig
+	dst     net.IP
+	packet  []byte
+	pi      PacketInfo
+	pending []span
+	cuts    []int // offsets the payload was cut at
+	fakes   []int // offsets of the urgent bytes sent, for the IP IDs
+}
+
+// pipelineFor returns the steps to run for cfg. Sets saved before pipelines
+// existed run the standard template.
+func pipelineFor(cfg *config.SetConfig) []config.PipelineStep {
+	if len(cfg.Pipeline) == 0 {
+		return config.PipelineTemplates["standard"]
+	}
+	return cfg.Pipeline
+}
+
+// runPipeline runs the steps of the set over a TCP packet carrying payload.
+// Segments still pending after the last step are sent in order.
+func (w *Worker) runPipeline(cfg *config.SetConfig, raw []byte, dst net.IP) {
+	r := &pipelineRun{w: w, cfg: cfg, dst: dst, packet: raw}
+	if !r.reset(raw) {
+		w.send(L3Of(raw), raw, dst)
+		return
+	}
+
+	for _, step := range pipelineFor(cfg) {
+		r.step(step)
+	}
+	r.send(len(r.pending), cfg.TCP.Seg2Delay)
+}
+
+// reset makes packet the only pending segment
+func (r *pipelineRun) reset(packet []byte) bool {
+	var ok bool
+	r.pi, ok = ExtractPacketInfo(packet)
+	if !ok || r.pi.PayloadLen <= 0 {
+		return false
+	}
+	r.packet = packet
+	r.pending = []span{{0, r.pi.PayloadLen}}
+	r.cuts, r.fakes = nil, nil
+	return true
+}
+
+// whole reports whether the packet has not been cut or sent in part
+func (r *pipelineRun) whole() bool {
+	return len(r.pending) == 1 && r.pending[0] == span{0, r.pi.PayloadLen}
+}
+
+func (r *pipelineRun) step(step config.PipelineStep) {
+	cfg := r.cfg
+	switch step.Type {
+	case config.StepMutate:
+		if cfg.Faking.SNIMutation.Mode == config.ConfigOff {
+			return
+		}
+		if !r.whole() {
+			log.Tracef("Pipeline: mutate after split or send skipped")
+			return
+		}
+		r.reset(r.w.MutateClientHello(cfg, r.packet, r.dst))
+		r.injected("mutate")
+
+	case config.StepDesync:
+		if cfg.TCP.DesyncMode == config.ConfigOff {
+			return
+		}
+		r.w.ExecuteDesync(cfg, r.packet, r.dst)
+		r.injected("desync-" + cfg.TCP.DesyncMode)
+		time.Sleep(time.Duration(cfg.TCP.Seg2Delay) * time.Millisecond)
+
+	case config.StepWindow:
+		if cfg.TCP.WinMode == config.ConfigOff {
+			return
+		}
+		r.w.ManipulateWindow(cfg, r.packet, r.dst)
+		r.injected("window-" + cfg.TCP.WinMode)
+
+	case config.StepFake:
+		if step.Count > 0 {
+			r.w.sendFakeSNIs(cfg, r.packet, r.dst, step.Count)
+		} else {
+			r.w.sendFakeSNISequence(cfg, r.packet, r.dst)
+		}
+		r.injected("fake")
+
+	case config.StepSplit:
+		for _, off := range r.splitOffsets(step.At) {
+			r.split(off)
+		}
+
+	case config.StepDisorder:
+		n := step.Count
+		if n <= 0 || n > len(r.pending) {
+			n = len(r.pending)
+		}
+		slices.Reverse(r.pending[:n])
+
+	case config.StepSend:
+		n := step.Count
+		if n <= 0 || n > len(r.pending) {
+			n = len(r.pending)
+		}
+		delay := step.DelayMs
+		if delay == 0 {
+			delay = cfg.TCP.Seg2Delay
+		}
+		r.send(n, delay)
+
+	case config.StepOOB:
+		if len(r.pending) == 0 {
+			return
+		}
+		off := r.pending[0].start
+		fake := buildOOBFake(cfg, r.packet, r.pi, off, r.id(off, true))
+		r.fakes = append(r.fakes, off)
+		r.w.send(r.pi.L3, fake, r.dst)
+		r.injected("oob")
+
+	case config.StepDelay:
+		time.Sleep(time.Duration(step.DelayMs) * time.Millisecond)
+
+	case config.StepFragment:
+		strategy := step.Strategy
+		if strategy == "" {
+			strategy = cfg.Fragmentation.Strategy
+		}
+		r.injected(strategy)
+		if r.whole() {
+			r.w.fragment(cfg, strategy, r.packet, r.dst)
+		} else {
+			for _, s := range r.pending {
+				r.w.fragment(cfg, strategy, r.segment(s), r.dst)
+			}
+		}
+		r.pending = nil
+	}
+}
+
+// injected counts a step that sent packets of its own
+func (r *pipelineRun) injected(strategy string) {
+	metrics.GetMetricsCollector().RecordInjection(r.cfg.Name, strategy, "tcp")
+}
+
+// splitOffsets resolves a split position to the payload offsets to cut at
+func (r *pipelineRun) splitOffsets(at string) []int {
+	payload := r.pi.Payload
+	switch at {
+	case "":
+		return tcpSplitPoints(r.cfg, payload)
+	case config.SplitAtOOB:
+		return []int{oobPosition(r.cfg, r.pi)}
+	case config.SplitAtSNI, config.SplitAtSNIMid, config.SplitAtSNIEnd:
+		s, e, ok := locateSNI(payload)
+		if !ok {
+			return nil
+		}
+		switch at {
+		case config.SplitAtSNI:
+			return []int{s}
+		case config.SplitAtSNIMid:
+			return []int{s + (e-s)/2}
+		}
+		return []int{e}
+	}
+	if n, err := strconv.Atoi(at); err == nil {
+		return []int{n}
+	}
+	return nil
+}
+
+// split cuts the pending segment that contains off
+func (r *pipelineRun) split(off int) {
+	for i, s := range r.pending {
+		if off <= s.start || off >= s.end {
+			continue
+		}
+		r.pending = append(r.pending[:i+1], r.pending[i:]...)
+		r.pending[i] = span{s.start, off}
+		r.pending[i+1] = span{off, s.end}
+		r.cuts = append(r.cuts, off)
+		return
+	}
+}
+
+// id returns the IP ID offset of the segment or urgent byte at payload
+// offset off. Like the fragmentation strategies, the IDs follow the payload
+// order whatever order the packets go out in; an urgent byte comes before
+// the segment starting at its offset.
+func (r *pipelineRun) id(off int, fake bool) uint16 {
+	var n uint16
+	if off > 0 {
+		n++
+	}
+	for _, c := range r.cuts {
+		if c < off {
+			n++
+		}
+	}
+	for _, f := range r.fakes {
+		if f < off || (f == off && !fake) {
+			n++
+		}
+	}
+	return n
+}
+
+// segment builds the packet carrying s
+func (r *pipelineRun) segment(s span) []byte {
+	if r.whole() {
+		return r.packet
+	}
+	payload := r.pi.Payload[s.start:s.end]
+	return BuildSegment(r.packet, r.pi, payload, uint32(s.start), r.id(s.start, false))
+}
+
+// send sends the first n pending segments with delay ms between them
+func (r *pipelineRun) send(n, delay int) {
+	if n == 0 {
+		return
+	}
+	packets := make([][]byte, n)
+	for i, s := range r.pending[:n] {
+		packets[i] = r.segment(s)
+	}
+	r.pending = r.pending[n:]
+
+	r.w.sendPackets(r.pi.L3, packets, r.dst, delay)
+}
@@ -0,0 +1,246 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestPipelineSplitAndDisorder(t *testing.T) {
+	set := config.NewSetConfig()
+	payload := buildHelloPayload("blocked.example.com", 16)
+	packet := buildTCPv4(1000, 0x18, payload)
+
+	r := &pipelineRun{cfg: &set}
+	if !r.reset(packet) {
+		t.Fatal("reset failed")
+	}
+	sniStart, sniEnd, ok := locateSNI(r.pi.Payload)
+	if !ok {
+		t.Fatal("SNI not found")
+	}
+	mid := sniStart + (sniEnd-sniStart)/2
+
+	r.step(config.PipelineStep{Type: config.StepSplit, At: config.SplitAtSNIMid})
+	r.step(config.PipelineStep{Type: config.StepSplit, At: "3"})
+	r.step(config.PipelineStep{Type: config.StepSplit, At: "3"}) // already cut there
+	r.step(config.PipelineStep{Type: config.StepDisorder})
+
+	want := []span{{mid, len(payload)}, {3, mid}, {0, 3}}
+	if len(r.pending) != len(want) {
+		t.Fatalf("pending %v, want %v", r.pending, want)
+	}
+	for i := range want {
+		if r.pending[i] != want[i] {
+			t.Fatalf("pending %v, want %v", r.pending, want)
+		}
+	}
+
+	for _, s := range r.pending {
+		seg := r.segment(s)
+		if seq := binary.BigEndian.Uint32(seg[24:28]); seq != 1000+uint32(s.start) {
+			t.Errorf("segment %v: seq %d", s, seq)
+		}
+		if got := int(binary.BigEndian.Uint16(seg[2:4])); got != len(seg) {
+			t.Errorf("segment %v: total length %d, have %d bytes", s, got, len(seg))
+		}
+		if !bytes.Equal(seg[40:], payload[s.start:s.end]) {
+			t.Errorf("segment %v carries the wrong bytes", s)
+		}
+	}
+}
+
+func TestPipelineSplitOffsets(t *testing.T) {
+	set := config.NewSetConfig()
+	set.Fragmentation.MiddleSNI = false
+	set.Fragmentation.SNIPosition = 0
+	set.Fragmentation.OOBPosition = 5
+	packet := buildTCPv4(1, 0x18, buildHelloPayload("example.org", 0))
+
+	r := &pipelineRun{cfg: &set}
+	r.reset(packet)
+	sniStart, sniEnd, _ := locateSNI(r.pi.Payload)
+	sniMid := sniStart + (sniEnd-sniStart)/2
+
+	cases := []struct {
+		at   string
+		want []int
+	}{
+		{config.SplitAtSNI, []int{sniStart}},
+		{config.SplitAtSNIEnd, []int{sniEnd}},
+		{config.SplitAtOOB, []int{5}},
+		{"7", []int{7}},
+		{"", []int{1}},
+	}
+	for _, tc := range cases {
+		if got := r.splitOffsets(tc.at); !slices.Equal(got, tc.want) {
+			t.Errorf("splitOffsets(%q) = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+
+	set.Fragmentation.MiddleSNI = true
+	if got := r.splitOffsets(config.SplitAtOOB); !slices.Equal(got, []int{sniMid}) {
+		t.Errorf("middle_sni oob split at %v", got)
+	}
+	set.Fragmentation.SNIPosition = 3
+	if got := r.splitOffsets(""); len(got) != 2 || got[0] != 3 || got[1] <= sniStart || got[1] >= sniEnd {
+		t.Errorf("middle_sni split at %v, want 3 and a point inside the SNI", got)
+	}
+
+	// Without a ClientHello the SNI positions do not resolve and nothing is cut
+	r.reset(buildTCPv4(1, 0x18, []byte("GET / HTTP/1.1\r\n\r\n")))
+	r.step(config.PipelineStep{Type: config.StepSplit, At: config.SplitAtSNIMid})
+	if !r.whole() {
+		t.Errorf("pending %v, want the whole payload", r.pending)
+	}
+}
+
+// sentBy returns the packets a worker sends for packet, running the steps of
+// set or, with strategy set, the fragmentation strategy on its own
+func sentBy(set *config.SetConfig, strategy string, packet []byte) [][]byte {
+	cfg := config.NewConfig()
+	sink := &recordSender{}
+	w := NewWorkerWithSink(&cfg, 0, sink)
+	dst := net.IPv4(203, 0, 113, 1)
+	if strategy != "" {
+		w.sendFakeSNISequence(set, packet, dst)
+		w.fragment(set, strategy, packet, dst)
+	} else {
+		w.runPipeline(set, packet, dst)
+	}
+	return sink.packets
+}
+
+// TestPipelineTemplatesMatchStrategies checks that the step templates send
+// the packets of the fragmentation strategy they spell out
+func TestPipelineTemplatesMatchStrategies(t *testing.T) {
+	packet := buildTCPv4(1000, 0x18, buildHelloPayload("blocked.example.com", 16))
+
+	cases := []struct {
+		name      string
+		strategy  string
+		template  string
+		configure func(*config.FragmentationConfig)
+	}{
+		{"tcp reverse middle", "tcp", "fake-split-reverse", func(f *config.FragmentationConfig) {}},
+		{"tcp middle", "tcp", "fake-split", func(f *config.FragmentationConfig) { f.ReverseOrder = false }},
+		{"tcp reverse position", "tcp", "fake-split-reverse", func(f *config.FragmentationConfig) { f.MiddleSNI = false; f.SNIPosition = 5 }},
+		{"tcp first byte", "tcp", "fake-split", func(f *config.FragmentationConfig) { f.MiddleSNI = false; f.SNIPosition = 0; f.ReverseOrder = false }},
+		{"oob", "oob", "oob", func(f *config.FragmentationConfig) { f.ReverseOrder = false; f.MiddleSNI = false; f.OOBPosition = 3 }},
+		{"oob middle", "oob", "oob", func(f *config.FragmentationConfig) { f.ReverseOrder = false }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			set := config.NewSetConfig()
+			set.Fragmentation.Strategy = tc.strategy
+			tc.configure(&set.Fragmentation)
+			set.Pipeline = config.PipelineTemplate(tc.template)
+
+			want := sentBy(&set, tc.strategy, packet)
+			got := sentBy(&set, "", packet)
+			if len(got) != len(want) {
+				t.Fatalf("pipeline sent %d packets, strategy %d", len(got), len(want))
+			}
+			for i := range want {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Errorf("packet %d differs:\n got % x\nwant % x", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestMigratedPipelineFollowsStrategy checks that a set saved before
+// pipelines existed still sends what its fragmentation settings say after
+// they are edited
+func TestMigratedPipelineFollowsStrategy(t *testing.T) {
+	packet := buildTCPv4(1000, 0x18, buildHelloPayload("blocked.example.com", 16))
+
+	old := config.NewConfig()
+	set := config.NewSetConfig()
+	set.Fragmentation.Strategy = "tcp"
+	set.Fragmentation.ReverseOrder = true
+	set.Pipeline = nil
+	old.Sets = []*config.SetConfig{&set}
+	old.Version = 18
+	data, err := json.Marshal(&old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig()
+	if err := cfg.LoadWithMigration(path); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	migrated := cfg.Sets[0]
+	if len(migrated.Pipeline) == 0 {
+		t.Fatal("migration left the pipeline empty")
+	}
+
+	before := sentBy(migrated, "", packet)
+	migrated.Fragmentation.Strategy = "oob"
+	migrated.Fragmentation.ReverseOrder = false
+	after := sentBy(migrated, "", packet)
+	if slices.EqualFunc(before, after, bytes.Equal) {
+		t.Error("changing the strategy of a migrated set did not change the segments sent")
+	}
+	if want := sentBy(migrated, "oob", packet); !slices.EqualFunc(after, want, bytes.Equal) {
+		t.Errorf("migrated set sent %d packets, the oob strategy %d", len(after), len(want))
+	}
+}
+
+func TestPipelineDisorderTemplate(t *testing.T) {
+	packet := buildTCPv4(1000, 0x18, buildHelloPayload("blocked.example.com", 16))
+	set := config.NewSetConfig()
+	set.Faking.SNI = false
+	set.Fragmentation.Disorder.ShuffleMode = "reverse"
+	set.Fragmentation.Disorder.MinJitterUs = 0
+	set.Fragmentation.Disorder.MaxJitterUs = 0
+	set.Pipeline = config.PipelineTemplate("disorder")
+
+	// The disorder strategy also moves PSH to the last segment, so compare
+	// what each packet carries and where
+	type piece struct {
+		seq     uint32
+		payload string
+	}
+	pieces := func(packets [][]byte) []piece {
+		var out []piece
+		for _, p := range packets {
+			pi, ok := ExtractPacketInfo(p)
+			if !ok {
+				t.Fatalf("bad packet % x", p)
+			}
+			out = append(out, piece{pi.Seq0, string(pi.Payload)})
+		}
+		return out
+	}
+
+	want := pieces(sentBy(&set, "disorder", packet))
+	got := pieces(sentBy(&set, "", packet))
+	if !slices.Equal(got, want) {
+		t.Errorf("pipeline sent %v, strategy %v", got, want)
+	}
+	if len(got) < 3 {
+		t.Errorf("expected the SNI cut out, got %d segments", len(got))
+	}
+}
+
+func TestPipelineFor(t *testing.T) {
+	set := config.NewSetConfig()
+	set.Pipeline = nil
+	if got := pipelineFor(&set); len(got) != len(config.PipelineTemplates["standard"]) {
+		t.Errorf("empty pipeline should run the standard template, got %v", got)
+	}
+}
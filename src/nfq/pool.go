@@ -2,26 +2,37 @@ package nfq
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/dhcp"
 	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
 	"github.com/daniellavrushin/b4/sni"
 )
 
 func NewWorkerWithQueue(cfg *config.Config, qnum uint16) *Worker {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	w := &Worker{
+	w := &Worker{queueState: &queueState{
 		qnum:   qnum,
 		ctx:    ctx,
 		cancel: cancel,
-	}
+	}}
 
 	w.cfg.Store(cfg)
+	w.reasm = newTCPReassembler(reasmMaxFlows, reasmMaxBytes, reasmTTL, w.sendRaw)
+
+	return w
+}
 
+// NewWorkerWithSink returns a worker that injects into sink instead of the
+// raw socket sender Start would open
+func NewWorkerWithSink(cfg *config.Config, qnum uint16, sink PacketSink) *Worker {
+	w := NewWorkerWithQueue(cfg, qnum)
+	w.sock = sink
 	return w
 }
 
@@ -133,16 +144,54 @@ func buildMatcher(cfg *config.Config) *sni.SuffixSet {
 func (p *Pool) UpdateConfig(newCfg *config.Config) error {
 	p.configMu.Lock()
 	defer p.configMu.Unlock()
+	return p.updateConfig(newCfg)
+}
+
+// ModifyConfig pushes the config update derives from the running one. No
+// other update can land in between, so update never works on a stale
+// config.
+func (p *Pool) ModifyConfig(update func(cur *config.Config) (*config.Config, error)) error {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	if len(p.Workers) == 0 {
+		return fmt.Errorf("no workers to update")
+	}
+	newCfg, err := update(p.Workers[0].getConfig())
+	if err != nil {
+		return err
+	}
+	return p.updateConfig(newCfg)
+}
 
+// updateConfig switches the workers to newCfg, configMu must be held
+func (p *Pool) updateConfig(newCfg *config.Config) error {
 	matcher := buildMatcher(newCfg)
 
+	var old *sni.SuffixSet
+	if len(p.Workers) > 0 {
+		old = p.Workers[0].getMatcher()
+	}
 	for _, w := range p.Workers {
 		w.cfg.Store(newCfg)
 		w.matcher.Store(matcher)
 	}
+	retireMatcher(old)
+
+	for _, cb := range p.onConfigUpdate {
+		cb(newCfg)
+	}
 	return nil
 }
 
+// OnConfigUpdate registers a callback run after the workers switched to a
+// new config
+func (p *Pool) OnConfigUpdate(cb func(*config.Config)) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.onConfigUpdate = append(p.onConfigUpdate, cb)
+}
+
 func (p *Pool) GetFirstWorkerConfig() *config.Config {
 	if len(p.Workers) == 0 {
 		return nil
@@ -150,7 +199,23 @@ func (p *Pool) GetFirstWorkerConfig() *config.Config {
 	return p.Workers[0].getConfig()
 }
 
+// retireMatcher keeps the cache lookups of a replaced matcher, so the
+// exported counters do not start over on every config update
+func retireMatcher(m *sni.SuffixSet) {
+	stats := m.GetCacheStats()
+	for _, cache := range []string{"ip", "domain", "regex"} {
+		hits, _ := stats[cache+"_cache_hits"].(uint64)
+		misses, _ := stats[cache+"_cache_misses"].(uint64)
+		metrics.RetireMatcherCache(cache, hits, misses)
+	}
+}
+
 func (w *Worker) GetCacheStats() map[string]interface{} {
 	matcher := w.getMatcher()
 	return matcher.GetCacheStats()
 }
+
+// Matcher returns the matcher the worker currently uses
+func (w *Worker) Matcher() *sni.SuffixSet {
+	return w.getMatcher()
+}
@@ -0,0 +1,33 @@
+package nfq
+
+import (
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+func TestUpdateConfigKeepsCacheCounts(t *testing.T) {
+	cfg := config.NewConfig()
+	set := config.NewSetConfig()
+	set.Targets.DomainsToMatch = []string{"example.com"}
+	cfg.Sets = []*config.SetConfig{&set}
+	pool := &Pool{Workers: []*Worker{NewWorkerWithQueue(&cfg, 0)}}
+	pool.Workers[0].matcher.Store(buildMatcher(&cfg))
+
+	hits, misses := metrics.MatcherCacheTotals("domain", 0, 0)
+	m := pool.Workers[0].Matcher()
+	m.MatchSNI("www.example.com") // miss
+	m.MatchSNI("www.example.com") // hit
+
+	if err := pool.UpdateConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Workers[0].Matcher() == m {
+		t.Fatal("matcher not rebuilt")
+	}
+	gotHits, gotMisses := metrics.MatcherCacheTotals("domain", 0, 0)
+	if gotHits-hits != 1 || gotMisses-misses != 1 {
+		t.Errorf("replaced matcher left %d hits and %d misses, want 1 and 1", gotHits-hits, gotMisses-misses)
+	}
+}
@@ -0,0 +1,46 @@
+package nfq
+
+import (
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+// probeState is the config applied to discovery probe flows while a
+// discovery run tests a preset
+type probeState struct {
+	cfg     *config.Config
+	matcher *sni.SuffixSet
+}
+
+// SetProbeConfig applies cfg to discovery probe flows only. Every other flow
+// keeps being handled with the config set by UpdateConfig.
+func (p *Pool) SetProbeConfig(cfg *config.Config) error {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	probe := &probeState{cfg: cfg, matcher: buildMatcher(cfg)}
+	for _, w := range p.Workers {
+		w.probe.Store(probe)
+	}
+	return nil
+}
+
+// ClearProbeConfig makes probe flows use the regular config again
+func (p *Pool) ClearProbeConfig() {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	for _, w := range p.Workers {
+		w.probe.Store((*probeState)(nil))
+	}
+}
+
+// probeFor returns the probe config when the packet carries the mark of a
+// discovery probe socket
+func (w *Worker) probeFor(mark uint32) *probeState {
+	if mark&config.ProbeMark == 0 {
+		return nil
+	}
+	probe, _ := w.probe.Load().(*probeState)
+	return probe
+}
@@ -0,0 +1,80 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestProbeFor(t *testing.T) {
+	cfg := config.NewConfig()
+	pool := &Pool{Workers: []*Worker{NewWorkerWithQueue(&cfg, 0)}}
+	w := pool.Workers[0]
+
+	probeSet := config.NewSetConfig()
+	probeSet.Name = "probe"
+	probeCfg := &config.Config{Queue: cfg.Queue, MainSet: &probeSet, Sets: []*config.SetConfig{&probeSet}}
+
+	if w.probeFor(config.ProbeMark) != nil {
+		t.Fatal("probe config returned before one was set")
+	}
+
+	if err := pool.SetProbeConfig(probeCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("probe flows", func(t *testing.T) {
+		for _, mark := range []uint32{config.ProbeMark, config.ProbeMark | 0x1} {
+			if p := w.probeFor(mark); p == nil || p.cfg != probeCfg {
+				t.Errorf("mark 0x%x: expected probe config", mark)
+			}
+		}
+	})
+
+	t.Run("other flows", func(t *testing.T) {
+		if w.probeFor(0) != nil {
+			t.Error("unmarked packet treated as probe")
+		}
+		if w.probeFor(0x8000) != nil {
+			t.Error("packet with another mark treated as probe")
+		}
+		if w.getConfig() != &cfg {
+			t.Error("regular config changed by SetProbeConfig")
+		}
+	})
+
+	pool.ClearProbeConfig()
+	if w.probeFor(config.ProbeMark) != nil {
+		t.Error("probe config still applied after ClearProbeConfig")
+	}
+}
+
+func TestDecideProbe(t *testing.T) {
+	cfg := config.NewConfig()
+	pool := &Pool{Workers: []*Worker{NewWorkerWithQueue(&cfg, 0)}}
+	w := pool.Workers[0]
+	w.matcher.Store(buildMatcher(&cfg))
+
+	probeSet := config.NewSetConfig()
+	probeSet.Name = "probe"
+	probeSet.Targets.DomainsToMatch = []string{"blocked.example.com"}
+	probeCfg := &config.Config{Queue: cfg.Queue, MainSet: &probeSet, Sets: []*config.SetConfig{&probeSet}}
+	if err := pool.SetProbeConfig(probeCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	hello := func(sport uint16) []byte {
+		raw := buildTCPv4(1000, 0x18, buildHelloPayload("blocked.example.com", 16))
+		binary.BigEndian.PutUint16(raw[20:22], sport)
+		return raw
+	}
+
+	if d := w.decide(hello(config.ProbePortMin), config.ProbeMark); d.route != routeTCP || d.set != &probeSet {
+		t.Errorf("probe flow: route %d, want the probe set's TCP pipeline", d.route)
+	}
+	// A forwarded client that happens to use a port of the probe range
+	if d := w.decide(hello(config.ProbePortMin), 0); d.route != routeAccept {
+		t.Errorf("unmarked flow from a probe port: route %d, want it let through", d.route)
+	}
+}
@@ -0,0 +1,84 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/quic"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// fakeQUICPayload returns an encrypted Initial with a decoy ClientHello to
+// send as a fake before the datagram carrying payload, or nil when the set
+// sends zero-filled fakes
+func fakeQUICPayload(cfg *config.UDPConfig, payload []byte) []byte {
+	if !cfg.FakeQUIC {
+		return nil
+	}
+	version := quic.VersionV1
+	if quic.IsInitial(payload) {
+		version = binary.BigEndian.Uint32(payload[1:5])
+	}
+	fake, err := quic.FakeInitial(version, cfg.FakeSNI, cfg.FakeLen)
+	if err != nil {
+		log.Tracef("Fake QUIC Initial for %s failed: %v", cfg.FakeSNI, err)
+		return nil
+	}
+	return fake
+}
+
+// buildFakeUDPV4 builds one fake datagram to send before raw
+func buildFakeUDPV4(cfg *config.SetConfig, raw []byte) ([]byte, bool) {
+	ipHdrLen := int((raw[0] & 0x0F) * 4)
+	if len(raw) >= ipHdrLen+8 {
+		if initial := fakeQUICPayload(&cfg.UDP, raw[ipHdrLen+8:]); initial != nil {
+			return sock.BuildFakeUDPWithPayloadV4(raw, initial, cfg.Faking.TTL)
+		}
+	}
+	return sock.BuildFakeUDPFromOriginalV4(raw, cfg.UDP.FakeLen, cfg.Faking.TTL)
+}
+
+// quicSplitPayloads re-encrypts a client Initial as set by mode. It returns
+// nil when the mode is off or the payload is not a client Initial carrying
+// CRYPTO data.
+func quicSplitPayloads(mode string, payload []byte) [][]byte {
+	if mode != "reorder" {
+		return nil
+	}
+	if packet, ok := quic.ReorderInitial(payload); ok {
+		return [][]byte{packet}
+	}
+	return nil
+}
+
+// sendQUICSplitV4 sends the Initial in raw re-encrypted as set by
+// cfg.UDP.QUICSplit and reports whether it did
+func (w *Worker) sendQUICSplitV4(cfg *config.SetConfig, raw []byte, dst net.IP) bool {
+	ipHdrLen := int((raw[0] & 0x0F) * 4)
+	if len(raw) < ipHdrLen+8 {
+		return false
+	}
+
+	payloads := quicSplitPayloads(cfg.UDP.QUICSplit, raw[ipHdrLen+8:])
+	if len(payloads) == 0 {
+		return false
+	}
+
+	packets := make([][]byte, 0, len(payloads))
+	for _, p := range payloads {
+		pkt, ok := sock.BuildUDPFromOriginalV4(raw, p)
+		if !ok {
+			return false
+		}
+		packets = append(packets, pkt)
+	}
+
+	if len(packets) == 1 {
+		_ = w.sock.SendIPv4(packets[0], dst)
+		return true
+	}
+	w.sendTwoSegments(IPv4Layer, packets[0], packets[1], dst, cfg.UDP.Seg2Delay, cfg.Fragmentation.ReverseOrder)
+	return true
+}
@@ -0,0 +1,49 @@
+package nfq
+
+import (
+	"net"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// buildFakeUDPV6 builds one fake datagram to send before raw
+func buildFakeUDPV6(cfg *config.SetConfig, raw []byte) ([]byte, bool) {
+	ipv6HdrLen := 40
+	if len(raw) >= ipv6HdrLen+8 {
+		if initial := fakeQUICPayload(&cfg.UDP, raw[ipv6HdrLen+8:]); initial != nil {
+			return sock.BuildFakeUDPWithPayloadV6(raw, initial, cfg.Faking.TTL)
+		}
+	}
+	return sock.BuildFakeUDPFromOriginalV6(raw, cfg.UDP.FakeLen, cfg.Faking.TTL)
+}
+
+// sendQUICSplitV6 sends the Initial in raw re-encrypted as set by
+// cfg.UDP.QUICSplit and reports whether it did
+func (w *Worker) sendQUICSplitV6(cfg *config.SetConfig, raw []byte, dst net.IP) bool {
+	ipv6HdrLen := 40
+	if len(raw) < ipv6HdrLen+8 {
+		return false
+	}
+
+	payloads := quicSplitPayloads(cfg.UDP.QUICSplit, raw[ipv6HdrLen+8:])
+	if len(payloads) == 0 {
+		return false
+	}
+
+	packets := make([][]byte, 0, len(payloads))
+	for _, p := range payloads {
+		pkt, ok := sock.BuildUDPFromOriginalV6(raw, p)
+		if !ok {
+			return false
+		}
+		packets = append(packets, pkt)
+	}
+
+	if len(packets) == 1 {
+		_ = w.sock.SendIPv6(packets[0], dst)
+		return true
+	}
+	w.sendTwoSegments(IPv6Layer, packets[0], packets[1], dst, cfg.UDP.Seg2Delay, cfg.Fragmentation.ReverseOrder)
+	return true
+}
@@ -0,0 +1,197 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+const (
+	reasmMaxFlows = 1024
+	reasmMaxBytes = 5 + 16384 // largest TLS record
+	reasmTTL      = 2 * time.Second
+)
+
+type reasmResult int
+
+const (
+	reasmPass     reasmResult = iota // not a split ClientHello, process the packet as is
+	reasmHeld                        // segment buffered, caller must drop it
+	reasmComplete                    // ClientHello fully reassembled
+)
+
+type reasmFlow struct {
+	dst          net.IP
+	packets      [][]byte
+	payload      []byte
+	ipHdrLen     int
+	payloadStart int
+	nextSeq      uint32
+	need         int
+	timer        *time.Timer
+}
+
+// tcpReassembler holds the first segments of a TLS ClientHello that spans
+// several TCP segments (e.g. post-quantum key shares) until the whole record
+// is present, so SNI matching and the strategies see the complete hello.
+type tcpReassembler struct {
+	mu       sync.Mutex
+	flows    map[string]*reasmFlow
+	maxFlows int
+	maxBytes int
+	ttl      time.Duration
+	release  func(packets [][]byte, dst net.IP)
+}
+
+func newTCPReassembler(maxFlows, maxBytes int, ttl time.Duration, release func([][]byte, net.IP)) *tcpReassembler {
+	return &tcpReassembler{
+		flows:    make(map[string]*reasmFlow),
+		maxFlows: maxFlows,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		release:  release,
+	}
+}
+
+// clientHelloRecordLen returns the full length of the TLS record carrying a
+// ClientHello that starts at the beginning of payload, or 0 if payload does
+// not start one.
+func clientHelloRecordLen(payload []byte) int {
+	if len(payload) < 6 || payload[0] != TLSHandshakeType || payload[1] != 0x03 || payload[5] != TLSClientHello {
+		return 0
+	}
+	return 5 + int(binary.BigEndian.Uint16(payload[3:5]))
+}
+
+// Push feeds a TCP segment of the flow identified by key. raw is the full IP
+// packet, ipHdrLen the offset of the TCP header and payloadStart the offset
+// of the TCP payload.
+//
+// On reasmComplete it returns a single packet carrying the whole ClientHello,
+// built from the headers of the first segment, along with the original
+// segments that were held (the current one excluded).
+func (r *tcpReassembler) Push(key string, raw []byte, ipHdrLen, payloadStart int, dst net.IP) (reasmResult, []byte, [][]byte) {
+	seq := binary.BigEndian.Uint32(raw[ipHdrLen+4 : ipHdrLen+8])
+	payload := raw[payloadStart:]
+
+	r.mu.Lock()
+	flow, exists := r.flows[key]
+
+	if !exists {
+		need := clientHelloRecordLen(payload)
+		if need <= len(payload) || need > r.maxBytes || len(r.flows) >= r.maxFlows {
+			r.mu.Unlock()
+			return reasmPass, nil, nil
+		}
+
+		d := make(net.IP, len(dst))
+		copy(d, dst)
+
+		flow = &reasmFlow{
+			dst:          d,
+			packets:      [][]byte{append([]byte(nil), raw...)},
+			payload:      append(make([]byte, 0, need), payload...),
+			ipHdrLen:     ipHdrLen,
+			payloadStart: payloadStart,
+			nextSeq:      seq + uint32(len(payload)),
+			need:         need,
+		}
+		flow.timer = time.AfterFunc(r.ttl, func() { r.expire(key, flow) })
+		r.flows[key] = flow
+		r.mu.Unlock()
+
+		log.Tracef("Reassembly: holding %d/%d bytes of ClientHello for %s", len(payload), need, key)
+		return reasmHeld, nil, nil
+	}
+
+	// Retransmission of data we already hold
+	if int32(seq+uint32(len(payload))-flow.nextSeq) <= 0 {
+		r.mu.Unlock()
+		return reasmHeld, nil, nil
+	}
+
+	if seq != flow.nextSeq || len(flow.payload)+len(payload) > r.maxBytes {
+		// Out of order or oversized: give up and let the original segments through
+		r.remove(key, flow)
+		r.mu.Unlock()
+
+		log.Tracef("Reassembly: giving up on %s (seq=%d, expected=%d)", key, seq, flow.nextSeq)
+		r.release(flow.packets, flow.dst)
+		return reasmPass, nil, nil
+	}
+
+	flow.payload = append(flow.payload, payload...)
+	flow.nextSeq += uint32(len(payload))
+
+	if len(flow.payload) < flow.need {
+		flow.packets = append(flow.packets, append([]byte(nil), raw...))
+		r.mu.Unlock()
+		return reasmHeld, nil, nil
+	}
+
+	r.remove(key, flow)
+	r.mu.Unlock()
+
+	combined := buildReassembledPacket(flow.packets[0], flow.ipHdrLen, flow.payloadStart, flow.payload, raw[ipHdrLen+13])
+
+	log.Tracef("Reassembly: ClientHello for %s complete, %d bytes from %d segments",
+		key, len(flow.payload), len(flow.packets)+1)
+	return reasmComplete, combined, flow.packets
+}
+
+// remove must be called with r.mu held
+func (r *tcpReassembler) remove(key string, flow *reasmFlow) {
+	flow.timer.Stop()
+	if cur, ok := r.flows[key]; ok && cur == flow {
+		delete(r.flows, key)
+	}
+}
+
+func (r *tcpReassembler) expire(key string, flow *reasmFlow) {
+	r.mu.Lock()
+	cur, ok := r.flows[key]
+	if !ok || cur != flow {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.flows, key)
+	r.mu.Unlock()
+
+	log.Tracef("Reassembly: timed out waiting for ClientHello of %s, releasing %d segments", key, len(flow.packets))
+	r.release(flow.packets, flow.dst)
+}
+
+// Flush releases every held segment, used on shutdown
+func (r *tcpReassembler) Flush() {
+	r.mu.Lock()
+	flows := r.flows
+	r.flows = make(map[string]*reasmFlow)
+	r.mu.Unlock()
+
+	for _, flow := range flows {
+		flow.timer.Stop()
+		r.release(flow.packets, flow.dst)
+	}
+}
+
+// sendRaw re-injects original packets unchanged
+func (w *Worker) sendRaw(packets [][]byte, dst net.IP) {
+	for _, pkt := range packets {
+		w.send(L3Of(pkt), pkt, dst)
+	}
+}
+
+// buildReassembledPacket returns a copy of first with its payload replaced by
+// payload. TCP flags are taken from the last segment so PSH is preserved.
+func buildReassembledPacket(first []byte, ipHdrLen, payloadStart int, payload []byte, lastFlags byte) []byte {
+	pkt := make([]byte, payloadStart+len(payload))
+	copy(pkt, first[:payloadStart])
+	copy(pkt[payloadStart:], payload)
+	pkt[ipHdrLen+13] = lastFlags
+
+	L3Of(pkt).Finish(pkt)
+	return pkt
+}
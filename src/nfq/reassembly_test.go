@@ -0,0 +1,154 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/sni"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+func buildHelloPayload(host string, padding int) []byte {
+	sniExt := []byte{0x00, 0x00}
+	sniExt = binary.BigEndian.AppendUint16(sniExt, uint16(len(host)+5))
+	sniExt = binary.BigEndian.AppendUint16(sniExt, uint16(len(host)+3))
+	sniExt = append(sniExt, 0x00)
+	sniExt = binary.BigEndian.AppendUint16(sniExt, uint16(len(host)))
+	sniExt = append(sniExt, host...)
+
+	padExt := []byte{0x00, 0x15}
+	padExt = binary.BigEndian.AppendUint16(padExt, uint16(padding))
+	padExt = append(padExt, make([]byte, padding)...)
+
+	exts := append(padExt, sniExt...)
+
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0x00, 0x00, 0x02, 0x13, 0x01, 0x01, 0x00)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(exts)))
+	body = append(body, exts...)
+
+	hs := []byte{TLSClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	hs = append(hs, body...)
+
+	rec := []byte{TLSHandshakeType, 0x03, 0x01}
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(hs)))
+	return append(rec, hs...)
+}
+
+func buildTCPv4(seq uint32, flags byte, payload []byte) []byte {
+	pkt := make([]byte, 40+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = 64
+	pkt[9] = 6
+	copy(pkt[12:16], []byte{192, 168, 1, 2})
+	copy(pkt[16:20], []byte{203, 0, 113, 1})
+	binary.BigEndian.PutUint16(pkt[20:22], 40000)
+	binary.BigEndian.PutUint16(pkt[22:24], HTTPSPort)
+	binary.BigEndian.PutUint32(pkt[24:28], seq)
+	pkt[32] = 0x50
+	pkt[33] = flags
+	copy(pkt[40:], payload)
+	sock.FixIPv4Checksum(pkt[:20])
+	sock.FixTCPChecksum(pkt)
+	return pkt
+}
+
+func TestReassembler_TwoSegments(t *testing.T) {
+	hello := buildHelloPayload("www.example.com", 1800)
+	split := 1200
+	dst := net.IPv4(203, 0, 113, 1)
+
+	var released [][]byte
+	r := newTCPReassembler(16, reasmMaxBytes, time.Minute, func(p [][]byte, _ net.IP) { released = append(released, p...) })
+
+	seg1 := buildTCPv4(1000, 0x10, hello[:split])
+	seg2 := buildTCPv4(1000+uint32(split), 0x18, hello[split:])
+
+	if res, _, _ := r.Push("k", seg1, 20, 40, dst); res != reasmHeld {
+		t.Fatalf("first segment: got %v, want reasmHeld", res)
+	}
+	if res, _, _ := r.Push("k", seg1, 20, 40, dst); res != reasmHeld {
+		t.Fatalf("retransmission: got %v, want reasmHeld", res)
+	}
+
+	res, full, held := r.Push("k", seg2, 20, 40, dst)
+	if res != reasmComplete {
+		t.Fatalf("second segment: got %v, want reasmComplete", res)
+	}
+	if len(held) != 1 || !bytes.Equal(held[0], seg1) {
+		t.Error("held segments should contain the original first segment")
+	}
+	if !bytes.Equal(full[40:], hello) {
+		t.Error("reassembled payload differs from the ClientHello")
+	}
+	if int(binary.BigEndian.Uint16(full[2:4])) != len(full) {
+		t.Error("reassembled packet has wrong IP total length")
+	}
+	if full[33]&0x08 == 0 {
+		t.Error("reassembled packet should carry PSH from the last segment")
+	}
+	if host, ok := sni.ParseTLSClientHelloSNI(full[40:]); !ok || host != "www.example.com" {
+		t.Errorf("SNI = %q, want www.example.com", host)
+	}
+	if len(released) != 0 {
+		t.Error("nothing should be released on completion")
+	}
+}
+
+func TestReassembler_PassThrough(t *testing.T) {
+	r := newTCPReassembler(16, reasmMaxBytes, time.Minute, func([][]byte, net.IP) {})
+	dst := net.IPv4(203, 0, 113, 1)
+
+	small := buildTCPv4(1000, 0x18, buildHelloPayload("a.example.com", 10))
+	if res, _, _ := r.Push("k", small, 20, 40, dst); res != reasmPass {
+		t.Errorf("complete hello: got %v, want reasmPass", res)
+	}
+
+	data := buildTCPv4(1000, 0x18, []byte("GET / HTTP/1.1\r\n\r\n"))
+	if res, _, _ := r.Push("k", data, 20, 40, dst); res != reasmPass {
+		t.Errorf("non-TLS payload: got %v, want reasmPass", res)
+	}
+}
+
+func TestReassembler_OutOfOrderReleases(t *testing.T) {
+	hello := buildHelloPayload("www.example.com", 1800)
+	dst := net.IPv4(203, 0, 113, 1)
+
+	var released [][]byte
+	r := newTCPReassembler(16, reasmMaxBytes, time.Minute, func(p [][]byte, _ net.IP) { released = append(released, p...) })
+
+	seg1 := buildTCPv4(1000, 0x10, hello[:1000])
+	_, _, _ = r.Push("k", seg1, 20, 40, dst)
+
+	gap := buildTCPv4(1000+1200, 0x18, hello[1200:])
+	if res, _, _ := r.Push("k", gap, 20, 40, dst); res != reasmPass {
+		t.Fatalf("out of order segment: got %v, want reasmPass", res)
+	}
+	if len(released) != 1 || !bytes.Equal(released[0], seg1) {
+		t.Error("held segment should be released when giving up")
+	}
+}
+
+func TestReassembler_Timeout(t *testing.T) {
+	hello := buildHelloPayload("www.example.com", 1800)
+	dst := net.IPv4(203, 0, 113, 1)
+
+	done := make(chan [][]byte, 1)
+	r := newTCPReassembler(16, reasmMaxBytes, 10*time.Millisecond, func(p [][]byte, _ net.IP) { done <- p })
+
+	_, _, _ = r.Push("k", buildTCPv4(1000, 0x10, hello[:1000]), 20, 40, dst)
+
+	select {
+	case p := <-done:
+		if len(p) != 1 {
+			t.Errorf("expected 1 released segment, got %d", len(p))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("held segment was not released after TTL")
+	}
+}
@@ -0,0 +1,78 @@
+package nfq
+
+import (
+	"net"
+
+	"github.com/daniellavrushin/b4/capture"
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+// recordingSink passes packets on to the worker's sink and copies them to a
+// flow recording
+type recordingSink struct {
+	PacketSink
+	rec *capture.FlowRecorder
+}
+
+func (s recordingSink) SendIPv4(packet []byte, dst net.IP) error {
+	s.rec.Emitted(packet)
+	return s.PacketSink.SendIPv4(packet, dst)
+}
+
+func (s recordingSink) SendIPv6(packet []byte, dst net.IP) error {
+	s.rec.Emitted(packet)
+	return s.PacketSink.SendIPv6(packet, dst)
+}
+
+func (s recordingSink) SendBatchIPv4(packets [][]byte, dst net.IP) error {
+	s.rec.Emitted(packets...)
+	return s.PacketSink.SendBatchIPv4(packets, dst)
+}
+
+func (s recordingSink) SendBatchIPv6(packets [][]byte, dst net.IP) error {
+	s.rec.Emitted(packets...)
+	return s.PacketSink.SendBatchIPv6(packets, dst)
+}
+
+// countingSink passes packets on and counts them as injected for a set
+type countingSink struct {
+	PacketSink
+	c *metrics.SetCounters
+}
+
+func (s countingSink) SendIPv4(packet []byte, dst net.IP) error {
+	s.c.Injected.Add(1)
+	return s.PacketSink.SendIPv4(packet, dst)
+}
+
+func (s countingSink) SendIPv6(packet []byte, dst net.IP) error {
+	s.c.Injected.Add(1)
+	return s.PacketSink.SendIPv6(packet, dst)
+}
+
+func (s countingSink) SendBatchIPv4(packets [][]byte, dst net.IP) error {
+	s.c.Injected.Add(uint64(len(packets)))
+	return s.PacketSink.SendBatchIPv4(packets, dst)
+}
+
+func (s countingSink) SendBatchIPv6(packets [][]byte, dst net.IP) error {
+	s.c.Injected.Add(uint64(len(packets)))
+	return s.PacketSink.SendBatchIPv6(packets, dst)
+}
+
+// forFlow returns a worker that sends like w for a flow matched by set. It
+// counts what it sends in the set's counters and, when rec is not nil,
+// copies it to the flow recording. Workers without a recording are kept
+// per set, so a matched packet only costs a map lookup.
+func (w *Worker) forFlow(set *config.SetConfig, rec *capture.FlowRecorder) *Worker {
+	fw, ok := w.flows.Load(set.Id)
+	if !ok {
+		sink := countingSink{PacketSink: w.sock, c: metrics.CountersFor(set.Id)}
+		fw, _ = w.flows.LoadOrStore(set.Id, &Worker{queueState: w.queueState, sock: sink})
+	}
+	if rec == nil {
+		return fw.(*Worker)
+	}
+	return &Worker{queueState: w.queueState, sock: recordingSink{PacketSink: fw.(*Worker).sock, rec: rec}}
+}
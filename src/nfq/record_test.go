@@ -0,0 +1,38 @@
+package nfq
+
+import (
+	"net"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+func TestForFlow(t *testing.T) {
+	cfg := config.NewConfig()
+	sink := &recordSender{}
+	w := NewWorkerWithSink(&cfg, 0, sink)
+
+	set := config.NewSetConfig()
+	set.Id = "for-flow-test"
+	fw := w.forFlow(&set, nil)
+	if again := w.forFlow(&set, nil); again != fw {
+		t.Error("a new flow worker was made for the same set")
+	}
+
+	// Flow workers share the queue state, probe config included
+	probe := &probeState{cfg: &cfg}
+	w.probe.Store(probe)
+	if got, _ := fw.probe.Load().(*probeState); got != probe {
+		t.Error("flow worker does not see the probe config")
+	}
+
+	before := metrics.CountersFor(set.Id).Injected.Load()
+	fw.send(IPv4Layer, buildTCPv4(1, 0x10, nil), net.IPv4(203, 0, 113, 1))
+	if len(sink.packets) != 1 {
+		t.Fatalf("sink got %d packets", len(sink.packets))
+	}
+	if got := metrics.CountersFor(set.Id).Injected.Load() - before; got != 1 {
+		t.Errorf("counted %d injected packets", got)
+	}
+}
@@ -0,0 +1,267 @@
+package nfq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sni"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Simulation runs recorded packets through one set as if it targeted every
+// destination, without a queue or sockets. Packets go through the same
+// matching as on a live queue, so the exclusions and port filters of the set
+// still apply. Everything the worker would inject, including the packets it
+// lets through unchanged, goes to the sink.
+type Simulation struct {
+	w *Worker
+}
+
+func NewSimulation(set *config.SetConfig, sink PacketSink) *Simulation {
+	// The matcher skips disabled sets, but a simulated set runs regardless
+	s := *set
+	s.Enabled = true
+	s.Targets.IpsToMatch = append(slices.Clone(s.Targets.IpsToMatch), "0.0.0.0/0", "::/0")
+
+	cfg := config.NewConfig()
+	cfg.MainSet = &s
+	cfg.Sets = []*config.SetConfig{&s}
+
+	w := NewWorkerWithSink(&cfg, 0, sink)
+	w.matcher.Store(sni.NewSuffixSet(cfg.Sets))
+	return &Simulation{w: w}
+}
+
+// Run processes one IPv4 or IPv6 packet. It returns once everything the set
+// sends for it, delays included, has reached the sink.
+func (s *Simulation) Run(raw []byte) {
+	d := s.w.decide(raw, 0)
+	switch d.route {
+	case routeHeld, routeDrop:
+		return
+	case routeSynFake, routeTCP, routeHTTP, routeQUIC:
+		d.detach()
+		s.w.inject(&d)
+		return
+	}
+
+	if d.dst == nil {
+		return
+	}
+	if d.held != nil {
+		s.w.sendRaw(d.held, d.dst)
+	}
+	s.w.send(L3Of(raw), raw, d.dst)
+}
+
+// Flush releases the segments still held for ClientHello reassembly
+func (s *Simulation) Flush() {
+	s.w.reasm.Flush()
+}
+
+// SimulateResult counts the packets of a pcap simulation
+type SimulateResult struct {
+	Read    int `json:"read"`
+	Skipped int `json:"skipped"`
+	Written int `json:"written"`
+}
+
+// SimulatePcap runs every IP packet of a pcap or pcapng capture through set
+// and writes what B4 would send as a raw IP pcap. Output timestamps start at
+// the capture time of the packet that produced them and include the delays
+// the strategies wait between packets.
+func SimulatePcap(set *config.SetConfig, in io.Reader, out io.Writer) (SimulateResult, error) {
+	var res SimulateResult
+
+	r, err := newPcapReader(in)
+	if err != nil {
+		return res, err
+	}
+
+	pw := pcapgo.NewWriter(out)
+	if err := pw.WriteFileHeader(0xffff, layers.LinkTypeRaw); err != nil {
+		return res, err
+	}
+	sink := &pcapSink{w: pw}
+	sim := NewSimulation(set, sink)
+
+	for {
+		data, ci, err := r.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		res.Read++
+
+		raw := ipPacket(r.LinkType(), data)
+		if raw == nil {
+			res.Skipped++
+			continue
+		}
+
+		sink.begin(ci.Timestamp)
+		sim.Run(raw)
+	}
+	sim.Flush()
+
+	res.Written = sink.count()
+	return res, sink.error()
+}
+
+type pcapPacketReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// newPcapReader opens a pcap or, by its magic number, a pcapng capture
+func newPcapReader(in io.Reader) (pcapPacketReader, error) {
+	br := bufio.NewReader(in)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("read capture header: %w", err)
+	}
+	if bytes.Equal(magic, []byte{0x0a, 0x0d, 0x0d, 0x0a}) {
+		r, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	r, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ipPacket strips the link layer of a captured frame and trims it to the
+// length in its IP header. It returns nil for anything but IPv4 and IPv6.
+func ipPacket(lt layers.LinkType, data []byte) []byte {
+	var etherType uint16
+	switch lt {
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		if len(data) < 4 {
+			return nil
+		}
+		data = data[4:]
+	case layers.LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType = binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+	case layers.LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		etherType = binary.BigEndian.Uint16(data[14:16])
+		data = data[16:]
+	default:
+		return nil
+	}
+	if etherType != 0 && etherType != 0x0800 && etherType != 0x86dd {
+		return nil
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	var n int
+	switch data[0] >> 4 {
+	case IPv4:
+		if len(data) < 20 {
+			return nil
+		}
+		n = int(binary.BigEndian.Uint16(data[2:4]))
+	case IPv6:
+		if len(data) < IPv6HeaderLen {
+			return nil
+		}
+		n = IPv6HeaderLen + int(binary.BigEndian.Uint16(data[4:6]))
+	default:
+		return nil
+	}
+	if n < 20 || n > len(data) {
+		return nil
+	}
+	return append([]byte(nil), data[:n]...)
+}
+
+// pcapSink writes the packets a simulation sends to a pcap
+type pcapSink struct {
+	mu      sync.Mutex
+	w       *pcapgo.Writer
+	base    time.Time
+	started time.Time
+	last    time.Time
+	n       int
+	err     error
+}
+
+// begin stamps the packets that follow relative to ts, the capture time of
+// the packet being simulated
+func (p *pcapSink) begin(ts time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.base = ts
+	p.started = time.Now()
+}
+
+func (p *pcapSink) write(packets ...[]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pkt := range packets {
+		ts := p.base.Add(time.Since(p.started))
+		if !ts.After(p.last) {
+			ts = p.last.Add(time.Microsecond)
+		}
+		p.last = ts
+
+		err := p.w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     ts,
+			CaptureLength: len(pkt),
+			Length:        len(pkt),
+		}, pkt)
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		p.n++
+	}
+	return p.err
+}
+
+func (p *pcapSink) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.n
+}
+
+func (p *pcapSink) error() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *pcapSink) SendIPv4(packet []byte, _ net.IP) error         { return p.write(packet) }
+func (p *pcapSink) SendIPv6(packet []byte, _ net.IP) error         { return p.write(packet) }
+func (p *pcapSink) SendBatchIPv4(packets [][]byte, _ net.IP) error { return p.write(packets...) }
+func (p *pcapSink) SendBatchIPv6(packets [][]byte, _ net.IP) error { return p.write(packets...) }
+func (p *pcapSink) Close()                                         {}
@@ -0,0 +1,187 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sock"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func buildUDPv4(dport uint16, payload []byte) []byte {
+	pkt := make([]byte, 28+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = 64
+	pkt[9] = 17
+	copy(pkt[12:16], []byte{192, 168, 1, 2})
+	copy(pkt[16:20], []byte{203, 0, 113, 1})
+	binary.BigEndian.PutUint16(pkt[20:22], 50000)
+	binary.BigEndian.PutUint16(pkt[22:24], dport)
+	binary.BigEndian.PutUint16(pkt[24:26], uint16(8+len(payload)))
+	copy(pkt[28:], payload)
+	sock.FixIPv4Checksum(pkt[:20])
+	sock.FixUDPChecksum(pkt, 20)
+	return pkt
+}
+
+func TestSimulatePcap(t *testing.T) {
+	hello := buildHelloPayload("blocked.example.com", 64)
+	syn := buildTCPv4(999, 0x02, nil)
+	data := buildTCPv4(1000, 0x18, hello)
+	// The set targets every destination, but excludes the one of the UDP packet
+	udp := buildUDPv4(5353, []byte("not a target"))
+	copy(udp[16:20], []byte{198, 51, 100, 7})
+	sock.FixIPv4Checksum(udp[:20])
+	sock.FixUDPChecksum(udp, 20)
+
+	// Ethernet input, with the SYN padded to the minimum frame size
+	var in bytes.Buffer
+	pw := pcapgo.NewWriter(&in)
+	if err := pw.WriteFileHeader(0xffff, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 0)
+	for i, ip := range [][]byte{syn, data, udp} {
+		frame := make([]byte, 14, 14+len(ip)+6)
+		binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+		frame = append(frame, ip...)
+		if len(frame) < 60 {
+			frame = append(frame, make([]byte, 60-len(frame))...)
+		}
+		ci := gopacket.CaptureInfo{Timestamp: ts.Add(time.Duration(i) * time.Millisecond), CaptureLength: len(frame), Length: len(frame)}
+		if err := pw.WritePacket(ci, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set := config.NewSetConfig()
+	set.Enabled = false
+	set.Faking.SNI = false
+	set.Fragmentation.Strategy = "tcp"
+	set.TCP.Seg2Delay = 0
+	set.Targets.IpsToExclude = []string{"198.51.100.0/24"}
+
+	var out bytes.Buffer
+	res, err := SimulatePcap(&set, &in, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Read != 3 || res.Skipped != 0 {
+		t.Fatalf("result %+v", res)
+	}
+
+	r, err := pcapgo.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LinkType() != layers.LinkTypeRaw {
+		t.Fatalf("link type %v", r.LinkType())
+	}
+	var got [][]byte
+	var last time.Time
+	for {
+		p, ci, err := r.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ci.Timestamp.Before(ts) || ci.Timestamp.Before(last) {
+			t.Errorf("packet %d: timestamp %v out of order", len(got), ci.Timestamp)
+		}
+		last = ci.Timestamp
+		got = append(got, p)
+	}
+	if len(got) != res.Written {
+		t.Fatalf("read back %d packets, result says %d", len(got), res.Written)
+	}
+	if len(got) < 4 {
+		t.Fatalf("wrote %d packets, want the SYN, two or more segments and the UDP packet", len(got))
+	}
+
+	if !bytes.Equal(got[0], syn) {
+		t.Errorf("SYN not passed through unchanged: % x", got[0])
+	}
+	if !bytes.Equal(got[len(got)-1], udp) {
+		t.Errorf("UDP packet not passed through unchanged")
+	}
+
+	// The segments carry the ClientHello between them
+	var rebuilt []byte
+	for _, seg := range got[1 : len(got)-1] {
+		pi, ok := ExtractPacketInfo(seg)
+		if !ok {
+			t.Fatal("segment does not parse")
+		}
+		if !tcpChecksumOK(seg) {
+			t.Errorf("segment at seq %d: bad TCP checksum", binary.BigEndian.Uint32(seg[24:28]))
+		}
+		off := int(binary.BigEndian.Uint32(seg[24:28]) - 1000)
+		if need := off + pi.PayloadLen; need > len(rebuilt) {
+			rebuilt = append(rebuilt, make([]byte, need-len(rebuilt))...)
+		}
+		copy(rebuilt[off:], pi.Payload)
+	}
+	if !bytes.Equal(rebuilt, hello) {
+		t.Error("segments do not add up to the ClientHello")
+	}
+}
+
+func TestSimulateExcludedHost(t *testing.T) {
+	set := config.NewSetConfig()
+	set.Faking.SNI = false
+	set.Targets.DomainsToExclude = []string{"allowed.example.com"}
+
+	sink := &recordSender{}
+	sim := NewSimulation(&set, sink)
+
+	excluded := buildTCPv4(1000, 0x18, buildHelloPayload("allowed.example.com", 16))
+	sim.Run(excluded)
+	if len(sink.packets) != 1 || !bytes.Equal(sink.packets[0], excluded) {
+		t.Fatalf("excluded host: sent %d packets, want the ClientHello unchanged", len(sink.packets))
+	}
+
+	sink.packets = nil
+	sim.Run(buildTCPv4(5000, 0x18, buildHelloPayload("blocked.example.com", 16)))
+	if len(sink.packets) < 2 {
+		t.Fatalf("targeted host: sent %d packets, want the ClientHello split", len(sink.packets))
+	}
+}
+
+func TestIPPacketLinkTypes(t *testing.T) {
+	ip := buildTCPv4(1, 0x10, nil)
+
+	sll := make([]byte, 16, 16+len(ip))
+	binary.BigEndian.PutUint16(sll[14:16], 0x0800)
+	vlan := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x81, 0x00, 0x00, 0x05, 0x08, 0x00}
+	arp := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x08, 0x06}
+
+	cases := []struct {
+		name string
+		lt   layers.LinkType
+		data []byte
+		want []byte
+	}{
+		{"raw", layers.LinkTypeRaw, ip, ip},
+		{"sll", layers.LinkTypeLinuxSLL, append(sll, ip...), ip},
+		{"vlan", layers.LinkTypeEthernet, append(vlan, ip...), ip},
+		{"arp", layers.LinkTypeEthernet, append(arp, make([]byte, 28)...), nil},
+		{"truncated", layers.LinkTypeRaw, ip[:30], nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ipPacket(tc.lt, tc.data); !bytes.Equal(got, tc.want) {
+				t.Errorf("ipPacket = % x, want % x", got, tc.want)
+			}
+		})
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/dhcp"
-	"github.com/daniellavrushin/b4/sock"
 	"github.com/florianl/go-nfqueue"
 )
 
@@ -16,9 +16,10 @@ type Segment struct {
 }
 
 type Pool struct {
-	Workers  []*Worker
-	configMu sync.Mutex
-	Dhcp     *dhcp.Manager
+	Workers        []*Worker
+	configMu       sync.Mutex
+	Dhcp           *dhcp.Manager
+	onConfigUpdate []func(*config.Config)
 }
 
 type PacketInfo struct {
@@ -30,10 +31,21 @@ type PacketInfo struct {
 	Seq0         uint32
 	ID0          uint16
 	IsIPv6       bool
+	L3           L3
 }
 
+// Worker handles one queue. The workers forFlow returns for it share its
+// state and only send through a sink of their own.
 type Worker struct {
+	*queueState
+	sock PacketSink
+}
+
+// queueState is the state of a queue, shared by its worker and flow workers
+type queueState struct {
 	packetsProcessed uint64
+	accepted         uint64
+	dropped          uint64
 	cfg              atomic.Value
 	qnum             uint16
 	ctx              context.Context
@@ -41,6 +53,8 @@ type Worker struct {
 	q                *nfqueue.Nfqueue
 	wg               sync.WaitGroup
 	matcher          atomic.Value
-	sock             *sock.Sender
+	probe            atomic.Value // *probeState
 	ipToMac          atomic.Value
+	reasm            *tcpReassembler
+	flows            sync.Map // set ID -> *Worker, see forFlow
 }
@@ -0,0 +1,204 @@
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Transport parameter IDs sent by the client (RFC 9000 §18.2)
+const (
+	tpMaxIdleTimeout        = 0x01
+	tpInitialMaxData        = 0x04
+	tpInitialMaxStreamBidi  = 0x05
+	tpInitialMaxStreamsBidi = 0x08
+	tpInitialSourceConnID   = 0x0f
+)
+
+// initialRetransmit is how long the probe waits for the server before sending
+// its Initial again
+const initialRetransmit = time.Second
+
+// HandshakeResult describes how the server answered a probe Initial
+type HandshakeResult struct {
+	RTT   time.Duration // time until the ServerHello or Retry
+	Retry bool          // the server asked for address validation
+}
+
+func newConnID(n int) []byte {
+	id := make([]byte, n)
+	_, _ = rand.Read(id)
+	return id
+}
+
+func appendTransportParam(b []byte, id uint64, value []byte) []byte {
+	b = appendVarint(b, id)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendTransportParamInt(b []byte, id, value uint64) []byte {
+	return appendTransportParam(b, id, appendVarint(nil, value))
+}
+
+func clientTransportParams(scid []byte) []byte {
+	var p []byte
+	p = appendTransportParamInt(p, tpMaxIdleTimeout, 10000)
+	p = appendTransportParamInt(p, tpInitialMaxData, 1<<20)
+	p = appendTransportParamInt(p, tpInitialMaxStreamBidi, 1<<18)
+	p = appendTransportParamInt(p, tpInitialMaxStreamsBidi, 100)
+	p = appendTransportParam(p, tpInitialSourceConnID, scid)
+	return p
+}
+
+// startClient starts a TLS 1.3 client for serverName over QUIC and returns
+// the ClientHello it wants to send in the Initial packet
+func startClient(ctx context.Context, serverName string, scid []byte) (*tls.QUICConn, []byte, error) {
+	qc := tls.QUICClient(&tls.QUICConfig{
+		TLSConfig: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h3"},
+			MinVersion:         tls.VersionTLS13,
+			// Without the post-quantum key share the ClientHello fits in a
+			// single Initial
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		},
+	})
+	qc.SetTransportParameters(clientTransportParams(scid))
+	if err := qc.Start(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var hello []byte
+	for {
+		e := qc.NextEvent()
+		if e.Kind == tls.QUICNoEvent {
+			break
+		}
+		if e.Kind == tls.QUICWriteData && e.Level == tls.QUICEncryptionLevelInitial {
+			hello = append(hello, e.Data...)
+		}
+	}
+	if len(hello) == 0 {
+		qc.Close()
+		return nil, nil, errors.New("no ClientHello produced")
+	}
+	return qc, hello, nil
+}
+
+// ClientHello returns a ClientHello for serverName as a browser would put in
+// the CRYPTO frames of its first Initial
+func ClientHello(serverName string) ([]byte, error) {
+	qc, hello, err := startClient(context.Background(), serverName, newConnID(8))
+	if err != nil {
+		return nil, err
+	}
+	qc.Close()
+	return hello, nil
+}
+
+// Handshake sends a real client Initial for serverName over conn and waits
+// until the server answers with a ServerHello that the TLS stack accepts.
+// The Initial is sent again every second until ctx is done.
+func Handshake(ctx context.Context, conn net.Conn, serverName string) (HandshakeResult, error) {
+	var res HandshakeResult
+
+	dcid, scid := newConnID(8), newConnID(8)
+	qc, hello, err := startClient(ctx, serverName, scid)
+	if err != nil {
+		return res, err
+	}
+	defer qc.Close()
+
+	var pn uint32
+	send := func() error {
+		packet, err := SealInitial(InitialHeader{Version: versionV1, DCID: dcid, SCID: scid, PN: pn},
+			AppendCryptoFrame(nil, 0, hello), MinInitialSize)
+		if err != nil {
+			return err
+		}
+		pn++
+		_, err = conn.Write(packet)
+		return err
+	}
+
+	start := time.Now()
+	if err := send(); err != nil {
+		return res, err
+	}
+
+	var crypto []CryptoFrame
+	buf := make([]byte, 65535)
+	for {
+		deadline := time.Now().Add(initialRetransmit)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = conn.SetReadDeadline(deadline)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+				if err := send(); err != nil {
+					return res, err
+				}
+				continue
+			}
+			if ctx.Err() != nil {
+				return res, fmt.Errorf("no handshake response: %w", ctx.Err())
+			}
+			return res, err
+		}
+		packet := buf[:n]
+
+		switch {
+		case IsVersionNegotiation(packet):
+			return res, errors.New("server does not support QUIC v1")
+		case IsRetry(packet):
+			res.RTT, res.Retry = time.Since(start), true
+			return res, nil
+		case !IsInitial(packet):
+			continue
+		}
+
+		plain, ok := DecryptServerInitial(dcid, packet)
+		if !ok {
+			continue
+		}
+		frames, ok := ParseInitialFrames(plain)
+		if !ok {
+			return res, errors.New("malformed server Initial")
+		}
+		if frames.Closed {
+			return res, fmt.Errorf("connection closed by server: code 0x%x %s", frames.CloseCode, frames.Reason)
+		}
+
+		had := len(CryptoStream(crypto))
+		for _, f := range frames.Crypto {
+			// buf is reused by the next read
+			crypto = append(crypto, CryptoFrame{Offset: f.Offset, Data: append([]byte(nil), f.Data...)})
+		}
+		data := CryptoStream(crypto)
+		if len(data) == had {
+			continue
+		}
+		if err := qc.HandleData(tls.QUICEncryptionLevelInitial, data[had:]); err != nil {
+			return res, fmt.Errorf("invalid ServerHello: %w", err)
+		}
+		for {
+			e := qc.NextEvent()
+			if e.Kind == tls.QUICNoEvent {
+				break
+			}
+			if e.Kind == tls.QUICSetReadSecret && e.Level == tls.QUICEncryptionLevelHandshake {
+				res.RTT = time.Since(start)
+				return res, nil
+			}
+		}
+	}
+}
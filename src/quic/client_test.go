@@ -0,0 +1,201 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveInitial answers the first client Initial received on pc with the
+// server's Initial, as a QUIC server would
+func serveInitial(t *testing.T, pc net.PacketConn, cert tls.Certificate, gotSNI chan<- string) {
+	buf := make([]byte, 65535)
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		return
+	}
+	packet := buf[:n]
+	if !IsInitial(packet) || n < MinInitialSize {
+		t.Errorf("client sent an invalid Initial (%d bytes)", n)
+		return
+	}
+
+	dcid := ParseDCID(packet)
+	clientSCID := packet[7+len(dcid) : 7+len(dcid)+int(packet[6+len(dcid)])]
+	plain, ok := DecryptInitial(dcid, packet)
+	if !ok {
+		t.Error("cannot decrypt client Initial")
+		return
+	}
+	frames, ok := ParseInitialFrames(plain)
+	if !ok {
+		t.Error("cannot parse client Initial frames")
+		return
+	}
+
+	qs := tls.QUICServer(&tls.QUICConfig{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h3"},
+		MinVersion:   tls.VersionTLS13,
+	}})
+	defer qs.Close()
+	qs.SetTransportParameters(appendTransportParam(nil, 0x00, dcid))
+	if err := qs.Start(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := qs.HandleData(tls.QUICEncryptionLevelInitial, CryptoStream(frames.Crypto)); err != nil {
+		t.Error(err)
+		return
+	}
+	gotSNI <- qs.ConnectionState().ServerName
+
+	var hello []byte
+	for e := qs.NextEvent(); e.Kind != tls.QUICNoEvent; e = qs.NextEvent() {
+		if e.Kind == tls.QUICWriteData && e.Level == tls.QUICEncryptionLevelInitial {
+			hello = append(hello, e.Data...)
+		}
+	}
+
+	h := InitialHeader{Version: versionV1, DCID: clientSCID, SCID: newConnID(8)}
+	// Split the ServerHello to check the client reassembles it
+	first, _ := sealInitial(h, dcid, AppendCryptoFrame(nil, 0, hello[:10]), 0, serverLabel)
+	h.PN = 1
+	second, _ := sealInitial(h, dcid, AppendCryptoFrame(nil, 10, hello[10:]), 0, serverLabel)
+	_, _ = pc.WriteTo(second, addr)
+	_, _ = pc.WriteTo(first, addr)
+}
+
+func TestHandshake(t *testing.T) {
+	cert := testCertificate(t)
+
+	t.Run("server answers", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+
+		gotSNI := make(chan string, 1)
+		go serveInitial(t, pc, cert, gotSNI)
+
+		conn, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, err := Handshake(ctx, conn, "example.com")
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if res.RTT <= 0 || res.Retry {
+			t.Errorf("unexpected result %+v", res)
+		}
+		if sni := <-gotSNI; sni != "example.com" {
+			t.Errorf("server saw SNI %q", sni)
+		}
+	})
+
+	t.Run("no answer", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+
+		conn, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		if _, err := Handshake(ctx, conn, "example.com"); err == nil {
+			t.Error("handshake succeeded without a server")
+		}
+	})
+}
+
+func TestSealInitial(t *testing.T) {
+	hello, err := ClientHello("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dcid := newConnID(8)
+	packet, err := SealInitial(InitialHeader{Version: versionV1, DCID: dcid, SCID: newConnID(8), PN: 7},
+		AppendCryptoFrame(nil, 0, hello), MinInitialSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet) != MinInitialSize {
+		t.Errorf("expected %d bytes, got %d", MinInitialSize, len(packet))
+	}
+	if !IsInitial(packet) || !bytes.Equal(ParseDCID(packet), dcid) {
+		t.Fatal("not an Initial for the given DCID")
+	}
+
+	plain, ok := DecryptInitial(dcid, packet)
+	if !ok {
+		t.Fatal("cannot decrypt sealed Initial")
+	}
+	frames, ok := ParseInitialFrames(plain)
+	if !ok || !bytes.Equal(CryptoStream(frames.Crypto), hello) {
+		t.Fatal("CRYPTO data not preserved")
+	}
+
+	off, ln := LocateSNIOffset(packet)
+	if off < 0 || ln != len("example.com") {
+		t.Errorf("SNI not located: off=%d len=%d", off, ln)
+	}
+
+	// Coalesced packets after the Initial must not break decryption
+	if _, ok := DecryptInitial(dcid, append(packet, 0x40, 1, 2, 3)); !ok {
+		t.Error("cannot decrypt Initial followed by another packet")
+	}
+}
+
+func TestCryptoStream(t *testing.T) {
+	frames := []CryptoFrame{
+		{Offset: 4, Data: []byte("efgh")},
+		{Offset: 0, Data: []byte("abcd")},
+		{Offset: 2, Data: []byte("cdef")},
+		{Offset: 20, Data: []byte("zz")},
+	}
+	if got := string(CryptoStream(frames)); got != "abcdefgh" {
+		t.Errorf("got %q", got)
+	}
+}
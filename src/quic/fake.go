@@ -0,0 +1,182 @@
+package quic
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"slices"
+	"sync"
+)
+
+// DefaultFakeSNI is the decoy server name used when none is configured
+const DefaultFakeSNI = "www.google.com"
+
+// TLS extensions and groups rewritten in the decoy ClientHello
+const (
+	extKeyShare            = 0x0033
+	extQUICTransportParams = 0x0039
+
+	groupX25519    = 0x001d
+	groupSecp256r1 = 0x0017
+)
+
+// fakeHellos caches one decoy ClientHello template per server name,
+// building one takes a full TLS client setup
+var fakeHellos sync.Map // string -> []byte
+
+func fakeClientHello(serverName string) ([]byte, error) {
+	if hello, ok := fakeHellos.Load(serverName); ok {
+		return hello.([]byte), nil
+	}
+	hello, err := ClientHello(serverName)
+	if err != nil {
+		return nil, err
+	}
+	fakeHellos.Store(serverName, hello)
+	return hello, nil
+}
+
+// FakeInitial builds a client Initial of the given version carrying a
+// ClientHello for serverName, at least minSize bytes long. Each call uses new
+// random connection IDs, client random and key shares, so the server cannot
+// tie it to a real connection and fakes cannot be told apart by them.
+func FakeInitial(version uint32, serverName string, minSize int) ([]byte, error) {
+	if serverName == "" {
+		serverName = DefaultFakeSNI
+	}
+	if version != versionV2 {
+		version = versionV1
+	}
+	template, err := fakeClientHello(serverName)
+	if err != nil {
+		return nil, err
+	}
+	hello, scid := freshClientHello(template)
+	h := InitialHeader{Version: version, DCID: newConnID(8), SCID: scid}
+	return SealInitial(h, AppendCryptoFrame(nil, 0, hello), max(minSize, MinInitialSize))
+}
+
+// freshClientHello copies a ClientHello template with a new random, session
+// ID, key shares and initial_source_connection_id, which it returns along
+// with the copy. Parts that do not parse are left as they are.
+func freshClientHello(template []byte) ([]byte, []byte) {
+	hello := slices.Clone(template)
+	scid := newConnID(8)
+
+	// Handshake header(4) + version(2) + random(32)
+	pos := 38
+	if len(hello) < pos+1 {
+		return hello, scid
+	}
+	_, _ = rand.Read(hello[6:38])
+
+	sidLen := int(hello[pos])
+	pos++
+	if pos+sidLen > len(hello) {
+		return hello, scid
+	}
+	_, _ = rand.Read(hello[pos : pos+sidLen])
+	pos += sidLen
+
+	// Cipher suites and compression methods
+	if pos+2 > len(hello) {
+		return hello, scid
+	}
+	pos += 2 + int(binary.BigEndian.Uint16(hello[pos:]))
+	if pos >= len(hello) {
+		return hello, scid
+	}
+	pos += 1 + int(hello[pos])
+	if pos+2 > len(hello) {
+		return hello, scid
+	}
+	end := pos + 2 + int(binary.BigEndian.Uint16(hello[pos:]))
+	pos += 2
+	if end > len(hello) {
+		return hello, scid
+	}
+
+	for pos+4 <= end {
+		extType := binary.BigEndian.Uint16(hello[pos:])
+		extLen := int(binary.BigEndian.Uint16(hello[pos+2:]))
+		pos += 4
+		if pos+extLen > end {
+			break
+		}
+		switch extType {
+		case extKeyShare:
+			refreshKeyShares(hello[pos : pos+extLen])
+		case extQUICTransportParams:
+			if id := sourceConnID(hello[pos : pos+extLen]); id != nil {
+				_, _ = rand.Read(id)
+				scid = slices.Clone(id)
+			}
+		}
+		pos += extLen
+	}
+	return hello, scid
+}
+
+// refreshKeyShares replaces the key exchange data of each client key share
+// in place with a new public key of the same group
+func refreshKeyShares(ext []byte) {
+	if len(ext) < 2 {
+		return
+	}
+	end := 2 + int(binary.BigEndian.Uint16(ext))
+	if end > len(ext) {
+		return
+	}
+	for pos := 2; pos+4 <= end; {
+		group := binary.BigEndian.Uint16(ext[pos:])
+		n := int(binary.BigEndian.Uint16(ext[pos+2:]))
+		pos += 4
+		if pos+n > end {
+			return
+		}
+		copy(ext[pos:pos+n], newKeyShare(group, n))
+		pos += n
+	}
+}
+
+// newKeyShare returns a public key of group, or random bytes for groups
+// the decoy does not offer
+func newKeyShare(group uint16, size int) []byte {
+	var curve ecdh.Curve
+	switch group {
+	case groupX25519:
+		curve = ecdh.X25519()
+	case groupSecp256r1:
+		curve = ecdh.P256()
+	}
+	if curve != nil {
+		if key, err := curve.GenerateKey(rand.Reader); err == nil {
+			if pub := key.PublicKey().Bytes(); len(pub) == size {
+				return pub
+			}
+		}
+	}
+	return newConnID(size)
+}
+
+// sourceConnID returns the initial_source_connection_id value within the
+// QUIC transport parameters extension
+func sourceConnID(params []byte) []byte {
+	for pos := 0; pos < len(params); {
+		id, n := readVar(params[pos:])
+		if n == 0 {
+			return nil
+		}
+		pos += n
+		length, m := readVar(params[pos:])
+		if m == 0 || uint64(len(params)-pos-m) < length {
+			return nil
+		}
+		pos += m
+		if id == tpInitialSourceConnID {
+			return params[pos : pos+int(length)]
+		}
+		pos += int(length)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFakeInitial(t *testing.T) {
+	a, err := FakeInitial(VersionV1, "decoy.example.org", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := FakeInitial(VersionV1, "decoy.example.org", 1400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != MinInitialSize || len(b) != 1400 {
+		t.Errorf("unexpected sizes %d and %d", len(a), len(b))
+	}
+	if bytes.Equal(ParseDCID(a), ParseDCID(b)) {
+		t.Error("fakes must use a new DCID each time")
+	}
+
+	off, ln := LocateSNIOffset(a)
+	if off < 0 {
+		t.Fatal("decoy SNI not found")
+	}
+	plain, _ := DecryptInitial(ParseDCID(a), a)
+	hdr, _, _ := parseHeaderLength(a)
+	if got := string(plain[off-hdr-initialPNLen : off-hdr-initialPNLen+ln]); got != "decoy.example.org" {
+		t.Errorf("got SNI %q", got)
+	}
+
+	t.Run("default server name", func(t *testing.T) {
+		p, err := FakeInitial(0, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ln := LocateSNIOffset(p); ln != len(DefaultFakeSNI) {
+			t.Errorf("expected the default decoy, SNI length %d", ln)
+		}
+	})
+}
+
+func TestFreshClientHello(t *testing.T) {
+	template, err := fakeClientHello("decoy.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := bytes.Clone(template)
+
+	a, scidA := freshClientHello(template)
+	b, scidB := freshClientHello(template)
+
+	if !bytes.Equal(template, original) {
+		t.Fatal("template modified")
+	}
+	if len(a) != len(template) || len(b) != len(template) {
+		t.Fatalf("size changed: %d, %d -> %d", len(template), len(a), len(b))
+	}
+	if bytes.Equal(a[6:38], b[6:38]) || bytes.Equal(a[6:38], template[6:38]) {
+		t.Error("client random reused")
+	}
+
+	// group x25519 with a 32 byte key is only found in the key share
+	share := func(hello []byte) []byte {
+		i := bytes.Index(hello, []byte{0x00, 0x1d, 0x00, 0x20})
+		if i < 0 {
+			t.Fatal("x25519 key share not found")
+		}
+		return hello[i+4 : i+36]
+	}
+	if bytes.Equal(share(a), share(b)) || bytes.Equal(share(a), share(template)) {
+		t.Error("key share reused")
+	}
+
+	if bytes.Equal(scidA, scidB) || !bytes.Contains(a, scidA) || !bytes.Contains(b, scidB) {
+		t.Error("initial_source_connection_id not refreshed")
+	}
+	if off, n := locateSNIInClientHello(a); off < 0 || string(a[off:off+n]) != "decoy.example.org" {
+		t.Error("decoy SNI lost")
+	}
+}
@@ -27,6 +27,11 @@ const (
 	longHdrBit = 0x80
 )
 
+const (
+	clientLabel = "client in"
+	serverLabel = "server in"
+)
+
 func IsInitial(b []byte) bool {
 	if len(b) < 7 || b[0]&longHdrBit == 0 { // short header or tiny packet
 		return false
@@ -48,13 +53,27 @@ func IsInitial(b []byte) bool {
 }
 
 func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
+	plain, _, ok := decryptInitial(dcid, packet, clientLabel)
+	return plain, ok
+}
+
+// DecryptServerInitial decrypts an Initial sent by the server. dcid is the
+// connection ID the client chose for its first Initial, which keys both
+// directions.
+func DecryptServerInitial(dcid, packet []byte) ([]byte, bool) {
+	plain, _, ok := decryptInitial(dcid, packet, serverLabel)
+	return plain, ok
+}
+
+// decryptInitial also returns the truncated packet number
+func decryptInitial(dcid, packet []byte, side string) ([]byte, uint32, bool) {
 	if len(packet) < 7 || packet[0]&0x80 == 0 {
-		return nil, false
+		return nil, 0, false
 	}
 	ver := binary.BigEndian.Uint32(packet[1:5])
-	hp, aead, iv, err := deriveInitial(dcid, ver)
+	hp, aead, iv, err := deriveInitialKeys(dcid, ver, side)
 	if err != nil {
-		return nil, false
+		return nil, 0, false
 	}
 
 	// flags+ver
@@ -62,12 +81,12 @@ func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
 
 	// DCID len + DCID
 	if len(packet) < off+1 {
-		return nil, false
+		return nil, 0, false
 	}
 	dlen := int(packet[off])
 	off++
 	if len(packet) < off+dlen+1 {
-		return nil, false
+		return nil, 0, false
 	}
 	off += dlen
 
@@ -75,27 +94,32 @@ func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
 	slen := int(packet[off])
 	off++
 	if len(packet) < off+slen {
-		return nil, false
+		return nil, 0, false
 	}
 	off += slen
 
 	// Token (varint + bytes)
 	tlen, n := readVar(packet[off:])
 	if n == 0 || len(packet) < off+n+int(tlen) {
-		return nil, false
+		return nil, 0, false
 	}
 	off += n + int(tlen)
 
 	// Length (varint) -> PN offset
-	_, m := readVar(packet[off:])
+	length, m := readVar(packet[off:])
 	if m == 0 {
-		return nil, false
+		return nil, 0, false
 	}
 	pnOff := off + m
 
+	// Coalesced packets may follow the Initial in the same datagram
+	if end := pnOff + int(length); length > 0 && end < len(packet) {
+		packet = packet[:end]
+	}
+
 	// HP sample (pnOff + 4)
 	if pnOff+4+16 > len(packet) {
-		return nil, false
+		return nil, 0, false
 	}
 	var sample [16]byte
 	copy(sample[:], packet[pnOff+4:pnOff+4+16])
@@ -107,7 +131,7 @@ func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
 	first := packet[0] ^ (mask[0] & 0x0f)
 	pnLen := int((first & 0x03) + 1)
 	if pnOff+pnLen > len(packet) {
-		return nil, false
+		return nil, 0, false
 	}
 
 	// Unmasked PN bytes (don’t write back)
@@ -138,12 +162,18 @@ func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
 	ct := packet[pnOff+pnLen:]
 	plain, err := aead.Open(nil, nonce, ct, aad)
 	if err != nil {
-		return nil, false
+		return nil, 0, false
 	}
-	return plain, true
+	return plain, uint32(pn), true
 }
 
 func deriveInitial(dcid []byte, version uint32) (cipher.Block, cipher.AEAD, []byte, error) {
+	return deriveInitialKeys(dcid, version, clientLabel)
+}
+
+// deriveInitialKeys derives the Initial keys of one side, clientLabel or
+// serverLabel
+func deriveInitialKeys(dcid []byte, version uint32, side string) (cipher.Block, cipher.AEAD, []byte, error) {
 	var salt []byte
 
 	labelPrefix := "quic"
@@ -162,21 +192,21 @@ func deriveInitial(dcid []byte, version uint32) (cipher.Block, cipher.AEAD, []by
 	// --- Step 1: initial_secret = HKDF-Extract(salt, dcid)
 	secret := hkdfExtractSHA256(salt, dcid)
 
-	client, err := hkdfExpandLabel(secret, "client in", secretSize)
+	sideSecret, err := hkdfExpandLabel(secret, side, secretSize)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
 	// --- Step 2: derive key/iv/hp with the *labelled* expand
-	key, err := hkdfExpandLabel(client, labelPrefix+" key", keySize)
+	key, err := hkdfExpandLabel(sideSecret, labelPrefix+" key", keySize)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	iv, err := hkdfExpandLabel(client, labelPrefix+" iv", ivSize)
+	iv, err := hkdfExpandLabel(sideSecret, labelPrefix+" iv", ivSize)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	hpkey, err := hkdfExpandLabel(client, labelPrefix+" hp", keySize)
+	hpkey, err := hkdfExpandLabel(sideSecret, labelPrefix+" hp", keySize)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -0,0 +1,251 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+const (
+	// MinInitialSize is the smallest UDP payload allowed for a datagram
+	// carrying a client Initial (RFC 9000 §14.1)
+	MinInitialSize = 1200
+
+	initialPNLen = 4
+	aeadTagLen   = 16
+)
+
+// Frame types that may appear in Initial packets
+const (
+	framePadding   = 0x00
+	framePing      = 0x01
+	frameAck       = 0x02
+	frameAckECN    = 0x03
+	frameCrypto    = 0x06
+	frameConnClose = 0x1c
+)
+
+// VersionV1 is the QUIC version used for the packets built by this package
+const VersionV1 uint32 = versionV1
+
+// CryptoFrame is a CRYPTO frame of an Initial payload
+type CryptoFrame struct {
+	Offset uint64
+	Data   []byte
+}
+
+// InitialFrames is the content of a decrypted Initial payload that matters
+// to a client: the CRYPTO frames and a CONNECTION_CLOSE if any
+type InitialFrames struct {
+	Crypto    []CryptoFrame
+	Closed    bool
+	CloseCode uint64
+	Reason    string
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case v < 1<<30:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// AppendCryptoFrame appends a CRYPTO frame carrying data at offset off
+func AppendCryptoFrame(b []byte, off uint64, data []byte) []byte {
+	b = append(b, frameCrypto)
+	b = appendVarint(b, off)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// ParseInitialFrames walks the frames of a decrypted Initial payload. It
+// fails on frame types that are not allowed in Initial packets.
+func ParseInitialFrames(plain []byte) (InitialFrames, bool) {
+	var f InitialFrames
+	i := 0
+	for i < len(plain) {
+		t, n := readVar(plain[i:])
+		if n == 0 {
+			return f, false
+		}
+		i += n
+
+		switch t {
+		case framePadding, framePing:
+		case frameAck, frameAckECN:
+			// largest, delay, range count, first range
+			var count uint64
+			for k := 0; k < 4; k++ {
+				v, n := readVar(plain[i:])
+				if n == 0 {
+					return f, false
+				}
+				i += n
+				if k == 2 {
+					count = v
+				}
+			}
+			fields := 2 * count
+			if t == frameAckECN {
+				fields += 3
+			}
+			for ; fields > 0; fields-- {
+				_, n := readVar(plain[i:])
+				if n == 0 {
+					return f, false
+				}
+				i += n
+			}
+		case frameCrypto:
+			off, n := readVar(plain[i:])
+			if n == 0 {
+				return f, false
+			}
+			i += n
+			ln, n := readVar(plain[i:])
+			if n == 0 || ln > uint64(len(plain)-i-n) {
+				return f, false
+			}
+			i += n
+			f.Crypto = append(f.Crypto, CryptoFrame{Offset: off, Data: plain[i : i+int(ln)]})
+			i += int(ln)
+		case frameConnClose:
+			code, n := readVar(plain[i:])
+			if n == 0 {
+				return f, false
+			}
+			i += n
+			if _, n = readVar(plain[i:]); n == 0 { // frame type
+				return f, false
+			}
+			i += n
+			ln, n := readVar(plain[i:])
+			if n == 0 || ln > uint64(len(plain)-i-n) {
+				return f, false
+			}
+			i += n
+			f.Closed, f.CloseCode, f.Reason = true, code, string(plain[i:i+int(ln)])
+			i += int(ln)
+		default:
+			return f, false
+		}
+	}
+	return f, true
+}
+
+// CryptoStream returns the CRYPTO data contiguous from offset 0
+func CryptoStream(frames []CryptoFrame) []byte {
+	sorted := append([]CryptoFrame(nil), frames...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	var out []byte
+	for _, f := range sorted {
+		end := f.Offset + uint64(len(f.Data))
+		if f.Offset > uint64(len(out)) {
+			break
+		}
+		if end > uint64(len(out)) {
+			out = append(out, f.Data[uint64(len(out))-f.Offset:]...)
+		}
+	}
+	return out
+}
+
+// InitialHeader holds the fields of a long header needed to build an Initial
+type InitialHeader struct {
+	Version uint32
+	DCID    []byte
+	SCID    []byte
+	Token   []byte
+	PN      uint32
+}
+
+// SealInitial builds a client Initial packet carrying plain, protected with
+// the keys derived from h.DCID. PADDING frames are added so the packet is at
+// least minSize bytes long.
+func SealInitial(h InitialHeader, plain []byte, minSize int) ([]byte, error) {
+	return sealInitial(h, h.DCID, plain, minSize, clientLabel)
+}
+
+// sealInitial protects the packet with the side's keys derived from keyCID
+func sealInitial(h InitialHeader, keyCID, plain []byte, minSize int, side string) ([]byte, error) {
+	if len(h.DCID) > 20 || len(h.SCID) > 20 {
+		return nil, errors.New("connection ID too long")
+	}
+	hp, aead, iv, err := deriveInitialKeys(keyCID, h.Version, side)
+	if err != nil {
+		return nil, err
+	}
+
+	typeBits := byte(0x00)
+	if h.Version == versionV2 {
+		typeBits = 0x10
+	}
+
+	hdr := []byte{0xc0 | typeBits | (initialPNLen - 1)}
+	hdr = binary.BigEndian.AppendUint32(hdr, h.Version)
+	hdr = append(hdr, byte(len(h.DCID)))
+	hdr = append(hdr, h.DCID...)
+	hdr = append(hdr, byte(len(h.SCID)))
+	hdr = append(hdr, h.SCID...)
+	hdr = appendVarint(hdr, uint64(len(h.Token)))
+	hdr = append(hdr, h.Token...)
+
+	// The Length field always takes 2 bytes, which covers any datagram. With
+	// a 4 byte packet number the header protection sample always fits.
+	size := len(hdr) + 2 + initialPNLen + len(plain) + aeadTagLen
+	if size < minSize {
+		plain = append(append([]byte(nil), plain...), make([]byte, minSize-size)...)
+	}
+	length := initialPNLen + len(plain) + aeadTagLen
+	if length >= 1<<14 {
+		return nil, errors.New("initial payload too large")
+	}
+	hdr = append(hdr, byte(length>>8)|0x40, byte(length))
+
+	pnOff := len(hdr)
+	hdr = binary.BigEndian.AppendUint32(hdr, h.PN)
+
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	for i := 0; i < initialPNLen; i++ {
+		nonce[len(nonce)-1-i] ^= byte(h.PN >> (8 * i))
+	}
+
+	packet := aead.Seal(hdr, nonce, plain, hdr)
+
+	var mask [16]byte
+	hp.Encrypt(mask[:], packet[pnOff+4:pnOff+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < initialPNLen; i++ {
+		packet[pnOff+i] ^= mask[1+i]
+	}
+	return packet, nil
+}
+
+// IsRetry reports whether b is a Retry packet
+func IsRetry(b []byte) bool {
+	if len(b) < 7 || b[0]&longHdrBit == 0 {
+		return false
+	}
+	ptype := (b[0] & 0x30) >> 4
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case versionV1:
+		return ptype == 0x03
+	case versionV2:
+		return ptype == 0x00
+	}
+	return false
+}
+
+// IsVersionNegotiation reports whether b is a Version Negotiation packet
+func IsVersionNegotiation(b []byte) bool {
+	return len(b) >= 7 && b[0]&longHdrBit != 0 && binary.BigEndian.Uint32(b[1:5]) == 0
+}
@@ -0,0 +1,112 @@
+package quic
+
+import (
+	"encoding/binary"
+)
+
+// parseInitialHeader reads the long header fields of an Initial and returns
+// where the Initial ends in the datagram
+func parseInitialHeader(packet []byte) (InitialHeader, int, bool) {
+	var h InitialHeader
+	if !IsInitial(packet) {
+		return h, 0, false
+	}
+	h.Version = binary.BigEndian.Uint32(packet[1:5])
+
+	off := 5
+	dlen := int(packet[off])
+	off++
+	if len(packet) < off+dlen+1 {
+		return h, 0, false
+	}
+	h.DCID = packet[off : off+dlen]
+	off += dlen
+
+	slen := int(packet[off])
+	off++
+	if len(packet) < off+slen {
+		return h, 0, false
+	}
+	h.SCID = packet[off : off+slen]
+	off += slen
+
+	tlen, n := readVar(packet[off:])
+	if n == 0 || uint64(len(packet)-off-n) < tlen {
+		return h, 0, false
+	}
+	h.Token = packet[off+n : off+n+int(tlen)]
+	off += n + int(tlen)
+
+	length, m := readVar(packet[off:])
+	if m == 0 || uint64(len(packet)-off-m) < length {
+		return h, 0, false
+	}
+	return h, off + m + int(length), true
+}
+
+// cutInitial decrypts a client Initial and cuts its CRYPTO data in two, in
+// the middle of the SNI or in the middle of the first frame when the packet
+// does not carry the SNI
+func cutInitial(packet []byte) (h InitialHeader, first, second []CryptoFrame, end int, ok bool) {
+	h, end, ok = parseInitialHeader(packet)
+	if !ok {
+		return h, nil, nil, 0, false
+	}
+	plain, pn, ok := decryptInitial(h.DCID, packet, clientLabel)
+	if !ok {
+		return h, nil, nil, 0, false
+	}
+	h.PN = pn
+
+	frames, ok := ParseInitialFrames(plain)
+	if !ok || len(frames.Crypto) == 0 {
+		return h, nil, nil, 0, false
+	}
+
+	cut := frames.Crypto[0].Offset + uint64(len(frames.Crypto[0].Data))/2
+	if off, n := locateSNIInClientHello(CryptoStream(frames.Crypto)); off >= 0 {
+		cut = uint64(off + n/2)
+	}
+
+	for _, f := range frames.Crypto {
+		fend := f.Offset + uint64(len(f.Data))
+		switch {
+		case fend <= cut:
+			first = append(first, f)
+		case f.Offset >= cut:
+			second = append(second, f)
+		default:
+			at := cut - f.Offset
+			first = append(first, CryptoFrame{Offset: f.Offset, Data: f.Data[:at]})
+			second = append(second, CryptoFrame{Offset: cut, Data: f.Data[at:]})
+		}
+	}
+	if len(first) == 0 || len(second) == 0 {
+		return h, nil, nil, 0, false
+	}
+	return h, first, second, end, true
+}
+
+func appendCryptoFrames(b []byte, frames []CryptoFrame) []byte {
+	for _, f := range frames {
+		b = AppendCryptoFrame(b, f.Offset, f.Data)
+	}
+	return b
+}
+
+// ReorderInitial re-encrypts a client Initial with its CRYPTO data cut in the
+// middle of the SNI and the second part placed first. The packet keeps its
+// packet number and size, so the connection is not affected.
+func ReorderInitial(packet []byte) ([]byte, bool) {
+	h, first, second, end, ok := cutInitial(packet)
+	if !ok {
+		return nil, false
+	}
+
+	plain := appendCryptoFrames(appendCryptoFrames(nil, second), first)
+	out, err := SealInitial(h, plain, end)
+	if err != nil {
+		return nil, false
+	}
+	return append(out, packet[end:]...), true
+}
@@ -0,0 +1,67 @@
+package quic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testInitial(t *testing.T, serverName string) ([]byte, []byte) {
+	t.Helper()
+	hello, err := ClientHello(serverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := SealInitial(InitialHeader{Version: VersionV1, DCID: newConnID(8), SCID: newConnID(8), PN: 3},
+		AppendCryptoFrame(nil, 0, hello), MinInitialSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet, hello
+}
+
+func openTestInitial(t *testing.T, dcid, packet []byte) (InitialFrames, uint32) {
+	t.Helper()
+	plain, pn, ok := decryptInitial(dcid, packet, clientLabel)
+	if !ok {
+		t.Fatal("cannot decrypt re-encrypted Initial")
+	}
+	frames, ok := ParseInitialFrames(plain)
+	if !ok {
+		t.Fatal("cannot parse re-encrypted Initial")
+	}
+	return frames, pn
+}
+
+func TestReorderInitial(t *testing.T) {
+	packet, hello := testInitial(t, "blocked.example.com")
+	dcid := ParseDCID(packet)
+
+	out, ok := ReorderInitial(packet)
+	if !ok {
+		t.Fatal("reorder failed")
+	}
+	if len(out) != len(packet) {
+		t.Errorf("size changed: %d -> %d", len(packet), len(out))
+	}
+
+	frames, pn := openTestInitial(t, dcid, out)
+	if pn != 3 {
+		t.Errorf("packet number changed to %d", pn)
+	}
+	if len(frames.Crypto) != 2 || frames.Crypto[0].Offset == 0 {
+		t.Fatalf("expected the second part first, got %d frames", len(frames.Crypto))
+	}
+	if !bytes.Equal(CryptoStream(frames.Crypto), hello) {
+		t.Error("CRYPTO data not preserved")
+	}
+	if strings.Contains(string(frames.Crypto[1].Data), "blocked.example.com") {
+		t.Error("SNI must be cut")
+	}
+
+	t.Run("not an Initial", func(t *testing.T) {
+		if _, ok := ReorderInitial([]byte{0x40, 1, 2, 3, 4, 5, 6, 7}); ok {
+			t.Error("reordered a short header packet")
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package sni
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/yl2chen/cidranger"
+)
+
+// exclusions holds the domain rules and networks a set opted out of. A nil
+// *exclusions excludes nothing.
+type exclusions struct {
+	full     map[string]struct{}
+	suffixes map[string]struct{}
+	keywords []string
+	regexes  []*regexp.Regexp
+	ipRanger cidranger.Ranger
+	hasIPs   bool
+}
+
+// newExclusions builds the exclusions of set, or nil if it has none
+func newExclusions(set *config.SetConfig) *exclusions {
+	t := &set.Targets
+	if len(t.DomainsToExclude) == 0 && len(t.IpsToExclude) == 0 {
+		return nil
+	}
+
+	e := &exclusions{
+		full:     make(map[string]struct{}),
+		suffixes: make(map[string]struct{}),
+		ipRanger: cidranger.NewPCTrieRanger(),
+	}
+	for _, d := range t.DomainsToExclude {
+		kind, value := parseDomainRule(d)
+		if value == "" {
+			continue
+		}
+		switch kind {
+		case "regexp":
+			if re, err := regexp.Compile(value); err == nil {
+				e.regexes = append(e.regexes, re)
+			}
+		case "keyword":
+			e.keywords = append(e.keywords, value)
+		case "full":
+			e.full[value] = struct{}{}
+		default:
+			e.suffixes[value] = struct{}{}
+		}
+	}
+	for _, ipStr := range t.IpsToExclude {
+		if ipNet := parseIPNet(ipStr); ipNet != nil {
+			_ = e.ipRanger.Insert(cidranger.NewBasicRangerEntry(*ipNet))
+			e.hasIPs = true
+		}
+	}
+	return e
+}
+
+// matchHost reports whether the lowercase host is excluded
+func (e *exclusions) matchHost(host string) bool {
+	if e == nil || host == "" {
+		return false
+	}
+	if _, ok := e.full[host]; ok {
+		return true
+	}
+	for remaining := host; ; {
+		if _, ok := e.suffixes[remaining]; ok {
+			return true
+		}
+		idx := strings.IndexByte(remaining, '.')
+		if idx == -1 {
+			break
+		}
+		remaining = remaining[idx+1:]
+	}
+	for _, kw := range e.keywords {
+		if strings.Contains(host, kw) {
+			return true
+		}
+	}
+	for _, re := range e.regexes {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIP reports whether ip is in an excluded network
+func (e *exclusions) matchIP(ip net.IP) bool {
+	if e == nil || !e.hasIPs {
+		return false
+	}
+	ok, err := e.ipRanger.Contains(ip)
+	return err == nil && ok
+}
+
+// coversNet reports whether all of n is excluded
+func (e *exclusions) coversNet(n *net.IPNet) bool {
+	if e == nil || !e.hasIPs {
+		return false
+	}
+	entries, err := e.ipRanger.ContainingNetworks(n.IP)
+	if err != nil {
+		return false
+	}
+	ones, _ := n.Mask.Size()
+	for _, entry := range entries {
+		network := entry.Network()
+		if o, _ := network.Mask.Size(); o <= ones {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveTargets counts the loaded domain rules and networks of set that
+// its exclusions leave in place. Keyword and regexp rules are counted as
+// they are, since what they match cannot be enumerated.
+func EffectiveTargets(set *config.SetConfig) (domains, ips int) {
+	e := newExclusions(set)
+	for _, d := range set.Targets.DomainsToMatch {
+		kind, value := parseDomainRule(d)
+		if value == "" {
+			continue
+		}
+		if (kind == "full" || kind == "domain") && e.matchHost(value) {
+			continue
+		}
+		domains++
+	}
+	for _, ipStr := range set.Targets.IpsToMatch {
+		ipNet := parseIPNet(ipStr)
+		if ipNet == nil || e.coversNet(ipNet) {
+			continue
+		}
+		ips++
+	}
+	return domains, ips
+}
@@ -0,0 +1,161 @@
+package sni
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+// Candidate is a set whose targets cover a flow
+type Candidate struct {
+	SetId    string `json:"set_id"`
+	SetName  string `json:"set_name"`
+	By       string `json:"by"` // "sni", "ip" or "port"
+	Rule     string `json:"rule"`
+	Priority int    `json:"priority"`
+	Excluded bool   `json:"excluded,omitempty"`
+}
+
+// Explanation tells which set handles a flow and why
+type Explanation struct {
+	SetId      string      `json:"set_id,omitempty"`
+	SetName    string      `json:"set_name,omitempty"`
+	By         string      `json:"matched_by,omitempty"`
+	Reason     string      `json:"reason"`
+	Candidates []Candidate `json:"candidates"`
+
+	Set *config.SetConfig `json:"-"`
+}
+
+// Explain resolves a flow to host, ip and port the way the queue workers
+// do. A host match wins over an IP match: addresses are shared by many
+// sites behind CDNs while the host names the site. The set found must not
+// exclude the host or IP, and for TCP it must target the port, or handle
+// plain HTTP on port 80. UDP flows matching neither fall back to sets that
+// target the port without an IP list.
+func (s *SuffixSet) Explain(host string, ip net.IP, port uint16, udp bool) Explanation {
+	host = strings.TrimRight(strings.ToLower(strings.TrimSpace(host)), ".")
+	e := Explanation{Candidates: s.candidates(host, ip, port, udp)}
+
+	var set *config.SetConfig
+	if host != "" {
+		if ok, st := s.MatchSNI(host); ok {
+			set, e.By = st, "sni"
+		}
+	}
+	if set == nil && ip != nil {
+		if ok, st := s.MatchIP(ip); ok {
+			set, e.By = st, "ip"
+		}
+	}
+	if set == nil && udp {
+		if ok, st := s.MatchUDPPortOnly(port); ok && !s.Excludes(st, host, ip) {
+			set, e.By = st, "port"
+		}
+	}
+
+	if set == nil {
+		e.By = ""
+		e.Reason = "no set targets this host or address"
+		return e
+	}
+
+	switch {
+	case s.Excludes(set, host, ip):
+		e.Reason = fmt.Sprintf("set %q matched by %s but excludes this flow", set.Name, e.By)
+		e.By = ""
+		return e
+	case !udp && port == 80 && !set.HTTP.Enabled:
+		e.Reason = fmt.Sprintf("set %q matched by %s but does not handle plain HTTP", set.Name, e.By)
+		e.By = ""
+		return e
+	case !udp && port != 80 && !s.TCPPortMatchesSet(port, set):
+		e.Reason = fmt.Sprintf("set %q matched by %s but does not target port %d", set.Name, e.By, port)
+		e.By = ""
+		return e
+	}
+
+	e.Set, e.SetId, e.SetName = set, set.Id, set.Name
+	e.Reason = s.reason(set, e.By, host, ip)
+	return e
+}
+
+func (s *SuffixSet) reason(set *config.SetConfig, by, host string, ip net.IP) string {
+	switch by {
+	case "sni":
+		return fmt.Sprintf("host %s matches the most specific rule of set %q", host, set.Name)
+	case "ip":
+		if host != "" {
+			return fmt.Sprintf("no set targets host %s; %s is in the most specific network of set %q", host, ip, set.Name)
+		}
+		return fmt.Sprintf("%s is in the most specific network of set %q", ip, set.Name)
+	default:
+		return fmt.Sprintf("set %q targets the port without an IP list", set.Name)
+	}
+}
+
+// candidates lists every rule of every set that covers the flow, whether
+// or not it won
+func (s *SuffixSet) candidates(host string, ip net.IP, port uint16, udp bool) []Candidate {
+	var out []Candidate
+	add := func(set *config.SetConfig, by, rule string) {
+		out = append(out, Candidate{
+			SetId:    set.Id,
+			SetName:  set.Name,
+			By:       by,
+			Rule:     rule,
+			Priority: set.Priority,
+			Excluded: s.Excludes(set, host, ip),
+		})
+	}
+
+	if host != "" {
+		for _, set := range s.full[host] {
+			add(set, "sni", "full:"+host)
+		}
+		for remaining := host; ; {
+			for _, set := range s.sets[remaining] {
+				add(set, "sni", "domain:"+remaining)
+			}
+			idx := strings.IndexByte(remaining, '.')
+			if idx == -1 {
+				break
+			}
+			remaining = remaining[idx+1:]
+		}
+		for _, kw := range s.keywords {
+			if strings.Contains(host, kw.keyword) {
+				add(kw.set, "sni", "keyword:"+kw.keyword)
+			}
+		}
+		for _, rws := range s.regexes {
+			if rws.regex.MatchString(host) {
+				add(rws.set, "sni", "regexp:"+rws.regex.String())
+			}
+		}
+	}
+
+	if ip != nil {
+		if entries, err := s.ipRanger.ContainingNetworks(ip); err == nil {
+			for i := len(entries) - 1; i >= 0; i-- {
+				r := entries[i].(*ipRange)
+				for _, set := range r.sets {
+					add(set, "ip", r.ipNet.String())
+				}
+			}
+		}
+	}
+
+	ranges := s.tcpPortRanges
+	if udp {
+		ranges = s.portRanges
+	}
+	for _, r := range ranges {
+		if int(port) >= r.min && int(port) <= r.max {
+			add(r.set, "port", fmt.Sprintf("%d-%d", r.min, r.max))
+		}
+	}
+	return out
+}
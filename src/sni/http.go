@@ -0,0 +1,84 @@
+package sni
+
+import (
+	"bytes"
+	"strings"
+)
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+func isHTTPRequest(b []byte) bool {
+	for _, m := range httpMethods {
+		if len(b) > len(m) && b[len(m)] == ' ' && string(b[:len(m)]) == m {
+			return true
+		}
+	}
+	return false
+}
+
+// LocateHTTPHost finds the Host header of a plain HTTP request. It returns the
+// offset of the header name and the bounds of its value (without surrounding
+// whitespace).
+func LocateHTTPHost(b []byte) (nameStart, valueStart, valueEnd int, ok bool) {
+	if !isHTTPRequest(b) {
+		return 0, 0, 0, false
+	}
+
+	p := bytes.Index(b, []byte("\r\n"))
+	if p < 0 {
+		return 0, 0, 0, false
+	}
+	p += 2
+
+	for p < len(b) {
+		eol := bytes.Index(b[p:], []byte("\r\n"))
+		if eol <= 0 {
+			// End of headers or truncated line
+			return 0, 0, 0, false
+		}
+		line := b[p : p+eol]
+
+		if len(line) > 5 && line[4] == ':' && strings.EqualFold(string(line[:4]), "host") {
+			vs := 5
+			for vs < len(line) && (line[vs] == ' ' || line[vs] == '\t') {
+				vs++
+			}
+			ve := len(line)
+			for ve > vs && (line[ve-1] == ' ' || line[ve-1] == '\t') {
+				ve--
+			}
+			if ve == vs {
+				return 0, 0, 0, false
+			}
+			return p, p + vs, p + ve, true
+		}
+
+		p += eol + 2
+	}
+
+	return 0, 0, 0, false
+}
+
+// ParseHTTPRequestHost extracts the host name from the Host header of a plain
+// HTTP request, without port and trailing dot.
+func ParseHTTPRequestHost(b []byte) (string, bool) {
+	_, vs, ve, ok := LocateHTTPHost(b)
+	if !ok {
+		return "", false
+	}
+
+	host := string(b[vs:ve])
+	if strings.HasPrefix(host, "[") {
+		// IPv6 literal, nothing to match by name
+		return "", false
+	}
+	if i := strings.IndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.TrimRight(strings.ToLower(host), ".")
+
+	if !validateSNI(host) {
+		return "", false
+	}
+	return host, true
+}
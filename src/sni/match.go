@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,9 +14,11 @@ import (
 	"github.com/yl2chen/cidranger"
 )
 
+// ipRange is a network in the ranger with the sets listing it, in order of
+// precedence
 type ipRange struct {
 	ipNet *net.IPNet
-	set   *config.SetConfig
+	sets  []*config.SetConfig
 }
 
 type portRange struct {
@@ -24,13 +27,29 @@ type portRange struct {
 	set *config.SetConfig
 }
 
+// SuffixSet matches hosts against the domain rules of the enabled sets.
+// Rules follow the geosite syntax: "full:" matches the host exactly,
+// "keyword:" anywhere in it, "regexp:" by expression, and "domain:" or no
+// prefix matches the domain and its subdomains. A set never matches a host
+// or IP it excludes; the next set targeting it is tried instead.
+//
+// When several sets match, the most specific rule wins: a full match, then
+// the longest domain suffix, then keywords and then regexps. For IPs the
+// longest prefix wins. Between equally specific rules the set with the
+// higher priority wins, and then the one listed first.
 type SuffixSet struct {
-	sets       map[string]*config.SetConfig
+	sets       map[string][]*config.SetConfig
+	full       map[string][]*config.SetConfig
+	keywords   []keywordWithSet
+	excludes   map[*config.SetConfig]*exclusions
+	rank       map[*config.SetConfig]int
 	regexes    []*regexWithSet
 	regexCache sync.Map
 	ipRanger   cidranger.Ranger
 	portRanges []portRange
 
+	tcpPortRanges []portRange
+
 	ipCache      map[string]*cacheEntry
 	ipCacheLRU   *list.List
 	ipCacheMu    sync.RWMutex
@@ -42,6 +61,13 @@ type SuffixSet struct {
 	domainCacheLimit int
 
 	regexCacheSize int32
+
+	ipCacheHits       uint64
+	ipCacheMisses     uint64
+	domainCacheHits   uint64
+	domainCacheMisses uint64
+	regexCacheHits    uint64
+	regexCacheMisses  uint64
 }
 
 type cacheEntry struct {
@@ -50,6 +76,11 @@ type cacheEntry struct {
 	element *list.Element
 }
 
+type keywordWithSet struct {
+	keyword string
+	set     *config.SetConfig
+}
+
 type regexWithSet struct {
 	regex *regexp.Regexp
 	set   *config.SetConfig
@@ -61,7 +92,10 @@ func (e *ipRange) Network() net.IPNet {
 
 func NewSuffixSet(sets []*config.SetConfig) *SuffixSet {
 	s := &SuffixSet{
-		sets:     make(map[string]*config.SetConfig),
+		sets:     make(map[string][]*config.SetConfig),
+		full:     make(map[string][]*config.SetConfig),
+		excludes: make(map[*config.SetConfig]*exclusions),
+		rank:     make(map[*config.SetConfig]int),
 		regexes:  make([]*regexWithSet, 0),
 		ipRanger: cidranger.NewPCTrieRanger(),
 
@@ -75,97 +109,200 @@ func NewSuffixSet(sets []*config.SetConfig) *SuffixSet {
 	}
 
 	seenRegexes := make(map[string]bool)
+	seenKeywords := make(map[string]bool)
+	networks := make(map[string]*ipRange)
 
-	for _, set := range sets {
+	for i, set := range sets {
 		if !set.Enabled {
 			continue
 		}
+		s.rank[set] = i
+
+		if e := newExclusions(set); e != nil {
+			s.excludes[set] = e
+		}
 
 		for _, d := range set.Targets.DomainsToMatch {
-			d = strings.ToLower(strings.TrimSpace(d))
-			if d == "" {
+			kind, value := parseDomainRule(d)
+			if value == "" {
 				continue
 			}
 
-			// Handle regex patterns
-			if strings.HasPrefix(d, "regexp:") {
-				pattern := strings.TrimPrefix(d, "regexp:")
-				if seenRegexes[pattern] {
+			// The same rule of several sets is kept once per set so that
+			// a set excluding a host leaves it to the next one
+			seen := set.Id + "\x00" + value
+			switch kind {
+			case "regexp":
+				if seenRegexes[seen] {
 					continue
 				}
-				if re, err := regexp.Compile(pattern); err == nil {
+				if re, err := regexp.Compile(value); err == nil {
 					s.regexes = append(s.regexes, &regexWithSet{regex: re, set: set})
-					seenRegexes[pattern] = true
+					seenRegexes[seen] = true
 				}
-				continue
-			}
-
-			// Regular domain
-			d = strings.TrimRight(d, ".")
-			if _, exists := s.sets[d]; !exists {
-				s.sets[d] = set
+			case "keyword":
+				if seenKeywords[seen] {
+					continue
+				}
+				s.keywords = append(s.keywords, keywordWithSet{keyword: value, set: set})
+				seenKeywords[seen] = true
+			case "full":
+				s.full[value] = appendSet(s.full[value], set)
+			default:
+				s.sets[value] = appendSet(s.sets[value], set)
 			}
 		}
 
 		for _, ipStr := range set.Targets.IpsToMatch {
-			ipStr = strings.TrimSpace(ipStr)
-			if ipStr == "" {
+			ipNet := parseIPNet(ipStr)
+			if ipNet == nil {
 				continue
 			}
-
-			var ipNet *net.IPNet
-			var err error
-
-			if strings.Contains(ipStr, "/") {
-				_, ipNet, err = net.ParseCIDR(ipStr)
-			} else {
-				ip := net.ParseIP(ipStr)
-				if ip != nil {
-					if ip.To4() != nil {
-						_, ipNet, _ = net.ParseCIDR(ipStr + "/32")
-					} else {
-						_, ipNet, _ = net.ParseCIDR(ipStr + "/128")
-					}
-				}
+			// The ranger keeps one entry per network, so every set listing
+			// the network goes in that entry
+			if entry, ok := networks[ipNet.String()]; ok {
+				entry.sets = appendSet(entry.sets, set)
+				continue
 			}
+			entry := &ipRange{ipNet: ipNet, sets: []*config.SetConfig{set}}
+			networks[ipNet.String()] = entry
+			_ = s.ipRanger.Insert(entry)
+		}
 
-			if err == nil && ipNet != nil {
-				// Store set config in the ranger entry
-				entry := &ipRange{ipNet: ipNet, set: set}
-				_ = s.ipRanger.Insert(entry)
-			}
+		s.portRanges = append(s.portRanges, parsePortRanges(set.UDP.DPortFilter, set)...)
+		s.tcpPortRanges = append(s.tcpPortRanges, parsePortRanges(set.TCP.Ports(), set)...)
+	}
+
+	for _, m := range []map[string][]*config.SetConfig{s.sets, s.full} {
+		for _, candidates := range m {
+			sort.SliceStable(candidates, func(i, j int) bool { return s.before(candidates[i], candidates[j]) })
 		}
+	}
+	for _, entry := range networks {
+		sort.SliceStable(entry.sets, func(i, j int) bool { return s.before(entry.sets[i], entry.sets[j]) })
+	}
+	sort.SliceStable(s.keywords, func(i, j int) bool { return s.before(s.keywords[i].set, s.keywords[j].set) })
+	sort.SliceStable(s.regexes, func(i, j int) bool { return s.before(s.regexes[i].set, s.regexes[j].set) })
+	sort.SliceStable(s.portRanges, func(i, j int) bool { return s.before(s.portRanges[i].set, s.portRanges[j].set) })
+	sort.SliceStable(s.tcpPortRanges, func(i, j int) bool { return s.before(s.tcpPortRanges[i].set, s.tcpPortRanges[j].set) })
 
-		if set.UDP.DPortFilter != "" {
-			ports := strings.Split(set.UDP.DPortFilter, ",")
-			for _, part := range ports {
-				part = strings.TrimSpace(part)
-				if part == "" {
-					continue
-				}
+	return s
+}
 
-				if strings.Contains(part, "-") {
-					bounds := strings.SplitN(part, "-", 2)
-					if len(bounds) == 2 {
-						min, err1 := strconv.Atoi(bounds[0])
-						max, err2 := strconv.Atoi(bounds[1])
-						if err1 == nil && err2 == nil {
-							if min >= 0 && max >= 0 && min <= max {
-								s.portRanges = append(s.portRanges, portRange{min: min, max: max, set: set})
-							}
-						}
-					}
-				} else {
-					port, err := strconv.Atoi(part)
-					if err == nil && port >= 0 {
-						s.portRanges = append(s.portRanges, portRange{min: port, max: port, set: set})
+// before reports whether a takes precedence over b when both match equally
+// specific rules
+func (s *SuffixSet) before(a, b *config.SetConfig) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return s.rank[a] < s.rank[b]
+}
+
+// parseDomainRule splits a domain rule into its geosite type and value.
+// Rules without a known type prefix are domain rules.
+func parseDomainRule(d string) (string, string) {
+	d = strings.ToLower(strings.TrimSpace(d))
+	kind, value, ok := strings.Cut(d, ":")
+	switch {
+	case !ok:
+		kind, value = "domain", d
+	case kind == "regexp" || kind == "keyword":
+		return kind, value
+	case kind != "full" && kind != "domain":
+		// Unknown prefix, keep the whole entry as a domain
+		kind, value = "domain", d
+	}
+	return kind, strings.TrimRight(value, ".")
+}
+
+// parseIPNet parses a CIDR or a single address
+func parseIPNet(s string) *net.IPNet {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil
+		}
+		return ipNet
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func appendSet(sets []*config.SetConfig, set *config.SetConfig) []*config.SetConfig {
+	for _, s := range sets {
+		if s == set {
+			return sets
+		}
+	}
+	return append(sets, set)
+}
+
+// pick returns the first of sets that excludes neither host nor ip. A nil
+// ip is not checked.
+func (s *SuffixSet) pick(sets []*config.SetConfig, host string, ip net.IP) *config.SetConfig {
+	for _, set := range sets {
+		if !s.excluded(set, host, ip) {
+			return set
+		}
+	}
+	return nil
+}
+
+func (s *SuffixSet) excluded(set *config.SetConfig, host string, ip net.IP) bool {
+	e := s.excludes[set]
+	return e.matchHost(host) || (ip != nil && e.matchIP(ip))
+}
+
+// Excludes reports whether set excludes host or ip. An empty host or a nil
+// ip is not checked.
+func (s *SuffixSet) Excludes(set *config.SetConfig, host string, ip net.IP) bool {
+	if s == nil || set == nil {
+		return false
+	}
+	e := s.excludes[set]
+	if host != "" && e.matchHost(strings.ToLower(host)) {
+		return true
+	}
+	return ip != nil && e.matchIP(ip)
+}
+
+func parsePortRanges(filter string, set *config.SetConfig) []portRange {
+	var ranges []portRange
+	for _, part := range strings.Split(filter, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.Contains(part, "-") {
+			bounds := strings.SplitN(part, "-", 2)
+			if len(bounds) == 2 {
+				min, err1 := strconv.Atoi(bounds[0])
+				max, err2 := strconv.Atoi(bounds[1])
+				if err1 == nil && err2 == nil {
+					if min >= 0 && max >= 0 && min <= max {
+						ranges = append(ranges, portRange{min: min, max: max, set: set})
 					}
 				}
 			}
+		} else {
+			port, err := strconv.Atoi(part)
+			if err == nil && port >= 0 {
+				ranges = append(ranges, portRange{min: port, max: port, set: set})
+			}
 		}
 	}
-
-	return s
+	return ranges
 }
 
 func (s *SuffixSet) MatchUDPPort(dport uint16) (bool, *config.SetConfig) {
@@ -187,13 +324,13 @@ func (s *SuffixSet) MatchUDPPort(dport uint16) (bool, *config.SetConfig) {
 }
 
 func (s *SuffixSet) MatchSNI(host string) (bool, *config.SetConfig) {
-	if s == nil || (len(s.sets) == 0 && len(s.regexes) == 0) || host == "" {
+	if s == nil || (len(s.sets) == 0 && len(s.full) == 0 && len(s.keywords) == 0 && len(s.regexes) == 0) || host == "" {
 		return false, nil
 	}
 
 	lower := strings.ToLower(host)
 
-	// Check exact/suffix match first (fast)
+	// Check exact, suffix and keyword rules first (fast)
 	if matched, set := s.matchDomain(lower); matched {
 		return true, set
 	}
@@ -205,6 +342,20 @@ func (s *SuffixSet) MatchSNI(host string) (bool, *config.SetConfig) {
 	return false, nil
 }
 
+// MatchSNIForIP is MatchSNI skipping the sets that exclude ip, for a host
+// whose first match excludes the address it connects to. It is not cached.
+func (s *SuffixSet) MatchSNIForIP(host string, ip net.IP) (bool, *config.SetConfig) {
+	if s == nil || host == "" {
+		return false, nil
+	}
+	lower := strings.ToLower(host)
+	set := s.findDomain(lower, ip)
+	if set == nil {
+		set = s.findRegex(lower, ip)
+	}
+	return set != nil, set
+}
+
 func (s *SuffixSet) MatchIP(ip net.IP) (bool, *config.SetConfig) {
 	if s == nil || s.ipRanger == nil || ip == nil {
 		return false, nil
@@ -222,21 +373,55 @@ func (s *SuffixSet) MatchIP(ip net.IP) (bool, *config.SetConfig) {
 			s.ipCacheLRU.MoveToFront(entry.element)
 			matched, set := entry.matched, entry.set
 			s.ipCacheMu.Unlock()
+			atomic.AddUint64(&s.ipCacheHits, 1)
 			return matched, set
 		}
 		s.ipCacheMu.Unlock()
 	}
+	atomic.AddUint64(&s.ipCacheMisses, 1)
 
-	entries, err := s.ipRanger.ContainingNetworks(ip)
-	if err != nil || len(entries) == 0 {
+	best := s.matchIP(ip, "")
+	if best == nil {
 		s.cacheIPResult(ipStr, false, nil)
 		return false, nil
 	}
+	s.cacheIPResult(ipStr, true, best)
+	return true, best
+}
 
-	matchedEntry := entries[0].(*ipRange)
-	s.cacheIPResult(ipStr, true, matchedEntry.set)
+// MatchIPForHost is MatchIP for a connection to host, skipping the sets
+// that exclude host. It is not cached.
+func (s *SuffixSet) MatchIPForHost(ip net.IP, host string) (bool, *config.SetConfig) {
+	if s == nil || s.ipRanger == nil || ip == nil {
+		return false, nil
+	}
+	set := s.matchIP(ip, strings.ToLower(host))
+	return set != nil, set
+}
 
-	return true, matchedEntry.set
+// matchIP returns the set of the longest network containing ip, taking the
+// first set listing it that excludes neither ip nor host
+func (s *SuffixSet) matchIP(ip net.IP, host string) *config.SetConfig {
+	var best *config.SetConfig
+	bestOnes := -1
+	entries, err := s.ipRanger.ContainingNetworks(ip)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		r := entry.(*ipRange)
+		ones, _ := r.ipNet.Mask.Size()
+		if ones <= bestOnes {
+			continue
+		}
+		for _, set := range r.sets {
+			if e := s.excludes[set]; !e.matchIP(ip) && !e.matchHost(host) {
+				best, bestOnes = set, ones
+				break
+			}
+		}
+	}
+	return best
 }
 
 func (s *SuffixSet) cacheIPResult(ipStr string, matched bool, set *config.SetConfig) {
@@ -271,32 +456,15 @@ func (s *SuffixSet) matchDomain(host string) (bool, *config.SetConfig) {
 			s.domainCacheLRU.MoveToFront(entry.element)
 			matched, set := entry.matched, entry.set
 			s.domainCacheMu.Unlock()
+			atomic.AddUint64(&s.domainCacheHits, 1)
 			return matched, set
 		}
 		s.domainCacheMu.Unlock()
 	}
+	atomic.AddUint64(&s.domainCacheMisses, 1)
 
-	var matched bool
-	var matchedSet *config.SetConfig
-
-	if set, ok := s.sets[host]; ok {
-		matched = true
-		matchedSet = set
-	} else {
-		remaining := host
-		for {
-			idx := strings.IndexByte(remaining, '.')
-			if idx == -1 {
-				break
-			}
-			remaining = remaining[idx+1:]
-			if set, ok := s.sets[remaining]; ok {
-				matched = true
-				matchedSet = set
-				break
-			}
-		}
-	}
+	matchedSet := s.findDomain(host, nil)
+	matched := matchedSet != nil
 
 	// Update cache
 	s.domainCacheMu.Lock()
@@ -320,21 +488,54 @@ func (s *SuffixSet) matchDomain(host string) (bool, *config.SetConfig) {
 	return matched, matchedSet
 }
 
+// findDomain returns the set the exact, suffix and keyword rules give host,
+// skipping the sets that exclude host or ip
+func (s *SuffixSet) findDomain(host string, ip net.IP) *config.SetConfig {
+	if set := s.pick(s.full[host], host, ip); set != nil {
+		return set
+	}
+	if set := s.pick(s.sets[host], host, ip); set != nil {
+		return set
+	}
+	remaining := host
+	for {
+		idx := strings.IndexByte(remaining, '.')
+		if idx == -1 {
+			break
+		}
+		remaining = remaining[idx+1:]
+		if set := s.pick(s.sets[remaining], host, ip); set != nil {
+			return set
+		}
+	}
+	for _, kw := range s.keywords {
+		if strings.Contains(host, kw.keyword) && !s.excluded(kw.set, host, ip) {
+			return kw.set
+		}
+	}
+	return nil
+}
+
+// findRegex is findDomain for the regex rules
+func (s *SuffixSet) findRegex(host string, ip net.IP) *config.SetConfig {
+	for _, rws := range s.regexes {
+		if rws.regex.MatchString(host) && !s.excluded(rws.set, host, ip) {
+			return rws.set
+		}
+	}
+	return nil
+}
+
 func (s *SuffixSet) matchRegex(host string) (bool, *config.SetConfig) {
 	if cached, ok := s.regexCache.Load(host); ok {
 		entry := cached.(cacheEntry)
+		atomic.AddUint64(&s.regexCacheHits, 1)
 		return entry.matched, entry.set
 	}
+	atomic.AddUint64(&s.regexCacheMisses, 1)
 
-	var matched bool
-	var matchedSet *config.SetConfig
-	for _, rws := range s.regexes {
-		if rws.regex.MatchString(host) {
-			matched = true
-			matchedSet = rws.set
-			break
-		}
-	}
+	matchedSet := s.findRegex(host, nil)
+	matched := matchedSet != nil
 
 	if atomic.LoadInt32(&s.regexCacheSize) < 2000 {
 		s.regexCache.Store(host, cacheEntry{matched: matched, set: matchedSet})
@@ -360,12 +561,18 @@ func (s *SuffixSet) GetCacheStats() map[string]interface{} {
 	regexCacheSize := atomic.LoadInt32(&s.regexCacheSize)
 
 	return map[string]interface{}{
-		"ip_cache_size":      ipCacheSize,
-		"ip_cache_limit":     s.ipCacheLimit,
-		"domain_cache_size":  domainCacheSize,
-		"domain_cache_limit": s.domainCacheLimit,
-		"regex_cache_size":   regexCacheSize,
-		"regex_cache_limit":  10000,
+		"ip_cache_size":       ipCacheSize,
+		"ip_cache_limit":      s.ipCacheLimit,
+		"domain_cache_size":   domainCacheSize,
+		"domain_cache_limit":  s.domainCacheLimit,
+		"regex_cache_size":    regexCacheSize,
+		"regex_cache_limit":   10000,
+		"ip_cache_hits":       atomic.LoadUint64(&s.ipCacheHits),
+		"ip_cache_misses":     atomic.LoadUint64(&s.ipCacheMisses),
+		"domain_cache_hits":   atomic.LoadUint64(&s.domainCacheHits),
+		"domain_cache_misses": atomic.LoadUint64(&s.domainCacheMisses),
+		"regex_cache_hits":    atomic.LoadUint64(&s.regexCacheHits),
+		"regex_cache_misses":  atomic.LoadUint64(&s.regexCacheMisses),
 	}
 }
 
@@ -394,3 +601,34 @@ func (s *SuffixSet) MatchUDPPortOnly(dport uint16) (bool, *config.SetConfig) {
 	}
 	return false, nil
 }
+
+// IsTCPTargetPort reports whether any enabled set targets the TCP port
+func (s *SuffixSet) IsTCPTargetPort(dport uint16) bool {
+	if s == nil {
+		return dport == 443
+	}
+	port := int(dport)
+	for _, r := range s.tcpPortRanges {
+		if port >= r.min && port <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// TCPPortMatchesSet reports whether the TCP port is targeted by targetSet
+func (s *SuffixSet) TCPPortMatchesSet(dport uint16, targetSet *config.SetConfig) bool {
+	if targetSet == nil {
+		return false
+	}
+	if s == nil {
+		return dport == 443
+	}
+	port := int(dport)
+	for _, r := range s.tcpPortRanges {
+		if r.set == targetSet && port >= r.min && port <= r.max {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package sni
+
+import (
+	"net"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestMatchSNIRuleTypes(t *testing.T) {
+	set := config.NewSetConfig()
+	set.Enabled = true
+	set.Targets.DomainsToMatch = []string{
+		"full:x.com",
+		"keyword:google",
+		"domain:example.org",
+		"youtube.com",
+		"regexp:^api[0-9]+\\.test$",
+	}
+	m := NewSuffixSet([]*config.SetConfig{&set})
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"x.com", true},
+		{"a.x.com", false},
+		{"foo-google.com", true},
+		{"googleapis.net", true},
+		{"example.org", true},
+		{"www.example.org", true},
+		{"notexample.org", false},
+		{"m.youtube.com", true},
+		{"api12.test", true},
+		{"api.test", false},
+		{"other.net", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, matched := m.MatchSNI(tt.host)
+			if got != tt.want {
+				t.Fatalf("MatchSNI(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+			if got && matched != &set {
+				t.Errorf("MatchSNI(%q) returned the wrong set", tt.host)
+			}
+		})
+	}
+}
+
+func TestMatchExclusions(t *testing.T) {
+	google := config.NewSetConfig()
+	google.Name = "google"
+	google.Enabled = true
+	google.Targets.DomainsToMatch = []string{"google.com", "keyword:gstatic"}
+	google.Targets.IpsToMatch = []string{"10.0.0.0/8"}
+	google.Targets.DomainsToExclude = []string{"accounts.google.com", "full:fonts.gstatic.com"}
+	google.Targets.IpsToExclude = []string{"10.1.0.0/16"}
+
+	fallback := config.NewSetConfig()
+	fallback.Name = "fallback"
+	fallback.Enabled = true
+	fallback.Targets.DomainsToMatch = []string{"google.com"}
+	fallback.Targets.IpsToMatch = []string{"10.1.2.0/24"}
+
+	m := NewSuffixSet([]*config.SetConfig{&google, &fallback})
+
+	hosts := []struct {
+		host string
+		want *config.SetConfig
+	}{
+		{"mail.google.com", &google},
+		{"accounts.google.com", &fallback},
+		{"login.accounts.google.com", &fallback},
+		{"www.gstatic.com", &google},
+		{"fonts.gstatic.com", nil},
+	}
+	for _, tt := range hosts {
+		t.Run(tt.host, func(t *testing.T) {
+			matched, set := m.MatchSNI(tt.host)
+			if matched != (tt.want != nil) || set != tt.want {
+				t.Errorf("MatchSNI(%q) = %v, %v", tt.host, matched, set)
+			}
+		})
+	}
+
+	ips := []struct {
+		ip   string
+		want *config.SetConfig
+	}{
+		{"10.2.0.1", &google},
+		{"10.1.2.3", &fallback},
+		{"10.1.3.3", nil},
+	}
+	for _, tt := range ips {
+		t.Run(tt.ip, func(t *testing.T) {
+			matched, set := m.MatchIP(net.ParseIP(tt.ip))
+			if matched != (tt.want != nil) || set != tt.want {
+				t.Errorf("MatchIP(%s) = %v, %v", tt.ip, matched, set)
+			}
+		})
+	}
+
+	if !m.Excludes(&google, "Accounts.Google.com", nil) || m.Excludes(&google, "", net.ParseIP("10.2.0.1")) {
+		t.Error("Excludes disagrees with the exclusion lists")
+	}
+
+	domains, nets := EffectiveTargets(&config.SetConfig{Targets: config.TargetsConfig{
+		DomainsToMatch:   []string{"a.google.com", "accounts.google.com", "keyword:google"},
+		IpsToMatch:       []string{"10.1.2.0/24", "10.0.0.0/8", "10.1.0.1"},
+		DomainsToExclude: google.Targets.DomainsToExclude,
+		IpsToExclude:     google.Targets.IpsToExclude,
+	}})
+	if domains != 2 || nets != 1 {
+		t.Errorf("EffectiveTargets = %d, %d, want 2, 1", domains, nets)
+	}
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	newSet := func(name string, priority int, domains, ips []string) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Enabled = true
+		set.Priority = priority
+		set.Targets.DomainsToMatch = domains
+		set.Targets.IpsToMatch = ips
+		return &set
+	}
+	broad := newSet("broad", 0, []string{"google.com", "keyword:video"}, []string{"10.0.0.0/8"})
+	narrow := newSet("narrow", 0, []string{"youtube.google.com", "regexp:video"}, []string{"10.1.0.0/16"})
+	exact := newSet("exact", 0, []string{"full:www.google.com"}, nil)
+	urgent := newSet("urgent", 5, []string{"google.com"}, []string{"10.1.0.0/16"})
+	m := NewSuffixSet([]*config.SetConfig{broad, narrow, exact, urgent})
+
+	hosts := []struct {
+		host string
+		want *config.SetConfig
+	}{
+		{"m.youtube.google.com", narrow},
+		{"www.google.com", exact},
+		{"mail.google.com", urgent},
+		{"video.example", broad},
+	}
+	for _, tt := range hosts {
+		if _, set := m.MatchSNI(tt.host); set != tt.want {
+			t.Errorf("MatchSNI(%q) = %v, want %s", tt.host, set, tt.want.Name)
+		}
+	}
+
+	ips := []struct {
+		ip   string
+		want *config.SetConfig
+	}{
+		{"10.2.0.1", broad},
+		{"10.1.0.1", urgent},
+	}
+	for _, tt := range ips {
+		if _, set := m.MatchIP(net.ParseIP(tt.ip)); set != tt.want {
+			t.Errorf("MatchIP(%s) = %v, want %s", tt.ip, set, tt.want.Name)
+		}
+	}
+
+	e := m.Explain("mail.google.com", net.ParseIP("10.2.0.1"), 443, false)
+	if e.SetName != "urgent" || e.By != "sni" {
+		t.Errorf("Explain = %+v", e)
+	}
+	var bySNI int
+	for _, c := range e.Candidates {
+		if c.By == "sni" {
+			bySNI++
+		}
+	}
+	if bySNI != 2 {
+		t.Errorf("expected 2 sni candidates, got %d", bySNI)
+	}
+	e = m.Explain("", net.ParseIP("10.2.0.1"), 8443, false)
+	if e.Set != nil || e.Reason == "" {
+		t.Errorf("port 8443 should not match: %+v", e)
+	}
+}
+
+func TestMatchSameNetwork(t *testing.T) {
+	newSet := func(name string, priority int) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Enabled = true
+		set.Priority = priority
+		set.Targets.IpsToMatch = []string{"192.0.2.0/24"}
+		return &set
+	}
+	a := newSet("a", 10)
+	b := newSet("b", 0)
+
+	for _, sets := range [][]*config.SetConfig{{a, b}, {b, a}} {
+		m := NewSuffixSet(sets)
+		if _, set := m.MatchIP(net.ParseIP("192.0.2.1")); set != a {
+			t.Errorf("sets %s, %s: MatchIP = %v, want a", sets[0].Name, sets[1].Name, set)
+		}
+
+		var byIP int
+		for _, c := range m.Explain("", net.ParseIP("192.0.2.1"), 443, false).Candidates {
+			if c.By == "ip" {
+				byIP++
+			}
+		}
+		if byIP != 2 {
+			t.Errorf("expected 2 ip candidates, got %d", byIP)
+		}
+	}
+}
+
+func TestMatchIPExcludedFallsThrough(t *testing.T) {
+	newSet := func(name string, priority int) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Enabled = true
+		set.Priority = priority
+		set.Targets.IpsToMatch = []string{"192.0.2.0/24"}
+		return &set
+	}
+	a := newSet("a", 10)
+	a.Targets.IpsToExclude = []string{"192.0.2.5"}
+	a.Targets.DomainsToExclude = []string{"skip.example"}
+	b := newSet("b", 0)
+	m := NewSuffixSet([]*config.SetConfig{a, b})
+
+	if _, set := m.MatchIP(net.ParseIP("192.0.2.5")); set != b {
+		t.Errorf("MatchIP of an address a excludes = %v, want b", set)
+	}
+	if _, set := m.MatchIP(net.ParseIP("192.0.2.1")); set != a {
+		t.Errorf("MatchIP = %v, want a", set)
+	}
+	if _, set := m.MatchIPForHost(net.ParseIP("192.0.2.1"), "www.skip.example"); set != b {
+		t.Errorf("MatchIPForHost of a host a excludes = %v, want b", set)
+	}
+	if _, set := m.MatchIPForHost(net.ParseIP("192.0.2.1"), "other.example"); set != a {
+		t.Errorf("MatchIPForHost = %v, want a", set)
+	}
+}
+
+func TestMatchSNIForIP(t *testing.T) {
+	newSet := func(name string, priority int) *config.SetConfig {
+		set := config.NewSetConfig()
+		set.Id, set.Name = name, name
+		set.Enabled = true
+		set.Priority = priority
+		set.Targets.DomainsToMatch = []string{"example.com", "regexp:^api\\."}
+		return &set
+	}
+	a := newSet("a", 10)
+	a.Targets.IpsToExclude = []string{"192.0.2.0/24"}
+	b := newSet("b", 0)
+	m := NewSuffixSet([]*config.SetConfig{a, b})
+
+	excluded, other := net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.1")
+	for _, host := range []string{"www.example.com", "api.example.org"} {
+		if _, set := m.MatchSNI(host); set != a {
+			t.Errorf("MatchSNI(%q) = %v, want a", host, set)
+		}
+		if _, set := m.MatchSNIForIP(host, excluded); set != b {
+			t.Errorf("MatchSNIForIP(%q) of an address a excludes = %v, want b", host, set)
+		}
+		if _, set := m.MatchSNIForIP(host, other); set != a {
+			t.Errorf("MatchSNIForIP(%q) = %v, want a", host, set)
+		}
+	}
+	if matched, _ := m.MatchSNIForIP("other.org", other); matched {
+		t.Error("MatchSNIForIP matched an unlisted host")
+	}
+}
@@ -44,3 +44,36 @@ func BenchmarkStripSACKFromTCP(b *testing.B) {
 		StripSACKFromTCP(pkt)
 	}
 }
+
+func benchmarkPackets(n, size int) [][]byte {
+	packets := make([][]byte, n)
+	for i := range packets {
+		packets[i] = make([]byte, size)
+	}
+	return packets
+}
+
+func BenchmarkSendtoLoop(b *testing.B) {
+	fd, addr, _ := loopbackUDP(b)
+	s := &Sender{}
+	packets := benchmarkPackets(10, 200)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.sendAll(fd, packets, addr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendmmsg(b *testing.B) {
+	fd, addr, _ := loopbackUDP(b)
+	packets := benchmarkPackets(10, 200)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sendmmsg(fd, packets, addr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
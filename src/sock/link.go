@@ -0,0 +1,372 @@
+package sock
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// linkHopTTL is how long a resolved next hop is reused
+	linkHopTTL = 30 * time.Second
+	// linkMissTTL is how long a destination without a usable next hop goes
+	// through the raw socket before it is looked up again
+	linkMissTTL = 5 * time.Second
+	// linkHopLimit caps how many destinations keep a next hop
+	linkHopLimit = 1024
+)
+
+var errNoNextHop = errors.New("no usable next hop")
+
+// nextHop is where frames for a destination go
+type nextHop struct {
+	ifindex int
+	src     net.HardwareAddr // nil when the interface has no link-layer header
+	dst     net.HardwareAddr
+	local   []net.IP // addresses of the interface
+	expires time.Time
+	err     error
+	element *list.Element
+}
+
+// linkSender writes packets as link-layer frames through an AF_PACKET
+// socket, bypassing netfilter. The egress interface and gateway come from
+// the routing table, the gateway MAC from the neighbor table.
+//
+// Next hops are cached per destination for linkHopTTL, least recently used
+// first out once linkHopLimit destinations are held. A failed send drops the
+// entry so the next packet resolves the route and neighbor again.
+type linkSender struct {
+	fd   int
+	mark int
+
+	mu     sync.Mutex
+	hops   map[string]*nextHop
+	hopLRU *list.List
+}
+
+func newLinkSender(mark int) (*linkSender, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &linkSender{fd: fd, mark: mark, hops: make(map[string]*nextHop), hopLRU: list.New()}, nil
+}
+
+func (l *linkSender) close() {
+	_ = syscall.Close(l.fd)
+}
+
+// frames wraps packets to dst for the egress interface. It fails when dst
+// has no usable next hop or the packets do not come from an address of the
+// egress interface, as happens for forwarded packets before NAT.
+func (l *linkSender) frames(packets [][]byte, dst net.IP) ([][]byte, *syscall.SockaddrLinklayer, error) {
+	if len(packets) == 0 {
+		return nil, nil, errNoNextHop
+	}
+	hop := l.nextHop(dst)
+	if hop.err != nil {
+		return nil, nil, hop.err
+	}
+	if !hop.isLocal(packetSource(packets[0])) {
+		return nil, nil, errNoNextHop
+	}
+
+	proto := uint16(unix.ETH_P_IP)
+	if dst.To4() == nil {
+		proto = unix.ETH_P_IPV6
+	}
+
+	frames := packets
+	if hop.src != nil {
+		frames = make([][]byte, len(packets))
+		for i, p := range packets {
+			frames[i] = ethernetFrame(hop.dst, hop.src, proto, p)
+		}
+	}
+
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(proto), Ifindex: hop.ifindex, Halen: uint8(len(hop.dst))}
+	copy(addr.Addr[:], hop.dst)
+	return frames, addr, nil
+}
+
+func (l *linkSender) nextHop(dst net.IP) *nextHop {
+	key := dst.String()
+	now := time.Now()
+
+	l.mu.Lock()
+	hop, ok := l.hops[key]
+	if ok && now.Before(hop.expires) {
+		l.hopLRU.MoveToFront(hop.element)
+		l.mu.Unlock()
+		return hop
+	}
+	l.mu.Unlock()
+
+	// The route and neighbor dumps run unlocked so other destinations are
+	// not held up; concurrent misses for one destination may resolve twice
+	hop = resolveNextHop(dst, l.mark)
+	if hop.err != nil {
+		hop.expires = now.Add(linkMissTTL)
+	} else {
+		hop.expires = now.Add(linkHopTTL)
+	}
+
+	l.mu.Lock()
+	l.storeHop(key, hop)
+	l.mu.Unlock()
+	return hop
+}
+
+// storeHop caches hop for key, evicting the least recently used entry when
+// the cache is full. l.mu must be held.
+func (l *linkSender) storeHop(key string, hop *nextHop) {
+	if old, ok := l.hops[key]; ok {
+		l.hopLRU.Remove(old.element)
+	} else if len(l.hops) >= linkHopLimit {
+		if oldest := l.hopLRU.Back(); oldest != nil {
+			delete(l.hops, oldest.Value.(string))
+			l.hopLRU.Remove(oldest)
+		}
+	}
+	hop.element = l.hopLRU.PushFront(key)
+	l.hops[key] = hop
+}
+
+// forget drops the cached next hop of dst, so the next packet resolves it
+// again instead of reusing a MAC the neighbor table may no longer hold
+func (l *linkSender) forget(dst net.IP) {
+	key := dst.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if hop, ok := l.hops[key]; ok {
+		l.hopLRU.Remove(hop.element)
+		delete(l.hops, key)
+	}
+}
+
+func (h *nextHop) isLocal(ip net.IP) bool {
+	for _, a := range h.local {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveNextHop(dst net.IP, mark int) *nextHop {
+	ifindex, gw, err := routeGet(dst, mark)
+	if err != nil {
+		return &nextHop{err: err}
+	}
+	iface, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return &nextHop{err: err}
+	}
+
+	hop := &nextHop{ifindex: ifindex}
+	addrs, _ := iface.Addrs()
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			hop.local = append(hop.local, ipn.IP)
+		}
+	}
+
+	// Point-to-point links such as PPPoE and tun carry bare IP packets
+	if len(iface.HardwareAddr) != 6 || iface.Flags&net.FlagPointToPoint != 0 {
+		return hop
+	}
+	hop.src = iface.HardwareAddr
+
+	if gw == nil {
+		gw = dst
+	}
+	hop.dst, err = neighLookup(ifindex, gw)
+	if err != nil {
+		return &nextHop{err: err}
+	}
+	return hop
+}
+
+func ethernetFrame(dst, src net.HardwareAddr, proto uint16, packet []byte) []byte {
+	frame := make([]byte, 14+len(packet))
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], proto)
+	copy(frame[14:], packet)
+	return frame
+}
+
+func packetSource(packet []byte) net.IP {
+	switch {
+	case len(packet) >= 20 && packet[0]>>4 == 4:
+		return net.IP(packet[12:16])
+	case len(packet) >= 40 && packet[0]>>4 == 6:
+		return net.IP(packet[8:24])
+	}
+	return nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// netlinkRequest sends one rtnetlink request and returns the messages of
+// the answer
+func netlinkRequest(msgType uint16, flags uint16, body []byte) ([]syscall.NetlinkMessage, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+len(body))
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], msgType)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|flags)
+	binary.NativeEndian.PutUint32(req[8:12], 1)
+	copy(req[syscall.NLMSG_HDRLEN:], body)
+
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var out []syscall.NetlinkMessage
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return out, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return nil, syscall.Errno(errno)
+					}
+				}
+				return out, nil
+			}
+			out = append(out, m)
+		}
+		if flags&syscall.NLM_F_DUMP == 0 && len(out) > 0 {
+			return out, nil
+		}
+	}
+}
+
+// netlinkAttrs splits rtnetlink attributes by type
+func netlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		l := int(binary.NativeEndian.Uint16(b[0:2]))
+		t := binary.NativeEndian.Uint16(b[2:4])
+		if l < syscall.SizeofRtAttr || l > len(b) {
+			break
+		}
+		attrs[t] = b[syscall.SizeofRtAttr:l]
+		aligned := (l + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs
+}
+
+func appendNetlinkAttr(b []byte, t uint16, data []byte) []byte {
+	l := syscall.SizeofRtAttr + len(data)
+	b = binary.NativeEndian.AppendUint16(b, uint16(l))
+	b = binary.NativeEndian.AppendUint16(b, t)
+	b = append(b, data...)
+	for len(b)%syscall.RTA_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// routeGet asks the kernel which interface and gateway it would use for
+// packets to dst carrying mark
+func routeGet(dst net.IP, mark int) (int, net.IP, error) {
+	family, addr := byte(syscall.AF_INET), dst.To4()
+	if addr == nil {
+		family, addr = syscall.AF_INET6, dst.To16()
+	}
+
+	body := make([]byte, syscall.SizeofRtMsg)
+	body[0] = family
+	body[1] = byte(len(addr) * 8) // dst_len
+	body = appendNetlinkAttr(body, syscall.RTA_DST, addr)
+	if mark != 0 {
+		body = appendNetlinkAttr(body, unix.RTA_MARK, binary.NativeEndian.AppendUint32(nil, uint32(mark)))
+	}
+
+	msgs, err := netlinkRequest(syscall.RTM_GETROUTE, 0, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		attrs := netlinkAttrs(m.Data[syscall.SizeofRtMsg:])
+		oif, ok := attrs[syscall.RTA_OIF]
+		if !ok || len(oif) < 4 {
+			break
+		}
+		var gw net.IP
+		if g, ok := attrs[syscall.RTA_GATEWAY]; ok {
+			gw = net.IP(append([]byte(nil), g...))
+		}
+		return int(binary.NativeEndian.Uint32(oif)), gw, nil
+	}
+	return 0, nil, errNoNextHop
+}
+
+// neighLookup returns the MAC the neighbor table holds for ip on ifindex
+func neighLookup(ifindex int, ip net.IP) (net.HardwareAddr, error) {
+	family := byte(syscall.AF_INET)
+	if ip.To4() == nil {
+		family = syscall.AF_INET6
+	}
+
+	body := make([]byte, unix.SizeofNdMsg)
+	body[0] = family
+	msgs, err := netlinkRequest(syscall.RTM_GETNEIGH, syscall.NLM_F_DUMP, body)
+	if err != nil {
+		return nil, err
+	}
+
+	const usable = unix.NUD_REACHABLE | unix.NUD_STALE | unix.NUD_DELAY | unix.NUD_PROBE | unix.NUD_PERMANENT | unix.NUD_NOARP
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < unix.SizeofNdMsg {
+			continue
+		}
+		nd := (*unix.NdMsg)(unsafe.Pointer(&m.Data[0]))
+		if int(nd.Ifindex) != ifindex || nd.State&usable == 0 {
+			continue
+		}
+		attrs := netlinkAttrs(m.Data[unix.SizeofNdMsg:])
+		if !net.IP(attrs[unix.NDA_DST]).Equal(ip) {
+			continue
+		}
+		if mac := attrs[unix.NDA_LLADDR]; len(mac) == 6 {
+			return net.HardwareAddr(append([]byte(nil), mac...)), nil
+		}
+	}
+	return nil, errNoNextHop
+}
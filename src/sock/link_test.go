@@ -0,0 +1,215 @@
+package sock
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// vethNetns moves the test into a new network namespace holding the veth
+// pair v0 (10.99.0.1/24) - v1, with a permanent neighbor 10.99.0.2 and a
+// route to 10.98.0.0/24 through it. It returns a socket capturing on v1.
+func vethNetns(t *testing.T) int {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("needs iproute2")
+	}
+
+	// The thread stays in the namespace and is dropped when the test ends
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		t.Skipf("cannot create network namespace: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"link", "add", "v0", "type", "veth", "peer", "name", "v1"},
+		{"addr", "add", "10.99.0.1/24", "dev", "v0"},
+		{"link", "set", "v0", "up"},
+		{"link", "set", "v1", "up"},
+		{"neigh", "add", "10.99.0.2", "lladdr", "02:00:00:00:00:02", "dev", "v0"},
+		{"route", "add", "10.98.0.0/24", "via", "10.99.0.2"},
+	} {
+		// ip runs in the namespace of the locked thread
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			t.Skipf("ip %v: %v: %s", args, err, out)
+		}
+	}
+
+	v1, err := net.InterfaceByName("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: v1.Index}); err != nil {
+		t.Fatal(err)
+	}
+	tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	_ = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	return fd
+}
+
+func buildIPv4UDP(src, dst net.IP, payload []byte) []byte {
+	pkt := make([]byte, 28+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = 64
+	pkt[9] = 17
+	copy(pkt[12:16], src.To4())
+	copy(pkt[16:20], dst.To4())
+	binary.BigEndian.PutUint16(pkt[10:12], ipv4Checksum(pkt[:20]))
+	binary.BigEndian.PutUint16(pkt[20:22], 40000)
+	binary.BigEndian.PutUint16(pkt[22:24], 443)
+	binary.BigEndian.PutUint16(pkt[24:26], uint16(8+len(payload)))
+	copy(pkt[28:], payload)
+	return pkt
+}
+
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// readFrame returns the next frame on fd that carries payload
+func readFrame(t *testing.T, fd int, payload []byte) []byte {
+	t.Helper()
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			t.Fatalf("no frame on v1: %v", err)
+		}
+		if bytes.HasSuffix(buf[:n], payload) {
+			return append([]byte(nil), buf[:n]...)
+		}
+	}
+}
+
+func TestLinkSender(t *testing.T) {
+	capture := vethNetns(t)
+	v0, err := net.InterfaceByName("v0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSenderWithMark(1 << 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.UseLinkLayer(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := net.IPv4(10, 99, 0, 1)
+	neighMAC := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+
+	// Everything runs on this goroutine: the namespace belongs to its thread
+	for _, dst := range []net.IP{
+		net.IPv4(10, 99, 0, 2), // on link
+		net.IPv4(10, 98, 0, 7), // through the gateway
+	} {
+		payload := []byte("link sender to " + dst.String())
+		packet := buildIPv4UDP(src, dst, payload)
+
+		if _, _, err := s.link.frames([][]byte{packet}, dst); err != nil {
+			t.Fatalf("%s: no next hop: %v", dst, err)
+		}
+		if err := s.SendIPv4(packet, dst); err != nil {
+			t.Fatal(err)
+		}
+
+		frame := readFrame(t, capture, payload)
+		if !bytes.Equal(frame[0:6], neighMAC) {
+			t.Errorf("%s: dst MAC %s, want %s", dst, net.HardwareAddr(frame[0:6]), neighMAC)
+		}
+		if !bytes.Equal(frame[6:12], v0.HardwareAddr) {
+			t.Errorf("%s: src MAC %s, want %s", dst, net.HardwareAddr(frame[6:12]), v0.HardwareAddr)
+		}
+		if proto := binary.BigEndian.Uint16(frame[12:14]); proto != unix.ETH_P_IP {
+			t.Errorf("%s: ethertype 0x%04x", dst, proto)
+		}
+		if !bytes.Equal(frame[14:], packet) {
+			t.Errorf("%s: frame does not carry the packet unchanged", dst)
+		}
+	}
+
+	dst := net.IPv4(10, 99, 0, 2)
+	payloads := [][]byte{[]byte("batch one"), []byte("batch two"), []byte("batch three")}
+	packets := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		packets[i] = buildIPv4UDP(src, dst, p)
+	}
+	if err := s.SendBatchIPv4(packets, dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range payloads {
+		readFrame(t, capture, p)
+	}
+
+	// A forwarded packet before NAT must not leave with its LAN source
+	foreign := buildIPv4UDP(net.IPv4(192, 168, 1, 10), dst, []byte("x"))
+	if _, _, err := s.link.frames([][]byte{foreign}, dst); err == nil {
+		t.Error("foreign source: expected the raw socket fallback")
+	}
+
+	unknown := net.IPv4(10, 99, 0, 3)
+	if _, _, err := s.link.frames([][]byte{buildIPv4UDP(src, unknown, []byte("x"))}, unknown); err == nil {
+		t.Error("no neighbor: expected the raw socket fallback")
+	}
+}
+
+func TestLinkSenderHopCache(t *testing.T) {
+	l := &linkSender{hops: make(map[string]*nextHop), hopLRU: list.New()}
+	future := time.Now().Add(time.Hour)
+	ip := func(i int) net.IP { return net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)) }
+
+	for i := 0; i < linkHopLimit; i++ {
+		l.mu.Lock()
+		l.storeHop(ip(i).String(), &nextHop{ifindex: i + 1, expires: future})
+		l.mu.Unlock()
+	}
+	// Touching the oldest entry keeps it over the next one
+	if hop := l.nextHop(ip(0)); hop.ifindex != 1 {
+		t.Fatalf("cached hop not reused: %+v", hop)
+	}
+	l.mu.Lock()
+	l.storeHop(ip(linkHopLimit).String(), &nextHop{expires: future})
+	l.mu.Unlock()
+
+	if len(l.hops) != linkHopLimit || l.hopLRU.Len() != linkHopLimit {
+		t.Fatalf("cache holds %d hops (%d in LRU), want %d", len(l.hops), l.hopLRU.Len(), linkHopLimit)
+	}
+	if _, ok := l.hops[ip(0).String()]; !ok {
+		t.Error("recently used hop was evicted")
+	}
+	if _, ok := l.hops[ip(1).String()]; ok {
+		t.Error("least recently used hop was kept")
+	}
+
+	l.forget(ip(0))
+	if _, ok := l.hops[ip(0).String()]; ok || l.hopLRU.Len() != linkHopLimit-1 {
+		t.Error("forgotten hop still cached")
+	}
+}
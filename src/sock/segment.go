@@ -0,0 +1,81 @@
+package sock
+
+import (
+	"encoding/binary"
+)
+
+const DefaultMTU = 1500
+
+// SegmentTCP re-segments a TCP packet whose total length exceeds mtu into
+// several packets that fit, the way the kernel would for a large write.
+// Raw sockets with a prebuilt header refuse packets above the link MTU, which
+// happens when a strategy is applied to a reassembled or padded ClientHello.
+// Returns nil if the packet fits or is not a plain, unfragmented TCP packet.
+func SegmentTCP(packet []byte, mtu int) [][]byte {
+	if len(packet) <= mtu || len(packet) < 20 {
+		return nil
+	}
+
+	var ipHdrLen int
+	isV4 := packet[0]>>4 == 4
+	if isV4 {
+		ipHdrLen = int((packet[0] & 0x0F) * 4)
+		if packet[9] != 6 || binary.BigEndian.Uint16(packet[6:8])&0x3FFF != 0 {
+			return nil
+		}
+	} else {
+		ipHdrLen = 40
+		if len(packet) < ipHdrLen || packet[6] != 6 {
+			return nil
+		}
+	}
+
+	if len(packet) < ipHdrLen+20 {
+		return nil
+	}
+	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
+	payloadStart := ipHdrLen + tcpHdrLen
+	maxPayload := mtu - payloadStart
+	if payloadStart >= len(packet) || maxPayload <= 0 {
+		return nil
+	}
+
+	payload := packet[payloadStart:]
+	seq0 := binary.BigEndian.Uint32(packet[ipHdrLen+4 : ipHdrLen+8])
+	flags := packet[ipHdrLen+13]
+
+	var id0 uint16
+	if isV4 {
+		id0 = binary.BigEndian.Uint16(packet[4:6])
+	}
+
+	segs := make([][]byte, 0, (len(payload)+maxPayload-1)/maxPayload)
+	for off := 0; off < len(payload); off += maxPayload {
+		end := off + maxPayload
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		seg := make([]byte, payloadStart+end-off)
+		copy(seg, packet[:payloadStart])
+		copy(seg[payloadStart:], payload[off:end])
+
+		binary.BigEndian.PutUint32(seg[ipHdrLen+4:ipHdrLen+8], seq0+uint32(off))
+		if end < len(payload) {
+			seg[ipHdrLen+13] = flags &^ 0x09 // PSH and FIN only on the last segment
+		}
+
+		if isV4 {
+			binary.BigEndian.PutUint16(seg[2:4], uint16(len(seg)))
+			binary.BigEndian.PutUint16(seg[4:6], id0+uint16(len(segs)))
+			FixIPv4Checksum(seg[:ipHdrLen])
+			FixTCPChecksum(seg)
+		} else {
+			binary.BigEndian.PutUint16(seg[4:6], uint16(len(seg)-ipHdrLen))
+			FixTCPChecksumV6(seg)
+		}
+		segs = append(segs, seg)
+	}
+
+	return segs
+}
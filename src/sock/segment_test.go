@@ -0,0 +1,69 @@
+package sock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSegmentTCP_FitsMTU(t *testing.T) {
+	pkt := buildMinimalIPv4TCPPacket(100)
+	if segs := SegmentTCP(pkt, DefaultMTU); segs != nil {
+		t.Errorf("expected nil for packet within MTU, got %d segments", len(segs))
+	}
+}
+
+func TestSegmentTCP_IPv4(t *testing.T) {
+	pkt := buildMinimalIPv4TCPPacket(3000)
+	segs := SegmentTCP(pkt, DefaultMTU)
+	if len(segs) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segs))
+	}
+
+	var payload []byte
+	for i, seg := range segs {
+		if len(seg) > DefaultMTU {
+			t.Errorf("segment %d exceeds MTU: %d", i, len(seg))
+		}
+		if int(binary.BigEndian.Uint16(seg[2:4])) != len(seg) {
+			t.Errorf("segment %d has wrong IP total length", i)
+		}
+		seq := binary.BigEndian.Uint32(seg[24:28])
+		if seq != 1000+uint32(len(payload)) {
+			t.Errorf("segment %d seq = %d, want %d", i, seq, 1000+len(payload))
+		}
+		psh := seg[33]&0x08 != 0
+		if psh != (i == len(segs)-1) {
+			t.Errorf("segment %d PSH = %v", i, psh)
+		}
+		payload = append(payload, seg[40:]...)
+	}
+
+	if !bytes.Equal(payload, pkt[40:]) {
+		t.Error("reassembled payload differs from original")
+	}
+}
+
+func TestSegmentTCP_IPv6(t *testing.T) {
+	pkt := buildMinimalIPv6TCPPacket(2000)
+	segs := SegmentTCP(pkt, DefaultMTU)
+	if len(segs) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segs))
+	}
+	for i, seg := range segs {
+		if int(binary.BigEndian.Uint16(seg[4:6])) != len(seg)-40 {
+			t.Errorf("segment %d has wrong IPv6 payload length", i)
+		}
+	}
+	if binary.BigEndian.Uint32(segs[1][44:48]) != 1000+uint32(len(segs[0])-60) {
+		t.Error("second segment has wrong seq")
+	}
+}
+
+func TestSegmentTCP_IPv4Fragment(t *testing.T) {
+	pkt := buildMinimalIPv4TCPPacket(3000)
+	pkt[6] |= 0x20 // MF
+	if segs := SegmentTCP(pkt, DefaultMTU); segs != nil {
+		t.Error("IP fragments must not be re-segmented")
+	}
+}
@@ -12,6 +12,8 @@ type Sender struct {
 	fd4  int
 	fd6  int
 	mark int
+	mtu  int
+	link *linkSender // nil unless UseLinkLayer succeeded
 }
 
 func NewSenderWithMark(mark int) (*Sender, error) {
@@ -19,6 +21,7 @@ func NewSenderWithMark(mark int) (*Sender, error) {
 		fd4:  -1,
 		fd6:  -1,
 		mark: mark,
+		mtu:  DefaultMTU,
 	}
 
 	// Create IPv4 raw socket
@@ -55,11 +58,53 @@ func NewSender(mark int) (*Sender, error) {
 	return NewSenderWithMark(mark)
 }
 
+// UseLinkLayer makes the sender write packets as frames to the egress
+// interface through an AF_PACKET socket, so they skip conntrack and NAT.
+// Packets without a resolved next hop still go through the raw sockets.
+//
+// Only packets whose source is an address of the egress interface can skip
+// NAT this way. Forwarded traffic is queued before it is masqueraded, so
+// packets of LAN clients always go through the raw sockets.
+func (s *Sender) UseLinkLayer() error {
+	l, err := newLinkSender(s.mark)
+	if err != nil {
+		return err
+	}
+	s.link = l
+	return nil
+}
+
+// sendLink tries the AF_PACKET path and returns how many of the packets it
+// sent. The rest, and only the rest, go through the raw sockets.
+func (s *Sender) sendLink(packets [][]byte, destIP net.IP) int {
+	if s.link == nil {
+		return 0
+	}
+	frames, addr, err := s.link.frames(packets, destIP)
+	sent := 0
+	if err == nil {
+		if sent, err = s.sendBatch(s.link.fd, frames, addr); err != nil {
+			s.link.forget(destIP)
+		}
+	}
+	if err != nil {
+		log.Tracef("AF_PACKET send to %s failed after %d of %d packets, using raw socket: %v",
+			destIP, sent, len(packets), err)
+	}
+	return sent
+}
+
 func (s *Sender) SendIPv4(packet []byte, destIP net.IP) error {
 	log.Tracef("Sending IPv4 packet to %s, len=%d", destIP.String(), len(packet))
 	addr := syscall.SockaddrInet4{}
 	copy(addr.Addr[:], destIP.To4())
-	return syscall.Sendto(s.fd4, packet, 0, &addr)
+	segs := SegmentTCP(packet, s.mtu)
+	if segs == nil {
+		segs = [][]byte{packet}
+	}
+	segs = segs[s.sendLink(segs, destIP):]
+	_, err := s.sendAll(s.fd4, segs, &addr)
+	return err
 }
 
 func (s *Sender) SendIPv6(packet []byte, destIP net.IP) error {
@@ -69,7 +114,71 @@ func (s *Sender) SendIPv6(packet []byte, destIP net.IP) error {
 	log.Tracef("Sending IPv6 packet to %s, len=%d", destIP.String(), len(packet))
 	addr := syscall.SockaddrInet6{}
 	copy(addr.Addr[:], destIP.To16())
-	return syscall.Sendto(s.fd6, packet, 0, &addr)
+	segs := SegmentTCP(packet, s.mtu)
+	if segs == nil {
+		segs = [][]byte{packet}
+	}
+	segs = segs[s.sendLink(segs, destIP):]
+	_, err := s.sendAll(s.fd6, segs, &addr)
+	return err
+}
+
+// SendBatchIPv4 sends packets to destIP back to back, in order, with one
+// sendmmsg call. Use it for packets that need no delay between them.
+func (s *Sender) SendBatchIPv4(packets [][]byte, destIP net.IP) error {
+	log.Tracef("Sending %d IPv4 packets to %s", len(packets), destIP.String())
+	addr := syscall.SockaddrInet4{}
+	copy(addr.Addr[:], destIP.To4())
+	packets = s.segmentAll(packets)
+	packets = packets[s.sendLink(packets, destIP):]
+	_, err := s.sendBatch(s.fd4, packets, &addr)
+	return err
+}
+
+// SendBatchIPv6 is SendBatchIPv4 for IPv6 packets
+func (s *Sender) SendBatchIPv6(packets [][]byte, destIP net.IP) error {
+	if s.fd6 < 0 {
+		return nil
+	}
+	log.Tracef("Sending %d IPv6 packets to %s", len(packets), destIP.String())
+	addr := syscall.SockaddrInet6{}
+	copy(addr.Addr[:], destIP.To16())
+	packets = s.segmentAll(packets)
+	packets = packets[s.sendLink(packets, destIP):]
+	_, err := s.sendBatch(s.fd6, packets, &addr)
+	return err
+}
+
+// segmentAll splits the packets larger than the MTU like SendIPv4 does
+func (s *Sender) segmentAll(packets [][]byte) [][]byte {
+	var out [][]byte
+	for i, p := range packets {
+		segs := SegmentTCP(p, s.mtu)
+		if segs == nil {
+			if out != nil {
+				out = append(out, p)
+			}
+			continue
+		}
+		if out == nil {
+			out = append(make([][]byte, 0, len(packets)+len(segs)), packets[:i]...)
+		}
+		out = append(out, segs...)
+	}
+	if out == nil {
+		return packets
+	}
+	return out
+}
+
+// sendAll sends packets one by one and returns how many were sent
+func (s *Sender) sendAll(fd int, packets [][]byte, addr syscall.Sockaddr) (int, error) {
+	for i, p := range packets {
+		if err := syscall.Sendto(fd, p, 0, addr); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
 }
 
 func (s *Sender) Close() {
@@ -81,4 +190,8 @@ func (s *Sender) Close() {
 		_ = syscall.Close(s.fd6)
 		s.fd6 = -1
 	}
+	if s.link != nil {
+		s.link.close()
+		s.link = nil
+	}
 }
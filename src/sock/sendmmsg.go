@@ -0,0 +1,98 @@
+package sock
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr mirrors struct mmsghdr, which x/sys/unix does not define. Go pads
+// the struct to the alignment of Msghdr like C does.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// noSendmmsg is set once the kernel reports sendmmsg as missing (before 3.0)
+var noSendmmsg atomic.Bool
+
+// rawSockaddr returns addr in the form the kernel takes
+func rawSockaddr(addr syscall.Sockaddr) (unsafe.Pointer, uint32, bool) {
+	switch a := addr.(type) {
+	case *syscall.SockaddrInet4:
+		raw := &unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: a.Addr}
+		port := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		port[0], port[1] = byte(a.Port>>8), byte(a.Port)
+		return unsafe.Pointer(raw), unix.SizeofSockaddrInet4, true
+	case *syscall.SockaddrInet6:
+		raw := &unix.RawSockaddrInet6{Family: unix.AF_INET6, Addr: a.Addr, Scope_id: a.ZoneId}
+		port := (*[2]byte)(unsafe.Pointer(&raw.Port))
+		port[0], port[1] = byte(a.Port>>8), byte(a.Port)
+		return unsafe.Pointer(raw), unix.SizeofSockaddrInet6, true
+	case *syscall.SockaddrLinklayer:
+		raw := &unix.RawSockaddrLinklayer{Family: unix.AF_PACKET, Protocol: a.Protocol,
+			Ifindex: int32(a.Ifindex), Hatype: a.Hatype, Pkttype: a.Pkttype, Halen: a.Halen, Addr: a.Addr}
+		return unsafe.Pointer(raw), unix.SizeofSockaddrLinklayer, true
+	}
+	return nil, 0, false
+}
+
+// sendmmsg sends packets to addr in order, as many per syscall as the kernel
+// takes, and returns how many were sent
+func sendmmsg(fd int, packets [][]byte, addr syscall.Sockaddr) (int, error) {
+	name, namelen, ok := rawSockaddr(addr)
+	if !ok {
+		return 0, syscall.EAFNOSUPPORT
+	}
+
+	msgs := make([]mmsghdr, len(packets))
+	iovs := make([]unix.Iovec, len(packets))
+	for i, p := range packets {
+		if len(p) > 0 {
+			iovs[i].Base = &p[0]
+		}
+		iovs[i].SetLen(len(p))
+		msgs[i].hdr.Name = (*byte)(name)
+		msgs[i].hdr.Namelen = namelen
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.SetIovlen(1)
+	}
+
+	sent := 0
+	var err error
+	for sent < len(msgs) {
+		n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+			uintptr(unsafe.Pointer(&msgs[sent])), uintptr(len(msgs)-sent), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			err = errno
+			break
+		}
+		sent += int(n)
+	}
+
+	runtime.KeepAlive(packets)
+	runtime.KeepAlive(iovs)
+	runtime.KeepAlive(name)
+	return sent, err
+}
+
+// sendBatch is sendAll with a single syscall where the kernel supports it
+func (s *Sender) sendBatch(fd int, packets [][]byte, addr syscall.Sockaddr) (int, error) {
+	if len(packets) < 2 || noSendmmsg.Load() {
+		return s.sendAll(fd, packets, addr)
+	}
+	sent, err := sendmmsg(fd, packets, addr)
+	if errors.Is(err, syscall.ENOSYS) {
+		noSendmmsg.Store(true)
+		n, err := s.sendAll(fd, packets[sent:], addr)
+		return sent + n, err
+	}
+	return sent, err
+}
@@ -0,0 +1,86 @@
+package sock
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// loopbackUDP returns a raw UDP socket fd and the address of a listener it
+// can send to
+func loopbackUDP(tb testing.TB) (int, *syscall.SockaddrInet4, *net.UDPConn) {
+	tb.Helper()
+	ln, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	_ = ln.SetReadBuffer(4 << 20)
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { syscall.Close(fd) })
+
+	addr := &syscall.SockaddrInet4{Port: ln.LocalAddr().(*net.UDPAddr).Port, Addr: [4]byte{127, 0, 0, 1}}
+	return fd, addr, ln
+}
+
+func TestSendmmsg(t *testing.T) {
+	fd, addr, ln := loopbackUDP(t)
+
+	packets := [][]byte{[]byte("first"), []byte("second"), []byte("third"), {}}
+	if n, err := sendmmsg(fd, packets, addr); err != nil || n != len(packets) {
+		t.Fatalf("sendmmsg: sent %d, %v", n, err)
+	}
+
+	buf := make([]byte, 64)
+	_ = ln.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range packets {
+		n, err := ln.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != string(want) {
+			t.Errorf("got %q, want %q", buf[:n], want)
+		}
+	}
+}
+
+func TestSendPartialBatch(t *testing.T) {
+	fd, addr, _ := loopbackUDP(t)
+	s := &Sender{}
+
+	// The oversized datagram fails, so only the first packet goes out
+	packets := [][]byte{[]byte("first"), make([]byte, 70000), []byte("third")}
+	if n, err := sendmmsg(fd, packets, addr); err == nil || n != 1 {
+		t.Errorf("sendmmsg: sent %d, %v", n, err)
+	}
+	if n, err := s.sendAll(fd, packets, addr); err == nil || n != 1 {
+		t.Errorf("sendAll: sent %d, %v", n, err)
+	}
+	if n, err := s.sendBatch(fd, packets, addr); err == nil || n != 1 {
+		t.Errorf("sendBatch: sent %d, %v", n, err)
+	}
+}
+
+func TestSegmentAll(t *testing.T) {
+	s := &Sender{mtu: 576}
+	small := buildMinimalIPv4TCPPacket(100)
+	large := buildMinimalIPv4TCPPacket(1400)
+
+	packets := [][]byte{small, small}
+	if got := s.segmentAll(packets); len(got) != 2 || &got[0] != &packets[0] {
+		t.Error("packets within the MTU must be sent as they are")
+	}
+
+	got := s.segmentAll([][]byte{small, large, small})
+	if len(got) < 4 {
+		t.Fatalf("expected the large packet to be segmented, got %d packets", len(got))
+	}
+	if len(got[0]) != len(small) || len(got[len(got)-1]) != len(small) {
+		t.Error("order not preserved")
+	}
+}
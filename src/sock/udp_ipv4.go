@@ -60,6 +60,42 @@ func BuildFakeUDPFromOriginalV4(orig []byte, fakeLen int, ttl uint8) ([]byte, bo
 	return out, true
 }
 
+// BuildUDPFromOriginalV4 returns a copy of the UDP datagram orig carrying
+// payload instead of its own
+func BuildUDPFromOriginalV4(orig, payload []byte) ([]byte, bool) {
+	if len(orig) < 28 || orig[0]>>4 != 4 {
+		return nil, false
+	}
+	ihl := int((orig[0] & 0x0f) << 2)
+	if len(orig) < ihl+8 || 28+len(payload) > 0xffff {
+		return nil, false
+	}
+	out := make([]byte, 28+len(payload))
+	copy(out, orig[:20])
+	out[0] = 0x45
+	id := binary.BigEndian.Uint16(out[4:6])
+	binary.BigEndian.PutUint16(out[4:6], id+1)
+	out[6], out[7] = 0, 0
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	copy(out[20:], orig[ihl:ihl+8])
+	binary.BigEndian.PutUint16(out[24:26], uint16(8+len(payload)))
+	copy(out[28:], payload)
+	FixIPv4Checksum(out[:20])
+	udpChecksumIPv4(out)
+	return out, true
+}
+
+// BuildFakeUDPWithPayloadV4 is BuildUDPFromOriginalV4 with the TTL set to ttl
+func BuildFakeUDPWithPayloadV4(orig, payload []byte, ttl uint8) ([]byte, bool) {
+	out, ok := BuildUDPFromOriginalV4(orig, payload)
+	if !ok {
+		return nil, false
+	}
+	out[8] = ttl
+	FixIPv4Checksum(out[:20])
+	return out, true
+}
+
 func IPv4FragmentUDP(orig []byte, split int) ([][]byte, bool) {
 	if len(orig) < 28 || orig[0]>>4 != 4 {
 		return nil, false
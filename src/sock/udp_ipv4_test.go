@@ -67,6 +67,28 @@ func TestBuildFakeUDPFromOriginalV4_Valid(t *testing.T) {
 	}
 }
 
+func TestBuildUDPFromOriginalV4_Valid(t *testing.T) {
+	pkt := buildMinimalIPv4UDPPacket(20)
+	payload := []byte("replacement payload, longer than the original")
+	result, ok := BuildUDPFromOriginalV4(pkt, payload)
+	if !ok {
+		t.Fatal("expected success")
+	}
+
+	if len(result) != 28+len(payload) || int(binary.BigEndian.Uint16(result[2:4])) != len(result) {
+		t.Errorf("wrong total length: %d", len(result))
+	}
+	if binary.BigEndian.Uint16(result[24:26]) != uint16(8+len(payload)) {
+		t.Error("UDP length not updated")
+	}
+	if binary.BigEndian.Uint16(result[20:22]) != 12345 || binary.BigEndian.Uint16(result[22:24]) != 53 {
+		t.Error("ports not preserved")
+	}
+	if string(result[28:]) != string(payload) {
+		t.Error("payload not replaced")
+	}
+}
+
 func TestIPv4FragmentUDP_TooShort(t *testing.T) {
 	_, ok := IPv4FragmentUDP(make([]byte, 20), 8)
 	if ok {
@@ -90,6 +90,36 @@ func BuildFakeUDPFromOriginalV6(orig []byte, fakeLen int, hopLimit uint8) ([]byt
 	return out, true
 }
 
+// BuildUDPFromOriginalV6 returns a copy of the UDP datagram orig carrying
+// payload instead of its own
+func BuildUDPFromOriginalV6(orig, payload []byte) ([]byte, bool) {
+	if len(orig) < 48 || orig[0]>>4 != 6 || orig[6] != 17 || 8+len(payload) > 0xffff {
+		return nil, false
+	}
+
+	ipv6HdrLen := 40
+	out := make([]byte, ipv6HdrLen+8+len(payload))
+	copy(out, orig[:ipv6HdrLen])
+	binary.BigEndian.PutUint16(out[4:6], uint16(8+len(payload)))
+	copy(out[ipv6HdrLen:], orig[ipv6HdrLen:ipv6HdrLen+8])
+	binary.BigEndian.PutUint16(out[ipv6HdrLen+4:ipv6HdrLen+6], uint16(8+len(payload)))
+	copy(out[ipv6HdrLen+8:], payload)
+	udpChecksumIPv6(out)
+
+	return out, true
+}
+
+// BuildFakeUDPWithPayloadV6 is BuildUDPFromOriginalV6 with the hop limit set
+// to hopLimit
+func BuildFakeUDPWithPayloadV6(orig, payload []byte, hopLimit uint8) ([]byte, bool) {
+	out, ok := BuildUDPFromOriginalV6(orig, payload)
+	if !ok {
+		return nil, false
+	}
+	out[7] = hopLimit
+	return out, true
+}
+
 // IPv6FragmentUDP fragments an IPv6 UDP packet
 // Note: IPv6 fragmentation is handled differently than IPv4
 // Fragment headers are extension headers in IPv6
@@ -71,6 +71,25 @@ func TestBuildFakeUDPFromOriginalV6_Valid(t *testing.T) {
 	}
 }
 
+func TestBuildUDPFromOriginalV6_Valid(t *testing.T) {
+	pkt := buildMinimalIPv6UDPPacket(20)
+	payload := []byte("replacement payload, longer than the original")
+	result, ok := BuildUDPFromOriginalV6(pkt, payload)
+	if !ok {
+		t.Fatal("expected success")
+	}
+
+	if len(result) != 48+len(payload) || binary.BigEndian.Uint16(result[4:6]) != uint16(8+len(payload)) {
+		t.Errorf("wrong payload length: %d", len(result))
+	}
+	if binary.BigEndian.Uint16(result[44:46]) != uint16(8+len(payload)) {
+		t.Error("UDP length not updated")
+	}
+	if string(result[48:]) != string(payload) {
+		t.Error("payload not replaced")
+	}
+}
+
 func TestIPv6FragmentUDP_TooShort(t *testing.T) {
 	_, ok := IPv6FragmentUDP(make([]byte, 40), 8)
 	if ok {
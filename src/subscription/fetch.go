@@ -0,0 +1,216 @@
+package subscription
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/geodat"
+)
+
+// maxDownloadSize caps a single list or dat file
+const maxDownloadSize = 128 << 20
+
+// Status is the download state of a subscription. It is kept next to the
+// downloaded file so that cache validators survive restarts.
+type Status struct {
+	URL          string    `json:"url"`
+	Format       string    `json:"format"`
+	Sets         []string  `json:"sets"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Entries      int       `json:"entries"`
+	CheckedAt    time.Time `json:"checked_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Error        string    `json:"error,omitempty"`
+}
+
+func loadStatus(path string) *Status {
+	st := &Status{}
+	if data, err := os.ReadFile(path + ".json"); err == nil {
+		_ = json.Unmarshal(data, st)
+	}
+	if _, err := os.Stat(path); err != nil {
+		// the cache validators are useless without the file
+		st.ETag, st.LastModified, st.SHA256 = "", "", ""
+	}
+	return st
+}
+
+func (st *Status) save(path string) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeAtomic(path+".json", data)
+}
+
+// fetch downloads sub into path unless the server reports it unchanged. It
+// reports whether the stored file changed. The file is replaced only once
+// the download passed its checksum and parses, so a failed fetch keeps the
+// previous version in use.
+func (m *Manager) fetch(ctx context.Context, sub *config.SubscriptionConfig, path string, st *Status) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	_, statErr := os.Stat(path)
+	stored := statErr == nil
+	if stored && st.ETag != "" {
+		req.Header.Set("If-None-Match", st.ETag)
+	}
+	if stored && st.LastModified != "" {
+		req.Header.Set("If-Modified-Since", st.LastModified)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, maxDownloadSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	if n > maxDownloadSize {
+		return false, fmt.Errorf("download exceeds %d bytes", maxDownloadSize)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	want, err := m.checksum(ctx, sub)
+	if err != nil {
+		return false, err
+	}
+	if want != "" && !strings.EqualFold(want, sum) {
+		return false, fmt.Errorf("checksum mismatch: got %s, want %s", sum, want)
+	}
+
+	st.ETag = resp.Header.Get("ETag")
+	st.LastModified = resp.Header.Get("Last-Modified")
+	if stored && sum == st.SHA256 {
+		return false, nil
+	}
+
+	var entries int
+	if sub.IsDat() {
+		tags, err := geodat.ListCategories(tmp.Name())
+		if err != nil {
+			return false, fmt.Errorf("invalid dat file: %w", err)
+		}
+		if len(tags) == 0 {
+			return false, fmt.Errorf("dat file has no categories")
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return false, err
+		}
+		entries = len(tags)
+	} else {
+		data, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return false, err
+		}
+		list, err := Parse(sub.Format, data)
+		if err != nil {
+			return false, err
+		}
+		if err := writeAtomic(path, []byte(strings.Join(list, "\n")+"\n")); err != nil {
+			return false, err
+		}
+		entries = len(list)
+	}
+
+	st.SHA256 = sum
+	st.Entries = entries
+	st.UpdatedAt = time.Now()
+	return true, nil
+}
+
+// checksum returns the expected SHA-256 of sub: the pinned one, or the
+// first field of the checksum file, as in "sha256sum" output
+func (m *Manager) checksum(ctx context.Context, sub *config.SubscriptionConfig) (string, error) {
+	if sub.SHA256 != "" {
+		return strings.TrimSpace(sub.SHA256), nil
+	}
+	if sub.ChecksumURL == "" {
+		return "", nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.ChecksumURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch checksum: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch checksum: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum file")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("invalid checksum file")
+	}
+	return fields[0], nil
+}
+
+// writeAtomic replaces path with data through a rename, so readers see
+// either the old or the new file
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,274 @@
+package subscription
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+)
+
+const (
+	checkInterval = time.Minute
+	// retryDelay is how long a failed subscription waits before the next
+	// attempt, whatever its interval
+	retryDelay = 5 * time.Minute
+)
+
+// Target is the running config the manager reads the subscriptions from
+// and pushes reloaded sets to. nfq.Pool implements it.
+type Target interface {
+	GetFirstWorkerConfig() *config.Config
+	// ModifyConfig pushes the config update derives from the running
+	// one, with no other update landing in between
+	ModifyConfig(update func(cur *config.Config) (*config.Config, error)) error
+}
+
+// Manager downloads the subscriptions of all sets and hot reloads the sets
+// whose lists changed
+type Manager struct {
+	target Target
+	client *http.Client
+
+	mu       sync.Mutex // serializes refreshes
+	statusMu sync.RWMutex
+	status   map[string]*Status
+	wake     chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// subscription is a download shared by every set subscribed to it
+type subscription struct {
+	sub  config.SubscriptionConfig
+	path string
+	sets []*config.SetConfig
+}
+
+// NewManager creates a manager for the config running in target
+func NewManager(target Target) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		target: target,
+		client: &http.Client{Timeout: 2 * time.Minute},
+		status: make(map[string]*Status),
+		wake:   make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (m *Manager) Start() {
+	if m.target.GetFirstWorkerConfig().SubscriptionsDir() == "" {
+		log.Infof("Subscriptions disabled: no config file to keep them next to")
+		return
+	}
+
+	m.wg.Add(1)
+	go m.loop()
+	log.Infof("Started subscription manager")
+}
+
+func (m *Manager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Wake makes the manager look for due subscriptions now, e.g. after a set
+// subscribed to a new list
+func (m *Manager) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		m.Refresh(false)
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// Refresh fetches the subscriptions that are due, or all of them when
+// force is set, reloads the sets whose lists changed and returns the
+// status of every subscription
+func (m *Manager) Refresh(force bool) []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subs := m.collect()
+	changed := make(map[string]bool)
+	now := time.Now()
+
+	m.statusMu.Lock()
+	for key := range m.status {
+		if _, ok := subs[key]; !ok {
+			delete(m.status, key)
+		}
+	}
+	m.statusMu.Unlock()
+
+	for key, s := range subs {
+		st := m.statusOf(key, s.path)
+		st.URL, st.Format = s.sub.URL, s.sub.Format
+		st.Sets = []string{}
+		for _, set := range s.sets {
+			st.Sets = append(st.Sets, set.Name)
+		}
+
+		if !force && !st.due(&s.sub, s.path, now) {
+			m.setStatus(key, st)
+			continue
+		}
+
+		updated, err := m.fetch(m.ctx, &s.sub, s.path, &st)
+		st.CheckedAt = now
+		st.Error = ""
+		if err != nil {
+			st.Error = err.Error()
+			log.Errorf("Failed to refresh subscription %s: %v", s.sub.URL, err)
+		} else if updated {
+			log.Infof("Subscription %s updated: %d entries", s.sub.URL, st.Entries)
+			for _, set := range s.sets {
+				changed[set.Id] = true
+			}
+		} else {
+			log.Tracef("Subscription %s unchanged", s.sub.URL)
+		}
+		if err := st.save(s.path); err != nil {
+			log.Errorf("Failed to save subscription state: %v", err)
+		}
+		m.setStatus(key, st)
+	}
+
+	if len(changed) > 0 {
+		if err := m.reload(changed); err != nil {
+			log.Errorf("Failed to reload subscribed sets: %v", err)
+		}
+	}
+	return m.Statuses()
+}
+
+// Statuses returns the state of every subscription
+func (m *Manager) Statuses() []Status {
+	m.statusMu.RLock()
+	defer m.statusMu.RUnlock()
+
+	out := make([]Status, 0, len(m.status))
+	for _, st := range m.status {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
+
+// statusOf returns a copy of the status of a download to work on, read
+// from disk the first time
+func (m *Manager) statusOf(key, path string) Status {
+	m.statusMu.RLock()
+	st, ok := m.status[key]
+	m.statusMu.RUnlock()
+	if !ok {
+		st = loadStatus(path)
+	}
+	return *st
+}
+
+// setStatus publishes a status. Published statuses are never modified, so
+// Statuses can hand out shallow copies.
+func (m *Manager) setStatus(key string, st Status) {
+	m.statusMu.Lock()
+	m.status[key] = &st
+	m.statusMu.Unlock()
+}
+
+// collect groups the enabled subscriptions of the enabled sets by download.
+// A list shared by sets is refreshed at the shortest of their intervals.
+func (m *Manager) collect() map[string]*subscription {
+	cfg := m.target.GetFirstWorkerConfig()
+	subs := make(map[string]*subscription)
+	for _, set := range cfg.Sets {
+		if !set.Enabled {
+			continue
+		}
+		for _, sub := range set.Targets.Subscriptions {
+			if !sub.Enabled || sub.URL == "" {
+				continue
+			}
+			key := sub.Key()
+			s, ok := subs[key]
+			if !ok {
+				s = &subscription{sub: sub, path: cfg.SubscriptionPath(&sub)}
+				subs[key] = s
+			} else if sub.Interval > 0 && (s.sub.Interval <= 0 || sub.Interval < s.sub.Interval) {
+				s.sub.Interval = sub.Interval
+			}
+			s.sets = append(s.sets, set)
+		}
+	}
+	return subs
+}
+
+func (st *Status) due(sub *config.SubscriptionConfig, path string, now time.Time) bool {
+	if st.CheckedAt.IsZero() {
+		return true
+	}
+	if _, err := os.Stat(path); err != nil {
+		return now.Sub(st.CheckedAt) >= retryDelay || st.Error == ""
+	}
+	if st.Error != "" && now.Sub(st.CheckedAt) >= retryDelay {
+		return true
+	}
+	return sub.Interval > 0 && now.Sub(st.CheckedAt) >= time.Duration(sub.Interval)*time.Minute
+}
+
+// reload pushes a config where the changed sets have their targets loaded
+// again. It starts from the config running at that time, so a config saved
+// meanwhile is kept; other sets are shared with it.
+func (m *Manager) reload(changed map[string]bool) error {
+	return m.target.ModifyConfig(func(cur *config.Config) (*config.Config, error) {
+		return reloadSets(cur, changed)
+	})
+}
+
+func reloadSets(cur *config.Config, changed map[string]bool) (*config.Config, error) {
+	newCfg := *cur
+	newCfg.Sets = make([]*config.SetConfig, len(cur.Sets))
+
+	for i, set := range cur.Sets {
+		if !changed[set.Id] {
+			newCfg.Sets[i] = set
+			continue
+		}
+		reloaded := *set
+		if _, _, err := newCfg.GetTargetsForSet(&reloaded); err != nil {
+			return nil, err
+		}
+		newCfg.Sets[i] = &reloaded
+		if set == cur.MainSet {
+			newCfg.MainSet = &reloaded
+		}
+		log.Infof("Reloaded targets for set %s: %d domains, %d IPs", set.Name,
+			len(reloaded.Targets.DomainsToMatch), len(reloaded.Targets.IpsToMatch))
+	}
+
+	return &newCfg, nil
+}
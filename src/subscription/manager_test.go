@@ -0,0 +1,202 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+// listServer serves a list with an ETag derived from its body, and the
+// SHA-256 it was published with at /list.sha256
+type listServer struct {
+	mu       sync.Mutex
+	body     string
+	checksum string
+	hits     int
+	notMod   int
+}
+
+func (s *listServer) set(body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := sha256.Sum256([]byte(body))
+	s.body, s.checksum = body, hex.EncodeToString(sum[:])
+}
+
+func (s *listServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/list.sha256" {
+		fmt.Fprintf(w, "%s  list.txt\n", s.checksum)
+		return
+	}
+	s.hits++
+	sum := sha256.Sum256([]byte(s.body))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		s.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	fmt.Fprint(w, s.body)
+}
+
+// target stands in for the pool running cfg
+type target struct {
+	mu     sync.Mutex
+	cfg    *config.Config
+	pushed []*config.Config
+}
+
+func (t *target) GetFirstWorkerConfig() *config.Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg
+}
+
+func (t *target) ModifyConfig(update func(*config.Config) (*config.Config, error)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	newCfg, err := update(t.cfg)
+	if err != nil {
+		return err
+	}
+	t.cfg = newCfg
+	t.pushed = append(t.pushed, newCfg)
+	return nil
+}
+
+func TestManagerRefresh(t *testing.T) {
+	srv := &listServer{}
+	srv.set("youtube.com\n10.0.0.0/8\n")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+	set := config.NewSetConfig()
+	set.Id, set.Name = "subs", "subs"
+	set.Enabled = true
+	set.Targets.SNIDomains = []string{"manual.com"}
+	set.Targets.Subscriptions = []config.SubscriptionConfig{{
+		Enabled:     true,
+		URL:         ts.URL + "/list.txt",
+		Format:      config.SubscriptionPlain,
+		Interval:    60,
+		ChecksumURL: ts.URL + "/list.sha256",
+	}}
+	cfg.Sets = []*config.SetConfig{&set}
+
+	tg := &target{cfg: &cfg}
+	m := NewManager(tg)
+
+	targets := func() ([]string, []string) {
+		s := tg.GetFirstWorkerConfig().Sets[0].Targets
+		return s.DomainsToMatch, s.IpsToMatch
+	}
+
+	st := m.Refresh(false)
+	if len(st) != 1 || st[0].Error != "" || st[0].Entries != 2 {
+		t.Fatalf("unexpected status after first fetch: %+v", st)
+	}
+	if len(tg.pushed) != 1 || tg.pushed[0].Sets[0] == &set {
+		t.Fatalf("expected one pushed config leaving the old one alone, got %d", len(tg.pushed))
+	}
+	if d, ips := targets(); !slices.Equal(d, []string{"youtube.com", "manual.com"}) || !slices.Equal(ips, []string{"10.0.0.0/8"}) {
+		t.Errorf("targets not reloaded: %v %v", d, ips)
+	}
+
+	// not due yet
+	m.Refresh(false)
+	if srv.hits != 1 {
+		t.Errorf("expected no request before the interval, got %d", srv.hits)
+	}
+
+	// unchanged on the server
+	m.Refresh(true)
+	if srv.notMod != 1 || len(tg.pushed) != 1 {
+		t.Errorf("expected a conditional request and no reload, got %d 304s and %d reloads", srv.notMod, len(tg.pushed))
+	}
+
+	srv.set("youtube.com\ngooglevideo.com\n")
+	m.Refresh(true)
+	if len(tg.pushed) != 2 {
+		t.Fatalf("expected a reload after the list changed")
+	}
+	if d, ips := targets(); !slices.Equal(d, []string{"youtube.com", "googlevideo.com", "manual.com"}) || len(ips) != 0 {
+		t.Errorf("targets not reloaded: %v %v", d, ips)
+	}
+
+	// a tampered download keeps the previous list
+	srv.mu.Lock()
+	srv.body = "evil.com\n"
+	srv.mu.Unlock()
+	st = m.Refresh(true)
+	if st[0].Error == "" || len(tg.pushed) != 2 {
+		t.Errorf("expected a checksum error and no reload: %+v", st[0])
+	}
+	if d, _ := cfg.LoadSubscriptions(cfg.Sets[0]); !slices.Equal(d, []string{"youtube.com", "googlevideo.com"}) {
+		t.Errorf("stored list replaced by a bad download: %v", d)
+	}
+
+	// the cache validators survive a restart
+	srv.set("youtube.com\ngooglevideo.com\n")
+	restarted := NewManager(tg)
+	restarted.Refresh(true)
+	if srv.notMod != 2 || len(tg.pushed) != 2 {
+		t.Errorf("expected a 304 after restart, got %d 304s and %d reloads", srv.notMod, len(tg.pushed))
+	}
+}
+
+func TestManagerReloadKeepsSavedConfig(t *testing.T) {
+	srv := &listServer{}
+	srv.set("youtube.com\n")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+	set := config.NewSetConfig()
+	set.Id, set.Name = "subs", "subs"
+	set.Enabled = true
+	set.Targets.Subscriptions = []config.SubscriptionConfig{{
+		Enabled: true,
+		URL:     ts.URL + "/list.txt",
+		Format:  config.SubscriptionPlain,
+	}}
+	cfg.Sets = []*config.SetConfig{&set}
+
+	tg := &target{cfg: &cfg}
+	m := NewManager(tg)
+	m.Refresh(false)
+
+	// a save through the API replaces the running config
+	saved := *tg.GetFirstWorkerConfig()
+	renamed := *saved.Sets[0]
+	renamed.Name = "renamed"
+	other := config.NewSetConfig()
+	other.Id, other.Name = "other", "other"
+	saved.Sets = []*config.SetConfig{&renamed, &other}
+	tg.cfg = &saved
+
+	srv.set("youtube.com\ngooglevideo.com\n")
+	m.Refresh(true)
+
+	live := tg.GetFirstWorkerConfig()
+	if len(live.Sets) != 2 || live.Sets[0].Name != "renamed" || live.Sets[1] != &other {
+		t.Fatalf("reload undid the saved config: %+v", live.Sets)
+	}
+	if d := live.Sets[0].Targets.DomainsToMatch; !slices.Equal(d, []string{"youtube.com", "googlevideo.com"}) {
+		t.Errorf("targets not reloaded: %v", d)
+	}
+}
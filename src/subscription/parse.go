@@ -0,0 +1,270 @@
+package subscription
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+// Parse extracts the entries of a downloaded list: domains as matcher
+// rules, IPs and CIDRs as they are. A list without entries is an error,
+// so that a broken download never empties the targets.
+func Parse(format string, data []byte) ([]string, error) {
+	var entries []string
+	var err error
+
+	switch format {
+	case config.SubscriptionPlain, "":
+		entries = parseLines(data, parsePlainLine)
+	case config.SubscriptionHosts:
+		entries = parseLines(data, parseHostsLine)
+	case config.SubscriptionAdblock:
+		entries = parseLines(data, parseAdblockLine)
+	case config.SubscriptionJSON:
+		entries, err = parseJSON(data)
+	default:
+		return nil, fmt.Errorf("unsupported list format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("list has no entries")
+	}
+	return entries, nil
+}
+
+func parseLines(data []byte, parseLine func(string) []string) []string {
+	seen := make(map[string]struct{})
+	entries := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || strings.HasPrefix(line, "//") {
+			continue
+		}
+		for _, e := range parseLine(line) {
+			if _, ok := seen[e]; !ok {
+				seen[e] = struct{}{}
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries
+}
+
+func parsePlainLine(line string) []string {
+	if idx := strings.Index(line, " #"); idx != -1 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	if e := normalizeEntry(fields[0]); e != "" {
+		return []string{e}
+	}
+	return nil
+}
+
+// hostnames every hosts file maps that are not list entries
+var hostsIgnored = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"ip6-localnet":          {},
+	"ip6-mcastprefix":       {},
+	"ip6-allnodes":          {},
+	"ip6-allrouters":        {},
+	"ip6-allhosts":          {},
+	"0.0.0.0":               {},
+}
+
+func parseHostsLine(line string) []string {
+	if idx := strings.IndexByte(line, '#'); idx != -1 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return nil
+	}
+
+	var entries []string
+	for _, host := range fields[1:] {
+		host = strings.ToLower(host)
+		if _, ok := hostsIgnored[host]; ok {
+			continue
+		}
+		if d := normalizeDomain(host); d != "" {
+			entries = append(entries, d)
+		}
+	}
+	return entries
+}
+
+// parseAdblockLine takes "||domain^" rules. Exceptions, cosmetic rules,
+// paths and rules narrowed by options do not block a whole domain and are
+// skipped.
+func parseAdblockLine(line string) []string {
+	if !strings.HasPrefix(line, "||") {
+		return nil
+	}
+	rule := strings.TrimPrefix(line, "||")
+	idx := strings.IndexByte(rule, '^')
+	if idx == -1 {
+		return nil
+	}
+	if rest := strings.TrimSuffix(rule[idx+1:], "|"); rest != "" && rest != "$important" {
+		return nil
+	}
+	if d := normalizeDomain(rule[:idx]); d != "" {
+		return []string{d}
+	}
+	return nil
+}
+
+// listable is a sing-box field holding a string or a list of them
+type listable []string
+
+func (l *listable) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = listable{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*l = many
+	return nil
+}
+
+type singBoxRule struct {
+	Domain        listable `json:"domain"`
+	DomainSuffix  listable `json:"domain_suffix"`
+	DomainKeyword listable `json:"domain_keyword"`
+	DomainRegex   listable `json:"domain_regex"`
+	IPCIDR        listable `json:"ip_cidr"`
+}
+
+// parseJSON takes an array of entries or a sing-box source rule set
+func parseJSON(data []byte) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		entries := []string{}
+		for _, e := range list {
+			if e = normalizeEntry(e); e != "" {
+				entries = append(entries, e)
+			}
+		}
+		return entries, nil
+	}
+
+	var ruleSet struct {
+		Rules []singBoxRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &ruleSet); err != nil {
+		return nil, fmt.Errorf("invalid JSON list: %w", err)
+	}
+
+	entries := []string{}
+	add := func(e string) {
+		if e != "" {
+			entries = append(entries, e)
+		}
+	}
+	for _, r := range ruleSet.Rules {
+		for _, d := range r.Domain {
+			if d = normalizeDomain(d); d != "" {
+				add("full:" + d)
+			}
+		}
+		for _, d := range r.DomainSuffix {
+			add(normalizeDomain(d))
+		}
+		for _, k := range r.DomainKeyword {
+			if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+				add("keyword:" + k)
+			}
+		}
+		for _, re := range r.DomainRegex {
+			if _, err := regexp.Compile(re); err == nil && re != "" {
+				add("regexp:" + re)
+			}
+		}
+		for _, c := range r.IPCIDR {
+			add(normalizeIP(c))
+		}
+	}
+	return entries, nil
+}
+
+// normalizeEntry returns an IP, a CIDR or a domain rule, or "" if the
+// entry is none of them
+func normalizeEntry(e string) string {
+	e = strings.TrimSpace(e)
+	if ip := normalizeIP(e); ip != "" {
+		return ip
+	}
+
+	kind, value, ok := strings.Cut(e, ":")
+	if !ok {
+		return normalizeDomain(e)
+	}
+	switch kind {
+	case "full", "domain":
+		if d := normalizeDomain(value); d != "" {
+			return kind + ":" + d
+		}
+	case "keyword":
+		if value = strings.ToLower(value); value != "" {
+			return kind + ":" + value
+		}
+	case "regexp":
+		if _, err := regexp.Compile(value); err == nil && value != "" {
+			return kind + ":" + value
+		}
+	}
+	return ""
+}
+
+func normalizeIP(e string) string {
+	if _, ipNet, err := net.ParseCIDR(e); err == nil {
+		return ipNet.String()
+	}
+	if ip := net.ParseIP(e); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// normalizeDomain lowercases a domain and drops wildcard and root dots,
+// returning "" if it is not a domain
+func normalizeDomain(d string) string {
+	d = strings.ToLower(strings.TrimSpace(d))
+	d = strings.TrimPrefix(d, "*.")
+	d = strings.Trim(d, ".")
+	if d == "" || !strings.Contains(d, ".") {
+		return ""
+	}
+	for _, c := range d {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return ""
+		}
+	}
+	return d
+}
@@ -0,0 +1,64 @@
+package subscription
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		want   []string
+	}{
+		{
+			name:   "plain",
+			format: "plain",
+			data:   "# comment\nExample.com\n*.wild.org\n10.0.0.0/8 # net\n1.2.3.4\nfull:x.com\nkeyword:tube\nnot a domain\nexample.com\n",
+			want:   []string{"example.com", "wild.org", "10.0.0.0/8", "1.2.3.4", "full:x.com", "keyword:tube"},
+		},
+		{
+			name:   "hosts",
+			format: "hosts",
+			data:   "127.0.0.1 localhost\n0.0.0.0 ads.example.com tracker.example.com # ads\n::1 ip6-localhost\n0.0.0.0 0.0.0.0\n",
+			want:   []string{"ads.example.com", "tracker.example.com"},
+		},
+		{
+			name:   "adblock",
+			format: "adblock",
+			data:   "[Adblock Plus 2.0]\n! comment\n||ads.example.com^\n||img.example.com^$third-party\n@@||ok.example.com^\n||cdn.example.com/path\n||late.example.com^$important\nexample.com##.banner\n",
+			want:   []string{"ads.example.com", "late.example.com"},
+		},
+		{
+			name:   "json array",
+			format: "json",
+			data:   `["youtube.com", "2001:db8::/32", "regexp:^r[0-9]+\\.cdn$", "bad entry"]`,
+			want:   []string{"youtube.com", "2001:db8::/32", `regexp:^r[0-9]+\.cdn$`},
+		},
+		{
+			name:   "sing-box rule set",
+			format: "json",
+			data:   `{"version":2,"rules":[{"domain":"x.com","domain_suffix":[".google.com"],"domain_keyword":["tube"],"ip_cidr":["8.8.8.0/24"]}]}`,
+			want:   []string{"full:x.com", "google.com", "keyword:tube", "8.8.8.0/24"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.format, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Parse("plain", []byte("<html>error page</html>\n")); err == nil {
+		t.Error("expected an error for a list without entries")
+	}
+	if _, err := Parse("xml", []byte("a.com")); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -23,21 +23,27 @@ func AddRules(cfg *config.Config) error {
 		ClearRules(cfg)
 		return AddRules(cfg)
 	})
+	handler.SetProbeRulesFuncs(
+		func() error { return StartProbes(cfg) },
+		func() { StopProbes(cfg) },
+	)
 
 	backend := detectFirewallBackend()
 	log.Tracef("Detected firewall backend: %s", backend)
 	metrics := handler.GetMetricsCollector()
 	metrics.TablesStatus = backend
 
+	var err error
 	if backend == "nftables" {
-		nft := NewNFTablesManager(cfg)
-		return nft.Apply()
+		err = NewNFTablesManager(cfg).Apply()
+	} else {
+		// Fall back to iptables
+		err = NewIPTablesManager(cfg).Apply()
 	}
-
-	// Fall back to iptables
-	ipt := NewIPTablesManager(cfg)
-
-	return ipt.Apply()
+	if err != nil {
+		return err
+	}
+	return restoreProbeRules(cfg)
 }
 
 // ClearRulesAuto automatically detects and clears the appropriate firewall rules
@@ -64,6 +70,17 @@ func run(args ...string) (string, error) {
 	return out.String(), err
 }
 
+// runInput runs a command with input on its stdin
+func runInput(input string, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
 func setSysctlOrProc(name, val string) {
 	_, _ = run("sh", "-c", "sysctl -w "+name+"="+val+" || echo "+val+" > /proc/sys/"+strings.ReplaceAll(name, ".", "/"))
 }
@@ -95,6 +112,11 @@ func detectFirewallBackend() string {
 		return "iptables"
 	}
 
+	// No userspace tools, but nf_tables can still be programmed over netlink
+	if netlinkAvailable() {
+		return "nftables"
+	}
+
 	// Default to iptables if nothing found
 	return "iptables"
 }
@@ -114,3 +136,11 @@ func loadKernelModules() {
 		_, _ = run("sh", "-c", "modprobe -q nft_ct 2>/dev/null || true")
 	})
 }
+
+// logPortLimits explains which sets drove each queue rule
+func logPortLimits(proto string, groups []config.PortLimit) {
+	for _, g := range groups {
+		log.Infof("Queueing %s ports %s for the first %d packets (sets: %s)",
+			proto, strings.Join(g.Ports, ","), g.Limit, strings.Join(g.Sets, ", "))
+	}
+}
@@ -0,0 +1,110 @@
+package tables
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+func ipsetFamily(ipt string) string {
+	if ipt == "ip6tables" {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+func iptablesBinary(family string) string {
+	if family == "ipv6" {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// ipsetEntries renders prefixes as hash:net entries, which cannot hold a
+// zero length prefix, so the whole address space is split in two halves
+func ipsetEntries(prefixes []netip.Prefix) []string {
+	entries := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.Bits() == 0 {
+			upper := p.Addr().AsSlice()
+			upper[0] = 0x80
+			hi, _ := netip.AddrFromSlice(upper)
+			entries = append(entries, netip.PrefixFrom(p.Addr(), 1).String(), netip.PrefixFrom(hi, 1).String())
+			continue
+		}
+		entries = append(entries, p.String())
+	}
+	return entries
+}
+
+func ipsetCreate(set prefilterSet) string {
+	family := "inet"
+	if set.Family == "ipv6" {
+		family = "inet6"
+	}
+	return fmt.Sprintf("create %s hash:net family %s\n", set.Name, family)
+}
+
+// createIPSet creates the set, or empties an existing one, and fills it in a
+// single ipset restore
+func createIPSet(set prefilterSet) error {
+	var b strings.Builder
+	b.WriteString(ipsetCreate(set))
+	fmt.Fprintf(&b, "flush %s\n", set.Name)
+	for _, e := range ipsetEntries(set.Prefix) {
+		fmt.Fprintf(&b, "add %s %s\n", set.Name, e)
+	}
+	if out, err := runInput(b.String(), "ipset", "-exist", "restore"); err != nil {
+		return fmt.Errorf("failed to create ipset %s: %v: %s", set.Name, err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// syncIPSet adds and deletes only the entries that differ between cur and next
+func syncIPSet(cur, next prefilterSet) error {
+	have := make(map[string]bool)
+	for _, e := range ipsetEntries(cur.Prefix) {
+		have[e] = true
+	}
+	want := make(map[string]bool)
+	for _, e := range ipsetEntries(next.Prefix) {
+		want[e] = true
+	}
+
+	var b strings.Builder
+	for e := range have {
+		if !want[e] {
+			fmt.Fprintf(&b, "del %s %s\n", next.Name, e)
+		}
+	}
+	for e := range want {
+		if !have[e] {
+			fmt.Fprintf(&b, "add %s %s\n", next.Name, e)
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	if out, err := runInput(b.String(), "ipset", "-exist", "restore"); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// destroyIPSets removes the prefilter sets left after the rules using them
+// are gone
+func destroyIPSets() {
+	if !hasBinary("ipset") {
+		return
+	}
+	out, err := run("ipset", "list", "-n")
+	if err != nil {
+		return
+	}
+	for _, name := range strings.Fields(out) {
+		if strings.HasPrefix(name, "b4_") {
+			_, _ = run("ipset", "destroy", name)
+		}
+	}
+}
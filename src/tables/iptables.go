@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +14,12 @@ import (
 	"github.com/daniellavrushin/b4/log"
 )
 
+// probeChainName is the chain discovery probe traffic is queued in, and
+// probeMark matches the traffic sent there
+const probeChainName = "B4_PROBE"
+
+var probeMark = fmt.Sprintf("0x%x/0x%x", config.ProbeMark, config.ProbeMark)
+
 type IPTablesManager struct {
 	cfg *config.Config
 }
@@ -144,12 +151,18 @@ func (s SysctlSetting) RevertBack() {
 }
 
 type Manifest struct {
+	IPSets  []prefilterSet
 	Chains  []Chain
 	Rules   []Rule
 	Sysctls []SysctlSetting
 }
 
 func (m Manifest) Apply() error {
+	for _, s := range m.IPSets {
+		if err := createIPSet(s); err != nil {
+			return err
+		}
+	}
 	for _, c := range m.Chains {
 		c.Ensure()
 	}
@@ -182,18 +195,52 @@ func (m Manifest) RevertSysctls() {
 	}
 }
 
-func (manager *IPTablesManager) buildManifest() (Manifest, error) {
-	cfg := manager.cfg
+// buildPortLimitSpecs returns one queue rule spec per group of at most 15
+// ports (multiport limit) sharing a connbytes limit
+func (manager *IPTablesManager) buildPortLimitSpecs(proto string, groups []config.PortLimit, queueNum, threads int) [][]string {
+	var specs [][]string
+	for _, g := range groups {
+		ports := make([]string, len(g.Ports))
+		for i, p := range g.Ports {
+			ports[i] = strings.ReplaceAll(p, "-", ":")
+		}
+
+		for _, chunk := range chunkPorts(ports, 15) {
+			spec := append(
+				[]string{"-p", proto, "-m", "multiport", "--dports", strings.Join(chunk, ","),
+					"-m", "connbytes", "--connbytes-dir", "original",
+					"--connbytes-mode", "packets", "--connbytes", fmt.Sprintf("0:%d", g.Limit)},
+				manager.buildNFQSpec(queueNum, threads)...,
+			)
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+func (manager *IPTablesManager) binaries() []string {
 	var ipts []string
-	if cfg.Queue.IPv4Enabled && hasBinary("iptables") {
+	if manager.cfg.Queue.IPv4Enabled && hasBinary("iptables") {
 		ipts = append(ipts, "iptables")
 	}
-	if cfg.Queue.IPv6Enabled && hasBinary("ip6tables") {
+	if manager.cfg.Queue.IPv6Enabled && hasBinary("ip6tables") {
 		ipts = append(ipts, "ip6tables")
 	}
+	return ipts
+}
+
+func (manager *IPTablesManager) buildManifest() (Manifest, error) {
+	ipts := manager.binaries()
 	if len(ipts) == 0 {
 		return Manifest{}, errors.New("no valid iptables binaries found")
 	}
+	return manager.manifestFor(ipts), nil
+}
+
+// manifestFor builds the manifest for the given iptables binaries without
+// checking that they are installed
+func (manager *IPTablesManager) manifestFor(ipts []string) Manifest {
+	cfg := manager.cfg
 	queueNum := cfg.Queue.StartNum
 	threads := cfg.Queue.Threads
 	chainName := "B4"
@@ -205,18 +252,34 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 	var chains []Chain
 	var rules []Rule
 
-	for _, ipt := range ipts {
-		ch := Chain{manager: manager, IPT: ipt, Table: "mangle", Name: chainName}
-		chains = append(chains, ch)
+	tcpLimits := cfg.CollectTCPPortLimits()
+	udpLimits := cfg.CollectUDPPortLimits()
 
-		tcpConnbytesRange := fmt.Sprintf("0:%d", cfg.MainSet.TCP.ConnBytesLimit)
-		udpConnbytesRange := fmt.Sprintf("0:%d", cfg.MainSet.UDP.ConnBytesLimit)
+	var ipsets []prefilterSet
+	useIPSet := hasBinary("ipset")
+	if useIPSet {
+		for _, set := range prefilterSets(cfg) {
+			if slices.Contains(ipts, iptablesBinary(set.Family)) {
+				ipsets = append(ipsets, set)
+			}
+		}
+	}
 
-		tcpSpec := append(
-			[]string{"-p", "tcp", "--dport", "443",
-				"-m", "connbytes", "--connbytes-dir", "original",
-				"--connbytes-mode", "packets", "--connbytes", tcpConnbytesRange},
-			manager.buildNFQSpec(queueNum, threads)...,
+	portLimitRules := func(ipt, proto string, groups []config.PortLimit) {
+		for i, g := range groups {
+			for _, spec := range manager.buildPortLimitSpecs(proto, []config.PortLimit{g}, queueNum, threads) {
+				if g.Prefilter && useIPSet {
+					spec = append([]string{"-m", "set", "--match-set", prefilterSetName(proto, i, ipsetFamily(ipt)), "dst"}, spec...)
+				}
+				rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: spec})
+			}
+		}
+	}
+
+	for _, ipt := range ipts {
+		chains = append(chains,
+			Chain{manager: manager, IPT: ipt, Table: "mangle", Name: chainName},
+			Chain{manager: manager, IPT: ipt, Table: "mangle", Name: probeChainName},
 		)
 
 		dnsSpec := append(
@@ -229,27 +292,13 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 			manager.buildNFQSpec(queueNum, threads)...,
 		)
 
+		portLimitRules(ipt, "tcp", tcpLimits)
+
 		rules = append(rules,
-			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: tcpSpec},
 			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: dnsSpec},
 		)
 
-		udpPorts := cfg.CollectUDPPorts()
-		for i, p := range udpPorts {
-			udpPorts[i] = strings.ReplaceAll(p, "-", ":")
-		}
-
-		udpPortChunks := chunkPorts(udpPorts, 15)
-		for _, chunk := range udpPortChunks {
-			udpPortSpec := []string{"-p", "udp", "-m", "multiport", "--dports", strings.Join(chunk, ",")}
-			udpSpec := append(
-				append(udpPortSpec,
-					"-m", "connbytes", "--connbytes-dir", "original",
-					"--connbytes-mode", "packets", "--connbytes", udpConnbytesRange),
-				manager.buildNFQSpec(queueNum, threads)...,
-			)
-			rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: udpSpec})
-		}
+		portLimitRules(ipt, "udp", udpLimits)
 
 		if cfg.Queue.Devices.Enabled && len(cfg.Queue.Devices.Mac) > 0 {
 			if cfg.Queue.Devices.WhiteIsBlack {
@@ -290,6 +339,8 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I", Spec: dnsResponseSpec},
 			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "OUTPUT", Action: "I",
 				Spec: []string{"-m", "mark", "--mark", markAccept, "-j", "ACCEPT"}},
+			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "OUTPUT", Action: "A",
+				Spec: []string{"-m", "mark", "--mark", probeMark, "-j", probeChainName}},
 			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "OUTPUT", Action: "A",
 				Spec: []string{"-j", chainName}},
 		)
@@ -300,7 +351,51 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 		{Name: "net.netfilter.nf_conntrack_tcp_be_liberal", Desired: "1", Revert: "0"},
 	}
 
-	return Manifest{Chains: chains, Rules: rules, Sysctls: sysctls}, nil
+	return Manifest{IPSets: ipsets, Chains: chains, Rules: rules, Sysctls: sysctls}
+}
+
+// probeRules queue discovery probe traffic, whatever its destination. They
+// go in the probe chain, which only marked traffic from this host reaches.
+func (manager *IPTablesManager) probeRules(ipts []string) []Rule {
+	cfg := manager.cfg
+	limits := map[string]int{
+		"tcp": config.DefaultSetConfig.TCP.ConnBytesLimit,
+		"udp": config.DefaultSetConfig.UDP.ConnBytesLimit,
+	}
+
+	var rules []Rule
+	for _, ipt := range ipts {
+		for _, proto := range []string{"tcp", "udp"} {
+			spec := append([]string{"-p", proto,
+				"-m", "connbytes", "--connbytes-dir", "original",
+				"--connbytes-mode", "packets", "--connbytes", fmt.Sprintf("0:%d", limits[proto])},
+				manager.buildNFQSpec(cfg.Queue.StartNum, cfg.Queue.Threads)...)
+			rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: probeChainName, Action: "A", Spec: spec})
+		}
+	}
+	return rules
+}
+
+// addProbeRules starts queueing discovery probe traffic
+func (ipt *IPTablesManager) addProbeRules() error {
+	for _, r := range ipt.probeRules(ipt.binaries()) {
+		if err := r.Apply(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearProbeRules stops queueing discovery probe traffic
+func (ipt *IPTablesManager) clearProbeRules() error {
+	for _, bin := range ipt.binaries() {
+		if ipt.existsChain(bin, "mangle", probeChainName) {
+			if _, err := run(bin, "-w", "-t", "mangle", "-F", probeChainName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (ipt *IPTablesManager) Apply() error {
@@ -310,7 +405,15 @@ func (ipt *IPTablesManager) Apply() error {
 	if err != nil {
 		return err
 	}
+	logPortLimits("tcp", ipt.cfg.CollectTCPPortLimits())
+	logPortLimits("udp", ipt.cfg.CollectUDPPortLimits())
+	if len(m.IPSets) == 0 && len(prefilterSets(ipt.cfg)) > 0 {
+		log.Warnf("IPTABLES: ipset not found, queueing prefiltered ports for all destinations")
+	}
 	result := m.Apply()
+	if result == nil {
+		rememberPrefilterSets("iptables", m.IPSets)
+	}
 
 	if log.Level(log.CurLevel.Load()) >= log.LevelTrace {
 		iptables_trace, _ := run("sh", "-c", "cat /proc/net/netfilter/nfnetlink_queue && iptables -t mangle -vnL --line-numbers")
@@ -326,10 +429,12 @@ func (ipt *IPTablesManager) Clear() error {
 	}
 
 	ipt.clearB4JumpRules()
+	rememberPrefilterSets("", nil)
 
 	m.RemoveRules()
 	time.Sleep(30 * time.Millisecond)
 	m.RemoveChains()
+	destroyIPSets()
 	return nil
 }
 
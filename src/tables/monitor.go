@@ -144,6 +144,10 @@ func (m *Monitor) checkIPTablesRules() bool {
 func (m *Monitor) checkNFTablesRules() bool {
 	nft := NewNFTablesManager(m.cfg)
 
+	if !hasBinary("nft") {
+		return m.checkNetlinkRules(nft)
+	}
+
 	if !nft.tableExists() {
 		log.Tracef("Monitor: nftables table missing")
 		return false
@@ -200,6 +204,35 @@ func (m *Monitor) checkNFTablesRules() bool {
 	return true
 }
 
+// checkNetlinkRules compares the installed chains and their rule counts with
+// the expected ruleset when there is no nft binary to list them
+func (m *Monitor) checkNetlinkRules(nft *NFTablesManager) bool {
+	counts := netlinkChains(nftTableName)
+	if counts == nil {
+		log.Tracef("Monitor: nftables table missing")
+		return false
+	}
+
+	rs := nft.buildRuleset()
+	want := make(map[string]int, len(rs.Chains))
+	for _, r := range rs.Rules {
+		want[r.Chain]++
+	}
+	for _, c := range rs.Chains {
+		got, ok := counts[c.Name]
+		if !ok {
+			log.Tracef("Monitor: %s chain missing", c.Name)
+			return false
+		}
+		if got < want[c.Name] {
+			log.Tracef("Monitor: %s chain has %d of %d rules", c.Name, got, want[c.Name])
+			return false
+		}
+	}
+
+	return true
+}
+
 func (m *Monitor) restoreRules() error {
 	return AddRules(m.cfg)
 }
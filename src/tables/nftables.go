@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,13 +13,13 @@ import (
 )
 
 const (
-	nftTableName = "b4_mangle"
-	nftChainName = "b4_chain"
+	nftTableName      = "b4_mangle"
+	nftChainName      = "b4_chain"
+	nftProbeChainName = "b4_probe"
 )
 
 type NFTablesManager struct {
-	cfg             *config.Config
-	ipVersionFilter string
+	cfg *config.Config
 }
 
 func NewNFTablesManager(cfg *config.Config) *NFTablesManager {
@@ -80,11 +81,27 @@ func (n *NFTablesManager) createChain(chain, hook string, priority int, policy s
 	return nil
 }
 
-func (n *NFTablesManager) buildNFQueueAction() string {
-	if n.cfg.Queue.Threads > 1 {
-		return fmt.Sprintf("queue num %d-%d bypass", n.cfg.Queue.StartNum, n.cfg.Queue.StartNum+n.cfg.Queue.Threads-1)
+// createSet adds a prefilter set with its elements, in chunks to keep the
+// command line short
+func (n *NFTablesManager) createSet(set prefilterSet) error {
+	_, err := n.runNft("add", "set", "inet", nftTableName, set.Name,
+		fmt.Sprintf("{ type %s_addr ; flags interval ; }", set.Family))
+	if err != nil {
+		return fmt.Errorf("failed to create set %s: %w", set.Name, err)
+	}
+
+	for chunk := range slices.Chunk(set.Prefix, 1000) {
+		_, err := n.runNft("add", "element", "inet", nftTableName, set.Name, "{ "+prefixList(chunk)+" }")
+		if err != nil {
+			return fmt.Errorf("failed to add elements to set %s: %w", set.Name, err)
+		}
 	}
-	return fmt.Sprintf("queue num %d bypass", n.cfg.Queue.StartNum)
+	return nil
+}
+
+func (n *NFTablesManager) buildNFQueueAction() string {
+	r := nftRule{Verdict: nftQueue, QueueNum: n.cfg.Queue.StartNum, QueueTotal: n.cfg.Queue.Threads}
+	return strings.Join(r.Args(), " ")
 }
 
 func (n *NFTablesManager) addRule(chain string, args ...string) error {
@@ -96,146 +113,104 @@ func (n *NFTablesManager) addRule(chain string, args ...string) error {
 	return nil
 }
 
-func (n *NFTablesManager) addFilteredRule(chain string, args ...string) error {
-	if n.ipVersionFilter != "" {
-		args = append(strings.Fields(n.ipVersionFilter), args...)
-	}
-	return n.addRule(chain, args...)
-}
-
-func (n *NFTablesManager) addQueueRule(chain string, args ...string) error {
-	args = append(args, strings.Fields(n.buildNFQueueAction())...)
-	return n.addFilteredRule(chain, args...)
+// Plan returns the ruleset Apply would install, in nft syntax
+func (n *NFTablesManager) Plan() string {
+	return n.buildRuleset().String()
 }
 
 func (n *NFTablesManager) Apply() error {
 	cfg := n.cfg
-	if !hasBinary("nft") {
-		return fmt.Errorf("nft binary not found")
-	}
 
 	log.Tracef("NFTABLES: adding rules")
 	loadKernelModules()
 
-	// Set IP version filter
-	switch {
-	case cfg.Queue.IPv4Enabled && cfg.Queue.IPv6Enabled:
-		n.ipVersionFilter = ""
-	case cfg.Queue.IPv4Enabled:
-		n.ipVersionFilter = "meta nfproto ipv4"
-	case cfg.Queue.IPv6Enabled:
-		n.ipVersionFilter = "meta nfproto ipv6"
-	}
+	logPortLimits("tcp", cfg.CollectTCPPortLimits())
+	logPortLimits("udp", cfg.CollectUDPPortLimits())
 
-	if err := n.createTable(); err != nil {
-		return err
+	rs := n.buildRuleset()
+	if err := applyNetlink(rs); err != nil {
+		if !hasBinary("nft") {
+			return err
+		}
+		log.Warnf("NFTABLES: netlink batch failed, falling back to nft binary: %v", err)
+		if err := n.applyNft(rs); err != nil {
+			return err
+		}
 	}
 
-	if err := n.createChain("output", "output", 149, "accept"); err != nil {
-		return err
-	}
-	if err := n.createChain("prerouting", "prerouting", -150, "accept"); err != nil {
-		return err
-	}
-	if err := n.createChain(nftChainName, "", 0, ""); err != nil {
-		return err
+	rememberPrefilterSets("nftables", rs.Sets)
+
+	setSysctlOrProc("net.netfilter.nf_conntrack_checksum", "0")
+	setSysctlOrProc("net.netfilter.nf_conntrack_tcp_be_liberal", "1")
+
+	if log.Level(log.CurLevel.Load()) >= log.LevelTrace {
+		log.Tracef("Current nftables rules:\n%s", rs.String())
 	}
 
-	markAccept := fmt.Sprintf("0x%x", cfg.Queue.Mark)
+	return nil
+}
 
-	if cfg.Queue.Devices.Enabled && len(cfg.Queue.Devices.Mac) > 0 {
-		if err := n.createChain("forward", "forward", -1, "accept"); err != nil {
+// applyNft installs rs rule by rule through the nft binary
+func (n *NFTablesManager) applyNft(rs nftRuleset) error {
+	if err := n.createTable(); err != nil {
+		return err
+	}
+	for _, set := range rs.Sets {
+		if err := n.createSet(set); err != nil {
 			return err
 		}
-
-		if cfg.Queue.Devices.WhiteIsBlack {
-			for _, mac := range cfg.Queue.Devices.Mac {
-				if mac = strings.ToUpper(strings.TrimSpace(mac)); mac != "" {
-					if err := n.addRule("forward", "ether", "saddr", mac, "return"); err != nil {
-						return err
-					}
-				}
-			}
-			if err := n.addRule("forward", "jump", nftChainName); err != nil {
-				return err
-			}
-		} else {
-			for _, mac := range cfg.Queue.Devices.Mac {
-				if mac = strings.ToUpper(strings.TrimSpace(mac)); mac != "" {
-					if err := n.addRule("forward", "ether", "saddr", mac, "jump", nftChainName); err != nil {
-						return err
-					}
-				}
-			}
-		}
-	} else {
-		if err := n.createChain("postrouting", "postrouting", 149, "accept"); err != nil {
+	}
+	for _, c := range rs.Chains {
+		if err := n.createChain(c.Name, c.Hook, c.Priority, c.Policy); err != nil {
 			return err
 		}
-		if err := n.addRule("postrouting", "jump", nftChainName); err != nil {
+	}
+	for _, r := range rs.Rules {
+		if err := n.addRule(r.Chain, r.Args()...); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	if err := n.addRule("output", "meta", "mark", markAccept, "accept"); err != nil {
-		return err
-	}
-	if err := n.addRule("output", "jump", nftChainName); err != nil {
-		return err
-	}
-	if err := n.addRule(nftChainName, "meta", "mark", markAccept, "return"); err != nil {
-		return err
-	}
-
-	tcpLimit := fmt.Sprintf("%d", cfg.MainSet.TCP.ConnBytesLimit+1)
-	udpLimit := fmt.Sprintf("%d", cfg.MainSet.UDP.ConnBytesLimit+1)
-
-	// TCP 443
-	if err := n.addQueueRule(nftChainName, "tcp", "dport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
-		return err
-	}
-
-	// DNS query
-	if err := n.addQueueRule(nftChainName, "udp", "dport", "53", "counter"); err != nil {
+// addProbeRules starts queueing discovery probe traffic
+func (n *NFTablesManager) addProbeRules() error {
+	rules := n.probeRules()
+	err := addRulesNetlink(rules)
+	if err == nil || !hasBinary("nft") {
 		return err
 	}
-
-	// DNS response
-	if err := n.addQueueRule("prerouting", "udp", "sport", "53", "counter"); err != nil {
-		return err
+	for _, r := range rules {
+		if err := n.addRule(r.Chain, r.Args()...); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// UDP ports
-	udpPorts := cfg.CollectUDPPorts()
-
-	var udpPortExpr string
-	if len(udpPorts) == 1 {
-		udpPortExpr = udpPorts[0]
-	} else {
-		udpPortExpr = "{ " + strings.Join(udpPorts, ", ") + " }"
-	}
-	if err := n.addQueueRule(nftChainName, "udp", "dport", udpPortExpr, "ct", "original", "packets", "<", udpLimit, "counter"); err != nil {
+// clearProbeRules stops queueing discovery probe traffic
+func (n *NFTablesManager) clearProbeRules() error {
+	err := flushChainNetlink(nftProbeChainName)
+	if err == nil || !hasBinary("nft") {
 		return err
 	}
-
-	setSysctlOrProc("net.netfilter.nf_conntrack_checksum", "0")
-	setSysctlOrProc("net.netfilter.nf_conntrack_tcp_be_liberal", "1")
-
-	if log.Level(log.CurLevel.Load()) >= log.LevelTrace {
-		out, _ := n.runNft("list", "table", "inet", nftTableName)
-		log.Tracef("Current nftables rules:\n%s", out)
-	}
-
-	return nil
+	_, err = n.runNft("flush", "chain", "inet", nftTableName, nftProbeChainName)
+	return err
 }
 
 func (n *NFTablesManager) Clear() error {
+	log.Tracef("NFTABLES: clearing rules")
+	rememberPrefilterSets("", nil)
+
+	err := deleteNetlink(nftTableName)
+	if err == nil {
+		return nil
+	}
 	if !hasBinary("nft") {
+		log.Errorf("Failed to delete nftables table: %v", err)
 		return nil
 	}
 
-	log.Tracef("NFTABLES: clearing rules")
-
 	if n.tableExists() {
 		if _, err := n.runNft("flush", "table", "inet", nftTableName); err != nil {
 			log.Errorf("Failed to flush nftables table: %v", err)
@@ -248,3 +223,11 @@ func (n *NFTablesManager) Clear() error {
 
 	return nil
 }
+
+// portsExpr renders a port list as a single nft port or anonymous set
+func portsExpr(ports []string) string {
+	if len(ports) == 1 {
+		return ports[0]
+	}
+	return "{ " + strings.Join(ports, ", ") + " }"
+}
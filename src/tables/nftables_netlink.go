@@ -0,0 +1,468 @@
+package tables
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+var nftHooks = map[string]*nftables.ChainHook{
+	"prerouting":  nftables.ChainHookPrerouting,
+	"forward":     nftables.ChainHookForward,
+	"output":      nftables.ChainHookOutput,
+	"postrouting": nftables.ChainHookPostrouting,
+}
+
+var nftNfProtos = map[string]byte{
+	"ipv4": unix.NFPROTO_IPV4,
+	"ipv6": unix.NFPROTO_IPV6,
+}
+
+var nftL4Protos = map[string]byte{
+	"tcp": unix.IPPROTO_TCP,
+	"udp": unix.IPPROTO_UDP,
+}
+
+// netlinkAvailable reports whether nf_tables can be reached over netlink
+func netlinkAvailable() bool {
+	conn, err := nftables.New()
+	if err != nil {
+		return false
+	}
+	_, err = conn.ListTablesOfFamily(nftables.TableFamilyINet)
+	return err == nil
+}
+
+// applyNetlink replaces the b4 table with rs in a single atomic batch. The
+// table is added and deleted first so an existing table is dropped along with
+// the rest of the batch and a missing one does not fail it.
+func applyNetlink(rs nftRuleset) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open netlink connection: %w", err)
+	}
+
+	table := &nftables.Table{Name: rs.Table, Family: nftables.TableFamilyINet}
+	conn.AddTable(table)
+	conn.DelTable(table)
+	conn.AddTable(table)
+
+	sets := make(map[string]*nftables.Set, len(rs.Sets))
+	for _, ps := range rs.Sets {
+		set := &nftables.Set{Table: table, Name: ps.Name, KeyType: nftSetKeyType(ps.Family), Interval: true}
+		if err := conn.AddSet(set, prefixElements(ps.Prefix)); err != nil {
+			return fmt.Errorf("failed to add set %s: %w", ps.Name, err)
+		}
+		sets[ps.Name] = set
+	}
+
+	chains := make(map[string]*nftables.Chain, len(rs.Chains))
+	for _, c := range rs.Chains {
+		ch := &nftables.Chain{Name: c.Name, Table: table}
+		if c.Hook != "" {
+			hook, ok := nftHooks[c.Hook]
+			if !ok {
+				return fmt.Errorf("unsupported hook %q", c.Hook)
+			}
+			policy := nftables.ChainPolicyAccept
+			ch.Type = nftables.ChainTypeFilter
+			ch.Hooknum = hook
+			ch.Priority = nftables.ChainPriorityRef(nftables.ChainPriority(c.Priority))
+			ch.Policy = &policy
+		}
+		chains[c.Name] = conn.AddChain(ch)
+	}
+
+	for _, r := range rs.Rules {
+		ch, ok := chains[r.Chain]
+		if !ok {
+			return fmt.Errorf("rule references unknown chain %q", r.Chain)
+		}
+		exprs, err := r.exprs(conn, table, sets)
+		if err != nil {
+			return fmt.Errorf("failed to build rule for %s: %w", r.Chain, err)
+		}
+		conn.AddRule(&nftables.Rule{Table: table, Chain: ch, Exprs: exprs})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to apply nftables batch: %w", err)
+	}
+	return nil
+}
+
+// deleteNetlink removes the b4 table, ignoring a missing one
+func deleteNetlink(name string) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open netlink connection: %w", err)
+	}
+
+	table := &nftables.Table{Name: name, Family: nftables.TableFamilyINet}
+	conn.AddTable(table)
+	conn.DelTable(table)
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to delete nftables table: %w", err)
+	}
+	return nil
+}
+
+// addRulesNetlink appends rules to existing chains of the b4 table
+func addRulesNetlink(rules []nftRule) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open netlink connection: %w", err)
+	}
+
+	table := &nftables.Table{Name: nftTableName, Family: nftables.TableFamilyINet}
+	for _, r := range rules {
+		exprs, err := r.exprs(conn, table, nil)
+		if err != nil {
+			return fmt.Errorf("failed to build rule for %s: %w", r.Chain, err)
+		}
+		conn.AddRule(&nftables.Rule{Table: table, Chain: &nftables.Chain{Name: r.Chain, Table: table}, Exprs: exprs})
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to add nftables rules: %w", err)
+	}
+	return nil
+}
+
+// flushChainNetlink removes the rules of a chain of the b4 table
+func flushChainNetlink(chain string) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open netlink connection: %w", err)
+	}
+
+	table := &nftables.Table{Name: nftTableName, Family: nftables.TableFamilyINet}
+	conn.FlushChain(&nftables.Chain{Name: chain, Table: table})
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables chain %s: %w", chain, err)
+	}
+	return nil
+}
+
+// netlinkChains returns the chains of the b4 table with their rule counts, or
+// nil if the table does not exist
+func netlinkChains(name string) map[string]int {
+	conn, err := nftables.New()
+	if err != nil {
+		return nil
+	}
+
+	table, err := conn.ListTableOfFamily(name, nftables.TableFamilyINet)
+	if err != nil {
+		return nil
+	}
+	chains, err := conn.ListChainsOfTableFamily(nftables.TableFamilyINet)
+	if err != nil {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, c := range chains {
+		if c.Table.Name != table.Name {
+			continue
+		}
+		rules, err := conn.GetRules(table, c)
+		if err != nil {
+			return nil
+		}
+		counts[c.Name] = len(rules)
+	}
+	return counts
+}
+
+// exprs translates the rule into netlink expressions. Anonymous port sets are
+// queued on conn as part of the same batch.
+func (r nftRule) exprs(conn *nftables.Conn, table *nftables.Table, sets map[string]*nftables.Set) ([]expr.Any, error) {
+	var e []expr.Any
+
+	if r.NfProto != "" {
+		p, ok := nftNfProtos[r.NfProto]
+		if !ok {
+			return nil, fmt.Errorf("unsupported nfproto %q", r.NfProto)
+		}
+		e = append(e,
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{p}},
+		)
+	}
+
+	if r.EtherSrc != "" {
+		mac, err := net.ParseMAC(r.EtherSrc)
+		if err != nil {
+			return nil, err
+		}
+		e = append(e,
+			&expr.Meta{Key: expr.MetaKeyIIFTYPE, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint16(unix.ARPHRD_ETHER)},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseLLHeader, Offset: 6, Len: 6},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: mac},
+		)
+	}
+
+	if r.MatchMark {
+		e = append(e,
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(r.Mark)},
+		)
+	}
+
+	if r.DAddrSet != "" {
+		set, ok := sets[r.DAddrSet]
+		if !ok {
+			return nil, fmt.Errorf("unknown set %q", r.DAddrSet)
+		}
+		offset, length := uint32(16), uint32(4)
+		if r.NfProto == "ipv6" {
+			offset, length = 24, 16
+		}
+		e = append(e,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: length},
+			&expr.Lookup{SourceRegister: 1, SetName: set.Name, SetID: set.ID},
+		)
+	}
+
+	if r.L4Proto != "" {
+		p, ok := nftL4Protos[r.L4Proto]
+		if !ok {
+			return nil, fmt.Errorf("unsupported l4proto %q", r.L4Proto)
+		}
+		e = append(e,
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{p}},
+		)
+	}
+
+	if len(r.DPorts) > 0 {
+		pe, err := portExprs(conn, table, 2, r.DPorts)
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, pe...)
+	}
+
+	if r.SPort != "" {
+		pe, err := portExprs(conn, table, 0, []string{r.SPort})
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, pe...)
+	}
+
+	if r.MaxPackets > 0 {
+		// nft_cmp compares bytes, so the host order counter is converted to
+		// big endian first, the same way nft does it
+		e = append(e,
+			&expr.Ct{Key: expr.CtKeyPKTS, Register: 1, Direction: 0, OptDirection: true},
+			&expr.Byteorder{SourceRegister: 1, DestRegister: 1, Op: expr.ByteorderHton, Len: 8, Size: 8},
+			&expr.Cmp{Op: expr.CmpOpLt, Register: 1, Data: binaryutil.BigEndian.PutUint64(uint64(r.MaxPackets))},
+		)
+	}
+
+	if r.Counter {
+		e = append(e, &expr.Counter{})
+	}
+
+	switch r.Verdict {
+	case nftAccept:
+		e = append(e, &expr.Verdict{Kind: expr.VerdictAccept})
+	case nftReturn:
+		e = append(e, &expr.Verdict{Kind: expr.VerdictReturn})
+	case nftJump:
+		e = append(e, &expr.Verdict{Kind: expr.VerdictJump, Chain: r.Target})
+	case nftQueue:
+		e = append(e, &expr.Queue{
+			Num:   uint16(r.QueueNum),
+			Total: uint16(max(r.QueueTotal, 1)),
+			Flag:  expr.QueueFlagBypass,
+		})
+	}
+
+	return e, nil
+}
+
+// portExprs matches the transport header port at offset against ports, using
+// a plain compare, a range or an anonymous interval set
+func portExprs(conn *nftables.Conn, table *nftables.Table, offset uint32, ports []string) ([]expr.Any, error) {
+	type portRange struct{ start, end uint16 }
+
+	ranges := make([]portRange, 0, len(ports))
+	for _, p := range ports {
+		start, end, err := parsePortSpec(p)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, portRange{start, end})
+	}
+
+	e := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: offset, Len: 2},
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		if r.start == r.end {
+			return append(e, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(r.start)}), nil
+		}
+		return append(e, &expr.Range{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			FromData: binaryutil.BigEndian.PutUint16(r.start),
+			ToData:   binaryutil.BigEndian.PutUint16(r.end),
+		}), nil
+	}
+
+	set := &nftables.Set{
+		Table:     table,
+		Anonymous: true,
+		Constant:  true,
+		Interval:  true,
+		KeyType:   nftables.TypeInetService,
+	}
+
+	var elems []nftables.SetElement
+	if ranges[0].start > 0 {
+		elems = append(elems, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(0), IntervalEnd: true})
+	}
+	for _, r := range ranges {
+		elems = append(elems, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(r.start)})
+		if r.end == 65535 {
+			elems[len(elems)-1].IntervalOpen = true
+			continue
+		}
+		elems = append(elems, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(r.end + 1), IntervalEnd: true})
+	}
+
+	if err := conn.AddSet(set, elems); err != nil {
+		return nil, err
+	}
+
+	return append(e, &expr.Lookup{SourceRegister: 1, SetName: set.Name, SetID: set.ID}), nil
+}
+
+// parsePortSpec parses "443" or "1000-2000"
+func parsePortSpec(s string) (uint16, uint16, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	start, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.ParseUint(strings.TrimSpace(hi), 10, 16); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return uint16(start), uint16(end), nil
+}
+
+func nftSetKeyType(family string) nftables.SetDatatype {
+	if family == "ipv6" {
+		return nftables.TypeIP6Addr
+	}
+	return nftables.TypeIPAddr
+}
+
+// prefixElements encodes prefixes as interval set elements. A prefix
+// reaching the end of the address space has no end element.
+func prefixElements(prefixes []netip.Prefix) []nftables.SetElement {
+	elems := make([]nftables.SetElement, 0, len(prefixes)*2)
+	for _, p := range prefixes {
+		elems = append(elems, nftables.SetElement{Key: p.Addr().AsSlice()})
+		if next := lastAddr(p).Next(); next.IsValid() {
+			elems = append(elems, nftables.SetElement{Key: next.AsSlice(), IntervalEnd: true})
+		} else {
+			elems[len(elems)-1].IntervalOpen = true
+		}
+	}
+	return elems
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// syncNFTSet moves the elements of a prefilter set from cur to next in one
+// batch. Without netlink access the set is refilled through the nft binary.
+func syncNFTSet(cur, next prefilterSet) error {
+	err := syncNFTSetNetlink(cur, next)
+	if err == nil || !hasBinary("nft") {
+		return err
+	}
+
+	script := fmt.Sprintf("flush set inet %s %s\n", nftTableName, next.Name)
+	if len(next.Prefix) > 0 {
+		script += fmt.Sprintf("add element inet %s %s { %s }\n", nftTableName, next.Name, prefixList(next.Prefix))
+	}
+	if out, err := runInput(script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func syncNFTSetNetlink(cur, next prefilterSet) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return err
+	}
+
+	table := &nftables.Table{Name: nftTableName, Family: nftables.TableFamilyINet}
+	set, err := conn.GetSetByName(table, next.Name)
+	if err != nil {
+		return err
+	}
+
+	type elemKey struct {
+		key string
+		end bool
+	}
+	index := func(elems []nftables.SetElement) map[elemKey]bool {
+		m := make(map[elemKey]bool, len(elems))
+		for _, e := range elems {
+			m[elemKey{string(e.Key), e.IntervalEnd}] = true
+		}
+		return m
+	}
+
+	curElems, nextElems := prefixElements(cur.Prefix), prefixElements(next.Prefix)
+	have, want := index(curElems), index(nextElems)
+
+	var del, add []nftables.SetElement
+	for _, e := range curElems {
+		if !want[elemKey{string(e.Key), e.IntervalEnd}] {
+			del = append(del, e)
+		}
+	}
+	for _, e := range nextElems {
+		if !have[elemKey{string(e.Key), e.IntervalEnd}] {
+			add = append(add, e)
+		}
+	}
+
+	if len(del) > 0 {
+		if err := conn.SetDeleteElements(set, del); err != nil {
+			return err
+		}
+	}
+	if len(add) > 0 {
+		if err := conn.SetAddElements(set, add); err != nil {
+			return err
+		}
+	}
+	return conn.Flush()
+}
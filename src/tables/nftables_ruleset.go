@@ -0,0 +1,250 @@
+package tables
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+type nftVerdict int
+
+const (
+	nftAccept nftVerdict = iota
+	nftReturn
+	nftJump
+	nftQueue
+)
+
+type nftChain struct {
+	Name     string
+	Hook     string // empty for a regular chain
+	Priority int
+	Policy   string
+}
+
+// nftRule is a rule of the b4 table in a form that can be rendered either as
+// nft syntax or as netlink expressions
+type nftRule struct {
+	Chain      string
+	NfProto    string // "ipv4", "ipv6" or empty for both
+	EtherSrc   string
+	MatchMark  bool
+	Mark       uint32
+	DAddrSet   string // named set of destinations, of the NfProto family
+	L4Proto    string // "tcp" or "udp", required by DPorts/SPort
+	DPorts     []string
+	SPort      string
+	MaxPackets int // ct original packets < MaxPackets, 0 to skip
+	Counter    bool
+	Verdict    nftVerdict
+	Target     string // chain for nftJump
+	QueueNum   int
+	QueueTotal int
+}
+
+type nftRuleset struct {
+	Table  string
+	Sets   []prefilterSet
+	Chains []nftChain
+	Rules  []nftRule
+}
+
+// Args renders the rule as nft command arguments
+func (r nftRule) Args() []string {
+	var a []string
+	if r.NfProto != "" && r.DAddrSet == "" {
+		a = append(a, "meta", "nfproto", r.NfProto)
+	}
+	if r.EtherSrc != "" {
+		a = append(a, "ether", "saddr", r.EtherSrc)
+	}
+	if r.MatchMark {
+		a = append(a, "meta", "mark", fmt.Sprintf("0x%x", r.Mark))
+	}
+	if r.DAddrSet != "" {
+		a = append(a, nftAddrProto(r.NfProto), "daddr", "@"+r.DAddrSet)
+	}
+	if r.L4Proto != "" && len(r.DPorts) == 0 && r.SPort == "" {
+		a = append(a, "meta", "l4proto", r.L4Proto)
+	}
+	if len(r.DPorts) > 0 {
+		a = append(a, r.L4Proto, "dport", portsExpr(r.DPorts))
+	}
+	if r.SPort != "" {
+		a = append(a, r.L4Proto, "sport", r.SPort)
+	}
+	if r.MaxPackets > 0 {
+		a = append(a, "ct", "original", "packets", "<", strconv.Itoa(r.MaxPackets))
+	}
+	if r.Counter {
+		a = append(a, "counter")
+	}
+
+	switch r.Verdict {
+	case nftAccept:
+		a = append(a, "accept")
+	case nftReturn:
+		a = append(a, "return")
+	case nftJump:
+		a = append(a, "jump", r.Target)
+	case nftQueue:
+		if r.QueueTotal > 1 {
+			a = append(a, "queue", "num", fmt.Sprintf("%d-%d", r.QueueNum, r.QueueNum+r.QueueTotal-1), "bypass")
+		} else {
+			a = append(a, "queue", "num", strconv.Itoa(r.QueueNum), "bypass")
+		}
+	}
+	return a
+}
+
+// String renders the ruleset in `nft -f` syntax
+func (rs nftRuleset) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s {\n", rs.Table)
+	for _, set := range rs.Sets {
+		fmt.Fprintf(&b, "\tset %s {\n\t\ttype %s_addr\n\t\tflags interval\n", set.Name, set.Family)
+		if len(set.Prefix) > 0 {
+			fmt.Fprintf(&b, "\t\telements = { %s }\n", prefixList(set.Prefix))
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, c := range rs.Chains {
+		fmt.Fprintf(&b, "\tchain %s {\n", c.Name)
+		if c.Hook != "" {
+			fmt.Fprintf(&b, "\t\ttype filter hook %s priority %d; policy %s;\n", c.Hook, c.Priority, c.Policy)
+		}
+		for _, r := range rs.Rules {
+			if r.Chain == c.Name {
+				fmt.Fprintf(&b, "\t\t%s\n", strings.Join(r.Args(), " "))
+			}
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// buildRuleset describes the whole b4 table for cfg. Regular chains come first
+// so jumps always refer to an existing chain.
+func (n *NFTablesManager) buildRuleset() nftRuleset {
+	cfg := n.cfg
+	rs := nftRuleset{Table: nftTableName}
+	queue := n.queue
+
+	rs.Chains = append(rs.Chains,
+		nftChain{Name: nftChainName},
+		nftChain{Name: nftProbeChainName},
+		nftChain{Name: "output", Hook: "output", Priority: 149, Policy: "accept"},
+		nftChain{Name: "prerouting", Hook: "prerouting", Priority: -150, Policy: "accept"},
+	)
+
+	if cfg.Queue.Devices.Enabled && len(cfg.Queue.Devices.Mac) > 0 {
+		rs.Chains = append(rs.Chains, nftChain{Name: "forward", Hook: "forward", Priority: -1, Policy: "accept"})
+
+		for _, mac := range cfg.Queue.Devices.Mac {
+			if mac = strings.ToUpper(strings.TrimSpace(mac)); mac == "" {
+				continue
+			}
+			if cfg.Queue.Devices.WhiteIsBlack {
+				rs.Rules = append(rs.Rules, nftRule{Chain: "forward", EtherSrc: mac, Verdict: nftReturn})
+			} else {
+				rs.Rules = append(rs.Rules, nftRule{Chain: "forward", EtherSrc: mac, Verdict: nftJump, Target: nftChainName})
+			}
+		}
+		if cfg.Queue.Devices.WhiteIsBlack {
+			rs.Rules = append(rs.Rules, nftRule{Chain: "forward", Verdict: nftJump, Target: nftChainName})
+		}
+	} else {
+		rs.Chains = append(rs.Chains, nftChain{Name: "postrouting", Hook: "postrouting", Priority: 149, Policy: "accept"})
+		rs.Rules = append(rs.Rules, nftRule{Chain: "postrouting", Verdict: nftJump, Target: nftChainName})
+	}
+
+	rs.Rules = append(rs.Rules,
+		nftRule{Chain: "output", MatchMark: true, Mark: uint32(cfg.Queue.Mark), Verdict: nftAccept},
+		nftRule{Chain: "output", MatchMark: true, Mark: config.ProbeMark, Verdict: nftJump, Target: nftProbeChainName},
+		nftRule{Chain: "output", Verdict: nftJump, Target: nftChainName},
+		nftRule{Chain: nftChainName, MatchMark: true, Mark: uint32(cfg.Queue.Mark), Verdict: nftReturn},
+	)
+
+	rs.Sets = prefilterSets(cfg)
+
+	// Prefiltered groups get one rule per family, matching the destinations
+	// in the group's set
+	portLimitRules := func(proto string, groups []config.PortLimit) {
+		for i, g := range groups {
+			r := nftRule{L4Proto: proto, DPorts: g.Ports, MaxPackets: g.Limit + 1}
+			if !g.Prefilter {
+				rs.Rules = append(rs.Rules, queue(r))
+				continue
+			}
+			for _, family := range prefilterFamilies(cfg) {
+				r.NfProto = family
+				r.DAddrSet = prefilterSetName(proto, i, family)
+				rs.Rules = append(rs.Rules, queue(r))
+			}
+		}
+	}
+
+	portLimitRules("tcp", cfg.CollectTCPPortLimits())
+
+	// DNS query
+	rs.Rules = append(rs.Rules, queue(nftRule{L4Proto: "udp", DPorts: []string{"53"}}))
+
+	// DNS response
+	rs.Rules = append(rs.Rules, queue(nftRule{Chain: "prerouting", L4Proto: "udp", SPort: "53"}))
+
+	portLimitRules("udp", cfg.CollectUDPPortLimits())
+
+	return rs
+}
+
+// queue makes r queue to the workers, in the b4 chain unless it names
+// another
+func (n *NFTablesManager) queue(r nftRule) nftRule {
+	cfg := n.cfg
+	if r.Chain == "" {
+		r.Chain = nftChainName
+	}
+	if r.NfProto == "" {
+		switch {
+		case cfg.Queue.IPv4Enabled && cfg.Queue.IPv6Enabled:
+		case cfg.Queue.IPv4Enabled:
+			r.NfProto = "ipv4"
+		case cfg.Queue.IPv6Enabled:
+			r.NfProto = "ipv6"
+		}
+	}
+	r.Counter = true
+	r.Verdict = nftQueue
+	r.QueueNum = cfg.Queue.StartNum
+	r.QueueTotal = max(cfg.Queue.Threads, 1)
+	return r
+}
+
+// probeRules queue discovery probe traffic, whatever its destination. They
+// go in the probe chain, which only marked traffic from this host reaches.
+func (n *NFTablesManager) probeRules() []nftRule {
+	return []nftRule{
+		n.queue(nftRule{Chain: nftProbeChainName, L4Proto: "tcp", MaxPackets: config.DefaultSetConfig.TCP.ConnBytesLimit + 1}),
+		n.queue(nftRule{Chain: nftProbeChainName, L4Proto: "udp", MaxPackets: config.DefaultSetConfig.UDP.ConnBytesLimit + 1}),
+	}
+}
+
+// nftAddrProto returns the payload protocol carrying addresses of family
+func nftAddrProto(family string) string {
+	if family == "ipv6" {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func prefixList(prefixes []netip.Prefix) string {
+	parts := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		parts[i] = p.String()
+	}
+	return strings.Join(parts, ", ")
+}
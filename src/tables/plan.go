@@ -0,0 +1,298 @@
+package tables
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/http/handler"
+)
+
+// planLine is a rule of a plan. Key is a normalized form used to match it
+// against the installed rules regardless of how the tool prints them.
+type planLine struct {
+	Key  string
+	Text string
+}
+
+var (
+	nftCounterRe = regexp.MustCompile(`counter packets \d+ bytes \d+`)
+	nftHexRe     = regexp.MustCompile(`0x0+([0-9a-f])`)
+	nftQueueRe   = regexp.MustCompile(`queue flags bypass to (\S+)`)
+	spacesRe     = regexp.MustCompile(`\s+`)
+)
+
+// PlanRules renders what AddRules would install for cfg without executing
+// anything and diffs it against the rules currently installed. An empty
+// backend is detected the same way AddRules does.
+func PlanRules(cfg *config.Config, backend string) (*handler.TablesPlan, error) {
+	if backend == "" {
+		backend = detectFirewallBackend()
+	}
+
+	var expected, installed []planLine
+	plan := &handler.TablesPlan{Backend: backend}
+
+	switch backend {
+	case "nftables":
+		rs := NewNFTablesManager(cfg).buildRuleset()
+		plan.Script = rs.String()
+		var full bool
+		plan.Installed, installed, full = installedNFTables()
+		expected = nftPlanLines(rs, full)
+	case "iptables":
+		ipt := NewIPTablesManager(cfg)
+		ipts := ipt.binaries()
+		if len(ipts) == 0 {
+			if cfg.Queue.IPv4Enabled {
+				ipts = append(ipts, "iptables")
+			}
+			if cfg.Queue.IPv6Enabled {
+				ipts = append(ipts, "ip6tables")
+			}
+		}
+		m := ipt.manifestFor(ipts)
+		plan.Script = m.Script()
+		expected = iptPlanLines(m)
+		plan.Installed, installed = installedIPTables(m)
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q", backend)
+	}
+
+	plan.Diff = diffPlanLines(expected, installed)
+	plan.InSync = len(plan.Diff) == 0
+	return plan, nil
+}
+
+// diffPlanLines lists expected rules that are missing with "+" followed by
+// installed rules that are not expected with "-"
+func diffPlanLines(expected, installed []planLine) []string {
+	have := make(map[string]int, len(installed))
+	for _, l := range installed {
+		have[l.Key]++
+	}
+	want := make(map[string]int, len(expected))
+	for _, l := range expected {
+		want[l.Key]++
+	}
+
+	diff := []string{}
+	for _, l := range expected {
+		if have[l.Key] > 0 {
+			have[l.Key]--
+			continue
+		}
+		diff = append(diff, "+ "+l.Text)
+	}
+	for _, l := range installed {
+		if want[l.Key] > 0 {
+			want[l.Key]--
+			continue
+		}
+		diff = append(diff, "- "+l.Text)
+	}
+	return diff
+}
+
+// Script renders the manifest as the shell commands Apply would run
+func (m Manifest) Script() string {
+	var b strings.Builder
+	for _, s := range m.IPSets {
+		fmt.Fprintf(&b, "ipset -exist %s", ipsetCreate(s))
+		for _, e := range ipsetEntries(s.Prefix) {
+			fmt.Fprintf(&b, "ipset -exist add %s %s\n", s.Name, e)
+		}
+	}
+	for _, c := range m.Chains {
+		fmt.Fprintf(&b, "%s -w -t %s -N %s\n", c.IPT, c.Table, c.Name)
+	}
+	for _, r := range m.Rules {
+		fmt.Fprintf(&b, "%s\n", r.command())
+	}
+	for _, s := range m.Sysctls {
+		fmt.Fprintf(&b, "sysctl -w %s=%s\n", s.Name, s.Desired)
+	}
+	return b.String()
+}
+
+func (r Rule) command() string {
+	op := "-A"
+	if strings.ToUpper(r.Action) == "I" {
+		op = "-I"
+	}
+	return fmt.Sprintf("%s -w -t %s %s %s %s", r.IPT, r.Table, op, r.Chain, strings.Join(r.Spec, " "))
+}
+
+func iptPlanLines(m Manifest) []planLine {
+	lines := make([]planLine, 0, len(m.Chains)+len(m.Rules))
+	for _, c := range m.Chains {
+		lines = append(lines, planLine{
+			Key:  c.IPT + " -N " + c.Name,
+			Text: fmt.Sprintf("%s -w -t %s -N %s", c.IPT, c.Table, c.Name),
+		})
+	}
+	for _, r := range m.Rules {
+		lines = append(lines, planLine{Key: iptRuleKey(r.IPT, r.Chain, r.Spec), Text: r.command()})
+	}
+	return lines
+}
+
+// installedIPTables lists the rules of the chains the manifest touches. Rules
+// in built-in chains are only reported when they look like b4's own.
+func installedIPTables(m Manifest) (string, []planLine) {
+	type chainRef struct{ ipt, table, chain string }
+	var refs []chainRef
+	owned := map[chainRef]bool{}
+	for _, c := range m.Chains {
+		ref := chainRef{c.IPT, c.Table, c.Name}
+		refs = append(refs, ref)
+		owned[ref] = true
+	}
+	for _, r := range m.Rules {
+		ref := chainRef{r.IPT, r.Table, r.Chain}
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+
+	expected := map[string]bool{}
+	for _, l := range iptPlanLines(m) {
+		expected[l.Key] = true
+	}
+
+	var raw strings.Builder
+	var lines []planLine
+	for _, ref := range refs {
+		if !hasBinary(ref.ipt) {
+			continue
+		}
+		out, err := run(ref.ipt, "-w", "-t", ref.table, "-S", ref.chain)
+		if err != nil {
+			continue
+		}
+		for _, l := range strings.Split(out, "\n") {
+			fields := strings.Fields(l)
+			if len(fields) < 2 {
+				continue
+			}
+			if fields[0] == "-N" {
+				text := fmt.Sprintf("%s -w -t %s -N %s", ref.ipt, ref.table, fields[1])
+				raw.WriteString(text + "\n")
+				lines = append(lines, planLine{Key: ref.ipt + " -N " + fields[1], Text: text})
+				continue
+			}
+			if fields[0] != "-A" {
+				continue
+			}
+			key := iptRuleKey(ref.ipt, ref.chain, fields[2:])
+			if !owned[ref] && !expected[key] && !strings.Contains(l, "B4") && !strings.Contains(l, "NFQUEUE") {
+				continue
+			}
+			text := fmt.Sprintf("%s -w -t %s %s", ref.ipt, ref.table, strings.Join(fields, " "))
+			raw.WriteString(text + "\n")
+			lines = append(lines, planLine{Key: key, Text: text})
+		}
+	}
+	return raw.String(), lines
+}
+
+// iptRuleKey normalizes a rule spec so that it matches the way iptables -S
+// prints it: options are compared as an unordered set and the implicit
+// protocol match module is ignored
+func iptRuleKey(ipt, chain string, spec []string) string {
+	var opts []string
+	for i := 0; i < len(spec); {
+		j := i + 1
+		for j < len(spec) && !strings.HasPrefix(spec[j], "-") {
+			j++
+		}
+		opt := strings.Join(spec[i:j], " ")
+		i = j
+		if opt == "-m tcp" || opt == "-m udp" {
+			continue
+		}
+		opts = append(opts, strings.ToLower(opt))
+	}
+	slices.Sort(opts)
+	return ipt + " " + chain + " " + strings.Join(opts, " ")
+}
+
+// nftPlanLines lists the chains and rules of rs, or only the chain names
+// when the installed rules cannot be listed in full
+func nftPlanLines(rs nftRuleset, full bool) []planLine {
+	var lines []planLine
+	for _, c := range rs.Chains {
+		text := "chain " + c.Name
+		if c.Hook != "" && full {
+			text += fmt.Sprintf(" { type filter hook %s priority %d; policy %s; }", c.Hook, c.Priority, c.Policy)
+		}
+		lines = append(lines, planLine{Key: nftLineKey("", text), Text: text})
+	}
+	if !full {
+		return lines
+	}
+	for _, r := range rs.Rules {
+		text := strings.Join(r.Args(), " ")
+		lines = append(lines, planLine{Key: nftLineKey(r.Chain, text), Text: r.Chain + ": " + text})
+	}
+	return lines
+}
+
+// installedNFTables lists the b4 table with numeric priorities so it can be
+// compared with the generated ruleset. Without the nft binary only the chain
+// layout is known.
+func installedNFTables() (string, []planLine, bool) {
+	if !hasBinary("nft") {
+		var lines []planLine
+		for name := range netlinkChains(nftTableName) {
+			lines = append(lines, planLine{Key: "chain " + name, Text: "chain " + name})
+		}
+		return "", lines, false
+	}
+
+	n := &NFTablesManager{}
+	out, err := n.runNft("-y", "list", "table", "inet", nftTableName)
+	if err != nil {
+		return "", nil, true
+	}
+
+	var lines []planLine
+	var chain, hook string
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimSpace(l)
+		switch {
+		case l == "" || l == "}" || strings.HasPrefix(l, "table "):
+			if l == "}" && chain != "" {
+				text := "chain " + chain + hook
+				lines = append(lines, planLine{Key: nftLineKey("", text), Text: text})
+				chain, hook = "", ""
+			}
+		case strings.HasPrefix(l, "chain "):
+			chain = strings.TrimSuffix(strings.TrimPrefix(l, "chain "), " {")
+			hook = ""
+		case chain == "":
+			// set declarations and their elements
+		case strings.HasPrefix(l, "type "):
+			hook = " { " + l + " }"
+		default:
+			lines = append(lines, planLine{Key: nftLineKey(chain, l), Text: chain + ": " + l})
+		}
+	}
+	return out, lines, true
+}
+
+// nftLineKey normalizes an nft statement so that the generated form matches
+// the listing: counters lose their values, hex values their zero padding and
+// queue statements use a single syntax
+func nftLineKey(chain, stmt string) string {
+	s := strings.ToLower(spacesRe.ReplaceAllString(strings.TrimSpace(stmt), " "))
+	s = nftCounterRe.ReplaceAllString(s, "counter")
+	s = nftHexRe.ReplaceAllString(s, "0x$1")
+	s = nftQueueRe.ReplaceAllString(s, "queue num $1 bypass")
+	if chain == "" {
+		return s
+	}
+	return chain + ": " + s
+}
@@ -0,0 +1,176 @@
+package tables
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+)
+
+// prefilterSet is a named nftables set or ipset holding the destinations of
+// a prefiltered port group for one address family
+type prefilterSet struct {
+	Name   string
+	Family string // "ipv4" or "ipv6"
+	Key    string // identifies the rule using the set
+	Prefix []netip.Prefix
+}
+
+var (
+	installedMu      sync.Mutex
+	installedBackend string
+	installedSets    map[string]prefilterSet
+)
+
+// prefilterSets returns the sets needed by the prefiltered port groups of
+// cfg, for each enabled address family
+func prefilterSets(cfg *config.Config) []prefilterSet {
+	var sets []prefilterSet
+	add := func(proto string, groups []config.PortLimit) {
+		for i, g := range groups {
+			if !g.Prefilter {
+				continue
+			}
+			for _, family := range prefilterFamilies(cfg) {
+				sets = append(sets, prefilterSet{
+					Name:   prefilterSetName(proto, i, family),
+					Family: family,
+					Key:    proto + ":" + g.String(),
+					Prefix: parsePrefixes(g.IPs, family),
+				})
+			}
+		}
+	}
+	add("tcp", cfg.CollectTCPPortLimits())
+	add("udp", cfg.CollectUDPPortLimits())
+	return sets
+}
+
+func prefilterFamilies(cfg *config.Config) []string {
+	var families []string
+	if cfg.Queue.IPv4Enabled {
+		families = append(families, "ipv4")
+	}
+	if cfg.Queue.IPv6Enabled {
+		families = append(families, "ipv6")
+	}
+	return families
+}
+
+func prefilterSetName(proto string, group int, family string) string {
+	return fmt.Sprintf("b4_%s%d_v%s", proto, group, strings.TrimPrefix(family, "ipv"))
+}
+
+// parsePrefixes turns IPs and CIDRs of the given family into a sorted list
+// of prefixes with no overlaps, as required by interval sets
+func parsePrefixes(ips []string, family string) []netip.Prefix {
+	var all []netip.Prefix
+	for _, s := range ips {
+		s = strings.TrimSpace(s)
+		var p netip.Prefix
+		if strings.Contains(s, "/") {
+			var err error
+			if p, err = netip.ParsePrefix(s); err != nil {
+				continue
+			}
+		} else {
+			a, err := netip.ParseAddr(s)
+			if err != nil {
+				continue
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		p = p.Masked()
+		if p.Addr().Is4() != (family == "ipv4") {
+			continue
+		}
+		all = append(all, p)
+	}
+
+	slices.SortFunc(all, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+
+	result := all[:0]
+	for _, p := range all {
+		if n := len(result); n > 0 && result[n-1].Contains(p.Addr()) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// openPrefix matches every address of the family
+func openPrefix(family string) netip.Prefix {
+	if family == "ipv4" {
+		return netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+	}
+	return netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+}
+
+// rememberPrefilterSets records the sets installed by Apply so later config
+// updates can tell which of them still back the same rule
+func rememberPrefilterSets(backend string, sets []prefilterSet) {
+	installedMu.Lock()
+	defer installedMu.Unlock()
+	installedBackend = backend
+	installedSets = make(map[string]prefilterSet, len(sets))
+	for _, s := range sets {
+		installedSets[s.Name] = s
+	}
+}
+
+// SyncTargetSets updates the elements of the installed prefilter sets to the
+// IPs of cfg without touching the rules. A set whose rule no longer
+// corresponds to a prefiltered group of cfg is opened to every address, so
+// the rule queues as if it was not filtered until the rules are rebuilt.
+func SyncTargetSets(cfg *config.Config) error {
+	installedMu.Lock()
+	defer installedMu.Unlock()
+
+	if len(installedSets) == 0 {
+		return nil
+	}
+
+	wanted := make(map[string]prefilterSet)
+	for _, s := range prefilterSets(cfg) {
+		wanted[s.Name] = s
+	}
+
+	var errs []string
+	for name, cur := range installedSets {
+		next, ok := wanted[name]
+		if !ok || next.Key != cur.Key || next.Family != cur.Family {
+			next = prefilterSet{Name: name, Family: cur.Family, Key: cur.Key, Prefix: []netip.Prefix{openPrefix(cur.Family)}}
+		}
+		if slices.Equal(next.Prefix, cur.Prefix) {
+			continue
+		}
+
+		var err error
+		if installedBackend == "nftables" {
+			err = syncNFTSet(cur, next)
+		} else {
+			err = syncIPSet(cur, next)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		log.Tracef("Updated prefilter set %s: %d -> %d prefixes", name, len(cur.Prefix), len(next.Prefix))
+		installedSets[name] = next
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to update prefilter sets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package tables
+
+import (
+	"sync"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+)
+
+// Discovery probe traffic is queued only while a discovery runs. Runs at the
+// same time share the probe rules, which go once the last one stops.
+var (
+	probeMu   sync.Mutex
+	probeRuns int
+)
+
+type probeRuleManager interface {
+	addProbeRules() error
+	clearProbeRules() error
+}
+
+func probeManager(cfg *config.Config) probeRuleManager {
+	if detectFirewallBackend() == "nftables" {
+		return NewNFTablesManager(cfg)
+	}
+	return NewIPTablesManager(cfg)
+}
+
+// StartProbes queues discovery probe traffic until the matching StopProbes
+func StartProbes(cfg *config.Config) error {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+
+	if probeRuns == 0 && !cfg.System.Tables.SkipSetup {
+		if err := probeManager(cfg).addProbeRules(); err != nil {
+			return err
+		}
+		log.Tracef("Discovery probe rules added")
+	}
+	probeRuns++
+	return nil
+}
+
+// StopProbes ends a discovery run started with StartProbes
+func StopProbes(cfg *config.Config) {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+
+	if probeRuns == 0 {
+		return
+	}
+	probeRuns--
+	if probeRuns == 0 && !cfg.System.Tables.SkipSetup {
+		if err := probeManager(cfg).clearProbeRules(); err != nil {
+			log.Errorf("Failed to remove discovery probe rules: %v", err)
+		}
+		log.Tracef("Discovery probe rules removed")
+	}
+}
+
+// restoreProbeRules adds the probe rules back after the tables were rebuilt
+// during a discovery
+func restoreProbeRules(cfg *config.Config) error {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+
+	if probeRuns == 0 {
+		return nil
+	}
+	return probeManager(cfg).addProbeRules()
+}
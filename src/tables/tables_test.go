@@ -1,9 +1,16 @@
 package tables
 
 import (
+	"bytes"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/daniellavrushin/b4/config"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
 )
 
 func TestIPTablesManager_BuildNFQSpec(t *testing.T) {
@@ -293,3 +300,327 @@ func TestLoadSysctlSnapshot_NoFile(t *testing.T) {
 		t.Error("should return empty map for non-existent file")
 	}
 }
+
+func TestIPTablesManager_BuildPortLimitSpecs(t *testing.T) {
+	cfg := config.NewConfig()
+	manager := NewIPTablesManager(&cfg)
+
+	groups := []config.PortLimit{
+		{Ports: []string{"443", "8440-8450"}, Limit: 19},
+		{Ports: []string{"443"}, Limit: 30},
+	}
+	specs := manager.buildPortLimitSpecs("tcp", groups, 100, 1)
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 specs, got %d", len(specs))
+	}
+
+	joined := strings.Join(specs[0], " ")
+	if !strings.Contains(joined, "--dports 443,8440:8450") {
+		t.Errorf("ports not rendered as multiport list: %s", joined)
+	}
+	if !strings.Contains(joined, "--connbytes 0:19") {
+		t.Errorf("wrong connbytes range: %s", joined)
+	}
+	if !strings.Contains(strings.Join(specs[1], " "), "--connbytes 0:30") {
+		t.Errorf("wrong connbytes range: %v", specs[1])
+	}
+}
+
+func TestNFTablesManager_BuildRuleset(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Queue.StartNum = 100
+	cfg.Queue.Threads = 2
+	cfg.Queue.Mark = 0x8000
+	cfg.Queue.IPv6Enabled = false
+
+	rs := NewNFTablesManager(&cfg).buildRuleset()
+	out := rs.String()
+
+	if rs.Chains[0].Name != nftChainName {
+		t.Errorf("regular chain must come first, got %s", rs.Chains[0].Name)
+	}
+
+	for _, want := range []string{
+		"table inet b4_mangle {",
+		"type filter hook output priority 149; policy accept;",
+		"type filter hook prerouting priority -150; policy accept;",
+		"meta mark 0x8000 accept",
+		"meta mark 0x8000 return",
+		"jump b4_chain",
+		"meta nfproto ipv4 tcp dport 443 ct original packets < ",
+		"meta nfproto ipv4 udp dport 53 counter queue num 100-101 bypass",
+		"meta nfproto ipv4 udp sport 53 counter queue num 100-101 bypass",
+		"meta mark 0x4000 jump b4_probe",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("ruleset missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "sport 61000") {
+		t.Errorf("probe traffic queued outside a discovery:\n%s", out)
+	}
+
+	t.Run("probe rules", func(t *testing.T) {
+		nft := NewNFTablesManager(&cfg)
+		var lines []string
+		for _, r := range nft.probeRules() {
+			if r.Chain != nftProbeChainName {
+				t.Errorf("probe rule in chain %s", r.Chain)
+			}
+			lines = append(lines, strings.Join(r.Args(), " "))
+		}
+		want := []string{
+			"meta nfproto ipv4 meta l4proto tcp ct original packets < 20 counter queue num 100-101 bypass",
+			"meta nfproto ipv4 meta l4proto udp ct original packets < 9 counter queue num 100-101 bypass",
+		}
+		if !slices.Equal(lines, want) {
+			t.Errorf("probe rules = %q, want %q", lines, want)
+		}
+	})
+
+	t.Run("forward chain for devices", func(t *testing.T) {
+		cfg := config.NewConfig()
+		cfg.Queue.Devices.Enabled = true
+		cfg.Queue.Devices.Mac = []string{"aa:bb:cc:dd:ee:ff"}
+
+		out := NewNFTablesManager(&cfg).Plan()
+		if !strings.Contains(out, "ether saddr AA:BB:CC:DD:EE:FF jump b4_chain") {
+			t.Errorf("missing device rule:\n%s", out)
+		}
+		if strings.Contains(out, "postrouting") {
+			t.Error("postrouting chain must not be used with device filtering")
+		}
+	})
+}
+
+func TestPortExprs(t *testing.T) {
+	conn, err := nftables.New(nftables.AsLasting())
+	if err != nil {
+		t.Skipf("netlink unavailable: %v", err)
+	}
+	defer conn.CloseLasting()
+	table := &nftables.Table{Name: nftTableName, Family: nftables.TableFamilyINet}
+
+	tests := []struct {
+		name  string
+		ports []string
+		check func(expr.Any) bool
+	}{
+		{"single port", []string{"443"}, func(e expr.Any) bool { _, ok := e.(*expr.Cmp); return ok }},
+		{"single range", []string{"1000-2000"}, func(e expr.Any) bool { _, ok := e.(*expr.Range); return ok }},
+		{"port list", []string{"80", "443", "8000-9000"}, func(e expr.Any) bool { _, ok := e.(*expr.Lookup); return ok }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exprs, err := portExprs(conn, table, 2, tt.ports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(exprs) != 2 || !tt.check(exprs[1]) {
+				t.Errorf("unexpected expressions %#v", exprs)
+			}
+		})
+	}
+
+	if _, err := portExprs(conn, table, 2, []string{"2000-1000"}); err == nil {
+		t.Error("expected error for inverted range")
+	}
+}
+
+func TestNFTRuleMaxPackets(t *testing.T) {
+	exprs, err := nftRule{MaxPackets: 258, Verdict: nftQueue}.exprs(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exprs) != 4 {
+		t.Fatalf("unexpected expressions %#v", exprs)
+	}
+	if ct, ok := exprs[0].(*expr.Ct); !ok || ct.Key != expr.CtKeyPKTS {
+		t.Errorf("expected ct packets, got %#v", exprs[0])
+	}
+	if bo, ok := exprs[1].(*expr.Byteorder); !ok || bo.Op != expr.ByteorderHton || bo.Len != 8 || bo.Size != 8 {
+		t.Errorf("expected hton byteorder, got %#v", exprs[1])
+	}
+	cmp, ok := exprs[2].(*expr.Cmp)
+	if !ok || cmp.Op != expr.CmpOpLt {
+		t.Fatalf("expected lt compare, got %#v", exprs[2])
+	}
+	if want := []byte{0, 0, 0, 0, 0, 0, 1, 2}; !bytes.Equal(cmp.Data, want) {
+		t.Errorf("compare data = %x, want big endian %x", cmp.Data, want)
+	}
+}
+
+func TestIPTRuleKey(t *testing.T) {
+	// Generated spec and the way iptables -S prints it back
+	spec := []string{"-p", "udp", "--dport", "53", "-j", "NFQUEUE", "--queue-num", "537", "--queue-bypass"}
+	listed := strings.Fields("-p udp -m udp --dport 53 -j NFQUEUE --queue-num 537 --queue-bypass")
+
+	if a, b := iptRuleKey("iptables", "B4", spec), iptRuleKey("iptables", "B4", listed); a != b {
+		t.Errorf("keys differ:\n%s\n%s", a, b)
+	}
+
+	connbytes := strings.Fields("-p tcp -m multiport --dports 443 -m connbytes --connbytes-dir original --connbytes-mode packets --connbytes 0:19 -j NFQUEUE --queue-num 537 --queue-bypass")
+	connbytesListed := strings.Fields("-p tcp -m multiport --dports 443 -m connbytes --connbytes 0:19 --connbytes-mode packets --connbytes-dir original -j NFQUEUE --queue-num 537 --queue-bypass")
+	if a, b := iptRuleKey("iptables", "B4", connbytes), iptRuleKey("iptables", "B4", connbytesListed); a != b {
+		t.Errorf("keys differ:\n%s\n%s", a, b)
+	}
+
+	if iptRuleKey("iptables", "B4", spec) == iptRuleKey("ip6tables", "B4", spec) {
+		t.Error("keys must include the binary")
+	}
+}
+
+func TestNFTLineKey(t *testing.T) {
+	generated := "meta mark 0x8000 return"
+	listed := "meta mark 0x00008000 return"
+	if nftLineKey("b4_chain", generated) != nftLineKey("b4_chain", listed) {
+		t.Error("mark padding not normalized")
+	}
+
+	generated = "meta nfproto ipv4 udp dport 53 counter queue num 537-540 bypass"
+	listed = "meta nfproto ipv4 udp dport 53 counter packets 12 bytes 840 queue flags bypass to 537-540"
+	if a, b := nftLineKey("b4_chain", generated), nftLineKey("b4_chain", listed); a != b {
+		t.Errorf("keys differ:\n%s\n%s", a, b)
+	}
+}
+
+func TestDiffPlanLines(t *testing.T) {
+	expected := []planLine{{"a", "rule a"}, {"b", "rule b"}, {"b", "rule b"}}
+	installed := []planLine{{"b", "rule b"}, {"c", "rule c"}}
+
+	diff := diffPlanLines(expected, installed)
+	want := []string{"+ rule a", "+ rule b", "- rule c"}
+	if strings.Join(diff, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", diff, want)
+	}
+
+	if len(diffPlanLines(expected, expected)) != 0 {
+		t.Error("identical plans must not differ")
+	}
+}
+
+func TestPlanRules(t *testing.T) {
+	cfg := config.NewConfig()
+
+	plan, err := PlanRules(&cfg, "nftables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Backend != "nftables" || !strings.HasPrefix(plan.Script, "table inet "+nftTableName) {
+		t.Errorf("unexpected nftables plan: %+v", plan)
+	}
+
+	plan, err = PlanRules(&cfg, "iptables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plan.Script, "-A OUTPUT -j B4") {
+		t.Errorf("unexpected iptables script:\n%s", plan.Script)
+	}
+
+	if _, err := PlanRules(&cfg, "pf"); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	ips := []string{"10.1.0.0/16", "10.0.0.0/8", "192.168.1.7", "bad", "2001:db8::1", "10.2.3.4/24"}
+
+	v4 := parsePrefixes(ips, "ipv4")
+	if len(v4) != 2 || v4[0].String() != "10.0.0.0/8" || v4[1].String() != "192.168.1.7/32" {
+		t.Errorf("unexpected ipv4 prefixes: %v", v4)
+	}
+
+	v6 := parsePrefixes(ips, "ipv6")
+	if len(v6) != 1 || v6[0].String() != "2001:db8::1/128" {
+		t.Errorf("unexpected ipv6 prefixes: %v", v6)
+	}
+}
+
+func TestPrefixElements(t *testing.T) {
+	elems := prefixElements(parsePrefixes([]string{"10.0.0.0/8", "0.0.0.0/0"}, "ipv4"))
+	if len(elems) != 1 || !elems[0].IntervalOpen {
+		t.Fatalf("whole address space must be a single open interval, got %+v", elems)
+	}
+
+	elems = prefixElements(parsePrefixes([]string{"10.0.0.0/8"}, "ipv4"))
+	if len(elems) != 2 || !elems[1].IntervalEnd || net.IP(elems[1].Key).String() != "11.0.0.0" {
+		t.Errorf("unexpected elements %+v", elems)
+	}
+}
+
+func TestIPSetEntries(t *testing.T) {
+	entries := ipsetEntries([]netip.Prefix{openPrefix("ipv4"), netip.MustParsePrefix("10.0.0.0/8")})
+	want := []string{"0.0.0.0/1", "128.0.0.0/1", "10.0.0.0/8"}
+	if strings.Join(entries, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", entries, want)
+	}
+
+	entries = ipsetEntries([]netip.Prefix{openPrefix("ipv6")})
+	if strings.Join(entries, " ") != "::/1 8000::/1" {
+		t.Errorf("unexpected ipv6 entries %v", entries)
+	}
+}
+
+func TestPrefilterRules(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.System.Tables.IPPrefilter = true
+	cfg.Queue.IPv6Enabled = true
+	set := config.NewSetConfig()
+	set.Targets.IpsToMatch = []string{"10.0.0.0/8", "2001:db8::/32"}
+	cfg.Sets = append(cfg.Sets, &set)
+	cfg.Validate()
+
+	rs := NewNFTablesManager(&cfg).buildRuleset()
+	out := rs.String()
+	for _, want := range []string{
+		"set b4_tcp0_v4 {",
+		"elements = { 10.0.0.0/8 }",
+		"set b4_tcp0_v6 {",
+		"ip daddr @b4_tcp0_v4 tcp dport 443",
+		"ip6 daddr @b4_tcp0_v6 tcp dport 443",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("ruleset missing %q:\n%s", want, out)
+		}
+	}
+
+	m := NewIPTablesManager(&cfg).manifestFor([]string{"iptables", "ip6tables"})
+	if hasBinary("ipset") {
+		script := m.Script()
+		if !strings.Contains(script, "-m set --match-set b4_tcp0_v4 dst") {
+			t.Errorf("iptables script missing set match:\n%s", script)
+		}
+	} else if len(m.IPSets) != 0 {
+		t.Error("ipsets must not be used without the ipset binary")
+	}
+}
+
+func TestIPTablesProbeRules(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Queue.StartNum = 100
+	cfg.Queue.Threads = 1
+	manager := NewIPTablesManager(&cfg)
+
+	script := manager.manifestFor([]string{"iptables"}).Script()
+	if !strings.Contains(script, "-A OUTPUT -m mark --mark 0x4000/0x4000 -j B4_PROBE") {
+		t.Errorf("missing jump of marked local traffic to the probe chain:\n%s", script)
+	}
+	if strings.Contains(script, "--sport 61000") {
+		t.Errorf("probe traffic queued outside a discovery:\n%s", script)
+	}
+
+	rules := manager.probeRules([]string{"iptables"})
+	if len(rules) != 2 {
+		t.Fatalf("expected a tcp and a udp rule, got %d", len(rules))
+	}
+	for i, want := range []string{
+		"-p tcp -m connbytes --connbytes-dir original --connbytes-mode packets --connbytes 0:19 -j NFQUEUE --queue-num 100 --queue-bypass",
+		"-p udp -m connbytes --connbytes-dir original --connbytes-mode packets --connbytes 0:8 -j NFQUEUE --queue-num 100 --queue-bypass",
+	} {
+		if rules[i].Chain != probeChainName || strings.Join(rules[i].Spec, " ") != want {
+			t.Errorf("probe rule %d = %s %q, want %q", i, rules[i].Chain, strings.Join(rules[i].Spec, " "), want)
+		}
+	}
+}